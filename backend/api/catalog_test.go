@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"docklet/catalog"
+	"docklet/links"
+	systemscanner "docklet/system_scanner"
+
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+)
+
+// hungDocker starts a Docker API endpoint that accepts requests and never answers them.
+func hungDocker(t *testing.T) *client.Client {
+	t.Helper()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")), client.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func serveCatalog(t *testing.T, collector *catalog.Collector) (*httptest.ResponseRecorder, time.Duration) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestIDMiddleware())
+	router.GET("/api/v1/catalog", CatalogHandlerGin(collector, nil, nil))
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/catalog", nil))
+	return w, time.Since(start)
+}
+
+func TestCatalogHungDockerPartial(t *testing.T) {
+	sysScanner, err := systemscanner.NewSystemScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := links.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(links.Link{ID: links.IDForURL("http://nas.lan"), Title: "NAS", URL: "http://nas.lan"}); err != nil {
+		t.Fatal(err)
+	}
+
+	const timeout = 300 * time.Millisecond
+	collector := &catalog.Collector{Docker: hungDocker(t), System: sysScanner, Links: store, DockerTimeout: timeout, SystemTimeout: timeout}
+	w, elapsed := serveCatalog(t, collector)
+
+	if elapsed > timeout+2*time.Second {
+		t.Errorf("request took %s with a %s Docker timeout", elapsed, timeout)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 with a partial catalog: %s", w.Code, w.Body)
+	}
+	var result catalog.Result
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	var warned bool
+	for _, warning := range result.Warnings {
+		if warning.Source == catalog.SourceDocker {
+			warned = true
+			if warning.Code != catalog.WarningSourceTimeout {
+				t.Errorf("docker warning code = %s, want %s", warning.Code, catalog.WarningSourceTimeout)
+			}
+		}
+	}
+	if !warned {
+		t.Errorf("no docker warning in %+v", result.Warnings)
+	}
+	var found bool
+	for _, item := range result.Items {
+		found = found || item.Title == "NAS"
+	}
+	if !found {
+		t.Errorf("stored link missing from partial catalog: %+v", result.Items)
+	}
+}
+
+func TestCatalogAllSourcesTimeout(t *testing.T) {
+	sysScanner, err := systemscanner.NewSystemScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const timeout = 300 * time.Millisecond
+	// The system scan gets a deadline that has passed by the time it starts
+	collector := &catalog.Collector{Docker: hungDocker(t), System: sysScanner, DockerTimeout: timeout, SystemTimeout: time.Nanosecond}
+	w, elapsed := serveCatalog(t, collector)
+
+	if elapsed > timeout+2*time.Second {
+		t.Errorf("request took %s with a %s Docker timeout", elapsed, timeout)
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != CodeSourceTimeout {
+		t.Errorf("code = %s, want %s", body.Code, CodeSourceTimeout)
+	}
+}
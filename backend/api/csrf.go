@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Docklet has no user accounts, so anything a browser on the LAN can be made to send, any
+// web page can send. Endpoints that change state are protected in layers: they don't allow
+// CORS, so other origins can't read their responses; SameOriginMiddleware rejects requests
+// browsers mark as coming from another site; requireJSON and requireFileType make
+// cross-origin requests need a CORS preflight, which Docklet never grants; and the most
+// dangerous ones need a token. Services behind the proxy are served on hosts of their own
+// (see ProxyHostHandler), so their pages are cross-origin too.
+
+// SameOriginMiddleware rejects requests with unsafe methods that a browser sent on behalf of
+// another site. Sec-Fetch-Site must be same-origin or none (the user typed the URL); browsers
+// that don't send it still send Origin on cross-origin requests, which must then match the
+// Host. Clients like curl send neither and are let through.
+func SameOriginMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if site := c.GetHeader("Sec-Fetch-Site"); site != "" {
+			if site != "same-origin" && site != "none" {
+				respondError(c, http.StatusForbidden, CodeForbidden, "Cross-site requests can't change Docklet's state", gin.H{"sec_fetch_site": site})
+				return
+			}
+		} else if origin := c.GetHeader("Origin"); origin != "" && !sameHost(origin, c.Request.Host) {
+			respondError(c, http.StatusForbidden, CodeForbidden, "Cross-origin requests can't change Docklet's state", gin.H{"origin": origin})
+			return
+		}
+		c.Next()
+	}
+}
+
+// sameHost reports whether the Origin header names host, ignoring the scheme so a page
+// served over HTTP can still use the API after a redirect to HTTPS.
+func sameHost(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, host)
+}
+
+// requireJSON rejects requests whose body isn't declared as JSON. HTML forms and no-cors
+// fetches can only send form and text content types, so other sites can't send the request
+// without a CORS preflight. It writes the error response and returns false if rejected.
+func requireJSON(c *gin.Context) bool {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		respondError(c, http.StatusUnsupportedMediaType, CodeInvalidRequest, "The request body must be JSON sent with Content-Type: application/json", nil)
+		return false
+	}
+	return true
+}
+
+// corsSafelistedTypes are the content types HTML forms and no-cors fetches can send.
+var corsSafelistedTypes = map[string]bool{
+	"application/x-www-form-urlencoded": true,
+	"multipart/form-data":               true,
+	"text/plain":                        true,
+}
+
+// requireFileType is requireJSON for uploaded files: the body may have any declared type,
+// such as application/yaml, text/html or application/octet-stream, except the ones other
+// sites can send without a CORS preflight. It writes the error response and returns false
+// if rejected.
+func requireFileType(c *gin.Context) bool {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || corsSafelistedTypes[mediaType] {
+		respondError(c, http.StatusUnsupportedMediaType, CodeInvalidRequest, "Send the file with its own Content-Type, such as application/yaml or text/html; form and text/plain bodies aren't accepted", nil)
+		return false
+	}
+	return true
+}
+
+// requireToken checks the bearer token in the Authorization header against token. An empty
+// token means none was configured under envName, and the endpoint is refused altogether.
+// It writes the error response and returns false if the request isn't authorized.
+func requireToken(c *gin.Context, token, envName string) bool {
+	if token == "" {
+		respondError(c, http.StatusForbidden, CodeForbidden, "This endpoint needs a token; set "+envName+" and send it as Authorization: Bearer <token>", nil)
+		return false
+	}
+	scheme, given, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(given)), []byte(token)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="docklet"`)
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "Missing or wrong bearer token", nil)
+		return false
+	}
+	return true
+}
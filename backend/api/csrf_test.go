@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"docklet/notify"
+	"docklet/portforward"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSameOriginMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SameOriginMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.POST("/api/v1/thing", ok)
+	router.GET("/api/v1/thing", ok)
+
+	for _, tt := range []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"curl", http.MethodPost, nil, http.StatusNoContent},
+		{"same origin", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://nas.lan:8888"}, http.StatusNoContent},
+		{"typed by the user", http.MethodPost, map[string]string{"Sec-Fetch-Site": "none"}, http.StatusNoContent},
+		{"cross site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"other subdomain", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "http://grafana.nas.lan"}, http.StatusForbidden},
+		{"old browser, same origin", http.MethodPost, map[string]string{"Origin": "http://nas.lan:8888"}, http.StatusNoContent},
+		{"old browser, after https redirect", http.MethodPost, map[string]string{"Origin": "https://nas.lan:8888"}, http.StatusNoContent},
+		{"old browser, other origin", http.MethodPost, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"sandboxed frame", http.MethodPost, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"cross-site read", http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusNoContent},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://nas.lan:8888/api/v1/thing", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.method == http.MethodPost && w.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Error("response to a POST allows CORS")
+			}
+		})
+	}
+}
+
+func TestPortForwardApplyRejects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	manager := &portforward.Manager{Token: "s3cret"}
+
+	for _, tt := range []struct {
+		name        string
+		manager     *portforward.Manager
+		auth        string
+		contentType string
+		body        string
+		want        int
+		wantCode    string
+	}{
+		{"disabled", nil, "Bearer s3cret", "application/json", `{"plan_id":"abc"}`, http.StatusNotFound, CodeDisabled},
+		{"no token configured", &portforward.Manager{}, "Bearer ", "application/json", `{"plan_id":"abc"}`, http.StatusForbidden, CodeForbidden},
+		{"no token", manager, "", "application/json", `{"plan_id":"abc"}`, http.StatusUnauthorized, CodeUnauthorized},
+		{"wrong token", manager, "Bearer guess", "application/json", `{"plan_id":"abc"}`, http.StatusUnauthorized, CodeUnauthorized},
+		{"basic auth", manager, "Basic czNjcmV0", "application/json", `{"plan_id":"abc"}`, http.StatusUnauthorized, CodeUnauthorized},
+		{"form post", manager, "Bearer s3cret", "application/x-www-form-urlencoded", "plan_id=abc", http.StatusUnsupportedMediaType, CodeInvalidRequest},
+		{"text body", manager, "Bearer s3cret", "text/plain", `{"plan_id":"abc"}`, http.StatusUnsupportedMediaType, CodeInvalidRequest},
+		{"invalid json", manager, "Bearer s3cret", "application/json", `plan_id=abc`, http.StatusBadRequest, CodeInvalidRequest},
+		{"no plan id", manager, "Bearer s3cret", "application/json; charset=utf-8", `{}`, http.StatusBadRequest, CodeInvalidRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/apply", PortForwardApplyHandlerGin(tt.manager))
+			req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body, tt.want, tt.wantCode)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if w.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Error("apply response allows CORS")
+			}
+		})
+	}
+}
+
+func TestUploadsNeedPreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hook.Close()
+	t.Setenv("DOCKLET_NOTIFY_URLS", "json://"+hook.Listener.Addr().String())
+	dispatcher, err := notify.NewDispatcher(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.POST("/import", ImportHandlerGin(nil, nil))
+	router.POST("/notify/test", NotifyTestHandlerGin(dispatcher))
+
+	for _, tt := range []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		want        int
+	}{
+		// An unknown format is only reported once the body type has been accepted
+		{"import yaml", "/import?format=unknown", "application/yaml", "services: []", http.StatusBadRequest},
+		{"import bookmarks", "/import?format=unknown", "text/html; charset=utf-8", "<dl></dl>", http.StatusBadRequest},
+		{"import binary", "/import?format=unknown", "application/octet-stream", "services: []", http.StatusBadRequest},
+		{"import form", "/import?format=homer", "application/x-www-form-urlencoded", "services=", http.StatusUnsupportedMediaType},
+		{"import multipart", "/import?format=homer", "multipart/form-data; boundary=x", "--x--", http.StatusUnsupportedMediaType},
+		{"import text", "/import?format=homer", "text/plain", "services: []", http.StatusUnsupportedMediaType},
+		{"import without type", "/import?format=homer", "", "services: []", http.StatusUnsupportedMediaType},
+		{"notify test", "/notify/test", "application/json", "{}", http.StatusOK},
+		{"notify test, form", "/notify/test", "application/x-www-form-urlencoded", "", http.StatusUnsupportedMediaType},
+		{"notify test, text", "/notify/test", "text/plain", "{}", http.StatusUnsupportedMediaType},
+		{"notify test without type", "/notify/test", "", "", http.StatusUnsupportedMediaType},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
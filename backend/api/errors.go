@@ -0,0 +1,94 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"docklet/catalog"
+	"docklet/openapi"
+
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned in the code field of error responses.
+const (
+	CodeInvalidRequest     = "INVALID_REQUEST"
+	CodeUnsupportedFormat  = "UNSUPPORTED_FORMAT"
+	CodePayloadTooLarge    = "PAYLOAD_TOO_LARGE"
+	CodeNotFound           = "NOT_FOUND"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodeForbidden          = "FORBIDDEN"
+	CodeDockerUnavailable  = "DOCKER_UNAVAILABLE"
+	CodeSourceTimeout      = "SOURCE_TIMEOUT"
+	CodeDisabled           = "DISABLED"
+	CodePlanChanged        = "PLAN_CHANGED"
+	CodeServiceUnreachable = "SERVICE_UNREACHABLE"
+	CodeInternal           = "INTERNAL_ERROR"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// RequestIDMiddleware assigns each request an ID, reusing one set by a proxy in front
+// of Docklet, so error responses and logs can be correlated.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// respondError writes an error envelope with the given status and code. Only errors of
+// read-only requests may be read by other origins.
+func respondError(c *gin.Context, status int, code, message string, details interface{}) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		c.Header("Access-Control-Allow-Origin", "*")
+	}
+	c.AbortWithStatusJSON(status, openapi.ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDKey),
+	})
+}
+
+// respondServerError logs err and writes it as a 503 if the Docker daemon is unreachable,
+// a 504 if a source didn't answer in time and a 500 otherwise, with the cause in the details.
+func respondServerError(c *gin.Context, message string, err error) {
+	log.Printf("[%s] %s: %v", c.GetString(requestIDKey), message, err)
+	status, code := http.StatusInternalServerError, CodeInternal
+	var timeoutErr *catalog.SourceTimeoutError
+	switch {
+	case client.IsErrConnectionFailed(err):
+		status, code = http.StatusServiceUnavailable, CodeDockerUnavailable
+	case errors.As(err, &timeoutErr):
+		status, code = http.StatusGatewayTimeout, CodeSourceTimeout
+	}
+	respondError(c, status, code, message, gin.H{"cause": err.Error()})
+}
+
+// NotFoundHandlerGin answers requests no route matched: unknown /api/ paths get a JSON
+// error, everything else goes to the web UI, which may be nil.
+func NotFoundHandlerGin(ui http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") || ui == nil {
+			respondError(c, http.StatusNotFound, CodeNotFound, "No route for "+c.Request.Method+" "+c.Request.URL.Path, nil)
+			return
+		}
+		ui.ServeHTTP(c.Writer, c.Request)
+	}
+}
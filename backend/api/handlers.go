@@ -1,25 +1,70 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	dockerscanner "docklet/docker_scanner" // Renamed to avoid conflict
+	"docklet/catalog"
+	"docklet/certcheck"
+	"docklet/certs"
+	"docklet/dnsrecords"
+	"docklet/enricher"
+	"docklet/export"
+	"docklet/health"
+	"docklet/icons"
+	"docklet/importer"
+	"docklet/links"
+	"docklet/mdns"
+	"docklet/metrics"
+	"docklet/notify"
+	"docklet/openapi"
+	"docklet/portaudit"
+	"docklet/portforward"
+	"docklet/proxy"
 	systemscanner "docklet/system_scanner"
 
-	"github.com/docker/docker/client"
 	"github.com/gin-gonic/gin"
 )
 
+// MetricsMiddleware records request latency by route template, so label values stay
+// bounded no matter which paths clients request.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched" // Static files, SPA fallback and 404s
+		}
+		method := c.Request.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			method = "OTHER"
+		}
+		metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), route, method, strconv.Itoa(c.Writer.Status()))
+	}
+}
+
 // ServicesHandlerGin handles requests to list Docker services using Gin.
-func ServicesHandlerGin(dockerCli *client.Client) gin.HandlerFunc {
+// enr may be nil if page enrichment is disabled.
+func ServicesHandlerGin(collector *catalog.Collector, enr *enricher.Enricher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		services, err := dockerscanner.ListServices(dockerCli)
+		services, err := collector.DockerServices(c.Request.Context())
 		if err != nil {
-			log.Printf("Error listing services: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list Docker services"})
+			respondServerError(c, "Failed to list Docker services", err)
 			return
 		}
+		enr.ApplyToServices(services)
 		// Allow all origins for simplicity in development
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.JSON(http.StatusOK, services)
@@ -27,12 +72,11 @@ func ServicesHandlerGin(dockerCli *client.Client) gin.HandlerFunc {
 }
 
 // SystemServicesHandlerGin handles requests to list native system services using Gin.
-func SystemServicesHandlerGin(sysScanner *systemscanner.SystemScanner) gin.HandlerFunc {
+func SystemServicesHandlerGin(collector *catalog.Collector) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		allServices, err := sysScanner.ListServices()
+		allServices, err := collector.SystemServices(c.Request.Context())
 		if err != nil {
-			log.Printf("Error listing system services: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list system services"})
+			respondServerError(c, "Failed to list system services", err)
 			return
 		}
 
@@ -49,10 +93,632 @@ func SystemServicesHandlerGin(sysScanner *systemscanner.SystemScanner) gin.Handl
 	}
 }
 
-// HealthCheckHandlerGin provides a simple health check endpoint using Gin.
+// CatalogHandlerGin handles requests for the unified service catalog using Gin.
+// It merges Docker and native system services into a single normalized list. If a source
+// fails, the others are still returned along with a warning naming the failed source.
+// checker may be nil if certificate checks are disabled.
+func CatalogHandlerGin(collector *catalog.Collector, enr *enricher.Enricher, checker *certcheck.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, ok := collectPartial(c, collector)
+		if !ok {
+			return
+		}
+		enr.ApplyToEntries(result.Items)
+		checker.ApplyToEntries(result.Items)
+
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// LegacyCatalogHandlerGin serves the catalog as a bare list, as /api/catalog did before
+// responses were versioned. Warnings are only logged.
+func LegacyCatalogHandlerGin(collector *catalog.Collector, enr *enricher.Enricher, checker *certcheck.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, ok := collectPartial(c, collector)
+		if !ok {
+			return
+		}
+		enr.ApplyToEntries(result.Items)
+		checker.ApplyToEntries(result.Items)
+
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, result.Items)
+	}
+}
+
+// collectPartial collects the catalog, logging per-source warnings. It writes an error
+// response and returns false if every discovery source failed and nothing is left to show.
+func collectPartial(c *gin.Context, collector *catalog.Collector) (*catalog.Result, bool) {
+	result := collector.CollectPartial(c.Request.Context())
+	for _, warning := range result.Warnings {
+		log.Printf("[%s] Catalog source %s failed: %v", c.GetString(requestIDKey), warning.Source, warning.Err)
+	}
+	if len(result.Items) == 0 && len(result.Warnings) > 0 {
+		respondServerError(c, "Failed to list services", result.Warnings[0].Err)
+		return nil, false
+	}
+	return result, true
+}
+
+// IconHandlerGin serves bundled icons and icons cached by the enricher.
+func IconHandlerGin(enr *enricher.Enricher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+
+		// Icons come from arbitrary services; make sure cached SVGs can't run scripts on our origin.
+		c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Cache-Control", "public, max-age=86400")
+
+		if data, ok := icons.Asset(key); ok {
+			c.Data(http.StatusOK, "image/svg+xml", data)
+			return
+		}
+
+		path, ok := enr.IconFile(key)
+		if !ok {
+			c.Header("Cache-Control", "no-store")
+			respondError(c, http.StatusNotFound, CodeNotFound, "Icon not found", nil)
+			return
+		}
+		c.File(path)
+	}
+}
+
+// ExportHandlerGin renders the current catalog in another dashboard's config format,
+// selected with ?format= (see export.Formats). Icon paths are made absolute with the
+// scheme of the request, or the X-Forwarded-Proto of one of trustedProxies.
+func ExportHandlerGin(collector *catalog.Collector, enr *enricher.Enricher, trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		renderExport(c, collector, enr, trustedProxies, c.DefaultQuery("format", "homer"))
+	}
+}
+
+// ExportFormatHandlerGin renders the current catalog in a fixed format, for routes
+// like /api/export/bookmarks.html that browsers and launchers can fetch directly.
+func ExportFormatHandlerGin(collector *catalog.Collector, enr *enricher.Enricher, trustedProxies []*net.IPNet, format string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		renderExport(c, collector, enr, trustedProxies, format)
+	}
+}
+
+func renderExport(c *gin.Context, collector *catalog.Collector, enr *enricher.Enricher, trustedProxies []*net.IPNet, name string) {
+	format, ok := export.Lookup(name)
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedFormat, "Unsupported export format", gin.H{"formats": export.Formats()})
+		return
+	}
+
+	entries, err := collector.Collect(c.Request.Context())
+	if err != nil {
+		respondServerError(c, "Failed to list services", err)
+		return
+	}
+	enr.ApplyToEntries(entries)
+
+	data, err := format.Render(entries, export.Options{Title: c.Query("title"), BaseURL: requestBaseURL(c, trustedProxies)})
+	if err != nil {
+		respondServerError(c, "Failed to render "+format.Name+" export", err)
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Content-Disposition", `inline; filename="`+format.FileName+`"`)
+	c.Data(http.StatusOK, format.ContentType+"; charset=utf-8", data)
+}
+
+// maxImportSize bounds the size of uploaded configs and bookmark files.
+const maxImportSize = 5 << 20
+
+// ImportHandlerGin imports links from another dashboard's config or a bookmarks file,
+// posted as the request body. ?format= selects homer, homepage or bookmarks;
+// ?dry_run=true previews the result without storing anything, and
+// ?keep_conflicts=true also stores links that match a discovered service.
+func ImportHandlerGin(collector *catalog.Collector, store *links.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireFileType(c) {
+			return
+		}
+		format := c.Query("format")
+		if !importer.IsFormat(format) {
+			respondError(c, http.StatusBadRequest, CodeUnsupportedFormat, "Unsupported import format", gin.H{"formats": importer.Formats()})
+			return
+		}
+		dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+		keepConflicts, _ := strconv.ParseBool(c.DefaultQuery("keep_conflicts", "false"))
+
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxImportSize+1))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to read request body", gin.H{"cause": err.Error()})
+			return
+		}
+		if len(data) > maxImportSize {
+			respondError(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Import file too large", gin.H{"max_bytes": maxImportSize})
+			return
+		}
+
+		parsed, err := importer.Parse(format, data)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Failed to parse "+format+" file", gin.H{"cause": err.Error()})
+			return
+		}
+
+		// Conflict detection needs every source, so a partial catalog isn't enough here
+		discovered, err := collector.Collect(c.Request.Context())
+		if err != nil {
+			respondServerError(c, "Failed to list services", err)
+			return
+		}
+
+		result, err := importer.Import(store, parsed, discovered, importer.Options{DryRun: dryRun, KeepConflicts: keepConflicts})
+		if err != nil {
+			respondServerError(c, "Failed to store links", err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// LinksHandlerGin lists stored links.
+func LinksHandlerGin(store *links.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storedLinks, err := store.List()
+		if err != nil {
+			respondServerError(c, "Failed to list links", err)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, storedLinks)
+	}
+}
+
+// DeleteLinkHandlerGin removes a stored link.
+func DeleteLinkHandlerGin(store *links.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		found, err := store.Delete(c.Param("id"))
+		if err != nil {
+			respondServerError(c, "Failed to delete link", err)
+			return
+		}
+		if !found {
+			respondError(c, http.StatusNotFound, CodeNotFound, "Link not found", nil)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// PortsHandlerGin lists the host ports in use by published container ports and native
+// listeners, with warnings about conflicts and exposed sensitive services. If one of the
+// sources fails, the other's ports are still listed, with the failure in unavailable.
+func PortsHandlerGin(auditor *portaudit.Auditor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := auditor.Report(c.Request.Context())
+		for _, warning := range report.Unavailable {
+			log.Printf("[%s] Port audit source %s failed: %v", c.GetString(requestIDKey), warning.Source, warning.Err)
+		}
+		if len(report.Unavailable) == 2 {
+			respondServerError(c, "Failed to list ports", report.Unavailable[0].Err)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// PortForwardPlanHandlerGin shows the port forwards the policy wants on the router and
+// what would change to get there. manager is nil unless DOCKLET_PORTFORWARD=true with the
+// openwrt method. Unlike the other read-only endpoints it doesn't allow CORS, so other sites
+// can't learn the plan ID an apply names.
+func PortForwardPlanHandlerGin(manager *portforward.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if manager == nil {
+			respondPortForwardDisabled(c)
+			return
+		}
+		plan, err := manager.Plan(c.Request.Context())
+		if err != nil {
+			respondServerError(c, "Failed to plan port forwards", err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, plan)
+	}
+}
+
+// PortForwardApplyHandlerGin changes the router's firewall. It needs the bearer token from
+// DOCKLET_PORTFORWARD_TOKEN and a JSON body naming the reviewed plan, {"plan_id": "..."};
+// if the current plan has another ID, nothing is changed and it answers 409 with the new
+// plan in the details.
+func PortForwardApplyHandlerGin(manager *portforward.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if manager == nil {
+			respondPortForwardDisabled(c)
+			return
+		}
+		if !requireToken(c, manager.Token, "DOCKLET_PORTFORWARD_TOKEN") || !requireJSON(c) {
+			return
+		}
+		var req openapi.PortForwardApplyRequest
+		if err := json.NewDecoder(io.LimitReader(c.Request.Body, 64<<10)).Decode(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON body", gin.H{"cause": err.Error()})
+			return
+		}
+		if req.PlanID == "" {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "plan_id is required; get it from the plan endpoint", nil)
+			return
+		}
+		plan, err := manager.Apply(c.Request.Context(), req.PlanID)
+		if errors.Is(err, portforward.ErrPlanChanged) {
+			respondError(c, http.StatusConflict, CodePlanChanged, "The plan changed since it was listed", gin.H{"plan": plan})
+			return
+		}
+		if err != nil {
+			respondServerError(c, "Failed to apply port forwards", err)
+			return
+		}
+		log.Printf("[%s] Applied port-forward plan %s to %s: %d added, %d modified, %d deleted",
+			c.GetString(requestIDKey), plan.ID, manager.Router.Addr(), len(plan.Add), len(plan.Modify), len(plan.Delete))
+		c.JSON(http.StatusOK, plan)
+	}
+}
+
+// PortMappingsHandlerGin lists the UPnP or NAT-PMP mappings Docklet keeps on the gateway.
+// keeper is nil unless DOCKLET_PORTFORWARD=true with DOCKLET_PORTFORWARD_METHOD upnp or natpmp.
+func PortMappingsHandlerGin(keeper *portforward.Keeper) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keeper == nil {
+			respondError(c, http.StatusNotFound, CodeDisabled, "Port mapping is disabled; set DOCKLET_PORTFORWARD=true and DOCKLET_PORTFORWARD_METHOD=upnp or natpmp", nil)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, keeper.Leases())
+	}
+}
+
+func respondPortForwardDisabled(c *gin.Context) {
+	respondError(c, http.StatusNotFound, CodeDisabled, "OpenWrt port forwarding is disabled; set DOCKLET_PORTFORWARD=true and DOCKLET_PORTFORWARD_METHOD=openwrt", nil)
+}
+
+// MDNSHandlerGin lists the services published with mDNS. advertiser is nil unless
+// DOCKLET_MDNS=true.
+func MDNSHandlerGin(advertiser *mdns.Advertiser) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if advertiser == nil {
+			respondError(c, http.StatusNotFound, CodeDisabled, "mDNS is disabled; set DOCKLET_MDNS=true", nil)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, advertiser.Services())
+	}
+}
+
+// DNSRecordsHandlerGin lists the host names Docklet derives for discovered services.
+func DNSRecordsHandlerGin(gen *dnsrecords.Generator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		records, err := gen.Records(c.Request.Context())
+		if err != nil {
+			respondServerError(c, "Failed to list services", err)
+			return
+		}
+		if records == nil {
+			records = []dnsrecords.Record{}
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, records)
+	}
+}
+
+// DNSFormatHandlerGin renders the records in a resolver's format (see dnsrecords.Formats),
+// for resolvers or scripts that fetch them instead of reading a file Docklet writes.
+func DNSFormatHandlerGin(gen *dnsrecords.Generator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, ok := dnsrecords.Lookup(c.Param("format"))
+		if !ok {
+			respondError(c, http.StatusBadRequest, CodeUnsupportedFormat, "Unsupported DNS format", gin.H{"formats": dnsrecords.Formats()})
+			return
+		}
+		records, err := gen.Records(c.Request.Context())
+		if err != nil {
+			respondServerError(c, "Failed to list services", err)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Content-Disposition", `inline; filename="`+format.FileName+`"`)
+		c.Data(http.StatusOK, format.ContentType+"; charset=utf-8", format.Render(records))
+	}
+}
+
+// ProxyHostHandler serves requests for <name>.<DOCKLET_PROXY_DOMAIN> with the service of
+// that name, and requests for /s/<name>/ on the domain itself, through middleware; all other
+// requests go to next. It wraps Docklet's router rather than running in it, since gin routes
+// a request before any middleware runs and answers some itself, such as with redirects
+// adding or removing a trailing slash, which would then never reach the service. Services
+// are kept off Docklet's own host, where their pages would pass SameOriginMiddleware.
+func ProxyHostHandler(p *proxy.Proxy, next http.Handler, middleware ...gin.HandlerFunc) http.Handler {
+	services := gin.New()
+	services.Use(gin.Logger(), gin.Recovery())
+	services.Use(middleware...)
+	// With no routes, every request ends up here
+	services.NoRoute(func(c *gin.Context) {
+		name, _ := p.NameForHost(c.Request.Host)
+		forward(c, p, name, "")
+	})
+
+	paths := gin.New()
+	paths.Use(gin.Logger(), gin.Recovery())
+	paths.Use(middleware...)
+	paths.Any("/s/:name", ProxyPathHandlerGin(p))
+	paths.Any("/s/:name/*path", ProxyPathHandlerGin(p))
+	paths.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, CodeNotFound, "Only services at /s/<name>/ are served on "+p.Domain, nil)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.IsPathHost(r.Host) {
+			paths.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := p.NameForHost(r.Host); ok {
+			services.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ProxyPathHandlerGin forwards requests for /s/<name>/... to the service of that name.
+// /s/<name> is redirected to /s/<name>/ so the service's relative links resolve.
+func ProxyPathHandlerGin(p *proxy.Proxy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		if c.Param("path") == "" {
+			target := "/s/" + url.PathEscape(name) + "/"
+			if c.Request.URL.RawQuery != "" {
+				target += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, target)
+			return
+		}
+		forward(c, p, name, "/s/"+name)
+	}
+}
+
+func forward(c *gin.Context, p *proxy.Proxy, name, prefix string) {
+	if ip := net.ParseIP(c.RemoteIP()); ip == nil || !p.Allowed(ip) {
+		respondError(c, http.StatusForbidden, CodeForbidden, "Your address is not allowed to use the proxy (see DOCKLET_PROXY_ALLOW)", nil)
+		return
+	}
+	route, ok := p.Lookup(name)
+	if !ok {
+		respondError(c, http.StatusNotFound, CodeNotFound, "No service named "+name, nil)
+		return
+	}
+	p.Forward(c.Writer, c.Request, route, prefix, func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("[%s] Proxy: %s %s to %s: %v", c.GetString(requestIDKey), r.Method, r.URL.Path, route.Target, err)
+		respondError(c, http.StatusBadGateway, CodeServiceUnreachable, "Service "+route.Name+" can't be reached", gin.H{"cause": err.Error()})
+	})
+	c.Abort()
+}
+
+// ProxyRoutesHandlerGin lists the services reachable through the proxy. p is nil unless
+// DOCKLET_PROXY=true.
+func ProxyRoutesHandlerGin(p *proxy.Proxy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p == nil {
+			respondError(c, http.StatusNotFound, CodeDisabled, "The proxy is disabled; set DOCKLET_PROXY=true", nil)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, p.Routes())
+	}
+}
+
+// httpPaths are served over plain HTTP too, under /api and /api/v1: health checks, and
+// the CA certificate, which devices download before they trust it.
+var httpPaths = map[string]bool{
+	"/health":       true,
+	"/health/live":  true,
+	"/health/ready": true,
+	"/tls/ca.pem":   true,
+	"/tls/ca.crt":   true,
+}
+
+// HTTPSRedirectMiddleware redirects plain HTTP requests to HTTPS on tlsPort. Requests from
+// this host, such as the container health check, and requests for httpPaths are still
+// served over HTTP, as are requests that a TLS-terminating proxy at one of trustedProxies
+// forwarded with X-Forwarded-Proto: https.
+func HTTPSRedirectMiddleware(tlsPort string, trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil || servedOverHTTP(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if ip := net.ParseIP(c.RemoteIP()); (ip != nil && ip.IsLoopback()) || proxy.Scheme(c.Request, trustedProxies) == "https" {
+			c.Next()
+			return
+		}
+
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]") // IPv6 address without a port
+		}
+		if tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		status := http.StatusMovedPermanently
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect // Keeps the method and body
+		}
+		c.Redirect(status, "https://"+host+c.Request.URL.RequestURI())
+		c.Abort()
+	}
+}
+
+func servedOverHTTP(path string) bool {
+	for _, prefix := range []string{"/api/v1", "/api"} {
+		if rest, ok := strings.CutPrefix(path, prefix); ok && httpPaths[rest] {
+			return true
+		}
+	}
+	return false
+}
+
+// CACertificateHandlerGin serves the local CA certificate, for devices to trust, as PEM
+// or, with der set, as DER, which phones install from a download. manager is nil unless
+// DOCKLET_TLS=true.
+func CACertificateHandlerGin(manager *certs.Manager, der bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if manager.CACertificate() == nil {
+			respondError(c, http.StatusNotFound, CodeDisabled, "No local CA; set DOCKLET_TLS=true without DOCKLET_TLS_CERT", nil)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		if der {
+			c.Header("Content-Disposition", `attachment; filename="docklet-ca.crt"`)
+			c.Data(http.StatusOK, "application/x-x509-ca-cert", manager.CACertificateDER())
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="docklet-ca.pem"`)
+		c.Data(http.StatusOK, "application/x-pem-file", manager.CACertificate())
+	}
+}
+
+// CertificatesHandlerGin lists the certificates of https services, expiring first at the
+// top. checker is nil if DOCKLET_CERTCHECK=false.
+func CertificatesHandlerGin(checker *certcheck.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if checker == nil {
+			respondError(c, http.StatusNotFound, CodeDisabled, "Certificate checks are disabled; unset DOCKLET_CERTCHECK", nil)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, checker.Reports())
+	}
+}
+
+// NotifyStatusHandlerGin describes the notification channels, mutes and quiet hours.
+// d is nil unless DOCKLET_NOTIFY_URLS is set.
+func NotifyStatusHandlerGin(d *notify.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d == nil {
+			respondNotifyDisabled(c)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.JSON(http.StatusOK, d.Status())
+	}
+}
+
+// NotifyTestHandlerGin sends a test notification to every channel and reports the result
+// of each. The body is an empty JSON object, which only same-origin pages can send.
+func NotifyTestHandlerGin(d *notify.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d == nil {
+			respondNotifyDisabled(c)
+			return
+		}
+		if !requireJSON(c) {
+			return
+		}
+		c.JSON(http.StatusOK, d.Test(c.Request.Context()))
+	}
+}
+
+// NotifyMuteHandlerGin mutes a service by name, for the duration in the for query parameter
+// or until it is unmuted.
+func NotifyMuteHandlerGin(d *notify.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d == nil {
+			respondNotifyDisabled(c)
+			return
+		}
+		var duration time.Duration
+		if value := c.Query("for"); value != "" {
+			var err error
+			if duration, err = time.ParseDuration(value); err != nil || duration <= 0 {
+				respondError(c, http.StatusBadRequest, CodeInvalidRequest, "for must be a positive duration such as 2h", nil)
+				return
+			}
+		}
+		mute, err := d.MuteService(c.Param("service"), duration)
+		if err != nil {
+			respondServerError(c, "Failed to save mute", err)
+			return
+		}
+		c.JSON(http.StatusOK, mute)
+	}
+}
+
+// NotifyUnmuteHandlerGin lifts the mute of a service.
+func NotifyUnmuteHandlerGin(d *notify.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d == nil {
+			respondNotifyDisabled(c)
+			return
+		}
+		found, err := d.UnmuteService(c.Param("service"))
+		if err != nil {
+			respondServerError(c, "Failed to save mutes", err)
+			return
+		}
+		if !found {
+			respondError(c, http.StatusNotFound, CodeNotFound, c.Param("service")+" is not muted", nil)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func respondNotifyDisabled(c *gin.Context) {
+	respondError(c, http.StatusNotFound, CodeDisabled, "Notifications are disabled; set DOCKLET_NOTIFY_URLS", nil)
+}
+
+// requestBaseURL returns the URL Docklet was reached at, used to make icon paths absolute.
+func requestBaseURL(c *gin.Context, trustedProxies []*net.IPNet) string {
+	return proxy.Scheme(c.Request, trustedProxies) + "://" + c.Request.Host
+}
+
+// HealthCheckHandlerGin is the liveness check: it succeeds as long as the process serves
+// requests, whatever the state of Docker or the scanners.
 func HealthCheckHandlerGin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		c.JSON(http.StatusOK, openapi.HealthResponse{Status: health.StatusOK})
 	}
-}
\ No newline at end of file
+}
+
+// ReadinessHandlerGin checks each component Docklet depends on and reports its status
+// and latency, answering 503 if a critical component failed.
+func ReadinessHandlerGin(checker *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := checker.Run(c.Request.Context())
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+			for _, component := range report.Components {
+				if component.Status != health.StatusOK {
+					log.Printf("[%s] Readiness check %s failed: %s", c.GetString(requestIDKey), component.Name, component.Error)
+				}
+			}
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Cache-Control", "no-store")
+		c.JSON(status, report)
+	}
+}
+
+// OpenAPIHandlerGin serves the OpenAPI document describing this API.
+func OpenAPIHandlerGin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Data(http.StatusOK, "application/json; charset=utf-8", openapi.JSON())
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"docklet/catalog"
+	"docklet/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startProxy returns a proxy routing grafana to a backend echoing the host and path it was
+// asked for, reached like a container without a URL or published port: on its network address.
+func startProxy(t *testing.T, allow string) *proxy.Proxy {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "grafana "+r.Header.Get("X-Forwarded-Host")+" "+r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	internalPort, _ := strconv.Atoi(port)
+
+	t.Setenv("DOCKLET_PROXY_DOMAIN", "home.test")
+	t.Setenv("DOCKLET_PROXY_ALLOW", allow)
+	p, err := proxy.NewProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []catalog.Entry{{
+		ID:     "docker:abc",
+		Source: catalog.SourceDocker,
+		Name:   "grafana",
+		Labels: map[string]string{proxy.EnabledLabel: "true"},
+		Docker: &catalog.DockerDetails{NetworkIPs: map[string]string{"proxy": host}, InternalPort: internalPort},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go p.Run(ctx, func(context.Context) ([]catalog.Entry, error) { return entries, nil })
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := p.Lookup("grafana"); ok {
+			return p
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("route not added")
+		}
+	}
+}
+
+// get requests path from handler with the given Host header, over a real connection since
+// the proxy needs more of the ResponseWriter than httptest.ResponseRecorder has.
+func get(t *testing.T, handler http.Handler, host, path string) (int, string) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = host
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestProxyHostHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	p := startProxy(t, "127.0.0.0/8")
+	router := gin.New()
+	router.GET("/login", func(c *gin.Context) { c.String(http.StatusOK, "docklet") })
+	handler := ProxyHostHandler(p, router, RequestIDMiddleware())
+
+	for _, tt := range []struct {
+		name, host, path string
+		wantStatus       int
+		wantBody         string
+	}{
+		// Docklet's router would redirect /login/ to /login
+		{"service, trailing slash", "grafana.home.test", "/login/", http.StatusOK, "grafana grafana.home.test /login/"},
+		{"service, own route", "grafana.home.test", "/login", http.StatusOK, "grafana grafana.home.test /login"},
+		{"service, port in host", "grafana.home.test:8888", "/api/health", http.StatusOK, "grafana grafana.home.test:8888 /api/health"},
+		{"docklet", "nas.lan:8888", "/login", http.StatusOK, "docklet"},
+		{"docklet, trailing slash", "nas.lan:8888", "/login/", http.StatusMovedPermanently, ""},
+		{"unknown service", "prometheus.home.test", "/", http.StatusNotFound, ""},
+		// Paths on the domain itself, never on Docklet's host where the service's pages
+		// could use Docklet's API
+		{"by path", "home.test:8888", "/s/grafana/login/", http.StatusOK, "grafana home.test:8888 /login/"},
+		{"by path, no slash", "home.test", "/s/grafana", http.StatusMovedPermanently, ""},
+		{"by path, not a service", "home.test", "/api/v1/catalog", http.StatusNotFound, ""},
+		{"by path on docklet", "nas.lan:8888", "/s/grafana/login/", http.StatusNotFound, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := get(t, handler, tt.host, tt.path)
+			if status != tt.wantStatus || (tt.wantBody != "" && body != tt.wantBody) {
+				t.Errorf("got %d %q, want %d %q", status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestProxyHostHandlerAllow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	p := startProxy(t, "192.168.0.0/16")
+	handler := ProxyHostHandler(p, http.NotFoundHandler())
+
+	if status, body := get(t, handler, "grafana.home.test", "/"); status != http.StatusForbidden {
+		t.Errorf("got %d %q for an address not allowed, want 403", status, body)
+	}
+}
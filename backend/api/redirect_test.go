@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHTTPSRedirectMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, proxyNet, _ := net.ParseCIDR("192.168.1.2/32")
+
+	for _, tt := range []struct {
+		name       string
+		tlsPort    string
+		method     string
+		host, path string
+		remote     string
+		proto      string // X-Forwarded-Proto
+		want       string // Redirect location; empty if served
+		wantStatus int
+	}{
+		{"redirected", "8443", http.MethodGet, "nas.lan:8888", "/api/v1/catalog?x=1", "192.168.1.20", "", "https://nas.lan:8443/api/v1/catalog?x=1", http.StatusMovedPermanently},
+		{"post keeps method", "8443", http.MethodPost, "nas.lan:8888", "/api/v1/links", "192.168.1.20", "", "https://nas.lan:8443/api/v1/links", http.StatusPermanentRedirect},
+		{"default port", "443", http.MethodGet, "nas.lan", "/", "192.168.1.20", "", "https://nas.lan/", http.StatusMovedPermanently},
+		{"ipv6 with port", "8443", http.MethodGet, "[fd00::1]:8888", "/", "fd00::20", "", "https://[fd00::1]:8443/", http.StatusMovedPermanently},
+		{"ipv6 without port", "8443", http.MethodGet, "[fd00::1]", "/", "fd00::20", "", "https://[fd00::1]:8443/", http.StatusMovedPermanently},
+		{"ipv6 to default port", "443", http.MethodGet, "[fd00::1]", "/", "fd00::20", "", "https://[fd00::1]/", http.StatusMovedPermanently},
+		{"health", "8443", http.MethodGet, "nas.lan:8888", "/api/v1/health/ready", "192.168.1.20", "", "", http.StatusOK},
+		{"legacy health", "8443", http.MethodGet, "nas.lan:8888", "/api/health", "192.168.1.20", "", "", http.StatusOK},
+		{"ca download", "8443", http.MethodGet, "nas.lan:8888", "/api/v1/tls/ca.crt", "192.168.1.20", "", "", http.StatusOK},
+		{"path containing health", "8443", http.MethodGet, "nas.lan:8888", "/s/grafana/api/health", "192.168.1.20", "", "https://nas.lan:8443/s/grafana/api/health", http.StatusMovedPermanently},
+		{"path containing ca", "8443", http.MethodGet, "nas.lan:8888", "/files/tls/ca.pem", "192.168.1.20", "", "https://nas.lan:8443/files/tls/ca.pem", http.StatusMovedPermanently},
+		{"this host", "8443", http.MethodGet, "localhost:8888", "/", "127.0.0.1", "", "", http.StatusOK},
+		{"trusted proxy", "8443", http.MethodGet, "nas.lan", "/", "192.168.1.2", "https", "", http.StatusOK},
+		{"untrusted proxy", "8443", http.MethodGet, "nas.lan:8888", "/", "192.168.1.20", "https", "https://nas.lan:8443/", http.StatusMovedPermanently},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(HTTPSRedirectMiddleware(tt.tlsPort, []*net.IPNet{proxyNet}))
+			router.NoRoute(func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Host = tt.host
+			req.RemoteAddr = net.JoinHostPort(tt.remote, "50000")
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus || w.Header().Get("Location") != tt.want {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Header().Get("Location"), tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestBaseURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, proxyNet, _ := net.ParseCIDR("192.168.1.2/32")
+
+	for _, tt := range []struct {
+		name   string
+		remote string
+		tls    bool
+		proto  string // X-Forwarded-Proto
+		want   string
+	}{
+		{"plain", "192.168.1.20", false, "", "http://nas.lan:8888"},
+		{"tls", "192.168.1.20", true, "", "https://nas.lan:8888"},
+		{"trusted proxy", "192.168.1.2", false, "https", "https://nas.lan:8888"},
+		{"untrusted proxy", "192.168.1.20", false, "https", "http://nas.lan:8888"},
+		{"untrusted downgrade", "192.168.1.20", true, "http", "https://nas.lan:8888"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/export", nil)
+			c.Request.Host = "nas.lan:8888"
+			c.Request.RemoteAddr = net.JoinHostPort(tt.remote, "50000")
+			if tt.tls {
+				c.Request.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				c.Request.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if got := requestBaseURL(c, []*net.IPNet{proxyNet}); got != tt.want {
+				t.Errorf("requestBaseURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
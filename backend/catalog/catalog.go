@@ -0,0 +1,202 @@
+package catalog
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	dockerscanner "docklet/docker_scanner"
+	"docklet/links"
+	systemscanner "docklet/system_scanner"
+)
+
+// dockerProxyNames are process names Docker uses to publish container ports on the host.
+// Sockets owned by these processes belong to a container, not to a native service.
+var dockerProxyNames = map[string]bool{
+	"docker-proxy": true,
+	"vpnkit":       true, // Docker Desktop (macOS/Windows)
+}
+
+// Merge combines Docker and system services into a single list of entries.
+// System services are only included if they are likely web services, and native
+// listening ports that are actually Docker-published ports are dropped, so the
+// same service isn't listed twice.
+func Merge(dockerServices []dockerscanner.ServiceInfo, systemServices []systemscanner.SystemServiceInfo, hostIP string) []Entry {
+	entries := make([]Entry, 0, len(dockerServices)+len(systemServices))
+
+	publishedPorts := make(map[string]bool)
+	for _, service := range dockerServices {
+		for _, p := range service.PortBindings {
+			if p.PublicPort > 0 && (p.Type == "" || p.Type == "tcp") {
+				publishedPorts[strconv.Itoa(int(p.PublicPort))] = true
+			}
+		}
+		entries = append(entries, FromDocker(service))
+	}
+
+	for _, service := range systemServices {
+		// Sockets attributed to a container are already covered by its Docker entry.
+		if !service.IsLikelyWebService || service.Container != nil || isDockerProxy(service) {
+			continue
+		}
+
+		// Drop ports that Docker has already published; if nothing is left,
+		// the whole service is a duplicate of a container.
+		var ports []string
+		for _, p := range service.ListeningPorts {
+			if !publishedPorts[p] {
+				ports = append(ports, p)
+			}
+		}
+		if len(service.ListeningPorts) > 0 && len(ports) == 0 {
+			continue
+		}
+		service.ListeningPorts = ports
+
+		var endpoints []systemscanner.WebEndpoint
+		for _, endpoint := range service.WebEndpoints {
+			if !publishedPorts[endpoint.Port] {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		service.WebEndpoints = endpoints
+
+		entries = append(entries, FromSystem(service, hostIP))
+	}
+
+	return entries
+}
+
+// FromDocker converts a Docker ServiceInfo into a catalog Entry.
+func FromDocker(service dockerscanner.ServiceInfo) Entry {
+	return Entry{
+		ID:          SourceDocker + ":" + service.ID,
+		Source:      SourceDocker,
+		Name:        service.Name,
+		Title:       service.Title,
+		Icon:        service.Icon,
+		URL:         service.URL,
+		Description: service.Description,
+		Category:    service.Category,
+		Order:       service.Order,
+		Status:      service.Status,
+		Ports:       service.Ports,
+		Labels:      service.RawLabels,
+		Docker: &DockerDetails{
+			ContainerID:   service.ID,
+			ContainerName: service.ContainerName,
+			ImageName:     service.ImageName,
+			Networks:      service.Networks,
+			NetworkIPs:    service.NetworkIPs,
+			InternalPort:  int(service.InternalPort),
+		},
+	}
+}
+
+// FromSystem converts a SystemServiceInfo into a catalog Entry.
+// The URL points at the first web endpoint (or, failing that, the first listening port) on hostIP.
+func FromSystem(service systemscanner.SystemServiceInfo, hostIP string) Entry {
+	title := service.DisplayName
+	if title == "" {
+		title = service.Name
+	}
+
+	var serviceURL string
+	if len(service.WebEndpoints) > 0 {
+		endpoint := service.WebEndpoints[0]
+		serviceURL = fmt.Sprintf("%s://%s:%s", endpoint.Scheme, hostIP, endpoint.Port)
+	} else if len(service.ListeningPorts) > 0 {
+		serviceURL = fmt.Sprintf("http://%s:%s", hostIP, service.ListeningPorts[0])
+	}
+
+	return Entry{
+		ID:          SourceSystem + ":" + service.Name,
+		Source:      SourceSystem,
+		Name:        service.Name,
+		Title:       title,
+		Icon:        service.Icon,
+		URL:         serviceURL,
+		Description: service.Description,
+		Status:      service.Status,
+		Ports:       service.ListeningPorts,
+		System: &SystemDetails{
+			PID:             service.PID,
+			PathName:        service.PathName,
+			StartType:       service.StartType,
+			DisplayName:     service.DisplayName,
+			ListenAddresses: service.ListenAddresses,
+		},
+	}
+}
+
+// FromLink converts a stored link into a catalog Entry.
+func FromLink(link links.Link) Entry {
+	return Entry{
+		ID:          SourceLink + ":" + link.ID,
+		Source:      SourceLink,
+		Name:        link.Title,
+		Title:       link.Title,
+		Icon:        link.Icon,
+		URL:         link.URL,
+		Description: link.Description,
+		Category:    link.Category,
+		Link: &LinkDetails{
+			Origin:     link.Origin,
+			ImportedAt: link.ImportedAt,
+		},
+	}
+}
+
+// isDockerProxy reports whether a system service is one of Docker's port forwarding processes.
+func isDockerProxy(service systemscanner.SystemServiceInfo) bool {
+	if dockerProxyNames[service.Name] {
+		return true
+	}
+	if service.PathName != "" && dockerProxyNames[filepath.Base(service.PathName)] {
+		return true
+	}
+	return strings.HasPrefix(service.Name, "com.docker.")
+}
+
+// HostLabel turns a service name into a DNS label: lowercase letters, digits and hyphens,
+// at most 63 characters. It returns "" if nothing usable is left.
+func HostLabel(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '-' || r == '_' || r == '.' || r == ' ':
+			b.WriteByte('-')
+		}
+	}
+	label := strings.Trim(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// NumberedLabel returns label for n = 1, and label-n for the names after, shortened so the
+// result is still a DNS label.
+func NumberedLabel(label string, n int) string {
+	if n < 2 {
+		return label
+	}
+	suffix := "-" + strconv.Itoa(n)
+	if len(label)+len(suffix) > 63 {
+		label = strings.TrimRight(label[:63-len(suffix)], "-")
+	}
+	return label + suffix
+}
+
+// UniqueLabel returns the first of label, label-2, label-3... that taken reports free, for
+// services that want the same name.
+func UniqueLabel(label string, taken func(label string) bool) string {
+	name := label
+	for n := 2; taken(name); n++ {
+		name = NumberedLabel(label, n)
+	}
+	return name
+}
@@ -0,0 +1,55 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostLabel(t *testing.T) {
+	for name, want := range map[string]string{
+		"grafana":                      "grafana",
+		"Home Assistant":               "home-assistant",
+		"nginx.service":                "nginx-service",
+		"my_app-":                      "my-app",
+		"Café Ünïcode!":                "caf-ncode",
+		"!!!":                          "",
+		strings.Repeat("a", 62) + "-b": strings.Repeat("a", 62),
+	} {
+		if got := HostLabel(name); got != want {
+			t.Errorf("HostLabel(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNumberedLabel(t *testing.T) {
+	long := strings.Repeat("a", 60) + "-bc"
+	for _, tt := range []struct {
+		label string
+		n     int
+		want  string
+	}{
+		{"grafana", 1, "grafana"},
+		{"grafana", 2, "grafana-2"},
+		{"grafana", 12, "grafana-12"},
+		{long, 1, long},
+		{long, 2, strings.Repeat("a", 60) + "-2"}, // Never ends up with a double hyphen
+		{long, 10, strings.Repeat("a", 60) + "-10"},
+	} {
+		if got := NumberedLabel(tt.label, tt.n); got != tt.want {
+			t.Errorf("NumberedLabel(%q, %d) = %q, want %q", tt.label, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueLabel(t *testing.T) {
+	taken := map[string]bool{"grafana": true, "grafana-2": true, "loki-2": true}
+	for label, want := range map[string]string{
+		"grafana": "grafana-3",
+		"loki":    "loki",
+		"tempo":   "tempo",
+	} {
+		if got := UniqueLabel(label, func(l string) bool { return taken[l] }); got != want {
+			t.Errorf("UniqueLabel(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
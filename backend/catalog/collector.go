@@ -0,0 +1,217 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	dockerscanner "docklet/docker_scanner"
+	"docklet/links"
+	"docklet/metrics"
+	systemscanner "docklet/system_scanner"
+
+	"github.com/docker/docker/client"
+)
+
+// Collector gathers services from all sources and merges them into catalog entries.
+type Collector struct {
+	Docker        *client.Client
+	System        *systemscanner.SystemScanner
+	Links         *links.Store  // Optional; stored links are appended after discovered services
+	HostIP        string        // Host used in URLs of system services
+	DockerTimeout time.Duration // Deadline for listing Docker services
+	SystemTimeout time.Duration // Deadline for scanning system services
+}
+
+// NewCollector creates a Collector configured from environment variables, and lets
+// sysScanner ask Docker which containers docker-proxy forwards to:
+//
+//	DOCKLET_HOST_IP         host used in system service URLs
+//	DOCKLET_DOCKER_TIMEOUT  deadline for listing containers (default 10s)
+//	DOCKLET_SYSTEM_TIMEOUT  deadline for scanning native services (default 10s)
+func NewCollector(dockerCli *client.Client, sysScanner *systemscanner.SystemScanner, linkStore *links.Store) (*Collector, error) {
+	dockerTimeout, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_DOCKER_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_DOCKER_TIMEOUT: %w", err)
+	}
+	systemTimeout, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_SYSTEM_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_SYSTEM_TIMEOUT: %w", err)
+	}
+	if dockerCli != nil && sysScanner != nil {
+		sysScanner.ContainerIDs = func(ctx context.Context) (map[string]string, error) {
+			return dockerscanner.ContainerIDsByIP(ctx, dockerCli)
+		}
+	}
+	return &Collector{
+		Docker:        dockerCli,
+		System:        sysScanner,
+		Links:         linkStore,
+		HostIP:        dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost),
+		DockerTimeout: dockerTimeout,
+		SystemTimeout: systemTimeout,
+	}, nil
+}
+
+// DockerServices lists Docker services, giving up after DockerTimeout.
+func (c *Collector) DockerServices(ctx context.Context) ([]dockerscanner.ServiceInfo, error) {
+	ctx, cancel := withTimeout(ctx, c.DockerTimeout)
+	defer cancel()
+	services, err := dockerscanner.ListServices(ctx, c.Docker)
+	return services, timeoutError(ctx, err, SourceDocker, c.DockerTimeout)
+}
+
+// DockerContainers lists every running container, web service or not, giving up after
+// DockerTimeout.
+func (c *Collector) DockerContainers(ctx context.Context) ([]dockerscanner.ServiceInfo, error) {
+	ctx, cancel := withTimeout(ctx, c.DockerTimeout)
+	defer cancel()
+	containers, err := dockerscanner.ListContainers(ctx, c.Docker)
+	return containers, timeoutError(ctx, err, SourceDocker, c.DockerTimeout)
+}
+
+// SystemServices scans native services, giving up after SystemTimeout.
+func (c *Collector) SystemServices(ctx context.Context) ([]systemscanner.SystemServiceInfo, error) {
+	ctx, cancel := withTimeout(ctx, c.SystemTimeout)
+	defer cancel()
+	services, err := c.System.ListServices(ctx)
+	return services, timeoutError(ctx, err, SourceSystem, c.SystemTimeout)
+}
+
+// Listeners lists the host's listening sockets, giving up after SystemTimeout.
+func (c *Collector) Listeners(ctx context.Context) ([]systemscanner.Listener, error) {
+	ctx, cancel := withTimeout(ctx, c.SystemTimeout)
+	defer cancel()
+	listeners, err := c.System.ListListeners(ctx)
+	return listeners, timeoutError(ctx, err, SourceSystem, c.SystemTimeout)
+}
+
+// SourceTimeoutError reports a source that didn't answer within its deadline.
+type SourceTimeoutError struct {
+	Source  string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *SourceTimeoutError) Error() string {
+	return fmt.Sprintf("%s did not answer within %s: %v", e.Source, e.Timeout, e.Err)
+}
+
+func (e *SourceTimeoutError) Unwrap() error { return e.Err }
+
+// withTimeout is context.WithTimeout, except that a zero timeout means none.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError wraps err in a SourceTimeoutError if the source's own deadline expired,
+// as opposed to the caller giving up.
+func timeoutError(ctx context.Context, err error, source string, timeout time.Duration) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &SourceTimeoutError{Source: source, Timeout: timeout, Err: err}
+	}
+	return err
+}
+
+// Warning reports a source that failed while the others were still collected.
+type Warning struct {
+	Source  string `json:"source"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+}
+
+// Warning codes.
+const (
+	WarningDockerUnavailable = "DOCKER_UNAVAILABLE"
+	WarningSourceTimeout     = "SOURCE_TIMEOUT"
+	WarningSourceFailed      = "SOURCE_FAILED"
+)
+
+// Result is a catalog built from whichever sources answered.
+type Result struct {
+	Items    []Entry   `json:"items"`
+	Warnings []Warning `json:"warnings"`
+}
+
+// Collect lists Docker and system services and stored links, and merges them.
+// It fails if any source fails; use CollectPartial to get the sources that answered.
+func (c *Collector) Collect(ctx context.Context) ([]Entry, error) {
+	result := c.CollectPartial(ctx)
+	if len(result.Warnings) > 0 {
+		return nil, result.Warnings[0].Err
+	}
+	return result.Items, nil
+}
+
+// CollectPartial lists all sources like Collect, but keeps going when one fails and
+// reports the failure as a warning instead. Docker and system services are listed
+// concurrently, each within its own deadline.
+func (c *Collector) CollectPartial(ctx context.Context) *Result {
+	result := &Result{Warnings: []Warning{}}
+
+	var systemServices []systemscanner.SystemServiceInfo
+	var systemErr error
+	systemDone := make(chan struct{})
+	go func() {
+		defer close(systemDone)
+		systemServices, systemErr = c.SystemServices(ctx)
+	}()
+
+	dockerServices, err := c.DockerServices(ctx)
+	if err != nil {
+		result.Warnings = append(result.Warnings, NewWarning(SourceDocker, err))
+	}
+	<-systemDone
+	if systemErr != nil {
+		result.Warnings = append(result.Warnings, NewWarning(SourceSystem, systemErr))
+	}
+	result.Items = Merge(dockerServices, systemServices, c.HostIP)
+
+	start := time.Now()
+	storedLinks, err := c.Links.List()
+	metrics.ScanDuration.Observe(time.Since(start).Seconds(), SourceLink)
+	if err != nil {
+		metrics.ScanErrors.Inc(SourceLink)
+		result.Warnings = append(result.Warnings, NewWarning(SourceLink, err))
+	}
+	for _, link := range storedLinks {
+		result.Items = append(result.Items, FromLink(link))
+	}
+
+	// Counts from a partial catalog would look like services disappearing
+	if len(result.Warnings) == 0 {
+		recordServiceCounts(result.Items)
+	}
+	return result
+}
+
+// NewWarning describes the failure of a source.
+func NewWarning(source string, err error) Warning {
+	code := WarningSourceFailed
+	var timeoutErr *SourceTimeoutError
+	switch {
+	case errors.As(err, &timeoutErr):
+		code = WarningSourceTimeout
+	case client.IsErrConnectionFailed(err):
+		code = WarningDockerUnavailable
+	}
+	return Warning{Source: source, Code: code, Message: err.Error(), Err: err}
+}
+
+// recordServiceCounts updates the docklet_services gauge from a fresh catalog.
+func recordServiceCounts(entries []Entry) {
+	counts := make(map[[2]string]int)
+	for _, entry := range entries {
+		counts[[2]string{entry.Source, metrics.StatusLabel(entry.Status)}]++
+	}
+	points := make([]metrics.GaugePoint, 0, len(counts))
+	for key, count := range counts {
+		points = append(points, metrics.GaugePoint{Values: key[:], Value: float64(count)})
+	}
+	metrics.Services.SetAll(points)
+}
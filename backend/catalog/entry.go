@@ -0,0 +1,72 @@
+package catalog
+
+import "time"
+
+// Source values used in Entry.Source.
+const (
+	SourceDocker = "docker"
+	SourceSystem = "system"
+	SourceLink   = "link" // Imported or manually added links
+)
+
+// Entry is the normalized representation of a service, regardless of where it was discovered.
+// It will be serialized to JSON for the /api/catalog endpoint.
+type Entry struct {
+	ID          string            `json:"id"`               // Unique ID, prefixed with the source (e.g. "docker:<container id>")
+	Source      string            `json:"source"`           // Discriminator: "docker", "system" or "link"
+	Name        string            `json:"name"`             // Container name or system service name
+	Title       string            `json:"title"`            // Display title
+	Icon        string            `json:"icon"`             // Icon URL or class
+	URL         string            `json:"url"`              // Access URL; empty for containers only reachable through the proxy
+	Description string            `json:"description"`      // Service description
+	Category    string            `json:"category"`         // Service category
+	Order       string            `json:"order"`            // Order hint, string for now (see docker ServiceInfo)
+	Status      string            `json:"status"`           // e.g. "running", "exited"
+	Ports       []string          `json:"ports"`            // Human readable port list
+	Labels      map[string]string `json:"labels,omitempty"` // Container labels (Docker only)
+	Docker      *DockerDetails    `json:"docker,omitempty"` // Set when Source == "docker"
+	System      *SystemDetails    `json:"system,omitempty"` // Set when Source == "system"
+	Link        *LinkDetails      `json:"link,omitempty"`   // Set when Source == "link"
+
+	Certificate *CertificateDetails `json:"certificate,omitempty"` // Set for https services once their certificate was checked
+}
+
+// DockerDetails holds the fields that only make sense for containers.
+type DockerDetails struct {
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	ImageName     string            `json:"image_name"`
+	Networks      []string          `json:"networks"`
+	NetworkIPs    map[string]string `json:"network_ips,omitempty"`   // Container IP on each network, by network name
+	InternalPort  int               `json:"internal_port,omitempty"` // Port inside the container the service listens on
+}
+
+// SystemDetails holds the fields that only make sense for native system services.
+type SystemDetails struct {
+	PID             string              `json:"pid,omitempty"`
+	PathName        string              `json:"path_name,omitempty"`
+	StartType       string              `json:"start_type,omitempty"`
+	DisplayName     string              `json:"display_name,omitempty"`
+	ListenAddresses map[string][]string `json:"listen_addresses,omitempty"` // Bind addresses by port, where known
+}
+
+// LinkDetails holds the fields that only make sense for stored links.
+type LinkDetails struct {
+	Origin     string    `json:"origin,omitempty"` // Format it was imported from
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// CertificateDetails summarizes the certificate an https service presents.
+type CertificateDetails struct {
+	Level            string     `json:"level"` // ok, warning, critical, expired, or error if it couldn't be read
+	Subject          string     `json:"subject,omitempty"`
+	Issuer           string     `json:"issuer,omitempty"`
+	NotAfter         *time.Time `json:"not_after,omitempty"`
+	DaysLeft         *int       `json:"days_left,omitempty"` // Whole days until NotAfter, negative once expired
+	SelfSigned       bool       `json:"self_signed"`
+	HostnameMismatch bool       `json:"hostname_mismatch"` // Not valid for the host in the service URL
+	Trusted          bool       `json:"trusted"`           // Chains to a root this host trusts
+	VerifyError      string     `json:"verify_error,omitempty"`
+	Error            string     `json:"error,omitempty"` // Why the certificate couldn't be read
+	CheckedAt        time.Time  `json:"checked_at"`
+}
@@ -0,0 +1,297 @@
+// Package certcheck reads the certificates https services present and warns before they
+// expire, flagging self-signed certificates and ones that don't match the service's host.
+package certcheck
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+)
+
+// Levels of a certificate, from best to worst.
+const (
+	LevelOK       = "ok"
+	LevelWarning  = "warning"  // Expires within the warning threshold
+	LevelCritical = "critical" // Expires within the critical threshold
+	LevelExpired  = "expired"
+	LevelError    = "error" // The certificate couldn't be read
+)
+
+const (
+	scanInterval        = time.Minute     // How often the catalog is checked for new https services
+	retryAfter          = 5 * time.Minute // Failed checks are retried after this long
+	maxConcurrentChecks = 4
+)
+
+// Report is the result of the latest check of a service's certificate.
+type Report struct {
+	EntryID     string                     `json:"entry_id"`
+	Name        string                     `json:"name"`
+	Source      string                     `json:"source"`
+	URL         string                     `json:"url"`
+	Address     string                     `json:"address"` // host:port the certificate was read from
+	Certificate catalog.CertificateDetails `json:"certificate"`
+	Chain       []Certificate              `json:"chain,omitempty"` // As presented, leaf first
+}
+
+// Checker checks the certificates of https services in the background.
+type Checker struct {
+	Interval time.Duration // Time between checks of the same service
+	Timeout  time.Duration // Per connection
+	Warn     time.Duration // Certificates expiring sooner are at LevelWarning
+	Critical time.Duration // and sooner still at LevelCritical
+
+	// OnLevelChange, if set, is called from Run for every service whose level changed,
+	// with previous "" the first time a service is checked.
+	OnLevelChange func(report Report, previous string)
+
+	roots *x509.CertPool // nil for the system's roots
+
+	mu      sync.RWMutex
+	reports map[string]Report // Keyed by catalog entry ID
+}
+
+// NewChecker creates a Checker configured from environment variables:
+//
+//	DOCKLET_CERTCHECK_INTERVAL       time between checks of a service (default 6h)
+//	DOCKLET_CERTCHECK_TIMEOUT        per connection timeout (default 5s)
+//	DOCKLET_CERTCHECK_WARN_DAYS      days before expiry a certificate is a warning (default 30)
+//	DOCKLET_CERTCHECK_CRITICAL_DAYS  days before expiry a certificate is critical (default 7)
+//	DOCKLET_CERTCHECK_ROOTS          PEM file with CA certificates to trust besides the system's,
+//	                                 such as a home CA
+func NewChecker() (*Checker, error) {
+	interval, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_CERTCHECK_INTERVAL", "6h"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid DOCKLET_CERTCHECK_INTERVAL: must be a positive duration")
+	}
+	timeout, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_CERTCHECK_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_CERTCHECK_TIMEOUT: %w", err)
+	}
+	warnDays, err := strconv.Atoi(dockerscanner.GetEnvOrDefault("DOCKLET_CERTCHECK_WARN_DAYS", "30"))
+	if err != nil || warnDays < 0 {
+		return nil, fmt.Errorf("invalid DOCKLET_CERTCHECK_WARN_DAYS: must be a number of days")
+	}
+	criticalDays, err := strconv.Atoi(dockerscanner.GetEnvOrDefault("DOCKLET_CERTCHECK_CRITICAL_DAYS", "7"))
+	if err != nil || criticalDays < 0 || criticalDays > warnDays {
+		return nil, fmt.Errorf("invalid DOCKLET_CERTCHECK_CRITICAL_DAYS: must be a number of days no larger than DOCKLET_CERTCHECK_WARN_DAYS")
+	}
+
+	var roots *x509.CertPool
+	if path := dockerscanner.GetEnvOrDefault("DOCKLET_CERTCHECK_ROOTS", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading DOCKLET_CERTCHECK_ROOTS: %w", err)
+		}
+		if roots, err = x509.SystemCertPool(); err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("DOCKLET_CERTCHECK_ROOTS: no certificates in %s", path)
+		}
+	}
+
+	return &Checker{
+		Interval: interval,
+		Timeout:  timeout,
+		Warn:     time.Duration(warnDays) * 24 * time.Hour,
+		Critical: time.Duration(criticalDays) * 24 * time.Hour,
+		roots:    roots,
+		reports:  make(map[string]Report),
+	}, nil
+}
+
+// Run checks the certificates of services returned by collect until ctx is cancelled.
+// New services are checked within a minute; known ones every Interval.
+func (c *Checker) Run(ctx context.Context, collect func(context.Context) ([]catalog.Entry, error)) {
+	ticker := time.NewTicker(scanInterval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := collect(ctx)
+		if ctx.Err() != nil {
+			return // Shutting down
+		}
+		if err != nil {
+			log.Printf("Certificates: failed to collect services: %v", err)
+		} else {
+			c.checkAll(ctx, entries)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll checks the https entries that are due, once per host, and drops the reports of
+// services that are gone.
+func (c *Checker) checkAll(ctx context.Context, entries []catalog.Entry) {
+	now := time.Now()
+	next := make(map[string]Report)
+	due := make(map[string][]catalog.Entry) // By URL host, which shares one connection
+	c.mu.RLock()
+	for _, entry := range entries {
+		u, err := url.Parse(entry.URL)
+		if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+			continue
+		}
+		if report, ok := c.reports[entry.ID]; ok && report.URL == entry.URL && !c.isDue(report, now) {
+			next[entry.ID] = report
+			continue
+		}
+		due[u.Host] = append(due[u.Host], entry)
+	}
+	c.mu.RUnlock()
+
+	var nextMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentChecks)
+	for _, group := range due {
+		wg.Add(1)
+		go func(group []catalog.Entry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			reports := c.check(ctx, group)
+			nextMu.Lock()
+			for _, report := range reports {
+				next[report.EntryID] = report
+			}
+			nextMu.Unlock()
+		}(group)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return // Cancelled mid-round; keep the previous, complete results
+	}
+	c.mu.Lock()
+	previous := c.reports
+	c.reports = next
+	c.mu.Unlock()
+
+	for id, report := range next {
+		old, known := previous[id]
+		if known && old.Certificate.Level == report.Certificate.Level {
+			continue
+		}
+		if report.Certificate.Level != LevelOK {
+			log.Printf("Certificates: %s at %s is %s", report.Name, report.Address, describeLevel(report))
+		}
+		if c.OnLevelChange != nil {
+			c.OnLevelChange(report, old.Certificate.Level)
+		}
+	}
+}
+
+func (c *Checker) isDue(report Report, now time.Time) bool {
+	if report.Certificate.Level == LevelError {
+		return now.Sub(report.Certificate.CheckedAt) >= retryAfter
+	}
+	return now.Sub(report.Certificate.CheckedAt) >= c.Interval
+}
+
+// check reads the certificate of entries sharing one URL host and reports it for each.
+func (c *Checker) check(ctx context.Context, entries []catalog.Entry) []Report {
+	u, _ := url.Parse(entries[0].URL)
+	host := u.Hostname()
+	addr := address(host, u.Port())
+
+	chain, err := fetchChain(ctx, addr, host, c.Timeout)
+	var details catalog.CertificateDetails
+	var described []Certificate
+	if err != nil {
+		details = catalog.CertificateDetails{Level: LevelError, Error: err.Error(), CheckedAt: time.Now()}
+	} else {
+		details = summarize(chain, host, c.roots, time.Now(), c.Warn, c.Critical)
+		described = describe(chain)
+	}
+
+	reports := make([]Report, 0, len(entries))
+	for _, entry := range entries {
+		reports = append(reports, Report{
+			EntryID:     entry.ID,
+			Name:        entry.Name,
+			Source:      entry.Source,
+			URL:         entry.URL,
+			Address:     addr,
+			Certificate: details,
+			Chain:       described,
+		})
+	}
+	return reports
+}
+
+func describeLevel(report Report) string {
+	details := report.Certificate
+	switch details.Level {
+	case LevelError:
+		return "unreadable: " + details.Error
+	case LevelExpired:
+		return fmt.Sprintf("expired since %s", details.NotAfter.Format(time.DateOnly))
+	}
+	return fmt.Sprintf("%s, expires in %d days on %s", details.Level, *details.DaysLeft, details.NotAfter.Format(time.DateOnly))
+}
+
+// Reports returns the latest report of every https service, the certificates expiring
+// first at the top and unreadable ones last.
+func (c *Checker) Reports() []Report {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	reports := make([]Report, 0, len(c.reports))
+	for _, report := range c.reports {
+		reports = append(reports, report)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		a, b := reports[i].Certificate.NotAfter, reports[j].Certificate.NotAfter
+		switch {
+		case a == nil || b == nil:
+			if (a == nil) != (b == nil) {
+				return b == nil
+			}
+		case !a.Equal(*b):
+			return a.Before(*b)
+		}
+		return reports[i].EntryID < reports[j].EntryID
+	})
+	return reports
+}
+
+// Report returns the latest report for one service.
+func (c *Checker) Report(id string) (Report, bool) {
+	if c == nil {
+		return Report{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	report, ok := c.reports[id]
+	return report, ok
+}
+
+// ApplyToEntries sets the certificate details of checked https services.
+func (c *Checker) ApplyToEntries(entries []catalog.Entry) {
+	for i := range entries {
+		if report, ok := c.Report(entries[i].ID); ok && report.URL == entries[i].URL {
+			details := report.Certificate
+			entries[i].Certificate = &details
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package certcheck
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"docklet/catalog"
+)
+
+const day = 24 * time.Hour
+
+// testCA issues certificates for the test services.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-day),
+		NotAfter:              time.Now().Add(365 * day),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue creates a certificate for names expiring after validLeft, which is negative for
+// an expired one. A nil CA issues a self-signed certificate.
+func (ca *testCA) issue(t *testing.T, validLeft time.Duration, names ...string) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now().Add(-400 * day),
+		NotAfter:     time.Now().Add(validLeft),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := [][]byte{der}
+	if ca != nil {
+		chain = append(chain, ca.cert.Raw)
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key}
+}
+
+// service is an https server whose certificate can be replaced between checks.
+type service struct {
+	URL        string
+	cert       atomic.Pointer[tls.Certificate]
+	handshakes atomic.Int32
+}
+
+func serve(t *testing.T, cert *tls.Certificate) *service {
+	t.Helper()
+	s := &service{}
+	s.cert.Store(cert)
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		s.handshakes.Add(1)
+		return &tls.Config{Certificates: []tls.Certificate{*s.cert.Load()}}, nil
+	}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	s.URL = server.URL
+	return s
+}
+
+func newTestChecker(roots ...*testCA) *Checker {
+	pool := x509.NewCertPool()
+	for _, ca := range roots {
+		pool.AddCert(ca.cert)
+	}
+	return &Checker{
+		Interval: time.Hour,
+		Timeout:  5 * time.Second,
+		Warn:     30 * day,
+		Critical: 7 * day,
+		roots:    pool,
+		reports:  make(map[string]Report),
+	}
+}
+
+func entry(id, url string) catalog.Entry {
+	return catalog.Entry{ID: id, Name: id, Source: catalog.SourceLink, URL: url}
+}
+
+func TestCheckerLevels(t *testing.T) {
+	ca := newTestCA(t, "Home CA")
+	otherCA := newTestCA(t, "Unknown CA")
+	checker := newTestChecker(ca)
+
+	closed := httptest.NewUnstartedServer(http.NotFoundHandler())
+	closedAddr := closed.Listener.Addr().String()
+	closed.Listener.Close()
+
+	entries := []catalog.Entry{
+		entry("ok", serve(t, ca.issue(t, 90*day, "127.0.0.1")).URL),
+		entry("warning", serve(t, ca.issue(t, 20*day, "127.0.0.1")).URL),
+		entry("critical", serve(t, ca.issue(t, 3*day, "127.0.0.1")).URL),
+		entry("expired", serve(t, ca.issue(t, -2*day, "127.0.0.1")).URL),
+		entry("self-signed", serve(t, (*testCA)(nil).issue(t, 90*day, "127.0.0.1")).URL),
+		entry("mismatch", serve(t, ca.issue(t, 90*day, "other.example")).URL),
+		entry("untrusted", serve(t, otherCA.issue(t, 90*day, "127.0.0.1")).URL),
+		entry("unreachable", "https://"+closedAddr),
+		entry("plain-http", "http://127.0.0.1:1"),
+	}
+	checker.checkAll(context.Background(), entries)
+
+	for _, tt := range []struct {
+		id                            string
+		level                         string
+		daysLeft                      int
+		selfSigned, mismatch, trusted bool
+		verifyError                   string
+	}{
+		{"ok", LevelOK, 89, false, false, true, ""},
+		{"warning", LevelWarning, 19, false, false, true, ""},
+		{"critical", LevelCritical, 2, false, false, true, ""},
+		{"expired", LevelExpired, -2, false, false, false, "expired"},
+		{"self-signed", LevelOK, 89, true, false, false, "unknown authority"},
+		// The host name is reported separately from whether the chain is trusted
+		{"mismatch", LevelOK, 89, false, true, true, ""},
+		{"untrusted", LevelOK, 89, false, false, false, "unknown authority"},
+	} {
+		t.Run(tt.id, func(t *testing.T) {
+			report, ok := checker.Report(tt.id)
+			if !ok {
+				t.Fatal("not checked")
+			}
+			got := report.Certificate
+			if got.Level != tt.level || got.DaysLeft == nil || *got.DaysLeft != tt.daysLeft {
+				t.Errorf("level %s with %v days left, want %s with %d", got.Level, got.DaysLeft, tt.level, tt.daysLeft)
+			}
+			if got.SelfSigned != tt.selfSigned || got.HostnameMismatch != tt.mismatch || got.Trusted != tt.trusted {
+				t.Errorf("self-signed %t, mismatch %t, trusted %t; want %t, %t, %t", got.SelfSigned, got.HostnameMismatch, got.Trusted, tt.selfSigned, tt.mismatch, tt.trusted)
+			}
+			if !strings.Contains(got.VerifyError, tt.verifyError) || (tt.verifyError == "") != (got.VerifyError == "") {
+				t.Errorf("verify error %q, want %q", got.VerifyError, tt.verifyError)
+			}
+			if len(report.Chain) == 0 || report.Chain[0].Subject != got.Subject {
+				t.Errorf("chain %+v doesn't start with the leaf", report.Chain)
+			}
+		})
+	}
+
+	report, ok := checker.Report("unreachable")
+	if !ok || report.Certificate.Level != LevelError || report.Certificate.Error == "" || report.Certificate.NotAfter != nil {
+		t.Errorf("unreachable service report = %+v, want an error", report)
+	}
+	if _, ok := checker.Report("plain-http"); ok {
+		t.Error("plain http service was checked")
+	}
+}
+
+func TestCheckerReportsOrder(t *testing.T) {
+	ca := newTestCA(t, "Home CA")
+	checker := newTestChecker(ca)
+	closed := httptest.NewUnstartedServer(http.NotFoundHandler())
+	closedAddr := closed.Listener.Addr().String()
+	closed.Listener.Close()
+
+	checker.checkAll(context.Background(), []catalog.Entry{
+		entry("b-unreachable", "https://"+closedAddr),
+		entry("c-later", serve(t, ca.issue(t, 200*day, "127.0.0.1")).URL),
+		entry("d-soon", serve(t, ca.issue(t, 5*day, "127.0.0.1")).URL),
+		entry("a-unreachable", "https://"+closedAddr+"/other"),
+		entry("e-expired", serve(t, ca.issue(t, -day, "127.0.0.1")).URL),
+	})
+
+	var order []string
+	for _, report := range checker.Reports() {
+		order = append(order, report.EntryID)
+	}
+	// Soonest expiry first, unreadable last and by ID
+	want := "e-expired d-soon c-later a-unreachable b-unreachable"
+	if got := strings.Join(order, " "); got != want {
+		t.Errorf("Reports() order = %s, want %s", got, want)
+	}
+}
+
+func TestCheckerOnLevelChange(t *testing.T) {
+	ca := newTestCA(t, "Home CA")
+	checker := newTestChecker(ca)
+	var changes []string
+	checker.OnLevelChange = func(report Report, previous string) {
+		changes = append(changes, report.EntryID+": "+previous+" -> "+report.Certificate.Level)
+	}
+	expectChanges := func(want ...string) {
+		t.Helper()
+		if got := strings.Join(changes, ", "); got != strings.Join(want, ", ") {
+			t.Errorf("level changes = %q, want %q", got, strings.Join(want, ", "))
+		}
+		changes = nil
+	}
+
+	svc := serve(t, ca.issue(t, 90*day, "127.0.0.1"))
+	entries := []catalog.Entry{entry("web", svc.URL), entry("web-admin", svc.URL+"/admin")}
+	checker.checkAll(context.Background(), entries)
+	sort.Strings(changes) // Reported in map order
+	expectChanges("web-admin:  -> ok", "web:  -> ok")
+	// Entries on the same host share one connection
+	if n := svc.handshakes.Load(); n != 1 {
+		t.Errorf("%d handshakes for two entries on one host, want 1", n)
+	}
+
+	// Not checked again before Interval
+	svc.cert.Store(ca.issue(t, 3*day, "127.0.0.1"))
+	checker.checkAll(context.Background(), entries[:1])
+	expectChanges()
+	if n := svc.handshakes.Load(); n != 1 {
+		t.Errorf("checked again before the interval passed")
+	}
+	if _, ok := checker.Report("web-admin"); ok {
+		t.Error("report kept for a service that's gone")
+	}
+
+	// Due again: the renewed-too-late certificate is critical
+	checker.Interval = time.Nanosecond
+	checker.checkAll(context.Background(), entries[:1])
+	expectChanges("web: ok -> critical")
+
+	// Unchanged level: no call
+	checker.checkAll(context.Background(), entries[:1])
+	expectChanges()
+
+	svc.cert.Store(ca.issue(t, -time.Hour, "127.0.0.1"))
+	checker.checkAll(context.Background(), entries[:1])
+	expectChanges("web: critical -> expired")
+
+	svc.cert.Store(ca.issue(t, 90*day, "127.0.0.1"))
+	checker.checkAll(context.Background(), entries[:1])
+	expectChanges("web: expired -> ok")
+}
@@ -0,0 +1,127 @@
+package certcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"time"
+
+	"docklet/catalog"
+)
+
+// Certificate describes one certificate of the chain a service presents.
+type Certificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	DNSNames     []string  `json:"dns_names,omitempty"`
+	IPAddresses  []string  `json:"ip_addresses,omitempty"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	IsCA         bool      `json:"is_ca"`
+	SHA256       string    `json:"sha256"` // Fingerprint of the DER encoding, in hex
+}
+
+// fetchChain connects to address and returns the certificates the server presents, leaf
+// first. host is sent for SNI unless it is an IP address. Nothing is verified here, so
+// expired and self-signed certificates can still be reported.
+func fetchChain(ctx context.Context, address, host string, timeout time.Duration) ([]*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	chain := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	return chain, nil
+}
+
+// summarize describes chain as presented for host at now. roots are the trusted roots,
+// nil for the system's.
+func summarize(chain []*x509.Certificate, host string, roots *x509.CertPool, now time.Time, warn, critical time.Duration) catalog.CertificateDetails {
+	leaf := chain[0]
+	notAfter := leaf.NotAfter
+	daysLeft := int(notAfter.Sub(now).Hours() / 24)
+	details := catalog.CertificateDetails{
+		Level:            level(notAfter.Sub(now), warn, critical),
+		Subject:          leaf.Subject.String(),
+		Issuer:           leaf.Issuer.String(),
+		NotAfter:         &notAfter,
+		DaysLeft:         &daysLeft,
+		SelfSigned:       selfSigned(leaf),
+		HostnameMismatch: leaf.VerifyHostname(host) != nil,
+		CheckedAt:        now,
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	// The host name is checked separately, so a mismatch doesn't hide an untrusted issuer
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: now})
+	details.Trusted = err == nil
+	if err != nil {
+		details.VerifyError = err.Error()
+	}
+	return details
+}
+
+// level classifies a certificate by the time left until it expires.
+func level(left, warn, critical time.Duration) string {
+	switch {
+	case left <= 0:
+		return LevelExpired
+	case left < critical:
+		return LevelCritical
+	case left < warn:
+		return LevelWarning
+	}
+	return LevelOK
+}
+
+// selfSigned reports whether cert is signed by its own key. CheckSignatureFrom would
+// require a CA certificate, and self-signed service certificates rarely are one.
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+func describe(chain []*x509.Certificate) []Certificate {
+	result := make([]Certificate, 0, len(chain))
+	for _, cert := range chain {
+		fingerprint := sha256.Sum256(cert.Raw)
+		c := Certificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: cert.SerialNumber.Text(16),
+			DNSNames:     cert.DNSNames,
+			NotBefore:    cert.NotBefore,
+			NotAfter:     cert.NotAfter,
+			IsCA:         cert.IsCA,
+			SHA256:       hex.EncodeToString(fingerprint[:]),
+		}
+		for _, ip := range cert.IPAddresses {
+			c.IPAddresses = append(c.IPAddresses, ip.String())
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// address returns host:port to connect to for an https URL host, defaulting to port 443.
+func address(host, port string) string {
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,45 @@
+package certcheck
+
+import (
+	"sort"
+
+	"docklet/metrics"
+)
+
+// RegisterMetrics exposes docklet_service_certificate_expiry_timestamp_seconds on the default
+// metrics registry, labelled by service name and source, for alerting on expiring
+// certificates. Like the monitor's metrics, at most maxServices services are exported and
+// maxServices <= 0 disables them.
+func (c *Checker) RegisterMetrics(maxServices int) {
+	if maxServices <= 0 {
+		return
+	}
+	metrics.Default.RegisterCollector(func() []metrics.Family {
+		reports := c.Reports()
+		sort.Slice(reports, func(i, j int) bool {
+			if reports[i].Source != reports[j].Source {
+				return reports[i].Source < reports[j].Source
+			}
+			return reports[i].Name < reports[j].Name
+		})
+
+		expiry := metrics.Family{
+			Name: "docklet_service_certificate_expiry_timestamp_seconds",
+			Help: "Unix time the certificate presented by the service expires.",
+			Type: "gauge",
+		}
+		seen := make(map[[2]string]bool)
+		for _, report := range reports {
+			key := [2]string{report.Name, report.Source}
+			if report.Certificate.NotAfter == nil || seen[key] || len(seen) >= maxServices {
+				continue
+			}
+			seen[key] = true
+			expiry.Samples = append(expiry.Samples, metrics.Sample{
+				Labels: []metrics.Label{{Name: "service", Value: report.Name}, {Name: "source", Value: report.Source}},
+				Value:  float64(report.Certificate.NotAfter.Unix()),
+			})
+		}
+		return []metrics.Family{expiry}
+	})
+}
@@ -0,0 +1,250 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"docklet/internal/fileutil"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 90 * 24 * time.Hour
+	renewBefore  = 30 * 24 * time.Hour // Leaf certificates are reissued when less is left
+)
+
+// The local CA may always issue certificates for names under these domains, mDNS names and
+// a common suffix on home networks, and for addresses in these networks: loopback,
+// private, shared (CGNAT, used by VPNs such as Tailscale) and link-local addresses.
+var (
+	localDomains  = []string{".local", ".lan"}
+	localNetworks = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16", "::1/128", "fc00::/7", "fe80::/10"}
+)
+
+// authority is the local CA that issues Docklet's certificates.
+type authority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// loadOrCreateCA reads the CA from dir, creating it on first use. The CA is limited with
+// name constraints to hosts, domains and local names and addresses, so its key can't be
+// used to impersonate other sites to devices that trust it. An expired CA, or one that
+// can't issue certificates for the host names in hosts and domains, is replaced, so
+// devices have to trust the new one. Addresses change more often, such as IPv6 addresses
+// from the ISP's prefix, and are left out of certificates instead.
+func loadOrCreateCA(dir string, hosts, domains []string) (*authority, error) {
+	certPath, keyPath := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	ca, err := loadCA(certPath, keyPath)
+	switch {
+	case err == nil && !time.Now().Before(ca.cert.NotAfter):
+		log.Printf("TLS: local CA expired on %s, creating a new one; devices need to trust it again", ca.cert.NotAfter.Format(time.DateOnly))
+	case err == nil && len(ca.cert.PermittedDNSDomains) == 0:
+		log.Printf("TLS: local CA has no name constraints, creating a new one; devices need to trust it again")
+	case err == nil:
+		name := ""
+		for _, n := range append(append([]string(nil), hosts...), domains...) {
+			if net.ParseIP(n) == nil && !ca.permits(n) {
+				name = n
+				break
+			}
+		}
+		if name == "" {
+			return ca, nil
+		}
+		log.Printf("TLS: local CA can't issue certificates for %s, creating a new one; devices need to trust it again", name)
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("reading local CA from %s: %w", dir, err)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "Docklet local CA " + hostname, Organization: []string{"Docklet"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+
+		PermittedDNSDomainsCritical: true,
+	}
+	template.PermittedDNSDomains, template.PermittedIPRanges = nameConstraints(hosts, domains)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	// The key first: a certificate without its key would be unusable on the next start
+	if err := fileutil.WriteFileAtomic(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, err
+	}
+	if err := fileutil.WriteFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, err
+	}
+	log.Printf("TLS: created local CA in %s", dir)
+	return loadCA(certPath, keyPath)
+}
+
+func loadCA(certPath, keyPath string) (*authority, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate with a signing key", certPath)
+	}
+	return &authority{cert: cert, certPEM: certPEM, key: key}, nil
+}
+
+// nameConstraints returns the DNS domains and IP ranges a CA for hosts and domains is
+// permitted to issue certificates for.
+func nameConstraints(hosts, domains []string) ([]string, []*net.IPNet) {
+	dnsDomains := append([]string(nil), localDomains...)
+	var ipRanges []*net.IPNet
+	for _, network := range localNetworks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			panic(err)
+		}
+		ipRanges = append(ipRanges, ipNet)
+	}
+	for _, name := range append(append([]string(nil), hosts...), domains...) {
+		if ip := net.ParseIP(name); ip != nil {
+			if !containsIP(ipRanges, ip) {
+				bits := 8 * len(ip.To16())
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 32
+				}
+				ipRanges = append(ipRanges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+		} else if name != "" && !domainPermitted(dnsDomains, name) {
+			dnsDomains = append(dnsDomains, name)
+		}
+	}
+	return dnsDomains, ipRanges
+}
+
+// permits reports whether the CA's name constraints permit name, a host name or IP address.
+func (ca *authority) permits(name string) bool {
+	if ip := net.ParseIP(name); ip != nil {
+		return containsIP(ca.cert.PermittedIPRanges, ip)
+	}
+	return domainPermitted(ca.cert.PermittedDNSDomains, name)
+}
+
+// domainPermitted reports whether name is within one of the name constraints domains: a
+// domain permits itself and its subdomains, and one with a leading dot only its subdomains.
+func domainPermitted(domains []string, name string) bool {
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, ".") {
+			if strings.HasSuffix(name, domain) {
+				return true
+			}
+		} else if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// issue creates a server certificate for names, which may be host names or IP addresses.
+// Names the CA isn't permitted to issue certificates for are left out.
+func (ca *authority) issue(names []string) (*tls.Certificate, error) {
+	var permitted []string
+	for _, name := range names {
+		if ca.permits(name) {
+			permitted = append(permitted, name)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil, fmt.Errorf("the local CA's name constraints don't permit %s", strings.Join(names, ", "))
+	}
+	names = permitted
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: names[0], Organization: []string{"Docklet"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	return serial
+}
@@ -0,0 +1,148 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func verify(ca *authority, name string) error {
+	cert, err := ca.issue([]string{name})
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+	return err
+}
+
+func TestCANameConstraints(t *testing.T) {
+	hosts := []string{"localhost", "nas", "nas.local", "192.168.1.10", "203.0.113.7", "2001:db8::7"}
+	ca, err := loadOrCreateCA(t.TempDir(), hosts, []string{"home.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.cert.PermittedDNSDomainsCritical {
+		t.Error("name constraints aren't critical")
+	}
+	// nas.local is already covered by .local
+	wantDNS := []string{".local", ".lan", "localhost", "nas", "home.example"}
+	if got := ca.cert.PermittedDNSDomains; len(got) != len(wantDNS) {
+		t.Errorf("PermittedDNSDomains = %q, want %q", got, wantDNS)
+	} else {
+		for i := range got {
+			if got[i] != wantDNS[i] {
+				t.Errorf("PermittedDNSDomains = %q, want %q", got, wantDNS)
+				break
+			}
+		}
+	}
+
+	for _, name := range []string{"localhost", "nas", "nas.local", "printer.lan", "grafana.home.example", "192.168.1.10", "10.1.2.3", "fd00::1", "203.0.113.7", "2001:db8::7"} {
+		if err := verify(ca, name); err != nil {
+			t.Errorf("certificate for %s: %v", name, err)
+		}
+	}
+
+	// Certificates for other names don't verify, even if the CA's key is used directly
+	for _, name := range []string{"example.com", "home.example.com", "lan", "203.0.113.8", "2001:db8::8"} {
+		if _, err := ca.issue([]string{name}); err == nil {
+			t.Errorf("issued a certificate for %s", name)
+		}
+		cert, err := ca.issue([]string{"localhost"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := *cert.Leaf
+		template.DNSNames, template.IPAddresses = nil, nil
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = []net.IP{ip}
+		} else {
+			template.DNSNames = []string{name}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, cert.Leaf.PublicKey, ca.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged, _ := x509.ParseCertificate(der)
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		if _, err := forged.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err == nil {
+			t.Errorf("certificate for %s verifies", name)
+		}
+	}
+}
+
+func TestCAIssueSkipsUnpermittedNames(t *testing.T) {
+	ca, err := loadOrCreateCA(t.TempDir(), []string{"nas.lan"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.issue([]string{"nas.lan", "2001:db8::9", "192.168.1.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Leaf.DNSNames) != 1 || len(cert.Leaf.IPAddresses) != 1 || !cert.Leaf.IPAddresses[0].Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("certificate for %q %v, want the public address left out", cert.Leaf.DNSNames, cert.Leaf.IPAddresses)
+	}
+}
+
+func TestCAReplaced(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := loadOrCreateCA(dir, []string{"nas.lan", "203.0.113.7"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Kept while it covers the host names; a new public address is just left out
+	same, err := loadOrCreateCA(dir, []string{"nas.lan", "203.0.113.8"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same.cert.Equal(ca.cert) {
+		t.Error("CA replaced although it covers the host names")
+	}
+
+	// Replaced when a proxy domain is configured that it can't cover
+	replaced, err := loadOrCreateCA(dir, []string{"nas.lan"}, []string{"home.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.cert.Equal(ca.cert) {
+		t.Fatal("CA kept although it can't issue certificates for home.example")
+	}
+	if err := verify(replaced, "grafana.home.example"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCAWithoutConstraintsReplaced(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := loadOrCreateCA(dir, []string{"nas.lan"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A CA from before name constraints, signed with the same key
+	template := *ca.cert
+	template.PermittedDNSDomains, template.PermittedIPRanges, template.PermittedDNSDomainsCritical = nil, nil, false
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, ca.key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, err := loadOrCreateCA(dir, []string{"nas.lan"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced.cert.PermittedDNSDomains) == 0 || replaced.cert.Equal(ca.cert) {
+		t.Error("CA without name constraints kept")
+	}
+}
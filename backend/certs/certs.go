@@ -0,0 +1,222 @@
+// Package certs provides the certificates Docklet serves HTTPS with: files the user
+// provides, or certificates issued by a local CA that devices can be told to trust.
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	dockerscanner "docklet/docker_scanner"
+)
+
+// Modes of a Manager.
+const (
+	ModeFiles = "files" // DOCKLET_TLS_CERT and DOCKLET_TLS_KEY
+	ModeCA    = "ca"    // Local CA in the data directory
+)
+
+// fileCheckInterval is how often certificate files are checked for replacement.
+const fileCheckInterval = 30 * time.Second
+
+// Manager picks the certificate for each TLS handshake.
+type Manager struct {
+	Mode  string
+	Hosts []string // Names and addresses Docklet itself is reached at, in the default certificate
+
+	certFile, keyFile string
+	ca                *authority
+	allowName         func(string) bool
+
+	mu          sync.Mutex
+	fileCert    *tls.Certificate
+	fileModTime time.Time
+	fileChecked time.Time
+	issued      map[string]*tls.Certificate // By SNI name; "" is the default certificate
+}
+
+// NewManager creates a Manager configured from environment variables:
+//
+//	DOCKLET_TLS_CERT / DOCKLET_TLS_KEY  PEM certificate chain and key to serve; reloaded when the files change
+//	                                    (default: certificates from a local CA kept in <dataDir>/tls)
+//	DOCKLET_TLS_HOSTS                   comma-separated names and addresses for the local CA's certificate
+//	                                    (default: localhost, the host name and the addresses of this host)
+//
+// With the local CA, allowName decides which other names requested with SNI get a
+// certificate of their own, such as services behind the proxy; it may be nil. Those names
+// must be under one of domains, or local names ending in .local or .lan, since the CA
+// can't issue certificates for others.
+func NewManager(dataDir string, domains []string, allowName func(string) bool) (*Manager, error) {
+	certFile := dockerscanner.GetEnvOrDefault("DOCKLET_TLS_CERT", "")
+	keyFile := dockerscanner.GetEnvOrDefault("DOCKLET_TLS_KEY", "")
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("DOCKLET_TLS_CERT and DOCKLET_TLS_KEY must be set together")
+	}
+	if certFile != "" {
+		m := &Manager{Mode: ModeFiles, certFile: certFile, keyFile: keyFile}
+		if _, err := m.loadFiles(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(dockerscanner.GetEnvOrDefault("DOCKLET_TLS_HOSTS", ""), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = defaultHosts()
+	}
+	ca, err := loadOrCreateCA(filepath.Join(dataDir, "tls"), hosts, domains)
+	if err != nil {
+		return nil, err
+	}
+	if allowName == nil {
+		allowName = func(string) bool { return false }
+	}
+	return &Manager{
+		Mode:      ModeCA,
+		Hosts:     hosts,
+		ca:        ca,
+		allowName: allowName,
+		issued:    make(map[string]*tls.Certificate),
+	}, nil
+}
+
+// TLSConfig returns a server configuration that uses m's certificates.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// GetCertificate returns the certificate for a handshake. With the local CA, names allowed
+// by allowName get their own certificate; everything else gets the default certificate.
+// Certificates are reissued a month before they expire.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if m.Mode == ModeFiles {
+		return m.loadFiles()
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if m.isHost(name) || !m.allowName(name) {
+		name = ""
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cert := m.issued[name]; cert != nil && time.Until(cert.Leaf.NotAfter) > renewBefore {
+		return cert, nil
+	}
+	names := m.Hosts
+	if name != "" {
+		names = []string{name}
+	}
+	cert, err := m.ca.issue(names)
+	if err != nil {
+		return nil, fmt.Errorf("issuing certificate for %s: %w", strings.Join(names, ", "), err)
+	}
+	m.issued[name] = cert
+	return cert, nil
+}
+
+// CACertificate returns the local CA's certificate in PEM, or nil if Docklet serves
+// certificates from files.
+func (m *Manager) CACertificate() []byte {
+	if m == nil || m.ca == nil {
+		return nil
+	}
+	return m.ca.certPEM
+}
+
+// CACertificateDER returns the local CA's certificate in DER, the form phones install.
+func (m *Manager) CACertificateDER() []byte {
+	if m == nil || m.ca == nil {
+		return nil
+	}
+	return m.ca.cert.Raw
+}
+
+func (m *Manager) isHost(name string) bool {
+	for _, host := range m.Hosts {
+		if host == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFiles returns the certificate from the files, reading them again if they changed,
+// so a renewed certificate is picked up without a restart. If the new files can't be
+// loaded, for example while only one of them has been replaced, the old certificate is kept.
+func (m *Manager) loadFiles() (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fileCert != nil && time.Since(m.fileChecked) < fileCheckInterval {
+		return m.fileCert, nil
+	}
+	m.fileChecked = time.Now()
+
+	info, err := os.Stat(m.certFile)
+	if err == nil && m.fileCert != nil && info.ModTime().Equal(m.fileModTime) {
+		return m.fileCert, nil
+	}
+	cert, loadErr := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		if m.fileCert != nil {
+			log.Printf("TLS: keeping the current certificate, failed to load %s: %v", m.certFile, err)
+			return m.fileCert, nil
+		}
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	if m.fileCert != nil {
+		log.Printf("TLS: loaded new certificate from %s, valid until %s", m.certFile, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	m.fileCert = &cert
+	m.fileModTime = info.ModTime()
+	return m.fileCert, nil
+}
+
+// defaultHosts returns localhost, the host name and this host's addresses.
+func defaultHosts() []string {
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hostname = strings.ToLower(hostname)
+		hosts = append(hosts, hostname)
+		if !strings.Contains(hostname, ".") {
+			hosts = append(hosts, hostname+".local")
+		}
+	}
+	if host := dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", ""); host != "" && host != "localhost" {
+		hosts = append(hosts, strings.ToLower(host))
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+
+	seen := make(map[string]bool)
+	unique := hosts[:0]
+	for _, host := range hosts {
+		if !seen[host] {
+			seen[host] = true
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/enricher"
+	"docklet/export"
+	"docklet/importer"
+	"docklet/links"
+	"docklet/portforward"
+	systemscanner "docklet/system_scanner"
+)
+
+const usage = `Usage: docklet [command] [flags]
+
+Without a command, docklet starts the web server.
+
+Commands:
+  export    Render discovered services as another dashboard's config
+  import    Store links from a Homer/Homepage config or bookmarks file
+  portforward
+            Sync port forwards on an OpenWrt router with discovered ports
+`
+
+// runCommand runs a CLI subcommand and returns the process exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "export":
+		return runExport(args)
+	case "import":
+		return runImport(args)
+	case "portforward":
+		return runPortForward(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+}
+
+// runExport implements "docklet export".
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "homer", "output format: "+strings.Join(export.Formats(), ", "))
+	output := flags.String("o", "", "write to this file instead of stdout")
+	title := flags.String("title", "", "dashboard title (default \"Docklet\")")
+	baseURL := flags.String("base-url", defaultBaseURL(), "Docklet URL used for icon links")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if _, ok := export.Lookup(*format); !ok {
+		fmt.Fprintf(os.Stderr, "Unknown format %q (supported: %s)\n", *format, strings.Join(export.Formats(), ", "))
+		return 2
+	}
+
+	collector, cleanup, err := newCollector()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer cleanup()
+
+	entries, err := collector.Collect(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list services: %v\n", err)
+		return 1
+	}
+
+	// Reuse titles and icons the server has already fetched; don't fetch anything here.
+	dataDir := dockerscanner.GetEnvOrDefault("DOCKLET_DATA_DIR", DefaultDataDir)
+	if enr, err := enricher.NewEnricher(dataDir); err == nil {
+		enr.ApplyToEntries(entries)
+	}
+
+	data, err := export.Render(*format, entries, export.Options{Title: *title, BaseURL: *baseURL})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+// runImport implements "docklet import".
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "input format: "+strings.Join(importer.Formats(), ", "))
+	dryRun := flags.Bool("dry-run", false, "only show what would be imported")
+	keepConflicts := flags.Bool("keep-conflicts", false, "also import links that match a discovered service")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: docklet import --format <format> [flags] <file>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || !importer.IsFormat(*format) {
+		flags.Usage()
+		return 2
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	parsed, err := importer.Parse(*format, data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	collector, cleanup, err := newCollector()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer cleanup()
+
+	discovered, err := collector.Collect(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list services: %v\n", err)
+		return 1
+	}
+
+	result, err := importer.Import(collector.Links, parsed, discovered, importer.Options{DryRun: *dryRun, KeepConflicts: *keepConflicts})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store links: %v\n", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+	return 0
+}
+
+// runPortForward implements "docklet portforward". It only shows the plan unless --apply is given.
+func runPortForward(args []string) int {
+	flags := flag.NewFlagSet("portforward", flag.ContinueOnError)
+	apply := flags.Bool("apply", false, "change the router's firewall instead of only showing the plan")
+	asJSON := flags.Bool("json", false, "print the plan as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: docklet portforward [--apply] [--json]")
+		fmt.Fprintln(flags.Output(), "The router is configured with DOCKLET_OPENWRT_* environment variables and")
+		fmt.Fprintln(flags.Output(), "what is forwarded with DOCKLET_PORTFORWARD_* and the docklet.expose=wan container label.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	collector, cleanup, err := newCollector()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer cleanup()
+
+	manager, err := portforward.NewManager(collector)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	router := manager.Router
+
+	ctx := context.Background()
+	var plan *portforward.Plan
+	if *apply {
+		plan, err = manager.Apply(ctx, "")
+	} else {
+		plan, err = manager.Plan(ctx)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to sync %s: %v\n", router.Addr(), err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(plan)
+		return 0
+	}
+	for _, rule := range plan.Add {
+		fmt.Printf("+ %s %s %d -> %s:%d (%s)\n", rule.Name, rule.Proto, rule.SrcPort, rule.DestIP, rule.DestPort, rule.Service)
+	}
+	for _, change := range plan.Modify {
+		r, rule := change.Redirect, change.Rule
+		fmt.Printf("~ %s %s %s -> %s:%s => %s %d -> %s:%d (%s)\n", r.Name(), r.Options["proto"], r.Options["src_dport"], r.Options["dest_ip"], r.Options["dest_port"],
+			rule.Proto, rule.SrcPort, rule.DestIP, rule.DestPort, rule.Service)
+	}
+	for _, r := range plan.Delete {
+		fmt.Printf("- %s %s %s -> %s:%s\n", r.Name(), r.Options["proto"], r.Options["src_dport"], r.Options["dest_ip"], r.Options["dest_port"])
+	}
+	for _, skip := range plan.Skipped {
+		fmt.Printf("  skipped %s %d (%s): %s\n", skip.Proto, skip.Port, skip.Service, skip.Reason)
+	}
+	switch {
+	case plan.Empty():
+		fmt.Printf("%s is up to date (%d rules)\n", router.Addr(), plan.Unchanged)
+	case *apply:
+		fmt.Printf("Applied to %s: %d added, %d modified, %d deleted, %d unchanged\n", router.Addr(), len(plan.Add), len(plan.Modify), len(plan.Delete), plan.Unchanged)
+	default:
+		fmt.Printf("Dry run: %d to add, %d to modify, %d to delete, %d unchanged. Run with --apply to change %s.\n", len(plan.Add), len(plan.Modify), len(plan.Delete), plan.Unchanged, router.Addr())
+	}
+	return 0
+}
+
+// newCollector creates the scanners used by CLI commands. The returned function closes them.
+func newCollector() (*catalog.Collector, func(), error) {
+	dockerCli, err := dockerscanner.NewScanner()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize Docker scanner: %w", err)
+	}
+	sysScanner, err := systemscanner.NewSystemScanner()
+	if err != nil {
+		dockerCli.Close()
+		return nil, nil, fmt.Errorf("failed to initialize System scanner: %w", err)
+	}
+	linkStore, err := links.NewStore(dockerscanner.GetEnvOrDefault("DOCKLET_DATA_DIR", DefaultDataDir))
+	if err != nil {
+		dockerCli.Close()
+		sysScanner.Close()
+		return nil, nil, fmt.Errorf("failed to open link store: %w", err)
+	}
+	cleanup := func() {
+		dockerCli.Close()
+		sysScanner.Close()
+	}
+	collector, err := catalog.NewCollector(dockerCli, sysScanner, linkStore)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return collector, cleanup, nil
+}
+
+// defaultBaseURL is the URL the server is reachable at, from DOCKLET_PUBLIC_URL or host and port.
+func defaultBaseURL() string {
+	if publicURL := os.Getenv("DOCKLET_PUBLIC_URL"); publicURL != "" {
+		return publicURL
+	}
+	host := dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost)
+	return "http://" + host + ":" + dockerscanner.GetEnvOrDefault("DOCKLET_PORT", DefaultPort)
+}
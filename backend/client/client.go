@@ -0,0 +1,377 @@
+// Package client is a typed Go client for the Docklet HTTP API, for tools that would
+// otherwise decode the JSON by hand. It uses the same types the server serializes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"docklet/catalog"
+	"docklet/certcheck"
+	"docklet/dnsrecords"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/health"
+	"docklet/importer"
+	"docklet/links"
+	"docklet/mdns"
+	"docklet/notify"
+	"docklet/openapi"
+	"docklet/portaudit"
+	"docklet/portforward"
+	"docklet/proxy"
+	systemscanner "docklet/system_scanner"
+)
+
+// APIPrefix is the path prefix of the API version this client speaks.
+const APIPrefix = "/api/v1"
+
+// DefaultTimeout bounds each request made by a client from New.
+const DefaultTimeout = 30 * time.Second
+
+// Client talks to one Docklet instance.
+type Client struct {
+	BaseURL    string // e.g. http://nas.local:8888
+	Token      string // Bearer token for endpoints that need one, e.g. DOCKLET_PORTFORWARD_TOKEN
+	HTTPClient *http.Client
+}
+
+// APIError is returned when Docklet answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Code       string // e.g. DOCKER_UNAVAILABLE; empty if the body wasn't an error envelope
+	Message    string
+	Details    interface{}
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("docklet: %d %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("docklet: %d %s: %s (request %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// New returns a client for the Docklet instance at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// Services lists Docker services (GET /api/v1/services).
+func (c *Client) Services(ctx context.Context) ([]dockerscanner.ServiceInfo, error) {
+	var services []dockerscanner.ServiceInfo
+	err := c.getJSON(ctx, "/services", nil, &services)
+	return services, err
+}
+
+// SystemServices lists native services that are likely web services (GET /api/v1/system-services).
+func (c *Client) SystemServices(ctx context.Context) ([]systemscanner.SystemServiceInfo, error) {
+	var services []systemscanner.SystemServiceInfo
+	err := c.getJSON(ctx, "/system-services", nil, &services)
+	return services, err
+}
+
+// Catalog lists all services and stored links (GET /api/v1/v1/catalog). If a source
+// failed, the result holds the others plus a warning for the failed source.
+func (c *Client) Catalog(ctx context.Context) (*catalog.Result, error) {
+	var result catalog.Result
+	if err := c.getJSON(ctx, "/catalog", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Links lists stored links (GET /api/v1/links).
+func (c *Client) Links(ctx context.Context) ([]links.Link, error) {
+	var stored []links.Link
+	err := c.getJSON(ctx, "/links", nil, &stored)
+	return stored, err
+}
+
+// DeleteLink removes a stored link (DELETE /api/v1/links/{id}).
+func (c *Client) DeleteLink(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/links/"+url.PathEscape(id), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Import imports links from a Homer/Homepage config or bookmarks file (POST /api/v1/import).
+func (c *Client) Import(ctx context.Context, format string, data []byte, opts importer.Options) (*importer.Result, error) {
+	query := url.Values{"format": {format}}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	if opts.KeepConflicts {
+		query.Set("keep_conflicts", "true")
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/import", query, bytes.NewReader(data), "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result importer.Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("docklet: decoding import result: %w", err)
+	}
+	return &result, nil
+}
+
+// Export renders the catalog in another dashboard's format (GET /api/v1/export).
+func (c *Client) Export(ctx context.Context, format, title string) ([]byte, error) {
+	query := url.Values{"format": {format}}
+	if title != "" {
+		query.Set("title", title)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/export", query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Ports lists the host ports in use, with warnings about conflicts and exposed services
+// (GET /api/v1/ports).
+func (c *Client) Ports(ctx context.Context) (*portaudit.Report, error) {
+	var report portaudit.Report
+	if err := c.getJSON(ctx, "/ports", nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// PortForwardPlan returns the router changes the port-forward policy asks for
+// (GET /api/v1/portforward/plan).
+func (c *Client) PortForwardPlan(ctx context.Context) (*portforward.Plan, error) {
+	var plan portforward.Plan
+	if err := c.getJSON(ctx, "/portforward/plan", nil, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// ApplyPortForwardPlan applies the plan with the given ID to the router
+// (POST /api/v1/portforward/apply). It needs Token. If the plan changed in the meantime,
+// the error is an *APIError with code PLAN_CHANGED and nothing was applied.
+func (c *Client) ApplyPortForwardPlan(ctx context.Context, planID string) (*portforward.Plan, error) {
+	body, err := json.Marshal(openapi.PortForwardApplyRequest{PlanID: planID})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/portforward/apply", nil, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var plan portforward.Plan
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("docklet: decoding applied plan: %w", err)
+	}
+	return &plan, nil
+}
+
+// PortMappings lists the UPnP or NAT-PMP mappings Docklet keeps on the gateway
+// (GET /api/v1/portforward/mappings).
+func (c *Client) PortMappings(ctx context.Context) ([]portforward.Lease, error) {
+	var leases []portforward.Lease
+	err := c.getJSON(ctx, "/portforward/mappings", nil, &leases)
+	return leases, err
+}
+
+// MDNSServices lists the services published with mDNS (GET /api/v1/mdns).
+func (c *Client) MDNSServices(ctx context.Context) ([]mdns.Service, error) {
+	var services []mdns.Service
+	err := c.getJSON(ctx, "/mdns", nil, &services)
+	return services, err
+}
+
+// ProxyRoutes lists the services reachable through the proxy (GET /api/v1/proxy).
+func (c *Client) ProxyRoutes(ctx context.Context) ([]proxy.Route, error) {
+	var routes []proxy.Route
+	err := c.getJSON(ctx, "/proxy", nil, &routes)
+	return routes, err
+}
+
+// Certificates lists the certificates of https services, the earliest expiry first
+// (GET /api/v1/certificates).
+func (c *Client) Certificates(ctx context.Context) ([]certcheck.Report, error) {
+	var reports []certcheck.Report
+	err := c.getJSON(ctx, "/certificates", nil, &reports)
+	return reports, err
+}
+
+// NotifyStatus describes the notification channels, mutes and quiet hours (GET /api/v1/notify).
+func (c *Client) NotifyStatus(ctx context.Context) (*notify.Status, error) {
+	var status notify.Status
+	if err := c.getJSON(ctx, "/notify", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// TestNotify sends a test notification to every channel (POST /api/v1/notify/test).
+func (c *Client) TestNotify(ctx context.Context) ([]notify.ChannelResult, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/notify/test", nil, strings.NewReader("{}"), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var results []notify.ChannelResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("docklet: decoding test results: %w", err)
+	}
+	return results, nil
+}
+
+// MuteService mutes notifications about a service for duration, or until unmuted if
+// duration is 0 (PUT /api/v1/notify/mutes/{service}).
+func (c *Client) MuteService(ctx context.Context, service string, duration time.Duration) (*notify.Mute, error) {
+	query := url.Values{}
+	if duration > 0 {
+		query.Set("for", duration.String())
+	}
+	resp, err := c.do(ctx, http.MethodPut, "/notify/mutes/"+url.PathEscape(service), query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var mute notify.Mute
+	if err := json.NewDecoder(resp.Body).Decode(&mute); err != nil {
+		return nil, fmt.Errorf("docklet: decoding mute: %w", err)
+	}
+	return &mute, nil
+}
+
+// UnmuteService lifts the mute of a service (DELETE /api/v1/notify/mutes/{service}).
+func (c *Client) UnmuteService(ctx context.Context, service string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/notify/mutes/"+url.PathEscape(service), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// CACertificate returns Docklet's local CA certificate in PEM (GET /api/v1/tls/ca.pem).
+func (c *Client) CACertificate(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/tls/ca.pem", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// DNSRecords lists the host names derived for discovered services (GET /api/v1/dns).
+func (c *Client) DNSRecords(ctx context.Context) ([]dnsrecords.Record, error) {
+	var records []dnsrecords.Record
+	err := c.getJSON(ctx, "/dns", nil, &records)
+	return records, err
+}
+
+// RenderDNSRecords renders the host names in a resolver's format, such as "dnsmasq"
+// (GET /api/v1/dns/{format}).
+func (c *Client) RenderDNSRecords(ctx context.Context, format string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/dns/"+url.PathEscape(format), nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Health returns the health status reported by /api/v1/health.
+func (c *Client) Health(ctx context.Context) (string, error) {
+	var health openapi.HealthResponse
+	err := c.getJSON(ctx, "/health", nil, &health)
+	return health.Status, err
+}
+
+// Ready runs the readiness checks (GET /api/v1/health/ready). A report is returned
+// whether or not Docklet is ready; check Report.Ready.
+func (c *Client) Ready(ctx context.Context) (*health.Report, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/health/ready", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Not being ready is reported with a 503 and the same body
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, decodeError(resp)
+	}
+	var report health.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("docklet: decoding readiness report: %w", err)
+	}
+	return &report, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("docklet: decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a request and turns non-2xx responses into an *APIError.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	resp, err := c.send(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return resp, nil
+}
+
+// send sends a request to path below APIPrefix.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	target := c.BaseURL + APIPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+func decodeError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body openapi.ErrorResponse
+	if json.Unmarshal(data, &body) == nil && body.Code != "" {
+		return &APIError{StatusCode: resp.StatusCode, Code: body.Code, Message: body.Message, Details: body.Details, RequestID: body.RequestID}
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+}
@@ -0,0 +1,163 @@
+// Package dnsrecords renders host names for discovered services in the formats local DNS
+// resolvers read, such as hosts files, dnsmasq and Unbound configs and AdGuard Home or
+// Pi-hole custom lists, so names follow discovery without editing the resolver by hand.
+package dnsrecords
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"docklet/catalog"
+)
+
+// Container labels controlling the records.
+const (
+	NameLabel    = "docklet.dns.name" // Host name for the service: a label the domain is appended to, or a full name if it contains a dot
+	EnabledLabel = "docklet.dns"      // "false" leaves a service out
+)
+
+// Record maps a host name to the address a service is reached at.
+type Record struct {
+	Hostname string `json:"hostname"` // Fully qualified, without the trailing dot
+	IP       string `json:"ip"`
+	EntryID  string `json:"entry_id"` // Catalog entry the record comes from
+}
+
+// Options control how records are derived from catalog entries.
+type Options struct {
+	Domain string // Appended to service names, e.g. "lan" for grafana.lan
+	IP     string // Address for services whose URL doesn't name a usable IP
+}
+
+// Records derives one record per Docker and system service. The address is taken from the
+// service's URL when it is an IP that other hosts can reach, otherwise opts.IP is used;
+// services left without an address are skipped. Links point elsewhere and are skipped too.
+// Names taken by another service get a -2, -3... suffix. Records are sorted by host name.
+func Records(entries []catalog.Entry, opts Options) []Record {
+	sorted := append([]catalog.Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var records []Record
+	taken := make(map[string]bool)
+	for _, entry := range sorted {
+		if entry.Source == catalog.SourceLink || entry.Labels[EnabledLabel] == "false" {
+			continue
+		}
+		ip := urlIP(entry.URL)
+		if ip == "" || containerIP(entry, ip) {
+			ip = opts.IP
+		}
+		if ip == "" {
+			continue
+		}
+
+		label, domain := entry.Name, opts.Domain
+		if name := strings.TrimSuffix(strings.ToLower(entry.Labels[NameLabel]), "."); name != "" {
+			label, domain = name, ""
+			if i := strings.IndexByte(name, '.'); i >= 0 {
+				label, domain = name[:i], name[i+1:]
+			} else {
+				domain = opts.Domain
+			}
+		}
+		label = catalog.HostLabel(label)
+		if label == "" || !validDomain(domain) {
+			continue
+		}
+
+		label = catalog.UniqueLabel(label, func(label string) bool { return taken[label+"."+domain] })
+		hostname := label + "." + domain
+		taken[hostname] = true
+		records = append(records, Record{Hostname: hostname, IP: ip, EntryID: entry.ID})
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Hostname < records[j].Hostname })
+	return records
+}
+
+// urlIP returns the host of rawURL if it is an IP address other hosts can use, or "".
+func urlIP(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return ""
+	}
+	return ip.String()
+}
+
+// containerIP reports whether ip is the entry's address on a Docker network, which other
+// hosts can't reach; such services are reached through this host.
+func containerIP(entry catalog.Entry, ip string) bool {
+	if entry.Docker == nil {
+		return false
+	}
+	for _, networkIP := range entry.Docker.NetworkIPs {
+		if networkIP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// validDomain reports whether domain is a dot-separated list of DNS labels.
+func validDomain(domain string) bool {
+	if domain == "" || len(domain) > 200 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || catalog.HostLabel(label) != label {
+			return false
+		}
+	}
+	return true
+}
+
+// Format describes an output format.
+type Format struct {
+	Name        string
+	ContentType string
+	FileName    string // Suggested file name for downloads
+	render      func([]Record) []byte
+}
+
+var formats = map[string]Format{}
+
+func register(f Format) {
+	formats[f.Name] = f
+}
+
+// Formats returns the names of all supported formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the format with the given name.
+func Lookup(name string) (Format, bool) {
+	f, ok := formats[strings.ToLower(name)]
+	return f, ok
+}
+
+// Render renders records in the format.
+func (f Format) Render(records []Record) []byte {
+	return f.render(records)
+}
+
+// Render renders records in the named format.
+func Render(name string, records []Record) ([]byte, error) {
+	f, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown DNS format %q (supported: %s)", name, strings.Join(Formats(), ", "))
+	}
+	return f.Render(records), nil
+}
@@ -0,0 +1,76 @@
+package dnsrecords
+
+import (
+	"strings"
+	"testing"
+
+	"docklet/catalog"
+)
+
+func TestRecords(t *testing.T) {
+	docker := func(id, name, url string, labels map[string]string) catalog.Entry {
+		return catalog.Entry{
+			ID: "docker:" + id, Source: catalog.SourceDocker, Name: name, URL: url, Labels: labels,
+			Docker: &catalog.DockerDetails{NetworkIPs: map[string]string{"bridge": "172.17.0.5"}},
+		}
+	}
+	entries := []catalog.Entry{
+		docker("a", "grafana", "http://192.168.1.10:3000", nil),
+		docker("b", "grafana", "http://192.168.1.10:3001", nil),
+		docker("c", "jellyfin", "http://localhost:8096", nil),
+		docker("d", "internal", "http://172.17.0.5:8080", nil),
+		docker("e", "nas", "http://192.168.1.10:5000", map[string]string{NameLabel: "Files"}),
+		docker("f", "wiki", "http://192.168.1.10:8081", map[string]string{NameLabel: "wiki.home.arpa."}),
+		docker("g", "hidden", "http://192.168.1.10:8082", map[string]string{EnabledLabel: "false"}),
+		docker("h", "bad", "http://192.168.1.10:8083", map[string]string{NameLabel: "bad.-domain"}),
+		docker("i", "v6", "http://[fd00::10]:8084", nil),
+		docker("j", "!!!", "http://192.168.1.10:8085", nil),
+		{ID: "system:nginx.service", Source: catalog.SourceSystem, Name: "nginx.service", URL: "http://0.0.0.0:80"},
+		{ID: "link:1", Source: catalog.SourceLink, Name: "router", URL: "http://192.168.1.1"},
+	}
+
+	var got []string
+	for _, r := range Records(entries, Options{Domain: "lan", IP: "192.168.1.2"}) {
+		got = append(got, r.Hostname+" "+r.IP+" "+r.EntryID)
+	}
+	want := []string{
+		"files.lan 192.168.1.10 docker:e",     // docklet.dns.name without a dot takes the domain
+		"grafana-2.lan 192.168.1.10 docker:b", // Second service with the name
+		"grafana.lan 192.168.1.10 docker:a",   // Address from the URL
+		"internal.lan 192.168.1.2 docker:d",   // Container addresses aren't reachable from other hosts
+		"jellyfin.lan 192.168.1.2 docker:c",   // localhost means this host
+		"nginx-service.lan 192.168.1.2 system:nginx.service",
+		"v6.lan fd00::10 docker:i",
+		"wiki.home.arpa 192.168.1.10 docker:f", // A full name
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("records:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without a fallback address, services whose URL names none are left out
+	got = nil
+	for _, r := range Records(entries, Options{Domain: "lan"}) {
+		got = append(got, r.Hostname)
+	}
+	if want := "files.lan grafana-2.lan grafana.lan v6.lan wiki.home.arpa"; strings.Join(got, " ") != want {
+		t.Errorf("records without an IP: %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestValidDomain(t *testing.T) {
+	for domain, want := range map[string]bool{
+		"lan":                           true,
+		"home.arpa":                     true,
+		"my-home.example":               true,
+		"":                              false,
+		"home..arpa":                    false,
+		"-lan":                          false,
+		"Home.arpa":                     false,
+		"home_lab":                      false,
+		strings.Repeat("a.", 101) + "a": false,
+	} {
+		if got := validDomain(domain); got != want {
+			t.Errorf("validDomain(%q) = %t, want %t", domain, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package dnsrecords
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+)
+
+// recordTTL is the TTL, in seconds, of records in formats that carry one.
+const recordTTL = 300
+
+const header = "Generated by Docklet from discovered services; changes will be overwritten."
+
+func init() {
+	register(Format{Name: "hosts", ContentType: "text/plain", FileName: "hosts", render: renderHosts})
+	register(Format{Name: "dnsmasq", ContentType: "text/plain", FileName: "docklet-dnsmasq.conf", render: renderDnsmasq})
+	register(Format{Name: "unbound", ContentType: "text/plain", FileName: "docklet-unbound.conf", render: renderUnbound})
+	register(Format{Name: "coredns", ContentType: "text/plain", FileName: "docklet.hosts", render: renderHosts})
+	register(Format{Name: "adguard", ContentType: "text/plain", FileName: "docklet-adguard.txt", render: renderAdGuard})
+	register(Format{Name: "pihole", ContentType: "text/plain", FileName: "custom.list", render: renderPiHole})
+}
+
+// renderHosts renders a hosts file fragment, which /etc/hosts, dnsmasq's addn-hosts and
+// CoreDNS's hosts plugin all read.
+func renderHosts(records []Record) []byte {
+	var b bytes.Buffer
+	b.WriteString("# " + header + "\n")
+	for _, r := range records {
+		b.WriteString(r.IP + "\t" + r.Hostname + "\n")
+	}
+	return b.Bytes()
+}
+
+// renderDnsmasq renders address= lines for a file in /etc/dnsmasq.d. They also answer for
+// subdomains of each name.
+func renderDnsmasq(records []Record) []byte {
+	var b bytes.Buffer
+	b.WriteString("# " + header + "\n")
+	for _, r := range records {
+		b.WriteString("address=/" + r.Hostname + "/" + r.IP + "\n")
+	}
+	return b.Bytes()
+}
+
+// renderUnbound renders local-data for a file included from unbound.conf. local-data-ptr
+// is left out, since several names may share an address.
+func renderUnbound(records []Record) []byte {
+	var b bytes.Buffer
+	b.WriteString("# " + header + "\n")
+	b.WriteString("server:\n")
+	for _, r := range records {
+		b.WriteString("\tlocal-data: \"" + r.Hostname + ". " + strconv.Itoa(recordTTL) + " IN " + recordType(r.IP) + " " + r.IP + "\"\n")
+	}
+	return b.Bytes()
+}
+
+// renderAdGuard renders DNS rewrite rules for an AdGuard Home custom filtering list.
+func renderAdGuard(records []Record) []byte {
+	var b bytes.Buffer
+	b.WriteString("! " + header + "\n")
+	for _, r := range records {
+		b.WriteString("||" + r.Hostname + "^$dnsrewrite=" + r.IP + "\n")
+	}
+	return b.Bytes()
+}
+
+// renderPiHole renders Pi-hole's local DNS records file, /etc/pihole/custom.list, which
+// has no comments.
+func renderPiHole(records []Record) []byte {
+	var b bytes.Buffer
+	for _, r := range records {
+		b.WriteString(r.IP + " " + r.Hostname + "\n")
+	}
+	return b.Bytes()
+}
+
+func recordType(ip string) string {
+	if net.ParseIP(ip).To4() == nil {
+		return "AAAA"
+	}
+	return "A"
+}
@@ -0,0 +1,50 @@
+package dnsrecords
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.golden with the current output")
+
+var testRecords = []Record{
+	{Hostname: "grafana.lan", IP: "192.168.1.10", EntryID: "docker:a"},
+	{Hostname: "nginx-service.lan", IP: "192.168.1.2", EntryID: "system:nginx.service"},
+	{Hostname: "v6.lan", IP: "fd00::10", EntryID: "docker:i"},
+}
+
+func TestRenderGolden(t *testing.T) {
+	for _, name := range Formats() {
+		t.Run(name, func(t *testing.T) {
+			got, err := Render(name, testRecords)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("output differs from %s (run go test -update to accept):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if f, ok := Lookup("DNSMasq"); !ok || f.Name != "dnsmasq" {
+		t.Errorf("Lookup(DNSMasq) = %+v, %t", f, ok)
+	}
+	if _, err := Render("bind", testRecords); err == nil {
+		t.Error("rendered an unknown format")
+	}
+}
@@ -0,0 +1,221 @@
+package dnsrecords
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/internal/fileutil"
+)
+
+// Output is a file kept up to date with the records in a format.
+type Output struct {
+	Format Format
+	Path   string
+}
+
+// Generator derives records from the catalog and writes them to the configured outputs.
+type Generator struct {
+	Domain        string
+	IP            string // Fixed fallback address; "" uses the address of the default route
+	Outputs       []Output
+	Interval      time.Duration // Time between checks for started and stopped services
+	ReloadCommand string        // Run with sh -c after an output changed
+	collector     *catalog.Collector
+
+	mu   sync.Mutex
+	last map[string][]Record // Records of each service source the last time it answered
+}
+
+// NewGenerator creates a Generator configured from environment variables:
+//
+//	DOCKLET_DNS_DOMAIN          domain appended to service names (default lan)
+//	DOCKLET_DNS_IP              address for services whose URL doesn't name one (default: this host's address on the default route)
+//	DOCKLET_DNS_OUTPUTS         comma-separated format:path pairs to write, e.g. dnsmasq:/etc/dnsmasq.d/docklet.conf
+//	DOCKLET_DNS_INTERVAL        time between checks for started and stopped services (default 1m)
+//	DOCKLET_DNS_RELOAD_COMMAND  shell command run after an output changed, e.g. "pkill -HUP dnsmasq"
+func NewGenerator(collector *catalog.Collector) (*Generator, error) {
+	domain := strings.Trim(strings.ToLower(dockerscanner.GetEnvOrDefault("DOCKLET_DNS_DOMAIN", "lan")), ".")
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("invalid DOCKLET_DNS_DOMAIN %q", domain)
+	}
+	ip := dockerscanner.GetEnvOrDefault("DOCKLET_DNS_IP", "")
+	if ip != "" {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid DOCKLET_DNS_IP: %q is not an IP address", ip)
+		}
+		ip = parsed.String()
+	}
+	interval, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_DNS_INTERVAL", "1m"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid DOCKLET_DNS_INTERVAL: must be a positive duration")
+	}
+
+	var outputs []Output
+	for _, spec := range strings.Split(dockerscanner.GetEnvOrDefault("DOCKLET_DNS_OUTPUTS", ""), ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(spec, ":")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid DOCKLET_DNS_OUTPUTS entry %q: expected format:path", spec)
+		}
+		format, ok := Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid DOCKLET_DNS_OUTPUTS entry %q: unknown format (supported: %s)", spec, strings.Join(Formats(), ", "))
+		}
+		outputs = append(outputs, Output{Format: format, Path: path})
+	}
+
+	return &Generator{
+		Domain:        domain,
+		IP:            ip,
+		Outputs:       outputs,
+		Interval:      interval,
+		ReloadCommand: dockerscanner.GetEnvOrDefault("DOCKLET_DNS_RELOAD_COMMAND", ""),
+		collector:     collector,
+	}, nil
+}
+
+// Records returns the records for the services currently discovered. A source that fails
+// keeps the records it had when it last answered, so names don't drop out of the resolver
+// because Docker hiccupped; if it never answered, the error is returned.
+func (g *Generator) Records(ctx context.Context) ([]Record, error) {
+	result := g.collector.CollectPartial(ctx)
+	ip := g.IP
+	if ip == "" {
+		// Without one, services whose URL names no usable address are left out
+		ip, _ = defaultRouteIP()
+	}
+	records := Records(result.Items, Options{Domain: g.Domain, IP: ip})
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.last = carryOver(records, result, g.last)
+	records = nil
+	for _, kept := range g.last {
+		records = append(records, kept...)
+	}
+	for _, warning := range result.Warnings {
+		if warning.Source == catalog.SourceLink {
+			continue
+		}
+		if _, ok := g.last[warning.Source]; !ok {
+			return nil, warning.Err
+		}
+		log.Printf("DNS records: failed to list %s services, keeping their last records: %v", warning.Source, warning.Err)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Hostname < records[j].Hostname })
+	return records, nil
+}
+
+// carryOver groups records by the source of their entries, and takes the previous records
+// of each service source that failed this time. Names taken since are dropped from them.
+func carryOver(records []Record, result *catalog.Result, previous map[string][]Record) map[string][]Record {
+	sources := make(map[string]string, len(result.Items))
+	for _, entry := range result.Items {
+		sources[entry.ID] = entry.Source
+	}
+	bySource := map[string][]Record{catalog.SourceDocker: nil, catalog.SourceSystem: nil}
+	taken := make(map[string]bool)
+	for _, r := range records {
+		bySource[sources[r.EntryID]] = append(bySource[sources[r.EntryID]], r)
+		taken[r.Hostname] = true
+	}
+	for _, warning := range result.Warnings {
+		if warning.Source == catalog.SourceLink {
+			continue // Links have no records
+		}
+		kept, ok := previous[warning.Source]
+		if !ok {
+			delete(bySource, warning.Source)
+			continue
+		}
+		bySource[warning.Source] = nil
+		for _, r := range kept {
+			if !taken[r.Hostname] {
+				bySource[warning.Source] = append(bySource[warning.Source], r)
+			}
+		}
+	}
+	return bySource
+}
+
+// Run writes the outputs every Interval until ctx is cancelled. Outputs are left in place
+// when it returns, so names keep resolving while Docklet restarts.
+func (g *Generator) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+
+	for {
+		g.write(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write renders every output and replaces the files whose contents changed.
+func (g *Generator) write(ctx context.Context) {
+	records, err := g.Records(ctx)
+	if ctx.Err() != nil {
+		return // Shutting down
+	}
+	if err != nil {
+		// Keep the files we have rather than dropping names Docklet never saw
+		log.Printf("DNS records: failed to list services: %v", err)
+		return
+	}
+
+	changed := false
+	for _, out := range g.Outputs {
+		data := out.Format.Render(records)
+		if old, err := os.ReadFile(out.Path); err == nil && bytes.Equal(old, data) {
+			continue
+		}
+		// World-readable, since resolvers usually run as their own user
+		if err := fileutil.WriteFileAtomic(out.Path, data, 0o644); err != nil {
+			log.Printf("DNS records: failed to write %s: %v", out.Path, err)
+			continue
+		}
+		log.Printf("DNS records: wrote %d %s records to %s", len(records), out.Format.Name, out.Path)
+		changed = true
+	}
+
+	if changed && g.ReloadCommand != "" {
+		cmdCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if out, err := exec.CommandContext(cmdCtx, "sh", "-c", g.ReloadCommand).CombinedOutput(); err != nil {
+			log.Printf("DNS records: reload command failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+}
+
+// defaultRouteIP returns this host's address on the interface of the default route.
+func defaultRouteIP() (string, error) {
+	// Connecting a UDP socket sends nothing; it only picks the route and source address.
+	conn, err := net.Dial("udp4", "192.0.2.1:53")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	if ip.IsLoopback() {
+		return "", errors.New("no route to other hosts")
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,86 @@
+package dnsrecords
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"docklet/catalog"
+)
+
+func TestCarryOver(t *testing.T) {
+	previous := map[string][]Record{
+		catalog.SourceDocker: {{Hostname: "grafana.lan", IP: "192.168.1.10", EntryID: "docker:a"}, {Hostname: "nas.lan", IP: "192.168.1.10", EntryID: "docker:b"}},
+		catalog.SourceSystem: {{Hostname: "old.lan", IP: "192.168.1.2", EntryID: "system:old.service"}},
+	}
+	failed := func(sources ...string) []catalog.Warning {
+		var warnings []catalog.Warning
+		for _, source := range sources {
+			warnings = append(warnings, catalog.NewWarning(source, errors.New("unavailable")))
+		}
+		return warnings
+	}
+	items := []catalog.Entry{{ID: "system:nas.service", Source: catalog.SourceSystem}, {ID: "docker:c", Source: catalog.SourceDocker}}
+	systemNAS := Record{Hostname: "nas.lan", IP: "192.168.1.2", EntryID: "system:nas.service"}
+	dockerLoki := Record{Hostname: "loki.lan", IP: "192.168.1.10", EntryID: "docker:c"}
+
+	for _, tt := range []struct {
+		name     string
+		records  []Record
+		warnings []catalog.Warning
+		previous map[string][]Record
+		want     string
+	}{
+		{
+			name:     "every source answered",
+			records:  []Record{systemNAS, dockerLoki},
+			previous: previous,
+			want:     "docker:[loki.lan] system:[nas.lan]",
+		},
+		{
+			name:     "docker failed",
+			records:  []Record{systemNAS},
+			warnings: failed(catalog.SourceDocker, catalog.SourceLink),
+			previous: previous,
+			want:     "docker:[grafana.lan] system:[nas.lan]", // nas.lan went to the system service meanwhile
+		},
+		{
+			name:     "docker failed before it ever answered",
+			records:  []Record{systemNAS},
+			warnings: failed(catalog.SourceDocker),
+			want:     "system:[nas.lan]",
+		},
+		{
+			name:     "both failed",
+			warnings: failed(catalog.SourceDocker, catalog.SourceSystem),
+			previous: previous,
+			want:     "docker:[grafana.lan nas.lan] system:[old.lan]",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &catalog.Result{Items: items, Warnings: tt.warnings}
+			got := carryOver(tt.records, result, tt.previous)
+
+			var sources []string
+			for source := range got {
+				sources = append(sources, source)
+			}
+			sort.Strings(sources)
+			var summary string
+			for _, source := range sources {
+				var hosts []string
+				for _, r := range got[source] {
+					hosts = append(hosts, r.Hostname)
+				}
+				if summary != "" {
+					summary += " "
+				}
+				summary += fmt.Sprintf("%s:%v", source, hosts)
+			}
+			if summary != tt.want {
+				t.Errorf("carryOver = %s, want %s", summary, tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"docklet/icons"
+	"docklet/metrics"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
@@ -36,9 +40,15 @@ func NewScanner() (*client.Client, error) {
 }
 
 // ListServices scans for running Docker containers and extracts service information.
-func ListServices(cli *client.Client) ([]ServiceInfo, error) {
-	containers, err := cli.ContainerList(context.Background(), container.ListOptions{})
+// It gives up when ctx is cancelled, so a hung daemon can't block the caller.
+func ListServices(ctx context.Context, cli *client.Client) ([]ServiceInfo, error) {
+	start := time.Now()
+	defer func() { metrics.ScanDuration.Observe(time.Since(start).Seconds(), "docker") }()
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{})
 	if err != nil {
+		metrics.DockerAPIErrors.Inc("container_list")
+		metrics.ScanErrors.Inc("docker")
 		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 
@@ -54,12 +64,18 @@ func ListServices(cli *client.Client) ([]ServiceInfo, error) {
 		if title == "" {
 			title = serviceName // Fallback to service name if no specific title
 		}
-		icon := cont.Labels[DefaultLabelPrefix+"icon"]
+		// Short names like "si:grafana" are resolved to bundled icons; without a label, guess from the image.
+		icon := icons.Resolve(cont.Labels[DefaultLabelPrefix+"icon"])
+		if icon == "" {
+			icon = icons.ForImage(cont.Image)
+		}
 		description := cont.Labels[DefaultLabelPrefix+"description"]
 		category := cont.Labels[DefaultLabelPrefix+"category"]
 		order := cont.Labels[DefaultLabelPrefix+"order"] // Keep as string for now
 		customURL := cont.Labels[DefaultLabelPrefix+"url"]
 
+		networkNames, networkIPs := containerNetworks(cont)
+
 		var serviceURL string
 		var portsInfo []string
 
@@ -132,26 +148,33 @@ func ListServices(cli *client.Client) ([]ServiceInfo, error) {
 			}
 		}
 
+		// Containers that publish no port but name one with docklet.port are only reachable
+		// from the host on their network address. That address means nothing to browsers, so
+		// they get no URL and are left to the proxy, which forwards to InternalPort on it;
+		// containers without one use host networking and listen on the host itself.
+		keepWithoutURL := false
+		if serviceURL == "" {
+			if p, err := strconv.ParseUint(cont.Labels[DefaultLabelPrefix+"port"], 10, 16); err == nil && p > 0 {
+				if len(networkIPs) == 0 || cont.HostConfig.NetworkMode == "host" {
+					serviceURL = fmt.Sprintf("http://%s:%d", hostIP, p)
+				} else {
+					keepWithoutURL = true
+				}
+			}
+		}
+
 		// If still no URL, check for docklet.url_override
 		urlOverride := cont.Labels[DefaultLabelPrefix+"url_override"]
 		if urlOverride != "" {
 			serviceURL = urlOverride
 		}
 
-
-		// Only include services with a valid HTTP/HTTPS URL
-		if serviceURL == "" || (!strings.HasPrefix(serviceURL, "http://") && !strings.HasPrefix(serviceURL, "https://")) {
+		// Only include services with a valid HTTP/HTTPS URL, or that only the proxy can reach
+		if (serviceURL == "" && !keepWithoutURL) || (serviceURL != "" && !strings.HasPrefix(serviceURL, "http://") && !strings.HasPrefix(serviceURL, "https://")) {
 			log.Printf("Skipping container %s (%s) as it does not have a valid HTTP/HTTPS URL: '%s'", serviceName, cont.ID, serviceURL)
 			continue // Skip to the next container
 		}
 
-		var networkNames []string
-		if cont.NetworkSettings != nil && cont.NetworkSettings.Networks != nil {
-			for name := range cont.NetworkSettings.Networks {
-				networkNames = append(networkNames, name)
-			}
-		}
-
 		services = append(services, ServiceInfo{
 			ID:            cont.ID,
 			Name:          serviceName, // User-friendly name, might be same as title initially
@@ -167,8 +190,117 @@ func ListServices(cli *client.Client) ([]ServiceInfo, error) {
 			Networks:      networkNames,
 			ImageName:     cont.Image,
 			Status:        cont.State, // e.g. "running", "exited"
+			PortBindings:  portBindings(cont.Ports),
+			NetworkIPs:    networkIPs,
+			InternalPort:  internalPort(cont.Ports, cont.Labels[DefaultLabelPrefix+"port"]),
 		})
 	}
 
 	return services, nil
-}
\ No newline at end of file
+}
+
+// ListContainers lists every running container, whether or not it serves a web page, with
+// the fields describing the container itself: names, image, labels, ports and networks.
+// URL, title and icon are left empty.
+func ListContainers(ctx context.Context, cli *client.Client) ([]ServiceInfo, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		metrics.DockerAPIErrors.Inc("container_list")
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	services := make([]ServiceInfo, 0, len(containers))
+	for _, cont := range containers {
+		name := strings.TrimPrefix(cont.Names[0], "/")
+		networkNames, networkIPs := containerNetworks(cont)
+		services = append(services, ServiceInfo{
+			ID:            cont.ID,
+			Name:          name,
+			RawLabels:     cont.Labels,
+			ContainerName: name,
+			Networks:      networkNames,
+			ImageName:     cont.Image,
+			Status:        cont.State,
+			PortBindings:  portBindings(cont.Ports),
+			NetworkIPs:    networkIPs,
+		})
+	}
+	return services, nil
+}
+
+// ContainerIDsByIP maps the addresses of running containers on their networks to their IDs,
+// for sockets of docker-proxy, which only knows the address of the container it forwards to.
+func ContainerIDsByIP(ctx context.Context, cli *client.Client) (map[string]string, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		metrics.DockerAPIErrors.Inc("container_list")
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	ids := make(map[string]string)
+	for _, cont := range containers {
+		if cont.NetworkSettings == nil {
+			continue
+		}
+		for _, settings := range cont.NetworkSettings.Networks {
+			if settings == nil {
+				continue
+			}
+			for _, ip := range []string{settings.IPAddress, settings.GlobalIPv6Address} {
+				if ip != "" {
+					ids[ip] = cont.ID
+				}
+			}
+		}
+	}
+	return ids, nil
+}
+
+// containerNetworks returns the networks a container is attached to and its address on each.
+func containerNetworks(cont container.Summary) ([]string, map[string]string) {
+	var names []string
+	ips := make(map[string]string)
+	if cont.NetworkSettings != nil && cont.NetworkSettings.Networks != nil {
+		for name, settings := range cont.NetworkSettings.Networks {
+			names = append(names, name)
+			if settings != nil && settings.IPAddress != "" {
+				ips[name] = settings.IPAddress
+			}
+		}
+	}
+	return names, ips
+}
+
+func portBindings(ports []container.Port) []PortBinding {
+	var bindings []PortBinding
+	for _, p := range ports {
+		bindings = append(bindings, PortBinding{
+			IP:          p.IP,
+			PublicPort:  p.PublicPort,
+			PrivatePort: p.PrivatePort,
+			Type:        p.Type,
+		})
+	}
+	return bindings
+}
+
+// internalPort returns the port inside the container the service URL points at: the
+// docklet.port label, the container side of the lowest published port, or the lowest
+// exposed TCP port. It returns 0 if the container exposes nothing.
+func internalPort(ports []container.Port, label string) uint16 {
+	if p, err := strconv.ParseUint(label, 10, 16); err == nil && p > 0 {
+		return uint16(p)
+	}
+	var lowestPublic, chosen, lowestTCP uint16
+	for _, p := range ports {
+		if p.PublicPort > 0 && (lowestPublic == 0 || p.PublicPort < lowestPublic) {
+			lowestPublic, chosen = p.PublicPort, p.PrivatePort
+		}
+		if p.Type == "tcp" && (lowestTCP == 0 || p.PrivatePort < lowestTCP) {
+			lowestTCP = p.PrivatePort
+		}
+	}
+	if chosen > 0 {
+		return chosen
+	}
+	return lowestTCP
+}
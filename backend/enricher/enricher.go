@@ -0,0 +1,236 @@
+package enricher
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/icons"
+	"docklet/internal/fileutil"
+)
+
+// Metadata is what the enricher learned about a service URL.
+type Metadata struct {
+	Title     string    `json:"title,omitempty"`
+	SiteName  string    `json:"site_name,omitempty"`
+	IconKey   string    `json:"icon_key,omitempty"` // File name in the icon cache, served from /api/icons/<key>
+	FetchedAt time.Time `json:"fetched_at"`
+	Error     string    `json:"error,omitempty"` // Last fetch error, retried after retryAfter
+}
+
+// DisplayTitle returns the best title for the page: the site name if set, otherwise <title>.
+func (m Metadata) DisplayTitle() string {
+	if m.SiteName != "" {
+		return m.SiteName
+	}
+	return m.Title
+}
+
+// IconURL returns the API path an icon key is served from.
+func IconURL(key string) string {
+	return "/api/icons/" + key
+}
+
+const (
+	indexFile  = "enricher.json"
+	refreshAge = 24 * time.Hour   // Successful fetches are refreshed after this long
+	retryAfter = 10 * time.Minute // Failed fetches are retried after this long
+)
+
+// Enricher fetches service landing pages in the background to find a title and an icon
+// for services that don't set docklet.title/docklet.icon. Icons are downloaded and cached
+// locally, so the browser never has to talk to internal hosts directly.
+type Enricher struct {
+	iconDir   string
+	indexPath string
+	interval  time.Duration
+	client    *http.Client
+
+	mu    sync.RWMutex
+	pages map[string]Metadata // Keyed by service URL
+}
+
+// NewEnricher creates an Enricher storing its cache below dataDir.
+// DOCKLET_ENRICH_INTERVAL sets how often services are checked (default 10m) and
+// DOCKLET_ENRICH_INSECURE=false enables certificate verification for https services.
+func NewEnricher(dataDir string) (*Enricher, error) {
+	interval, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_ENRICH_INTERVAL", "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_ENRICH_INTERVAL: %w", err)
+	}
+
+	iconDir := filepath.Join(dataDir, "icons")
+	if err := os.MkdirAll(iconDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create icon cache directory: %w", err)
+	}
+
+	// Self-hosted services commonly use self-signed certificates; we only read public metadata.
+	insecure := dockerscanner.GetEnvOrDefault("DOCKLET_ENRICH_INSECURE", "true") != "false"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+
+	e := &Enricher{
+		iconDir:   iconDir,
+		indexPath: filepath.Join(dataDir, indexFile),
+		interval:  interval,
+		client:    &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		pages:     make(map[string]Metadata),
+	}
+	if err := e.load(); err != nil {
+		log.Printf("Warning: failed to load enricher cache, starting empty: %v", err)
+	}
+	return e, nil
+}
+
+// Run refreshes metadata for all services returned by collect until ctx is cancelled.
+func (e *Enricher) Run(ctx context.Context, collect func(context.Context) ([]catalog.Entry, error)) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := collect(ctx)
+		if ctx.Err() != nil {
+			return // Shutting down
+		}
+		if err != nil {
+			log.Printf("Enricher: failed to collect services: %v", err)
+		} else {
+			e.refresh(ctx, entries)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh fetches every service URL that needs enrichment and isn't fresh in the cache.
+func (e *Enricher) refresh(ctx context.Context, entries []catalog.Entry) {
+	changed := false
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if entry.URL == "" || !entryNeedsEnrichment(entry) || e.isFresh(entry.URL) {
+			continue
+		}
+
+		meta, err := e.fetch(ctx, entry.URL)
+		if err != nil {
+			log.Printf("Enricher: failed to fetch %s: %v", entry.URL, err)
+			meta.Error = err.Error()
+		}
+		meta.FetchedAt = time.Now()
+
+		e.mu.Lock()
+		e.pages[entry.URL] = meta
+		e.mu.Unlock()
+		changed = true
+	}
+
+	if changed {
+		if err := e.save(); err != nil {
+			log.Printf("Enricher: failed to save cache: %v", err)
+		}
+	}
+}
+
+func (e *Enricher) isFresh(serviceURL string) bool {
+	e.mu.RLock()
+	meta, ok := e.pages[serviceURL]
+	e.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if meta.Error != "" {
+		return time.Since(meta.FetchedAt) < retryAfter
+	}
+	return time.Since(meta.FetchedAt) < refreshAge
+}
+
+// Lookup returns the cached metadata for a service URL.
+func (e *Enricher) Lookup(serviceURL string) (Metadata, bool) {
+	if e == nil {
+		return Metadata{}, false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	meta, ok := e.pages[serviceURL]
+	return meta, ok && meta.Error == ""
+}
+
+// ApplyToEntries fills in titles and icons of catalog entries that don't set them via labels.
+// Stored links keep their title and icon, since they were chosen by a person.
+func (e *Enricher) ApplyToEntries(entries []catalog.Entry) {
+	for i := range entries {
+		chosen := entries[i].Source == catalog.SourceLink
+		e.apply(&entries[i].Title, &entries[i].Icon, entries[i].URL, entries[i].Labels, chosen)
+	}
+}
+
+// ApplyToServices fills in titles and icons of Docker services that don't set them via labels.
+func (e *Enricher) ApplyToServices(services []dockerscanner.ServiceInfo) {
+	for i := range services {
+		e.apply(&services[i].Title, &services[i].Icon, services[i].URL, services[i].RawLabels, false)
+	}
+}
+
+func (e *Enricher) apply(title, icon *string, serviceURL string, labels map[string]string, chosen bool) {
+	meta, ok := e.Lookup(serviceURL)
+	if !ok {
+		return
+	}
+	if !chosen && labels[dockerscanner.DefaultLabelPrefix+"title"] == "" && meta.DisplayTitle() != "" {
+		*title = meta.DisplayTitle()
+	}
+	// The service's own icon beats one guessed from its image or executable name.
+	guessed := !chosen && labels[dockerscanner.DefaultLabelPrefix+"icon"] == "" && icons.IsBundled(*icon)
+	if (*icon == "" || guessed) && meta.IconKey != "" {
+		*icon = IconURL(meta.IconKey)
+	}
+}
+
+// entryNeedsEnrichment reports whether a service is missing a title or an icon label.
+// Stored links only ever need an icon.
+func entryNeedsEnrichment(entry catalog.Entry) bool {
+	if entry.Source == catalog.SourceLink {
+		return entry.Icon == ""
+	}
+	return entry.Labels[dockerscanner.DefaultLabelPrefix+"title"] == "" || entry.Labels[dockerscanner.DefaultLabelPrefix+"icon"] == ""
+}
+
+// load reads the metadata index from disk. A missing file is not an error.
+func (e *Enricher) load() error {
+	data, err := os.ReadFile(e.indexPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return json.Unmarshal(data, &e.pages)
+}
+
+// save writes the metadata index to disk atomically.
+func (e *Enricher) save() error {
+	e.mu.RLock()
+	data, err := json.MarshalIndent(e.pages, "", "  ")
+	e.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(e.indexPath, data, 0o600)
+}
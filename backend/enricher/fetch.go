@@ -0,0 +1,158 @@
+package enricher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"docklet/internal/fileutil"
+)
+
+const (
+	maxPageSize     = 1 << 20   // 1 MiB of HTML is plenty to find <head>
+	maxManifestSize = 256 << 10 // 256 KiB
+	maxIconSize     = 512 << 10 // 512 KiB
+)
+
+// iconExtensions maps accepted icon content types to the extension used in the cache.
+var iconExtensions = map[string]string{
+	"image/png":                ".png",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"image/svg+xml":            ".svg",
+	"image/jpeg":               ".jpg",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+}
+
+// iconKeyPattern matches the file names produced by downloadIcon.
+var iconKeyPattern = regexp.MustCompile(`^[0-9a-f]{32}\.(png|ico|svg|jpg|gif|webp)$`)
+
+// IconFile returns the path of a cached icon, or false if the key is invalid or unknown.
+func (e *Enricher) IconFile(key string) (string, bool) {
+	if e == nil || !iconKeyPattern.MatchString(key) {
+		return "", false
+	}
+	path := filepath.Join(e.iconDir, key)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// fetch loads a service's landing page and downloads the best icon it can find.
+// It returns whatever it found even when err is non-nil.
+func (e *Enricher) fetch(ctx context.Context, pageURL string) (Metadata, error) {
+	var meta Metadata
+
+	body, finalURL, err := e.get(ctx, pageURL, maxPageSize)
+	if err != nil {
+		return meta, err
+	}
+
+	info, err := parsePage(bytes.NewReader(body), finalURL)
+	if err != nil {
+		return meta, fmt.Errorf("failed to parse page: %w", err)
+	}
+	meta.Title = info.Title
+	meta.SiteName = info.SiteName
+
+	candidates := info.IconURLs
+	if info.ManifestURL != "" {
+		if manifestURL, err := url.Parse(info.ManifestURL); err == nil {
+			if data, _, err := e.get(ctx, info.ManifestURL, maxManifestSize); err == nil {
+				if icons, err := parseManifestIcons(bytes.NewReader(data), manifestURL); err == nil {
+					candidates = append(candidates, icons...)
+				}
+			}
+		}
+	}
+	candidates = append(candidates, finalURL.ResolveReference(&url.URL{Path: "/favicon.ico"}).String())
+
+	for _, iconURL := range candidates {
+		key, err := e.downloadIcon(ctx, iconURL)
+		if err == nil {
+			meta.IconKey = key
+			break
+		}
+	}
+	return meta, nil
+}
+
+// downloadIcon fetches an icon into the cache and returns its key.
+// Keys are derived from the icon URL, so re-downloading overwrites the previous version.
+func (e *Enricher) downloadIcon(ctx context.Context, iconURL string) (string, error) {
+	data, _, contentType, err := e.getWithType(ctx, iconURL, maxIconSize)
+	if err != nil {
+		return "", err
+	}
+
+	ext, ok := iconExtensions[contentType]
+	if !ok {
+		// Some servers send icons as application/octet-stream or text/plain; sniff instead.
+		sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+		ext, ok = iconExtensions[sniffed]
+		if !ok && bytes.Contains(data[:min(len(data), 512)], []byte("<svg")) {
+			ext, ok = ".svg", true
+		}
+	}
+	if !ok || len(data) == 0 {
+		return "", fmt.Errorf("%s is not an image (content type %q)", iconURL, contentType)
+	}
+
+	sum := sha256.Sum256([]byte(iconURL))
+	key := hex.EncodeToString(sum[:16]) + ext
+	if err := fileutil.WriteFileAtomic(filepath.Join(e.iconDir, key), data, 0o600); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// get performs a GET request and returns up to limit bytes of the body and the final URL after redirects.
+func (e *Enricher) get(ctx context.Context, rawURL string, limit int64) ([]byte, *url.URL, error) {
+	data, finalURL, _, err := e.getWithType(ctx, rawURL, limit)
+	return data, finalURL, err
+}
+
+func (e *Enricher) getWithType(ctx context.Context, rawURL string, limit int64) ([]byte, *url.URL, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	req.Header.Set("User-Agent", "Docklet")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if int64(len(data)) > limit {
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			return nil, nil, "", errors.New("response too large")
+		}
+		data = data[:limit] // Truncated HTML still has its <head>
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return data, resp.Request.URL, contentType, nil
+}
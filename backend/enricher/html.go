@@ -0,0 +1,171 @@
+package enricher
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pageInfo is what we extract from a service's landing page.
+type pageInfo struct {
+	Title       string
+	SiteName    string   // og:site_name or application-name
+	IconURLs    []string // Candidate icons, best first
+	ManifestURL string   // Web app manifest, may list more icons
+}
+
+// iconCandidate is a <link rel=icon> or manifest icon with its declared size.
+type iconCandidate struct {
+	url  string
+	size int  // Largest declared dimension, 0 if unknown
+	svg  bool // Vector icons scale to any size
+	rank int  // Lower is better for equal sizes: icon < apple-touch-icon < shortcut icon
+}
+
+// parsePage extracts title, site name and icon links from an HTML document.
+// Relative URLs are resolved against base.
+func parsePage(r io.Reader, base *url.URL) (*pageInfo, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &pageInfo{}
+	var icons []iconCandidate
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				if info.Title == "" && n.FirstChild != nil {
+					info.Title = strings.TrimSpace(n.FirstChild.Data)
+				}
+			case "meta":
+				property := strings.ToLower(attr(n, "property") + attr(n, "name"))
+				if (property == "og:site_name" || property == "application-name") && info.SiteName == "" {
+					info.SiteName = strings.TrimSpace(attr(n, "content"))
+				}
+			case "link":
+				href := attr(n, "href")
+				if href == "" {
+					break
+				}
+				resolved := resolve(base, href)
+				for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+					switch rel {
+					case "icon", "apple-touch-icon":
+						icons = append(icons, iconCandidate{
+							url:  resolved,
+							size: parseSizes(attr(n, "sizes")),
+							svg:  strings.Contains(attr(n, "type"), "svg") || strings.HasSuffix(strings.ToLower(href), ".svg"),
+							rank: iconRank(attr(n, "rel")),
+						})
+					case "manifest":
+						info.ManifestURL = resolved
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	info.IconURLs = sortIcons(icons)
+	return info, nil
+}
+
+// parseManifestIcons returns the icons listed in a web app manifest, best first.
+func parseManifestIcons(r io.Reader, base *url.URL) ([]string, error) {
+	var manifest struct {
+		Name      string `json:"name"`
+		ShortName string `json:"short_name"`
+		Icons     []struct {
+			Src   string `json:"src"`
+			Sizes string `json:"sizes"`
+			Type  string `json:"type"`
+		} `json:"icons"`
+	}
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	var icons []iconCandidate
+	for _, icon := range manifest.Icons {
+		if icon.Src == "" {
+			continue
+		}
+		icons = append(icons, iconCandidate{
+			url:  resolve(base, icon.Src),
+			size: parseSizes(icon.Sizes),
+			svg:  strings.Contains(icon.Type, "svg"),
+		})
+	}
+	return sortIcons(icons), nil
+}
+
+// sortIcons orders candidates: SVG first, then largest, then by rel preference.
+func sortIcons(icons []iconCandidate) []string {
+	sort.SliceStable(icons, func(i, j int) bool {
+		if icons[i].svg != icons[j].svg {
+			return icons[i].svg
+		}
+		if icons[i].size != icons[j].size {
+			return icons[i].size > icons[j].size
+		}
+		return icons[i].rank < icons[j].rank
+	})
+	urls := make([]string, 0, len(icons))
+	for _, icon := range icons {
+		urls = append(urls, icon.url)
+	}
+	return urls
+}
+
+// iconRank prefers plain "icon" links over touch icons and the legacy "shortcut icon".
+func iconRank(rel string) int {
+	rel = strings.ToLower(rel)
+	switch {
+	case strings.Contains(rel, "shortcut"):
+		return 2
+	case strings.Contains(rel, "apple-touch-icon"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseSizes returns the largest dimension from a sizes attribute like "16x16 32x32".
+func parseSizes(sizes string) int {
+	largest := 0
+	for _, size := range strings.Fields(strings.ToLower(sizes)) {
+		width, _, _ := strings.Cut(size, "x")
+		if n, err := strconv.Atoi(width); err == nil && n > largest {
+			largest = n
+		}
+	}
+	return largest
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func resolve(base *url.URL, ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
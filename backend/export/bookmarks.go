@@ -0,0 +1,142 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"strings"
+)
+
+func init() {
+	register(Format{Name: "bookmarks", ContentType: "text/html", FileName: "bookmarks.html", render: renderBookmarks})
+	register(Format{Name: "opml", ContentType: "text/x-opml", FileName: "services.opml", render: renderOPML})
+	register(Format{Name: "jsonfeed", ContentType: "application/feed+json", FileName: "feed.json", render: renderJSONFeed})
+}
+
+// renderBookmarks renders a Netscape bookmark file, which Firefox, Chrome and most launchers
+// can import. All services go into a top-level folder named after the title, with one
+// subfolder per category; re-importing it replaces the folder's contents in most browsers.
+func renderBookmarks(groups []Group, opts Options) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("<!DOCTYPE NETSCAPE-Bookmark-file-1>\n")
+	b.WriteString("<!-- This is an automatically generated file. It will be read and overwritten. -->\n")
+	b.WriteString(`<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">` + "\n")
+	b.WriteString("<TITLE>Bookmarks</TITLE>\n<H1>Bookmarks</H1>\n<DL><p>\n")
+	b.WriteString("    <DT><H3>" + html.EscapeString(opts.Title) + "</H3>\n    <DL><p>\n")
+	for _, g := range groups {
+		b.WriteString("        <DT><H3>" + html.EscapeString(g.Name) + "</H3>\n        <DL><p>\n")
+		for _, entry := range g.Entries {
+			b.WriteString(`            <DT><A HREF="` + html.EscapeString(entry.URL) + `"`)
+			if icon := iconURL(entry, opts); icon != "" {
+				b.WriteString(` ICON_URI="` + html.EscapeString(icon) + `"`)
+			}
+			b.WriteString(">" + html.EscapeString(entry.Title) + "</A>\n")
+			if entry.Description != "" {
+				b.WriteString("            <DD>" + html.EscapeString(oneLine(entry.Description)) + "\n")
+			}
+		}
+		b.WriteString("        </DL><p>\n")
+	}
+	b.WriteString("    </DL><p>\n</DL><p>\n")
+	return b.Bytes(), nil
+}
+
+// OPML 2.0, see http://opml.org/spec2.opml. Categories become outline groups and
+// services "link" outlines.
+type opmlDocument struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"head>title"`
+	Body    []opmlEntry `xml:"body>outline"`
+}
+
+type opmlEntry struct {
+	Text        string      `xml:"text,attr"`
+	Type        string      `xml:"type,attr,omitempty"`
+	URL         string      `xml:"url,attr,omitempty"`
+	Description string      `xml:"description,attr,omitempty"`
+	Icon        string      `xml:"icon,attr,omitempty"`
+	Children    []opmlEntry `xml:"outline"`
+}
+
+func renderOPML(groups []Group, opts Options) ([]byte, error) {
+	doc := opmlDocument{Version: "2.0", Title: opts.Title}
+	for _, g := range groups {
+		group := opmlEntry{Text: g.Name}
+		for _, entry := range g.Entries {
+			group.Children = append(group.Children, opmlEntry{
+				Text:        entry.Title,
+				Type:        "link",
+				URL:         entry.URL,
+				Description: oneLine(entry.Description),
+				Icon:        iconURL(entry, opts),
+			})
+		}
+		doc.Body = append(doc.Body, group)
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(xml.Header), data...), '\n'), nil
+}
+
+// JSON Feed 1.1, see https://jsonfeed.org/version/1.1. Each service is an item so
+// launcher apps and feed readers can list and follow it.
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	ContentText string   `json:"content_text"`
+	Image       string   `json:"image,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func renderJSONFeed(groups []Group, opts Options) ([]byte, error) {
+	feed := jsonFeed{
+		Version: "https://jsonfeed.org/version/1.1",
+		Title:   opts.Title,
+		Items:   []jsonFeedItem{},
+	}
+	if opts.BaseURL != "" {
+		feed.HomePageURL = opts.BaseURL
+		feed.FeedURL = strings.TrimSuffix(opts.BaseURL, "/") + "/api/export/feed.json"
+	}
+	for _, g := range groups {
+		for _, entry := range g.Entries {
+			text := entry.Description
+			if text == "" {
+				text = entry.Title // content_text is required
+			}
+			feed.Items = append(feed.Items, jsonFeedItem{
+				ID:          entry.ID,
+				URL:         entry.URL,
+				Title:       entry.Title,
+				ContentText: text,
+				Image:       iconURL(entry, opts),
+				Tags:        []string{g.Name},
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// oneLine collapses whitespace, for formats that keep descriptions on a single line.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,172 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	register(Format{Name: "homer", ContentType: "application/yaml", FileName: "config.yml", render: renderHomer})
+	register(Format{Name: "homepage", ContentType: "application/yaml", FileName: "services.yaml", render: renderHomepage})
+	register(Format{Name: "dashy", ContentType: "application/yaml", FileName: "conf.yml", render: renderDashy})
+	register(Format{Name: "heimdall", ContentType: "application/json", FileName: "heimdall.json", render: renderHeimdall})
+}
+
+// Homer config.yml, see https://github.com/bastienwirtz/homer/blob/main/docs/configuration.md
+type homerConfig struct {
+	Title    string       `yaml:"title"`
+	Services []homerGroup `yaml:"services"`
+}
+
+type homerGroup struct {
+	Name  string      `yaml:"name"`
+	Items []homerItem `yaml:"items"`
+}
+
+type homerItem struct {
+	Name     string `yaml:"name"`
+	Logo     string `yaml:"logo,omitempty"`
+	Icon     string `yaml:"icon,omitempty"`
+	Subtitle string `yaml:"subtitle,omitempty"`
+	URL      string `yaml:"url"`
+	Target   string `yaml:"target,omitempty"`
+}
+
+func renderHomer(groups []Group, opts Options) ([]byte, error) {
+	config := homerConfig{Title: opts.Title}
+	for _, g := range groups {
+		group := homerGroup{Name: g.Name}
+		for _, entry := range g.Entries {
+			group.Items = append(group.Items, homerItem{
+				Name:     entry.Title,
+				Logo:     iconURL(entry, opts),
+				Icon:     iconClass(entry),
+				Subtitle: entry.Description,
+				URL:      entry.URL,
+				Target:   "_blank",
+			})
+		}
+		config.Services = append(config.Services, group)
+	}
+	return marshalYAML(config)
+}
+
+// Homepage services.yaml, see https://gethomepage.dev/configs/services/
+// The format is a list of single-key maps, so groups and services keep their order.
+type homepageService struct {
+	Href        string `yaml:"href"`
+	Description string `yaml:"description,omitempty"`
+	Icon        string `yaml:"icon,omitempty"`
+}
+
+func renderHomepage(groups []Group, opts Options) ([]byte, error) {
+	config := []map[string][]map[string]homepageService{}
+	for _, g := range groups {
+		var services []map[string]homepageService
+		for _, entry := range g.Entries {
+			services = append(services, map[string]homepageService{
+				entry.Title: {
+					Href:        entry.URL,
+					Description: entry.Description,
+					Icon:        iconURL(entry, opts),
+				},
+			})
+		}
+		config = append(config, map[string][]map[string]homepageService{g.Name: services})
+	}
+	return marshalYAML(config)
+}
+
+// Dashy conf.yml, see https://dashy.to/docs/configuring
+type dashyConfig struct {
+	PageInfo dashyPageInfo  `yaml:"pageInfo"`
+	Sections []dashySection `yaml:"sections"`
+}
+
+type dashyPageInfo struct {
+	Title string `yaml:"title"`
+}
+
+type dashySection struct {
+	Name  string      `yaml:"name"`
+	Items []dashyItem `yaml:"items"`
+}
+
+type dashyItem struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description,omitempty"`
+	URL         string `yaml:"url"`
+	Icon        string `yaml:"icon,omitempty"`
+}
+
+func renderDashy(groups []Group, opts Options) ([]byte, error) {
+	config := dashyConfig{PageInfo: dashyPageInfo{Title: opts.Title}}
+	for _, g := range groups {
+		section := dashySection{Name: g.Name}
+		for _, entry := range g.Entries {
+			icon := iconURL(entry, opts)
+			if icon == "" {
+				icon = iconClass(entry)
+			}
+			section.Items = append(section.Items, dashyItem{
+				Title:       entry.Title,
+				Description: entry.Description,
+				URL:         entry.URL,
+				Icon:        icon,
+			})
+		}
+		config.Sections = append(config.Sections, section)
+	}
+	return marshalYAML(config)
+}
+
+// Heimdall has no config file; this matches the JSON its Settings > Import accepts.
+type heimdallItem struct {
+	Title       string   `json:"title"`
+	Colour      string   `json:"colour"`
+	URL         string   `json:"url"`
+	Description string   `json:"description,omitempty"`
+	Icon        string   `json:"icon,omitempty"`
+	Pinned      int      `json:"pinned"`
+	Tags        []string `json:"tags"`
+}
+
+// heimdallColour is the default tile colour Heimdall uses for new items.
+const heimdallColour = "#161b1f"
+
+func renderHeimdall(groups []Group, opts Options) ([]byte, error) {
+	items := []heimdallItem{}
+	for _, g := range groups {
+		for _, entry := range g.Entries {
+			items = append(items, heimdallItem{
+				Title:       entry.Title,
+				Colour:      heimdallColour,
+				URL:         entry.URL,
+				Description: entry.Description,
+				Icon:        iconURL(entry, opts),
+				Pinned:      1,
+				Tags:        []string{g.Name},
+			})
+		}
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func marshalYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,147 @@
+// Package export renders the discovered services into configuration formats of other dashboards.
+package export
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"docklet/catalog"
+)
+
+// DefaultCategory is used for services without a docklet.category label.
+const DefaultCategory = "Services"
+
+// Options control how services are rendered.
+type Options struct {
+	Title   string // Dashboard title, where the format has one
+	BaseURL string // Absolute Docklet URL used to turn icon paths like /api/icons/... into URLs
+}
+
+// Format describes an export format.
+type Format struct {
+	Name        string
+	ContentType string
+	FileName    string // Suggested file name for downloads
+	render      func([]Group, Options) ([]byte, error)
+}
+
+// Group is a category of services, in display order.
+type Group struct {
+	Name    string
+	Entries []catalog.Entry
+}
+
+var formats = map[string]Format{}
+
+func register(f Format) {
+	formats[f.Name] = f
+}
+
+// Formats returns the names of all supported formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the format with the given name.
+func Lookup(name string) (Format, bool) {
+	f, ok := formats[strings.ToLower(name)]
+	return f, ok
+}
+
+// Render renders entries in the given format.
+func (f Format) Render(entries []catalog.Entry, opts Options) ([]byte, error) {
+	if opts.Title == "" {
+		opts.Title = "Docklet"
+	}
+	return f.render(GroupByCategory(entries), opts)
+}
+
+// Render renders entries in the named format.
+func Render(name string, entries []catalog.Entry, opts Options) ([]byte, error) {
+	f, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown export format %q (supported: %s)", name, strings.Join(Formats(), ", "))
+	}
+	return f.Render(entries, opts)
+}
+
+// GroupByCategory groups entries by category. Groups are sorted by name with the default
+// category last; entries within a group are sorted by docklet.order, then title.
+// Entries without a URL are skipped since they can't be linked to.
+func GroupByCategory(entries []catalog.Entry) []Group {
+	byName := make(map[string]*Group)
+	var groups []*Group
+	for _, entry := range entries {
+		if entry.URL == "" {
+			continue
+		}
+		name := entry.Category
+		if name == "" {
+			name = DefaultCategory
+		}
+		g, ok := byName[name]
+		if !ok {
+			g = &Group{Name: name}
+			byName[name] = g
+			groups = append(groups, g)
+		}
+		g.Entries = append(g.Entries, entry)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if (groups[i].Name == DefaultCategory) != (groups[j].Name == DefaultCategory) {
+			return groups[j].Name == DefaultCategory
+		}
+		return groups[i].Name < groups[j].Name
+	})
+
+	result := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		sort.SliceStable(g.Entries, func(i, j int) bool {
+			oi, oj := orderOf(g.Entries[i]), orderOf(g.Entries[j])
+			if oi != oj {
+				return oi < oj
+			}
+			return strings.ToLower(g.Entries[i].Title) < strings.ToLower(g.Entries[j].Title)
+		})
+		result = append(result, *g)
+	}
+	return result
+}
+
+// orderOf parses docklet.order; services without a valid order sort after those with one.
+func orderOf(entry catalog.Entry) int {
+	if n, err := strconv.Atoi(entry.Order); err == nil {
+		return n
+	}
+	return int(^uint(0) >> 1)
+}
+
+// iconURL returns the entry's icon as an absolute URL, or "" if it has none.
+// CSS classes (e.g. "fas fa-film") are not URLs and are returned as "" here.
+func iconURL(entry catalog.Entry, opts Options) string {
+	switch {
+	case entry.Icon == "":
+		return ""
+	case strings.HasPrefix(entry.Icon, "/"):
+		return strings.TrimSuffix(opts.BaseURL, "/") + entry.Icon
+	case strings.Contains(entry.Icon, "://"):
+		return entry.Icon
+	}
+	return ""
+}
+
+// iconClass returns the entry's icon if it looks like a Font Awesome class.
+func iconClass(entry catalog.Entry) string {
+	if strings.HasPrefix(entry.Icon, "fa") && strings.Contains(entry.Icon, " ") {
+		return entry.Icon
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"docklet/catalog"
+	"docklet/importer"
+
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.golden with the current output")
+
+// testEntries covers categories, ordering, icon paths, icon classes and an entry without a URL.
+var testEntries = []catalog.Entry{
+	{Title: "Jellyfin", URL: "http://192.168.1.10:8096", Icon: "/api/icons/jellyfin.svg", Description: "Media server", Category: "Media", Order: "2"},
+	{Title: "Sonarr", URL: "http://192.168.1.10:8989", Icon: "fas fa-tv", Category: "Media", Order: "1"},
+	{Title: "Grafana", URL: "https://grafana.example.com", Icon: "https://example.com/grafana.png", Description: "Dashboards\nand alerts", Category: "Monitoring"},
+	{Title: "adminer", URL: "http://192.168.1.10:8081"},
+	{Title: "Worker", Category: "Media"},
+}
+
+var testOptions = Options{Title: "Home Lab", BaseURL: "http://docklet.lan:8080/"}
+
+// want is what each format must carry over for the entries with a URL, in display order.
+var want = []struct{ title, url, icon, description, category string }{
+	{"Sonarr", "http://192.168.1.10:8989", "fas fa-tv", "", "Media"},
+	{"Jellyfin", "http://192.168.1.10:8096", "http://docklet.lan:8080/api/icons/jellyfin.svg", "Media server", "Media"},
+	{"Grafana", "https://grafana.example.com", "https://example.com/grafana.png", "Dashboards\nand alerts", "Monitoring"},
+	{"adminer", "http://192.168.1.10:8081", "", "", DefaultCategory},
+}
+
+func TestRenderGolden(t *testing.T) {
+	for _, name := range []string{"homer", "homepage", "dashy", "heimdall"} {
+		t.Run(name, func(t *testing.T) {
+			got, err := Render(name, testEntries, testOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("output differs from %s (run go test -update to accept):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestRoundTripImporter(t *testing.T) {
+	for _, name := range []string{"homer", "homepage"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := Render(name, testEntries, testOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := importer.Parse(name, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parsed) != len(want) {
+				t.Fatalf("parsed %d links, want %d: %+v", len(parsed), len(want), parsed)
+			}
+			for i, w := range want {
+				link := parsed[i]
+				icon := w.icon
+				if name == "homepage" && icon == "fas fa-tv" {
+					icon = "" // Homepage has no icon classes
+				}
+				if link.Title != w.title || link.URL != w.url || link.Icon != icon ||
+					link.Description != w.description || link.Category != w.category || link.Origin != name {
+					t.Errorf("link %d = %+v, want %+v", i, link, w)
+				}
+			}
+		})
+	}
+}
+
+// Dashy and Heimdall configs can't be imported, so they are read back with their own schema.
+func TestRoundTripDashy(t *testing.T) {
+	data, err := Render("dashy", testEntries, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config dashyConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.PageInfo.Title != testOptions.Title {
+		t.Errorf("title = %q, want %q", config.PageInfo.Title, testOptions.Title)
+	}
+	var i int
+	for _, section := range config.Sections {
+		for _, item := range section.Items {
+			if i >= len(want) {
+				t.Fatalf("unexpected item %+v", item)
+			}
+			w := want[i]
+			if item.Title != w.title || item.URL != w.url || item.Icon != w.icon ||
+				item.Description != w.description || section.Name != w.category {
+				t.Errorf("item %d = %+v in %q, want %+v", i, item, section.Name, w)
+			}
+			i++
+		}
+	}
+	if i != len(want) {
+		t.Errorf("got %d items, want %d", i, len(want))
+	}
+}
+
+func TestRoundTripHeimdall(t *testing.T) {
+	data, err := Render("heimdall", testEntries, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []heimdallItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+	for i, w := range want {
+		item := items[i]
+		icon := w.icon
+		if icon == "fas fa-tv" {
+			icon = "" // Heimdall has no icon classes
+		}
+		if item.Title != w.title || item.URL != w.url || item.Icon != icon || item.Description != w.description ||
+			len(item.Tags) != 1 || item.Tags[0] != w.category || item.Colour != heimdallColour {
+			t.Errorf("item %d = %+v, want %+v", i, item, w)
+		}
+	}
+}
@@ -4,8 +4,10 @@ go 1.24.4
 
 require (
 	github.com/docker/docker v28.2.2+incompatible
-	github.com/gin-contrib/static v1.1.5
 	github.com/gin-gonic/gin v1.10.1
+	golang.org/x/crypto v0.38.0
+	golang.org/x/net v0.40.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -52,12 +54,9 @@ require (
 	go.opentelemetry.io/otel/metric v1.36.0 // indirect
 	go.opentelemetry.io/otel/trace v1.36.0 // indirect
 	golang.org/x/arch v0.16.0 // indirect
-	golang.org/x/crypto v0.38.0 // indirect
-	golang.org/x/net v0.40.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.25.0 // indirect
 	golang.org/x/time v0.12.0 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gotest.tools/v3 v3.5.2 // indirect
 )
@@ -0,0 +1,129 @@
+// Package health runs readiness checks against the components Docklet depends on.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dockerscanner "docklet/docker_scanner"
+)
+
+// Component and overall statuses.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded" // A non-critical component failed
+	StatusFailed   = "failed"   // A critical component failed
+)
+
+// Check is one component to verify.
+type Check struct {
+	Name     string
+	Critical bool // Failing makes Docklet not ready
+	Run      func(ctx context.Context) error
+}
+
+// ComponentStatus is the outcome of one check.
+type ComponentStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of a readiness check.
+type Report struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components"`
+	CheckedAt  time.Time         `json:"checked_at"`
+}
+
+// Ready reports whether no critical component failed.
+func (r *Report) Ready() bool {
+	return r.Status != StatusFailed
+}
+
+// Checker runs a set of checks concurrently, each bounded by a timeout.
+type Checker struct {
+	timeout time.Duration
+	checks  []Check
+}
+
+// NewChecker creates a Checker configured from environment variables:
+//
+//	DOCKLET_HEALTH_TIMEOUT   per check timeout (default 2s)
+//	DOCKLET_HEALTH_CRITICAL  comma-separated components whose failure makes Docklet not
+//	                         ready (default "docker"); other components only degrade it
+func NewChecker(checks ...Check) (*Checker, error) {
+	timeout, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_HEALTH_TIMEOUT", "2s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_HEALTH_TIMEOUT: %w", err)
+	}
+	critical := make(map[string]bool)
+	for _, name := range strings.Split(dockerscanner.GetEnvOrDefault("DOCKLET_HEALTH_CRITICAL", "docker"), ",") {
+		critical[strings.TrimSpace(name)] = true
+	}
+	for i := range checks {
+		checks[i].Critical = checks[i].Critical || critical[checks[i].Name]
+	}
+	return &Checker{timeout: timeout, checks: checks}, nil
+}
+
+// Run runs all checks and summarizes them.
+func (c *Checker) Run(ctx context.Context) *Report {
+	report := &Report{
+		Status:     StatusOK,
+		Components: make([]ComponentStatus, len(c.checks)),
+		CheckedAt:  time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Components[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status == StatusOK {
+			continue
+		}
+		if component.Critical {
+			report.Status = StatusFailed
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	sort.Slice(report.Components, func(i, j int) bool { return report.Components[i].Name < report.Components[j].Name })
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	status := ComponentStatus{Name: check.Name, Status: StatusOK, Critical: check.Critical}
+	start := time.Now()
+	// Checks that ignore ctx (e.g. reading procfs) still can't hold up the report
+	done := make(chan error, 1)
+	go func() { done <- check.Run(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", c.timeout)
+	}
+	status.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		status.Status = StatusFailed
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,237 @@
+// Package icons provides bundled, offline placeholder icons for well-known self-hosted
+// applications: monogram tiles in each application's brand color, not the applications'
+// logos. Icons are embedded in the binary and served from /api/icons/<key>, so the
+// dashboard never needs to fetch them from a CDN. When the enricher finds a service's own
+// icon, that replaces the guessed one.
+package icons
+
+import (
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+//go:embed assets/*.svg
+var assets embed.FS
+
+const (
+	builtinKeyPrefix  = "builtin-"
+	monogramKeyPrefix = "letter-"
+	keySuffix         = ".svg"
+	urlPrefix         = "/api/icons/"
+)
+
+// shortNamePrefixes are accepted in docklet.icon. "si:" follows Simple Icons naming and
+// "dashboard:" follows dashboard-icons naming; both resolve against the bundled set.
+var shortNamePrefixes = []string{"si:", "dashboard:", "builtin:"}
+
+// aliases maps image repository names and executable names to a bundled icon name.
+// Names that already match an icon file don't need an entry.
+var aliases = map[string]string{
+	"adguardhome":         "adguard",
+	"adguard-home":        "adguard",
+	"gitea-server":        "gitea",
+	"gitlab-ce":           "gitlab",
+	"gitlab-ee":           "gitlab",
+	"grafana-oss":         "grafana",
+	"grafana-enterprise":  "grafana",
+	"grafana-server":      "grafana",
+	"home-assistant":      "homeassistant",
+	"hass":                "homeassistant",
+	"immich-server":       "immich",
+	"influxd":             "influxdb",
+	"jellyfin-server":     "jellyfin",
+	"mongo":               "mongodb",
+	"mongod":              "mongodb",
+	"mariadbd":            "mariadb",
+	"mysqld":              "mysql",
+	"node-red":            "nodered",
+	"paperless-ngx":       "paperless",
+	"pi-hole":             "pihole",
+	"plex-media-server":   "plex",
+	"plexmediaserver":     "plex",
+	"pms-docker":          "plex",
+	"portainer-ce":        "portainer",
+	"portainer-ee":        "portainer",
+	"postgresql":          "postgres",
+	"prometheus-server":   "prometheus",
+	"qbittorrent-nox":     "qbittorrent",
+	"redis-server":        "redis",
+	"redis-stack":         "redis",
+	"syncthing-discosrv":  "syncthing",
+	"transmission-daemon": "transmission",
+	"vaultwarden/server":  "vaultwarden",
+	"bitwarden_rs":        "vaultwarden",
+	"codeserver":          "code-server",
+	"uptimekuma":          "uptime-kuma",
+	"minio/minio":         "minio",
+	"docker-desktop":      "docker",
+	"dockerd":             "docker",
+}
+
+// namePattern restricts icon names to safe file name characters.
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// URL returns the API path of a bundled icon.
+func URL(name string) string {
+	return urlPrefix + builtinKeyPrefix + name + keySuffix
+}
+
+// Has reports whether the bundled set contains an icon with this name.
+func Has(name string) bool {
+	if !namePattern.MatchString(name) {
+		return false
+	}
+	_, err := assets.Open("assets/" + name + keySuffix)
+	return err == nil
+}
+
+// lookup resolves a name or alias to a bundled icon name.
+func lookup(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if Has(name) {
+		return name, true
+	}
+	return "", false
+}
+
+// IsShortName reports whether a docklet.icon value uses the short name syntax, e.g. "si:grafana".
+func IsShortName(ref string) bool {
+	_, ok := trimShortName(ref)
+	return ok
+}
+
+// trimShortName returns the icon name of a short name.
+func trimShortName(ref string) (string, bool) {
+	for _, prefix := range shortNamePrefixes {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix), true
+		}
+	}
+	return "", false
+}
+
+// IsBundled reports whether an icon URL is served from the bundled set, including monograms.
+func IsBundled(iconURL string) bool {
+	return strings.HasPrefix(iconURL, urlPrefix+builtinKeyPrefix) || strings.HasPrefix(iconURL, urlPrefix+monogramKeyPrefix)
+}
+
+// Resolve turns a docklet.icon value into a URL. Short names are resolved against the
+// bundled set, falling back to a generated monogram so a typo never yields a broken image.
+// Anything else (URLs, CSS classes) is returned unchanged.
+func Resolve(ref string) string {
+	name, ok := trimShortName(ref)
+	if !ok {
+		return ref
+	}
+	if builtin, ok := lookup(name); ok {
+		return URL(builtin)
+	}
+	return urlPrefix + monogramKeyPrefix + monogramKey(name) + keySuffix
+}
+
+// ForImage returns the bundled icon URL for a container image, or "" if none matches.
+// It accepts references like "lscr.io/linuxserver/sonarr:latest" or "portainer/portainer-ce".
+func ForImage(image string) string {
+	repo := image
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo = repo[:i] // Digest
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i] // Tag
+	}
+	// Drop the registry host, if any.
+	if parts := strings.SplitN(repo, "/", 2); len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		repo = parts[1]
+	}
+
+	// Try the full repository path first ("vaultwarden/server"), then just the last component.
+	if name, ok := lookup(repo); ok {
+		return URL(name)
+	}
+	if name, ok := lookup(path.Base(repo)); ok {
+		return URL(name)
+	}
+	return ""
+}
+
+// ForExecutable returns the bundled icon URL for a native service, given its executable
+// path or service name (e.g. "/usr/sbin/nginx", "grafana-server.service", "homebrew.mxcl.redis").
+func ForExecutable(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".service"), ".exe")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:] // launchd labels like "homebrew.mxcl.nginx"
+	}
+	if icon, ok := lookup(name); ok {
+		return URL(icon)
+	}
+	return ""
+}
+
+// Asset returns the SVG for an icon key as served under /api/icons/, or false if the key
+// isn't a bundled or monogram icon.
+func Asset(key string) ([]byte, bool) {
+	if !strings.HasSuffix(key, keySuffix) {
+		return nil, false
+	}
+	name := strings.TrimSuffix(key, keySuffix)
+
+	switch {
+	case strings.HasPrefix(name, builtinKeyPrefix):
+		name = strings.TrimPrefix(name, builtinKeyPrefix)
+		if !Has(name) {
+			return nil, false
+		}
+		data, err := assets.ReadFile("assets/" + name + keySuffix)
+		return data, err == nil
+	case strings.HasPrefix(name, monogramKeyPrefix):
+		name = strings.TrimPrefix(name, monogramKeyPrefix)
+		if !namePattern.MatchString(name) {
+			return nil, false
+		}
+		return monogram(name), true
+	}
+	return nil, false
+}
+
+// monogramKey normalizes a name for use in a monogram icon key.
+func monogramKey(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.') {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 || !namePattern.MatchString(b.String()) {
+		return "x" + b.String()
+	}
+	return b.String()
+}
+
+// monogramColors are used for generated icons, picked by a hash of the name.
+var monogramColors = []string{"#2563EB", "#7C3AED", "#DB2777", "#DC2626", "#EA580C", "#16A34A", "#0891B2", "#4B5563"}
+
+// monogram renders a rounded square with the first two letters of name.
+func monogram(name string) []byte {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	color := monogramColors[h.Sum32()%uint32(len(monogramColors))]
+
+	text := strings.ToUpper(name[:1])
+	if len(name) > 1 {
+		text += name[1:2]
+	}
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 64 64" width="64" height="64">
+  <rect width="64" height="64" rx="14" fill="%s"/>
+  <text x="32" y="41" font-family="Helvetica, Arial, sans-serif" font-size="26" font-weight="700" fill="#FFFFFF" text-anchor="middle">%s</text>
+</svg>
+`, color, text))
+}
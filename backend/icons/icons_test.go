@@ -0,0 +1,103 @@
+package icons
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForImage(t *testing.T) {
+	for _, tt := range []struct {
+		image string
+		want  string
+	}{
+		{"linuxserver/sonarr", "/api/icons/builtin-sonarr.svg"},
+		{"lscr.io/linuxserver/sonarr:latest", "/api/icons/builtin-sonarr.svg"},
+		{"ghcr.io/linuxserver/radarr:5.2.6@sha256:0123abcd", "/api/icons/builtin-radarr.svg"},
+		{"grafana/grafana", "/api/icons/builtin-grafana.svg"},
+		{"grafana/grafana-oss:10.4.1", "/api/icons/builtin-grafana.svg"},
+		{"portainer/portainer-ce:2.19.4", "/api/icons/builtin-portainer.svg"},
+		{"vaultwarden/server:latest", "/api/icons/builtin-vaultwarden.svg"},
+		{"postgres:16-alpine", "/api/icons/builtin-postgres.svg"},
+		{"mongo", "/api/icons/builtin-mongodb.svg"},
+		{"ghcr.io/home-assistant/home-assistant:stable", "/api/icons/builtin-homeassistant.svg"},
+		{"localhost:5000/jellyfin/jellyfin", "/api/icons/builtin-jellyfin.svg"},
+		{"Pihole/PiHole", "/api/icons/builtin-pihole.svg"},
+		// Unknown images and names that only look like a path
+		{"example/my-app:1.0", ""},
+		{"", ""},
+		{"../../etc/passwd", ""},
+	} {
+		if got := ForImage(tt.image); got != tt.want {
+			t.Errorf("ForImage(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestForExecutable(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"/usr/sbin/nginx", "/api/icons/builtin-nginx.svg"},
+		{"grafana-server.service", "/api/icons/builtin-grafana.svg"},
+		{"homebrew.mxcl.redis", "/api/icons/builtin-redis.svg"},
+		{`C:\Program Files\Plex\Plex Media Server\PlexMediaServer.exe`, "/api/icons/builtin-plex.svg"},
+		{"/usr/bin/mysqld", "/api/icons/builtin-mysql.svg"},
+		{"sshd", ""},
+	} {
+		if got := ForExecutable(tt.name); got != tt.want {
+			t.Errorf("ForExecutable(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	for _, tt := range []struct {
+		ref  string
+		want string
+	}{
+		{"si:grafana", "/api/icons/builtin-grafana.svg"},
+		{"dashboard:sonarr", "/api/icons/builtin-sonarr.svg"},
+		{"builtin:portainer", "/api/icons/builtin-portainer.svg"},
+		// Each set's own spelling of a name
+		{"si:homeassistant", "/api/icons/builtin-homeassistant.svg"},
+		{"dashboard:home-assistant", "/api/icons/builtin-homeassistant.svg"},
+		{"dashboard:adguard-home", "/api/icons/builtin-adguard.svg"},
+		{"si:uptimekuma", "/api/icons/builtin-uptime-kuma.svg"},
+		// Unknown names get a monogram instead of a broken image
+		{"si:my-app", "/api/icons/letter-my-app.svg"},
+		{"dashboard:<script>", "/api/icons/letter-script.svg"},
+		{"si:", "/api/icons/letter-x.svg"},
+		// Anything else is left alone
+		{"https://nas.lan/icon.png", "https://nas.lan/icon.png"},
+		{"mdi:server", "mdi:server"},
+		{"", ""},
+	} {
+		if got := Resolve(tt.ref); got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+		if got := IsShortName(tt.ref); got != (tt.want != tt.ref) {
+			t.Errorf("IsShortName(%q) = %t", tt.ref, got)
+		}
+	}
+}
+
+func TestAsset(t *testing.T) {
+	for _, url := range []string{Resolve("si:grafana"), Resolve("si:my-app"), ForImage("linuxserver/sonarr")} {
+		if !IsBundled(url) {
+			t.Errorf("IsBundled(%q) = false", url)
+		}
+		data, ok := Asset(strings.TrimPrefix(url, urlPrefix))
+		if !ok || !strings.HasPrefix(string(data), "<svg") {
+			t.Errorf("Asset for %s = %q, %t", url, data, ok)
+		}
+	}
+	for _, key := range []string{"builtin-nope.svg", "builtin-grafana.png", "builtin-../icons.go", "letter-.svg", "letter-A B.svg", "grafana.svg"} {
+		if _, ok := Asset(key); ok {
+			t.Errorf("Asset(%q) found", key)
+		}
+	}
+	if IsBundled("/api/icons/0123456789abcdef0123456789abcdef.png") {
+		t.Error("cached favicon counted as bundled")
+	}
+}
@@ -0,0 +1,96 @@
+package importer
+
+import (
+	"strings"
+
+	"docklet/catalog"
+	"docklet/links"
+)
+
+// Conflict reasons.
+const (
+	ConflictURL   = "url"   // The link points at the same URL as a discovered service
+	ConflictTitle = "title" // The link has the same title as a discovered service
+)
+
+// Conflict is an imported link that matches a discovered service.
+type Conflict struct {
+	Link       links.Link `json:"link"`
+	EntryID    string     `json:"entry_id"`    // ID of the catalog entry it conflicts with
+	EntryTitle string     `json:"entry_title"` // Title of that entry
+	Reason     string     `json:"reason"`      // "url" or "title"
+}
+
+// Result describes what an import did, or would do in a dry run.
+type Result struct {
+	DryRun    bool         `json:"dry_run"`
+	Added     []links.Link `json:"added"`     // New links
+	Updated   []links.Link `json:"updated"`   // Links that replaced a stored link with the same URL
+	Conflicts []Conflict   `json:"conflicts"` // Links matching a discovered service
+}
+
+// Options control an import.
+type Options struct {
+	DryRun bool // Only report what would happen
+	// KeepConflicts also stores links that conflict with discovered services.
+	// By default they are skipped so a service isn't listed twice.
+	KeepConflicts bool
+}
+
+// Import stores parsed links, reporting conflicts with discovered catalog entries.
+func Import(store *links.Store, parsed []links.Link, discovered []catalog.Entry, opts Options) (*Result, error) {
+	result := &Result{
+		DryRun:    opts.DryRun,
+		Added:     []links.Link{},
+		Updated:   []links.Link{},
+		Conflicts: []Conflict{},
+	}
+
+	var toStore []links.Link
+	for _, link := range parsed {
+		if conflict, ok := findConflict(link, discovered); ok {
+			result.Conflicts = append(result.Conflicts, conflict)
+			if !opts.KeepConflicts {
+				continue
+			}
+		}
+
+		_, exists, err := store.Get(link.ID)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			result.Updated = append(result.Updated, link)
+		} else {
+			result.Added = append(result.Added, link)
+		}
+		toStore = append(toStore, link)
+	}
+
+	if opts.DryRun || len(toStore) == 0 {
+		return result, nil
+	}
+	return result, store.Put(toStore...)
+}
+
+// findConflict matches a link against discovered services (links themselves are ignored),
+// first by URL, then by title.
+func findConflict(link links.Link, discovered []catalog.Entry) (Conflict, bool) {
+	normalized := links.NormalizeURL(link.URL)
+	var byTitle *catalog.Entry
+	for i, entry := range discovered {
+		if entry.Source == catalog.SourceLink {
+			continue
+		}
+		if entry.URL != "" && links.NormalizeURL(entry.URL) == normalized {
+			return Conflict{Link: link, EntryID: entry.ID, EntryTitle: entry.Title, Reason: ConflictURL}, true
+		}
+		if byTitle == nil && strings.EqualFold(strings.TrimSpace(entry.Title), strings.TrimSpace(link.Title)) {
+			byTitle = &discovered[i]
+		}
+	}
+	if byTitle != nil {
+		return Conflict{Link: link, EntryID: byTitle.ID, EntryTitle: byTitle.Title, Reason: ConflictTitle}, true
+	}
+	return Conflict{}, false
+}
@@ -0,0 +1,264 @@
+// Package importer parses other dashboards' configs and bookmark files into links.
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"docklet/links"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// maxIconDataURI bounds the size of inline bookmark icons we keep.
+const maxIconDataURI = 16 << 10
+
+var parsers = map[string]func([]byte) ([]links.Link, error){
+	"homer":     parseHomer,
+	"homepage":  parseHomepage,
+	"bookmarks": parseBookmarks,
+}
+
+// Formats returns the names of all supported import formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsFormat reports whether name is a supported import format.
+func IsFormat(name string) bool {
+	_, ok := parsers[name]
+	return ok
+}
+
+// Parse parses data in the given format. Links without an http(s) URL are dropped.
+func Parse(format string, data []byte) ([]links.Link, error) {
+	parse, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown import format %q (supported: %s)", format, strings.Join(Formats(), ", "))
+	}
+	parsed, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s config: %w", format, err)
+	}
+
+	now := time.Now().UTC()
+	var result []links.Link
+	seen := make(map[string]bool)
+	for _, link := range parsed {
+		u, err := url.Parse(strings.TrimSpace(link.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		link.URL = u.String()
+		link.ID = links.IDForURL(link.URL)
+		if seen[link.ID] {
+			continue // Same URL listed twice in the source file
+		}
+		seen[link.ID] = true
+		if link.Title == "" {
+			link.Title = u.Host
+		}
+		link.Origin = format
+		link.ImportedAt = now
+		result = append(result, link)
+	}
+	return result, nil
+}
+
+// parseHomer parses a Homer config.yml.
+func parseHomer(data []byte) ([]links.Link, error) {
+	var config struct {
+		Services []struct {
+			Name  string `yaml:"name"`
+			Items []struct {
+				Name     string `yaml:"name"`
+				Logo     string `yaml:"logo"`
+				Icon     string `yaml:"icon"`
+				Subtitle string `yaml:"subtitle"`
+				URL      string `yaml:"url"`
+			} `yaml:"items"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	var result []links.Link
+	for _, group := range config.Services {
+		for _, item := range group.Items {
+			icon := item.Logo
+			if icon == "" {
+				icon = item.Icon
+			}
+			result = append(result, links.Link{
+				Title:       item.Name,
+				URL:         item.URL,
+				Icon:        icon,
+				Description: item.Subtitle,
+				Category:    group.Name,
+			})
+		}
+	}
+	return result, nil
+}
+
+// parseHomepage parses a Homepage services.yaml: a list of single-key maps from group
+// name to a list of single-key maps from service name to its settings. Groups may nest.
+func parseHomepage(data []byte) ([]links.Link, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	var result []links.Link
+	if err := walkHomepageGroups(root.Content[0], &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func walkHomepageGroups(list *yaml.Node, result *[]links.Link) error {
+	if list.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: expected a list of groups", list.Line)
+	}
+	for _, group := range list.Content {
+		if group.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(group.Content); i += 2 {
+			name, services := group.Content[i].Value, group.Content[i+1]
+			if services.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, service := range services.Content {
+				if service.Kind != yaml.MappingNode || len(service.Content) < 2 {
+					continue
+				}
+				title, value := service.Content[0].Value, service.Content[1]
+				if value.Kind == yaml.SequenceNode {
+					// Nested group: { Name: [ {service: ...}, ... ] }
+					nested := &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{service}}
+					if err := walkHomepageGroups(nested, result); err != nil {
+						return err
+					}
+					continue
+				}
+				var settings struct {
+					Href        string `yaml:"href"`
+					Description string `yaml:"description"`
+					Icon        string `yaml:"icon"`
+				}
+				if err := value.Decode(&settings); err != nil {
+					return fmt.Errorf("line %d: %w", value.Line, err)
+				}
+				*result = append(*result, links.Link{
+					Title:       title,
+					URL:         settings.Href,
+					Icon:        settings.Icon,
+					Description: settings.Description,
+					Category:    name,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// parseBookmarks parses a Netscape bookmark file as exported by Firefox and Chrome.
+// The innermost folder of each bookmark becomes its category.
+func parseBookmarks(data []byte) ([]links.Link, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var result []links.Link
+	walkBookmarks(doc, "", &result)
+	return result, nil
+}
+
+// walkBookmarks visits n's children. A <H3> names the folder whose <DL> follows it; depending
+// on how the parser closed the surrounding <DT>/<DD> tags, that <DL> is a later sibling or sits
+// inside a sibling. It returns the name of a folder declared but not yet consumed by a <DL>,
+// and whether a <DL> was consumed below n.
+func walkBookmarks(n *html.Node, category string, result *[]links.Link) (pending string, consumed bool) {
+	folder := category
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "h3":
+			pending = strings.TrimSpace(textOf(c))
+			folder = pending
+		case "a":
+			link := links.Link{Title: strings.TrimSpace(textOf(c)), Category: category}
+			for _, a := range c.Attr {
+				switch strings.ToLower(a.Key) {
+				case "href":
+					link.URL = a.Val
+				case "icon_uri":
+					link.Icon = a.Val
+				case "icon":
+					if link.Icon == "" && len(a.Val) <= maxIconDataURI {
+						link.Icon = a.Val
+					}
+				}
+			}
+			if dd := nextElement(c.Parent); dd != nil && dd.Data == "dd" {
+				link.Description = strings.TrimSpace(textOf(dd))
+			}
+			*result = append(*result, link)
+		case "dl":
+			walkBookmarks(c, folder, result)
+			folder, pending, consumed = category, "", true
+		default:
+			childPending, childConsumed := walkBookmarks(c, folder, result)
+			if childConsumed {
+				folder, pending, consumed = category, "", true
+			}
+			if childPending != "" {
+				folder, pending = childPending, childPending
+			}
+		}
+	}
+	return pending, consumed
+}
+
+func nextElement(n *html.Node) *html.Node {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+func textOf(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "dl" || c.Data == "dd") {
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -0,0 +1,199 @@
+// Package links stores manually added or imported links, so they can be listed
+// alongside discovered services.
+package links
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"docklet/internal/fileutil"
+)
+
+const storeFile = "links.json"
+
+// Link is a persisted service link. Field names follow docker_scanner.ServiceInfo.
+type Link struct {
+	ID          string    `json:"id"`                    // Derived from the URL, so re-importing updates instead of duplicating
+	Title       string    `json:"title"`                 // Display title
+	URL         string    `json:"url"`                   // Link target
+	Icon        string    `json:"icon,omitempty"`        // Icon URL or class
+	Description string    `json:"description,omitempty"` // Short description
+	Category    string    `json:"category,omitempty"`    // Group / folder name
+	Origin      string    `json:"origin,omitempty"`      // Format it was imported from, e.g. "homer"
+	ImportedAt  time.Time `json:"imported_at"`
+}
+
+// IDForURL returns the link ID for a URL.
+func IDForURL(rawURL string) string {
+	sum := sha256.Sum256([]byte(NormalizeURL(rawURL)))
+	return hex.EncodeToString(sum[:6])
+}
+
+// NormalizeURL lowercases scheme and host, drops default ports and a trailing slash,
+// so equivalent URLs compare equal.
+func NormalizeURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return strings.TrimSuffix(strings.TrimSpace(rawURL), "/")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.Fragment = ""
+	return u.String()
+}
+
+// Store persists links as JSON in the data directory. It reloads the file when it
+// changes on disk, so links imported from the CLI show up in a running server.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	links   map[string]Link
+	modTime time.Time
+}
+
+// NewStore opens the link store in dataDir, creating the directory if needed.
+func NewStore(dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	s := &Store{path: filepath.Join(dataDir, storeFile), links: make(map[string]Link)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns all links sorted by category and title.
+func (s *Store) List() ([]Link, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+
+	links := make([]Link, 0, len(s.links))
+	for _, link := range s.links {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Category != links[j].Category {
+			return links[i].Category < links[j].Category
+		}
+		return strings.ToLower(links[i].Title) < strings.ToLower(links[j].Title)
+	})
+	return links, nil
+}
+
+// Get returns the link with the given ID.
+func (s *Store) Get(id string) (Link, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return Link{}, false, err
+	}
+	link, ok := s.links[id]
+	return link, ok, nil
+}
+
+// Put adds or replaces links and saves the store.
+func (s *Store) Put(links ...Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return err
+	}
+	for _, link := range links {
+		s.links[link.ID] = link
+	}
+	return s.saveLocked()
+}
+
+// Delete removes a link and saves the store. It reports whether the link existed.
+func (s *Store) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return false, err
+	}
+	if _, ok := s.links[id]; !ok {
+		return false, nil
+	}
+	delete(s.links, id)
+	return true, s.saveLocked()
+}
+
+// reloadLocked reads the file if it changed since the last load. A missing file means no links.
+func (s *Store) reloadLocked() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var links []Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	s.links = make(map[string]Link, len(links))
+	for _, link := range links {
+		s.links[link.ID] = link
+	}
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// saveLocked writes all links to disk atomically.
+func (s *Store) saveLocked() error {
+	links := make([]Link, 0, len(s.links))
+	for _, link := range s.links {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := fileutil.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
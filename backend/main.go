@@ -1,38 +1,280 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strings"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"docklet/api"
+	"docklet/catalog"
+	"docklet/certcheck"
+	"docklet/certs"
+	"docklet/dnsrecords"
 	dockerscanner "docklet/docker_scanner" // Renamed import for clarity
+	"docklet/enricher"
+	"docklet/health"
+	"docklet/links"
+	"docklet/mdns"
+	"docklet/metrics"
+	"docklet/monitor"
+	"docklet/notify"
+	"docklet/portaudit"
+	"docklet/portforward"
+	"docklet/proxy"
 	systemscanner "docklet/system_scanner" // Added for system services
+	"docklet/webui"
 
-	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
 )
 
 const (
-	DefaultPort = "8888"
+	DefaultPort            = "8888"
+	DefaultTLSPort         = "8443"
+	DefaultDataDir         = "./data"
+	DefaultShutdownTimeout = "10s"
 )
 
 func main() {
+	// Subcommands (e.g. "docklet export --format homer") run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	// Create a new Docker client
 	dockerCli, err := dockerscanner.NewScanner()
 	if err != nil {
 		log.Fatalf("Failed to initialize Docker scanner: %v", err)
 	}
-	// defer dockerCli.Close() // Consider closing when app exits
+	// Closed on shutdown, after the server and background workers have stopped
 
 	// Create a new System scanner
 	sysScanner, err := systemscanner.NewSystemScanner()
 	if err != nil {
 		log.Fatalf("Failed to initialize System scanner: %v", err)
 	}
-	// defer sysScanner.Close() // Consider closing when app exits
+
+	// Time allowed on SIGINT/SIGTERM for in-flight requests and background work to finish
+	shutdownTimeout, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_SHUTDOWN_TIMEOUT", DefaultShutdownTimeout))
+	if err != nil {
+		log.Fatalf("Invalid DOCKLET_SHUTDOWN_TIMEOUT: %v", err)
+	}
+
+	// Cancelled on SIGINT/SIGTERM, which stops the background workers
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var workers sync.WaitGroup
+
+	// Directory for caches and other state that should survive restarts
+	dataDir := dockerscanner.GetEnvOrDefault("DOCKLET_DATA_DIR", DefaultDataDir)
+
+	// Links imported from other dashboards, listed alongside discovered services
+	linkStore, err := links.NewStore(dataDir)
+	if err != nil {
+		log.Fatalf("Failed to open link store: %v", err)
+	}
+
+	collector, err := catalog.NewCollector(dockerCli, sysScanner, linkStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize collector: %v", err)
+	}
+
+	// Background enrichment of titles and icons for services without labels
+	var enr *enricher.Enricher
+	if dockerscanner.GetEnvOrDefault("DOCKLET_ENRICH", "true") != "false" {
+		enr, err = enricher.NewEnricher(dataDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize enricher: %v", err)
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			enr.Run(ctx, collector.Collect)
+		}()
+	}
+
+	// Notifications about services going down or up, new containers and expiring certificates
+	var notifier *notify.Dispatcher
+	if dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_URLS", "") != "" {
+		notifier, err = notify.NewDispatcher(dataDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize notifications: %v", err)
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			notifier.Run(ctx)
+		}()
+	}
+
+	// Cap on services with metrics of their own, to bound label cardinality
+	maxServices, err := strconv.Atoi(dockerscanner.GetEnvOrDefault("DOCKLET_METRICS_MAX_SERVICES", "100"))
+	if err != nil {
+		log.Fatalf("Invalid DOCKLET_METRICS_MAX_SERVICES: %v", err)
+	}
+
+	// Background probing of every service, feeding per-service metrics
+	var mon *monitor.Monitor
+	if dockerscanner.GetEnvOrDefault("DOCKLET_MONITOR", "true") != "false" {
+		mon, err = monitor.NewMonitor()
+		if err != nil {
+			log.Fatalf("Failed to initialize monitor: %v", err)
+		}
+		mon.RegisterMetrics(maxServices)
+		if notifier != nil {
+			mon.OnRound = notifier.ObserveRound
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			mon.Run(ctx, collector.Collect)
+		}()
+	}
+
+	// Background checks of the certificates https services present, warning before they expire
+	var certChecker *certcheck.Checker
+	if dockerscanner.GetEnvOrDefault("DOCKLET_CERTCHECK", "true") != "false" {
+		certChecker, err = certcheck.NewChecker()
+		if err != nil {
+			log.Fatalf("Failed to initialize certificate checks: %v", err)
+		}
+		certChecker.RegisterMetrics(maxServices)
+		if notifier != nil {
+			certChecker.OnLevelChange = notifier.ObserveCertificate
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			certChecker.Run(ctx, collector.Collect)
+		}()
+	}
+
+	// Publishes services as <name>.local with mDNS/DNS-SD; opt-in since it claims names on the LAN
+	var advertiser *mdns.Advertiser
+	if dockerscanner.GetEnvOrDefault("DOCKLET_MDNS", "false") == "true" {
+		advertiser, err = mdns.NewAdvertiser()
+		if err != nil {
+			log.Fatalf("Failed to initialize mDNS: %v", err)
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			advertiser.Run(ctx, collector.Collect)
+		}()
+	}
+
+	// TLS-terminating proxies in front of Docklet, whose X-Forwarded-Proto is believed
+	trustedProxies, err := proxy.ParseNetworks(dockerscanner.GetEnvOrDefault("DOCKLET_TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatalf("Invalid DOCKLET_TRUSTED_PROXIES: %v", err)
+	}
+
+	// Reverse proxy to services at their internal address; opt-in, and per service too, since
+	// it makes services reachable through Docklet's port
+	var reverseProxy *proxy.Proxy
+	if dockerscanner.GetEnvOrDefault("DOCKLET_PROXY", "false") == "true" {
+		reverseProxy, err = proxy.NewProxy()
+		if err != nil {
+			log.Fatalf("Failed to initialize proxy: %v", err)
+		}
+		reverseProxy.TrustedProxies = trustedProxies
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			reverseProxy.Run(ctx, collector.Collect)
+		}()
+	}
+
+	// HTTPS with the user's certificate or certificates from a local CA, which also issues
+	// certificates for <name>.<domain> of services behind the proxy
+	var certManager *certs.Manager
+	tlsPort := dockerscanner.GetEnvOrDefault("DOCKLET_TLS_PORT", DefaultTLSPort)
+	if dockerscanner.GetEnvOrDefault("DOCKLET_TLS", "false") == "true" {
+		var proxyDomains []string
+		if reverseProxy != nil {
+			proxyDomains = append(proxyDomains, reverseProxy.Domain)
+		}
+		certManager, err = certs.NewManager(dataDir, proxyDomains, func(host string) bool {
+			if reverseProxy == nil {
+				return false
+			}
+			if reverseProxy.IsPathHost(host) {
+				return true
+			}
+			name, ok := reverseProxy.NameForHost(host)
+			if ok {
+				_, ok = reverseProxy.Lookup(name)
+			}
+			return ok
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize TLS: %v", err)
+		}
+	}
+
+	// Host names for services, served over the API and written to DOCKLET_DNS_OUTPUTS if set
+	dnsGen, err := dnsrecords.NewGenerator(collector)
+	if err != nil {
+		log.Fatalf("Failed to initialize DNS records: %v", err)
+	}
+	if len(dnsGen.Outputs) > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			dnsGen.Run(ctx)
+		}()
+	}
+
+	// Audit of the host ports in use, for conflicts and accidentally exposed databases
+	auditor, err := portaudit.NewAuditor(collector)
+	if err != nil {
+		log.Fatalf("Failed to initialize the port audit: %v", err)
+	}
+
+	// Port forwards for services labelled docklet.expose=wan; opt-in since they open ports
+	// to the internet. OpenWrt rules are changed through the API, UPnP and NAT-PMP mappings
+	// are kept in the background.
+	var forwards *portforward.Manager
+	var mappings *portforward.Keeper
+	if dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD", "false") == "true" {
+		switch method := dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_METHOD", "openwrt"); method {
+		case "openwrt":
+			forwards, err = portforward.NewManager(collector)
+		default:
+			mappings, err = portforward.NewKeeper(collector, method)
+		}
+		if err != nil {
+			log.Fatalf("Failed to initialize port forwarding: %v", err)
+		}
+		if mappings != nil {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				mappings.Run(ctx)
+			}()
+		}
+	}
+
+	// Readiness checks; DOCKLET_HEALTH_CRITICAL selects which failures make Docklet not ready
+	checker, err := health.NewChecker(
+		health.Check{Name: "docker", Run: func(ctx context.Context) error {
+			_, err := dockerCli.Ping(ctx)
+			return err
+		}},
+		health.Check{Name: "system", Run: func(context.Context) error { return sysScanner.Ping() }},
+		health.Check{Name: "links", Run: func(context.Context) error {
+			_, err := linkStore.List()
+			return err
+		}},
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize health checks: %v", err)
+	}
 
 	// Get port from environment or use default
 	port := dockerscanner.GetEnvOrDefault("DOCKLET_PORT", DefaultPort)
@@ -40,58 +282,112 @@ func main() {
 
 	// Initialize Gin router
 	router := gin.Default()
+	middleware := []gin.HandlerFunc{api.RequestIDMiddleware(), api.MetricsMiddleware()}
 
-	// API routes
-	apiRoutes := router.Group("/api")
-	{
-		apiRoutes.GET("/services", api.ServicesHandlerGin(dockerCli)) // Docker services
-		apiRoutes.GET("/system-services", api.SystemServicesHandlerGin(sysScanner)) // Native system services
-		apiRoutes.GET("/health", api.HealthCheckHandlerGin())
-	}
-
-	// Serve static files for the frontend
-	// Vue/React apps usually build to a 'dist' folder.
-	// We'll serve from './frontend/dist' assuming the Vue app is in './frontend'
-	// and its build output is in 'dist'.
-	frontendDistPath := "./frontend/dist"
-
-	// Check if the frontend build directory exists
-	if _, err := os.Stat(frontendDistPath); !os.IsNotExist(err) {
-		// Serve static files from frontend/dist
-		router.Use(static.Serve("/", static.LocalFile(frontendDistPath, false)))
-		// If you want to ensure that non-API routes also serve index.html for SPA routing:
-		router.NoRoute(func(c *gin.Context) {
-			// Check if it's not an API call and not a file that exists in static
-			if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
-				// Check if the file exists in the static directory
-                // If not, serve index.html
-                filePath := filepath.Join(frontendDistPath, c.Request.URL.Path)
-                if _, err := os.Stat(filePath); os.IsNotExist(err) {
-                    c.File(filepath.Join(frontendDistPath, "index.html"))
-                    return
-                }
-			}
-			// Default 404 if it's an API route not found or an existing file not found by static.Serve
-			// c.JSON(http.StatusNotFound, gin.H{"code": "PAGE_NOT_FOUND", "message": "Page not found"})
-		})
-		log.Printf("Serving Vue/React frontend from %s on /", frontendDistPath)
+	// Plain HTTP is redirected to HTTPS, except for local health checks and CA downloads
+	if certManager != nil && dockerscanner.GetEnvOrDefault("DOCKLET_TLS_REDIRECT", "true") != "false" {
+		middleware = append(middleware, api.HTTPSRedirectMiddleware(tlsPort, trustedProxies))
+	}
+	router.Use(middleware...)
+
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// API routes, versioned under /api/v1. The unversioned /api paths remain as aliases for
+	// existing scripts; only /api/catalog keeps its old bare-list response.
+	comp := components{
+		collector:      collector,
+		enr:            enr,
+		linkStore:      linkStore,
+		auditor:        auditor,
+		forwards:       forwards,
+		mappings:       mappings,
+		advertiser:     advertiser,
+		reverseProxy:   reverseProxy,
+		trustedProxies: trustedProxies,
+		certChecker:    certChecker,
+		certManager:    certManager,
+		notifier:       notifier,
+		dnsGen:         dnsGen,
+		checker:        checker,
+	}
+	registerAPIRoutes(router.Group("/api/v1"), api.CatalogHandlerGin(collector, enr, certChecker), comp)
+	registerAPIRoutes(router.Group("/api"), api.LegacyCatalogHandlerGin(collector, enr, certChecker), comp)
+
+	// Frontend: embedded with -tags embedui, DOCKLET_UI_DIR, or ./frontend/dist
+	var ui http.Handler
+	if uiFS, uiSource := webui.Source(); uiFS != nil {
+		ui = webui.NewHandler(uiFS)
+		log.Printf("Serving web UI from %s on /", uiSource)
 	} else {
-		// Fallback if no frontend build is found (e.g., during backend-only development)
-		// This also means the old './static' directory is no longer primary.
-		// We can remove the old static serving logic or keep it as a deeper fallback.
-		// For now, let's assume the new frontend will be the primary.
-		log.Printf("No frontend build directory found at %s. API will be available, but no UI.", frontendDistPath)
+		log.Printf("Web UI disabled: %s. API will be available, but no UI.", uiSource)
 		router.GET("/", func(c *gin.Context) {
-			c.String(http.StatusOK, "Docklet Gin API is running. No frontend UI found at %s.", frontendDistPath)
+			c.String(http.StatusOK, "Docklet Gin API is running. No frontend UI found.")
 		})
 	}
+	router.NoRoute(api.NotFoundHandlerGin(ui))
 
 	log.Printf("Docklet Gin server starting on %s", listenAddr)
-	log.Printf("Docker Services API: http://%s%s/api/services", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
-	log.Printf("System Services API: http://%s%s/api/system-services", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
-	log.Printf("Health check: http://%s%s/api/health", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
+	log.Printf("Docker Services API: http://%s%s/api/v1/services", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
+	log.Printf("System Services API: http://%s%s/api/v1/system-services", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
+	log.Printf("Catalog API: http://%s%s/api/v1/catalog", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
+	log.Printf("Metrics: http://%s%s/metrics", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
+	log.Printf("Health check: http://%s%s/api/v1/health/ready", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), listenAddr)
+
+	// Proxied services at <name>.<domain> and <domain>/s/<name>/ are dispatched before the router
+	handler := http.Handler(router)
+	if reverseProxy != nil {
+		handler = api.ProxyHostHandler(reverseProxy, router, middleware...)
+	}
+
+	server := &http.Server{Addr: listenAddr, Handler: handler}
+	serveErr := make(chan error, 2)
+	go func() { serveErr <- server.ListenAndServe() }()
+
+	var tlsServer *http.Server
+	if certManager != nil {
+		tlsServer = &http.Server{Addr: ":" + tlsPort, Handler: handler, TLSConfig: certManager.TLSConfig()}
+		go func() { serveErr <- tlsServer.ListenAndServeTLS("", "") }()
+		log.Printf("HTTPS: https://%s:%s/ with %s certificates", dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost), tlsPort, certManager.Mode)
+	}
 
-	if err := router.Run(listenAddr); err != nil {
+	select {
+	case err := <-serveErr:
 		log.Fatalf("Failed to start Gin server: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills the process right away
+	log.Printf("Shutting down, waiting up to %s for requests and background work", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// Stops accepting connections and waits for in-flight requests
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to drain requests: %v", err)
+	}
+	if tlsServer != nil {
+		if err := tlsServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to drain HTTPS requests: %v", err)
+		}
+	}
+
+	// The enricher and monitor return once their current round sees the cancelled context
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Background workers did not stop within %s", shutdownTimeout)
+	}
+
+	if err := sysScanner.Close(); err != nil {
+		log.Printf("Failed to close system scanner: %v", err)
+	}
+	if err := dockerCli.Close(); err != nil {
+		log.Printf("Failed to close Docker client: %v", err)
 	}
-}
\ No newline at end of file
+	log.Printf("Docklet stopped")
+}
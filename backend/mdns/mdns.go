@@ -0,0 +1,443 @@
+// Package mdns publishes discovered web services on the local network with multicast DNS
+// and DNS-SD (RFC 6762, RFC 6763), so they can be reached as <name>.local and show up in
+// service browsers without editing DNS.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	mdnsPort   = 5353
+	recordTTL  = 120 // Seconds; RFC 6762 recommends 120 for records that name hosts
+	legacyTTL  = 10  // Maximum TTL in answers to resolvers that aren't mDNS-aware
+	cacheFlush = 0x8000
+
+	probeInterval = 250 * time.Millisecond
+	maxRenames    = 5 // Suffixes tried when a host name is taken by another device
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+var servicesName = mustName("_services._dns-sd._udp.local.")
+
+// Advertiser answers mDNS queries for the services it publishes and announces them as
+// they come and go.
+type Advertiser struct {
+	interval  time.Duration
+	fixedIP   net.IP        // Address to publish instead of each interface's own
+	localHost string        // DOCKLET_HOST_IP, which service URLs of this host use
+	probeWait time.Duration // probeInterval but in tests
+
+	conn    *ipv4.PacketConn
+	ifaces  []net.Interface
+	writeMu sync.Mutex // SetMulticastInterface and WriteTo go together
+
+	mu        sync.RWMutex
+	published map[string]Service // Keyed by catalog entry ID
+	probing   map[string]bool    // Lowercased host names being probed; true once taken
+}
+
+// NewAdvertiser joins the mDNS group, configured from environment variables:
+//
+//	DOCKLET_MDNS_INTERFACES  comma-separated interfaces to publish on (default: all up
+//	                         multicast interfaces with an IPv4 address, except Docker's)
+//	DOCKLET_MDNS_IP          address to publish (default: the address of the interface a query came in on)
+//	DOCKLET_MDNS_INTERVAL    time between checks for started and stopped services (default 30s)
+func NewAdvertiser() (*Advertiser, error) {
+	interval, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_MDNS_INTERVAL", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_MDNS_INTERVAL: %w", err)
+	}
+	var fixedIP net.IP
+	if s := dockerscanner.GetEnvOrDefault("DOCKLET_MDNS_IP", ""); s != "" {
+		if fixedIP = net.ParseIP(s).To4(); fixedIP == nil {
+			return nil, fmt.Errorf("invalid DOCKLET_MDNS_IP: %q is not an IPv4 address", s)
+		}
+	}
+	ifaces, err := multicastInterfaces(dockerscanner.GetEnvOrDefault("DOCKLET_MDNS_INTERFACES", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	// Binds to the group address with SO_REUSEADDR, so Avahi or another responder can
+	// share the port
+	udp, err := net.ListenMulticastUDP("udp4", &ifaces[0], mdnsGroup)
+	if err != nil {
+		return nil, fmt.Errorf("joining mDNS group on %s: %w", ifaces[0].Name, err)
+	}
+	conn := ipv4.NewPacketConn(udp)
+	joined := ifaces[:1]
+	for _, ifi := range ifaces[1:] {
+		if err := conn.JoinGroup(&ifi, mdnsGroup); err != nil {
+			log.Printf("mDNS: not publishing on %s: %v", ifi.Name, err)
+			continue
+		}
+		joined = append(joined, ifi)
+	}
+	conn.SetControlMessage(ipv4.FlagInterface, true) // Not supported everywhere; then fixedIP or the first address is used
+	conn.SetMulticastTTL(255)                        // RFC 6762 section 11
+
+	return &Advertiser{
+		interval:  interval,
+		fixedIP:   fixedIP,
+		localHost: dockerscanner.GetEnvOrDefault("DOCKLET_HOST_IP", dockerscanner.DefaultHost),
+		probeWait: probeInterval,
+		conn:      conn,
+		ifaces:    joined,
+		published: make(map[string]Service),
+		probing:   make(map[string]bool),
+	}, nil
+}
+
+// multicastInterfaces returns the interfaces named in spec, or all suitable ones.
+func multicastInterfaces(spec string) ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+
+	var ifaces []net.Interface
+	for _, ifi := range all {
+		if len(wanted) > 0 && !wanted[ifi.Name] {
+			continue
+		}
+		if len(wanted) == 0 && (ifi.Flags&net.FlagLoopback != 0 || isDockerInterface(ifi.Name)) {
+			continue
+		}
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || interfaceIP(ifi) == nil {
+			continue
+		}
+		ifaces = append(ifaces, ifi)
+	}
+	if len(ifaces) == 0 {
+		return nil, errors.New("no up multicast interface with an IPv4 address to publish mDNS records on (see DOCKLET_MDNS_INTERFACES)")
+	}
+	return ifaces, nil
+}
+
+func isDockerInterface(name string) bool {
+	return name == "docker0" || strings.HasPrefix(name, "br-") || strings.HasPrefix(name, "veth")
+}
+
+// interfaceIP returns the first IPv4 address of an interface.
+func interfaceIP(ifi net.Interface) net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4()
+		}
+	}
+	return nil
+}
+
+// Run answers queries and publishes the services returned by collect, checking for
+// changes every interval, until ctx is cancelled. It then withdraws every record and
+// leaves the group.
+func (a *Advertiser) Run(ctx context.Context, collect func(context.Context) ([]catalog.Entry, error)) {
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		a.serve()
+	}()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		entries, err := collect(ctx)
+		switch {
+		case ctx.Err() != nil:
+			// Shutting down
+		case err != nil:
+			// Keep publishing what we have rather than withdrawing everything because Docker hiccupped
+			log.Printf("mDNS: failed to collect services: %v", err)
+		default:
+			a.update(ctx, entries)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	a.mu.Lock()
+	withdrawn := make([]Service, 0, len(a.published))
+	for _, svc := range a.published {
+		withdrawn = append(withdrawn, svc)
+	}
+	a.published = make(map[string]Service)
+	a.mu.Unlock()
+	a.announce(withdrawn, 0)
+	a.conn.Close()
+	<-served
+}
+
+// Services returns the published services, sorted by host name.
+func (a *Advertiser) Services() []Service {
+	if a == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	services := make([]Service, 0, len(a.published))
+	for _, svc := range a.published {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Host < services[j].Host })
+	return services
+}
+
+// update publishes new services, withdraws those that went away and re-publishes those
+// whose port or title changed.
+func (a *Advertiser) update(ctx context.Context, entries []catalog.Entry) {
+	local := localAddrs()
+	local[a.localHost] = true
+	wanted := candidates(entries, func(host string) bool { return local[host] })
+
+	a.mu.RLock()
+	previous := make(map[string]Service, len(a.published))
+	for id, svc := range a.published {
+		previous[id] = svc
+	}
+	a.mu.RUnlock()
+
+	next := make(map[string]Service)
+	taken := make(map[string]bool)
+	var added []Service
+	for _, svc := range wanted {
+		if old, ok := previous[svc.EntryID]; ok && old.sameAs(svc) {
+			next[svc.EntryID] = old
+			taken[old.Host] = true
+			continue
+		}
+		added = append(added, svc)
+	}
+	var removed []Service
+	for id, old := range previous {
+		if _, ok := next[id]; !ok {
+			removed = append(removed, old)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	// Stop answering for services being re-published before probing for their new
+	// records, or their old records would answer the probe and look like a conflict
+	a.mu.Lock()
+	a.published = make(map[string]Service, len(next))
+	for id, svc := range next {
+		a.published[id] = svc
+	}
+	a.mu.Unlock()
+	a.announce(removed, 0)
+	for _, svc := range removed {
+		log.Printf("mDNS: withdrew %s", svc.Host)
+	}
+	for _, svc := range a.claim(ctx, added, taken) {
+		next[svc.EntryID] = svc
+	}
+
+	a.mu.Lock()
+	a.published = next
+	a.mu.Unlock()
+
+	var announced []Service
+	for _, svc := range next {
+		if old, ok := previous[svc.EntryID]; !ok || old != svc {
+			announced = append(announced, svc)
+			log.Printf("mDNS: published %s as %s:%d (%s)", svc.Instance, svc.Host, svc.Port, svc.Type)
+		}
+	}
+	if len(announced) == 0 {
+		return
+	}
+	// RFC 6762 section 8.3: announce at least twice, a second apart
+	a.announce(announced, recordTTL)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		a.announce(announced, recordTTL)
+	}
+}
+
+// claim picks a free host name for each service: its base label, or base-2, base-3, ...
+// when another service or another device on the network already uses it.
+func (a *Advertiser) claim(ctx context.Context, services []Service, taken map[string]bool) []Service {
+	attempt := make([]int, len(services))
+	pending := make([]int, len(services))
+	for i := range services {
+		pending[i] = i
+	}
+	var claimed []Service
+	for round := 0; len(pending) > 0 && round <= maxRenames; round++ {
+		var probing []int
+		for _, i := range pending {
+			for {
+				attempt[i]++
+				host := catalog.NumberedLabel(services[i].base, attempt[i]) + ".local"
+				if !taken[host] {
+					services[i].Host = host
+					taken[host] = true
+					break
+				}
+			}
+			probing = append(probing, i)
+		}
+
+		conflicts := a.probe(ctx, services, probing)
+		pending = pending[:0]
+		for _, i := range probing {
+			if conflicts[strings.ToLower(services[i].Host)] {
+				log.Printf("mDNS: %s is taken by another device, trying another name", services[i].Host)
+				pending = append(pending, i)
+				continue
+			}
+			claimed = append(claimed, services[i])
+		}
+	}
+	for _, i := range pending {
+		log.Printf("mDNS: not publishing %s, no free host name found", services[i].Instance)
+	}
+	return claimed
+}
+
+// probe asks three times whether anyone answers for the services' host names, as RFC 6762
+// section 8.1 asks, and returns the names someone answered for.
+func (a *Advertiser) probe(ctx context.Context, services []Service, indexes []int) map[string]bool {
+	questions := make([]dnsmessage.Question, 0, len(indexes))
+	a.mu.Lock()
+	for _, i := range indexes {
+		host := strings.ToLower(services[i].Host)
+		a.probing[host] = false
+		questions = append(questions, dnsmessage.Question{
+			Name:  mustName(services[i].Host + "."),
+			Type:  dnsmessage.TypeALL,
+			Class: dnsmessage.ClassINET | cacheFlush, // Unicast response requested
+		})
+	}
+	a.mu.Unlock()
+
+	query := dnsmessage.Message{Questions: questions}
+	for n := 0; n < 3; n++ {
+		if packet, err := query.Pack(); err == nil {
+			for _, ifi := range a.ifaces {
+				a.send(packet, &ifi, mdnsGroup)
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(a.probeWait):
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	conflicts := make(map[string]bool)
+	for _, i := range indexes {
+		host := strings.ToLower(services[i].Host)
+		if a.probing[host] {
+			conflicts[host] = true
+		}
+		delete(a.probing, host)
+	}
+	return conflicts
+}
+
+// announce multicasts the records of services on every interface; a TTL of 0 withdraws them.
+func (a *Advertiser) announce(services []Service, ttl uint32) {
+	for _, ifi := range a.ifaces {
+		ip := a.fixedIP
+		if ip == nil {
+			ip = interfaceIP(ifi)
+		}
+		if ip == nil {
+			continue
+		}
+		for _, svc := range services {
+			answers := records(svc, ip, ttl)
+			if ttl == 0 {
+				answers = answers[1:] // Other services may still have the same type
+			}
+			msg := dnsmessage.Message{
+				Header:  dnsmessage.Header{Response: true, Authoritative: true},
+				Answers: answers,
+			}
+			if packet, err := msg.Pack(); err == nil {
+				a.send(packet, &ifi, mdnsGroup)
+			}
+		}
+	}
+}
+
+func (a *Advertiser) send(packet []byte, ifi *net.Interface, dst *net.UDPAddr) {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	if dst.IP.IsMulticast() && ifi != nil {
+		a.conn.SetMulticastInterface(ifi)
+	}
+	a.conn.WriteTo(packet, nil, dst)
+}
+
+// records returns the DNS-SD records of a service: the service type enumeration PTR
+// (always first), the instance PTR, the instance's SRV and TXT, and the host's address.
+func records(svc Service, ip net.IP, ttl uint32) []dnsmessage.Resource {
+	typeName := mustName(svc.Type + ".local.")
+	instance := mustName(svc.Instance + "." + svc.Type + ".local.")
+	host := mustName(svc.Host + ".")
+	txt := []string{""}
+	if svc.Path != "" {
+		txt = []string{"path=" + svc.Path}
+	}
+
+	var a4 [4]byte
+	copy(a4[:], ip.To4())
+	shared := dnsmessage.ClassINET
+	unique := dnsmessage.ClassINET | cacheFlush
+	return []dnsmessage.Resource{
+		{Header: dnsmessage.ResourceHeader{Name: servicesName, Type: dnsmessage.TypePTR, Class: shared, TTL: ttl},
+			Body: &dnsmessage.PTRResource{PTR: typeName}},
+		{Header: dnsmessage.ResourceHeader{Name: typeName, Type: dnsmessage.TypePTR, Class: shared, TTL: ttl},
+			Body: &dnsmessage.PTRResource{PTR: instance}},
+		{Header: dnsmessage.ResourceHeader{Name: instance, Type: dnsmessage.TypeSRV, Class: unique, TTL: ttl},
+			Body: &dnsmessage.SRVResource{Port: uint16(svc.Port), Target: host}},
+		{Header: dnsmessage.ResourceHeader{Name: instance, Type: dnsmessage.TypeTXT, Class: unique, TTL: ttl},
+			Body: &dnsmessage.TXTResource{TXT: txt}},
+		{Header: dnsmessage.ResourceHeader{Name: host, Type: dnsmessage.TypeA, Class: unique, TTL: ttl},
+			Body: &dnsmessage.AResource{A: a4}},
+	}
+}
+
+// mustName parses a DNS name. Callers only pass names built from labels candidates and
+// catalog.NumberedLabel keep short and non-empty, so an error is a bug.
+func mustName(name string) dnsmessage.Name {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		panic(fmt.Sprintf("mdns: invalid name %q: %v", name, err))
+	}
+	return n
+}
@@ -0,0 +1,183 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"docklet/catalog"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// newTestAdvertiser returns an advertiser on a loopback socket, with no interfaces to
+// multicast on, publishing 192.168.1.10.
+func newTestAdvertiser(t *testing.T) *Advertiser {
+	t.Helper()
+	udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	return &Advertiser{
+		fixedIP:   net.IPv4(192, 168, 1, 10).To4(),
+		localHost: "192.168.1.10",
+		probeWait: 20 * time.Millisecond,
+		conn:      ipv4.NewPacketConn(udp),
+		published: make(map[string]Service),
+		probing:   make(map[string]bool),
+	}
+}
+
+// answerProbes plays the network while a probes: another device answers for the hosts in
+// others, and a's responder for the services a publishes. It returns a func to stop.
+func answerProbes(a *Advertiser, others ...string) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			a.mu.RLock()
+			var probed []string
+			for host := range a.probing {
+				probed = append(probed, host)
+			}
+			a.mu.RUnlock()
+
+			published := a.Services()
+			for _, host := range probed {
+				for _, other := range others {
+					if host == other {
+						a.noteAnswers([]dnsmessage.Resource{{
+							Header: dnsmessage.ResourceHeader{Name: mustName(host + "."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: recordTTL},
+							Body:   &dnsmessage.AResource{A: [4]byte{192, 168, 1, 20}},
+						}})
+					}
+				}
+				for _, svc := range published {
+					if svc.Host == host {
+						a.noteAnswers(records(svc, a.fixedIP, recordTTL))
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func TestClaim(t *testing.T) {
+	a := newTestAdvertiser(t)
+	stop := answerProbes(a, "grafana.local", "grafana-2.local", "gone.local", "gone-2.local", "gone-3.local",
+		"gone-4.local", "gone-5.local", "gone-6.local", strings.Repeat("x", 63)+".local")
+	defer stop()
+
+	services := []Service{
+		{EntryID: "docker:a", Instance: "Grafana", base: "grafana"},
+		{EntryID: "docker:b", Instance: "NAS", base: "nas"},
+		{EntryID: "docker:c", Instance: "NAS (2)", base: "nas"},
+		{EntryID: "docker:d", Instance: "Gone", base: "gone"},
+		{EntryID: "docker:e", Instance: "Long", base: strings.Repeat("x", 63)},
+	}
+	taken := map[string]bool{"nas.local": true}
+
+	var got []string
+	for _, svc := range a.claim(context.Background(), services, taken) {
+		got = append(got, svc.EntryID+" "+svc.Host)
+	}
+	want := []string{
+		"docker:b nas-2.local",
+		"docker:c nas-3.local",
+		"docker:e " + strings.Repeat("x", 61) + "-2.local",
+		"docker:a grafana-3.local",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("claimed:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(a.probing) != 0 {
+		t.Errorf("still probing %v", a.probing)
+	}
+}
+
+func TestUpdateRepublishKeepsHost(t *testing.T) {
+	a := newTestAdvertiser(t)
+	a.published["docker:a"] = Service{EntryID: "docker:a", Instance: "Grafana", Type: "_http._tcp", Host: "grafana.local", Port: 3000, base: "grafana"}
+	a.published["docker:b"] = Service{EntryID: "docker:b", Instance: "Loki", Type: "_http._tcp", Host: "loki.local", Port: 3100, base: "loki"}
+	stop := answerProbes(a)
+	defer stop()
+
+	// Grafana moved to another port and is probed again; its old records mustn't count as a conflict
+	a.update(context.Background(), []catalog.Entry{
+		{ID: "docker:a", Source: catalog.SourceDocker, Name: "grafana", Title: "Grafana", URL: "http://192.168.1.10:3001"},
+		{ID: "docker:b", Source: catalog.SourceDocker, Name: "loki", Title: "Loki", URL: "http://192.168.1.10:3100"},
+		{ID: "docker:c", Source: catalog.SourceDocker, Name: "loki", Title: "Loki", URL: "http://192.168.1.10:3200"},
+	})
+
+	var got []string
+	for _, svc := range a.Services() {
+		got = append(got, fmt.Sprintf("%s %s %q %d", svc.EntryID, svc.Host, svc.Instance, svc.Port))
+	}
+	want := []string{
+		`docker:a grafana.local "Grafana" 3001`,
+		`docker:c loki-2.local "Loki (2)" 3200`,
+		`docker:b loki.local "Loki" 3100`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("published:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRecords(t *testing.T) {
+	svc := Service{Instance: "NAS Admin", Type: "_https._tcp", Host: "nas.local", Port: 8443, Path: "/admin/"}
+
+	var got []string
+	for _, r := range records(svc, net.IPv4(192, 168, 1, 10), recordTTL) {
+		flush := r.Header.Class&cacheFlush != 0
+		got = append(got, fmt.Sprintf("%s flush=%t ttl=%d", describe(r), flush, r.Header.TTL))
+	}
+	want := []string{
+		"_services._dns-sd._udp.local. PTR _https._tcp.local. flush=false ttl=120",
+		"_https._tcp.local. PTR NAS Admin._https._tcp.local. flush=false ttl=120",
+		"NAS Admin._https._tcp.local. SRV nas.local.:8443 flush=true ttl=120",
+		`NAS Admin._https._tcp.local. TXT ["path=/admin/"] flush=true ttl=120`,
+		"nas.local. A 192.168.1.10 flush=true ttl=120",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("records:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// TXT records can't be empty, so a service without a path has one empty string
+	svc.Path = ""
+	if txt := records(svc, net.IPv4(192, 168, 1, 10), 0)[3].Body.(*dnsmessage.TXTResource).TXT; len(txt) != 1 || txt[0] != "" {
+		t.Errorf("TXT without a path = %q", txt)
+	}
+}
+
+// describe formats a record as "name TYPE value".
+func describe(r dnsmessage.Resource) string {
+	value := r.Body.GoString()
+	switch body := r.Body.(type) {
+	case *dnsmessage.PTRResource:
+		value = body.PTR.String()
+	case *dnsmessage.SRVResource:
+		value = fmt.Sprintf("%s:%d", body.Target, body.Port)
+	case *dnsmessage.TXTResource:
+		value = fmt.Sprintf("%q", body.TXT)
+	case *dnsmessage.AResource:
+		value = net.IP(body.A[:]).String()
+	}
+	return fmt.Sprintf("%s %s %s", r.Header.Name, strings.TrimPrefix(r.Header.Type.String(), "Type"), value)
+}
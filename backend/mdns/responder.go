@@ -0,0 +1,173 @@
+package mdns
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxAnswerSize is the largest answer sent with additional records; bigger ones drop them
+// so they fit in a single Ethernet frame.
+const maxAnswerSize = 1400
+
+// serve reads packets until the connection is closed, answering queries for published
+// records and noting answers for host names being probed.
+func (a *Advertiser) serve() {
+	buf := make([]byte, 9000) // RFC 6762 section 17
+	for {
+		n, cm, src, err := a.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		udpSrc, ok := src.(*net.UDPAddr)
+		if err != nil || !ok {
+			continue
+		}
+		ifi := &a.ifaces[0]
+		if cm != nil {
+			for i := range a.ifaces {
+				if a.ifaces[i].Index == cm.IfIndex {
+					ifi = &a.ifaces[i]
+				}
+			}
+		}
+		a.handle(buf[:n], udpSrc, ifi)
+	}
+}
+
+func (a *Advertiser) handle(packet []byte, src *net.UDPAddr, ifi *net.Interface) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil {
+		return
+	}
+	if msg.Header.Response {
+		a.noteAnswers(msg.Answers)
+		return
+	}
+
+	ip := a.fixedIP
+	if ip == nil {
+		ip = interfaceIP(*ifi)
+	}
+	if ip == nil || len(msg.Questions) == 0 {
+		return
+	}
+	// Queries from a port other than 5353 come from plain DNS resolvers (RFC 6762 section 6.7)
+	legacy := src.Port != mdnsPort
+	ttl := uint32(recordTTL)
+	if legacy {
+		ttl = legacyTTL
+	}
+
+	var all []dnsmessage.Resource
+	for _, svc := range a.Services() {
+		all = append(all, records(svc, ip, ttl)...)
+	}
+
+	var answers []dnsmessage.Resource
+	unicast := legacy
+	for _, q := range msg.Questions {
+		if q.Class&cacheFlush != 0 {
+			unicast = true // The QU bit: the asker wants a unicast response
+		}
+		for _, r := range all {
+			if sameName(r.Header.Name, q.Name) && (q.Type == dnsmessage.TypeALL || q.Type == r.Header.Type) {
+				answers = appendUnique(answers, r)
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	// Save a round trip: PTR answers come with the instance's SRV and TXT, SRV with the address
+	var additionals []dnsmessage.Resource
+	addTargets := func(from []dnsmessage.Resource) {
+		for _, ans := range from {
+			var target dnsmessage.Name
+			switch body := ans.Body.(type) {
+			case *dnsmessage.PTRResource:
+				target = body.PTR
+			case *dnsmessage.SRVResource:
+				target = body.Target
+			default:
+				continue
+			}
+			for _, r := range all {
+				if sameName(r.Header.Name, target) && !contains(answers, r) {
+					additionals = appendUnique(additionals, r)
+				}
+			}
+		}
+	}
+	addTargets(answers)
+	addTargets(additionals) // Addresses of the SRV records added above
+
+	reply := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: additionals,
+	}
+	if legacy {
+		reply.Header.ID = msg.Header.ID
+		reply.Questions = msg.Questions
+		for _, records := range [][]dnsmessage.Resource{reply.Answers, reply.Additionals} {
+			for i := range records {
+				records[i].Header.Class &^= cacheFlush
+			}
+		}
+	}
+	out, err := reply.Pack()
+	if err == nil && len(out) > maxAnswerSize {
+		reply.Additionals = nil
+		out, err = reply.Pack()
+	}
+	if err != nil {
+		return
+	}
+
+	dst := mdnsGroup
+	if unicast {
+		dst = src
+	}
+	a.send(out, ifi, dst)
+}
+
+// noteAnswers marks host names being probed that someone else answered for. Goodbyes
+// don't count; they may be our own, for a service being re-published.
+func (a *Advertiser) noteAnswers(answers []dnsmessage.Resource) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.probing) == 0 {
+		return
+	}
+	for _, r := range answers {
+		host := strings.ToLower(strings.TrimSuffix(r.Header.Name.String(), "."))
+		if _, ok := a.probing[host]; ok && r.Header.TTL > 0 {
+			a.probing[host] = true
+		}
+	}
+}
+
+func sameName(a, b dnsmessage.Name) bool {
+	return strings.EqualFold(a.String(), b.String())
+}
+
+func contains(records []dnsmessage.Resource, r dnsmessage.Resource) bool {
+	for _, existing := range records {
+		if sameName(existing.Header.Name, r.Header.Name) && existing.Header.Type == r.Header.Type &&
+			existing.Body.GoString() == r.Body.GoString() {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(records []dnsmessage.Resource, r dnsmessage.Resource) []dnsmessage.Resource {
+	if contains(records, r) {
+		return records
+	}
+	return append(records, r)
+}
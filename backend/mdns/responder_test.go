@@ -0,0 +1,137 @@
+package mdns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestHandle(t *testing.T) {
+	a := newTestAdvertiser(t)
+	a.published["docker:a"] = Service{EntryID: "docker:a", Instance: "Grafana", Type: "_http._tcp", Host: "grafana.local", Port: 3000}
+	a.published["docker:b"] = Service{EntryID: "docker:b", Instance: "NAS", Type: "_https._tcp", Host: "nas.local", Port: 443, Path: "/admin/"}
+
+	// Queries from a port other than 5353 are answered by unicast, so the test can read them
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, tt := range []struct {
+		name        string
+		questions   []dnsmessage.Question
+		answers     []string
+		additionals []string
+	}{
+		{
+			name:      "browse a service type",
+			questions: []dnsmessage.Question{{Name: mustName("_http._tcp.local."), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+			answers:   []string{"_http._tcp.local. PTR Grafana._http._tcp.local."},
+			additionals: []string{
+				`Grafana._http._tcp.local. SRV grafana.local.:3000`,
+				`Grafana._http._tcp.local. TXT [""]`,
+				"grafana.local. A 192.168.1.10",
+			},
+		},
+		{
+			name:        "resolve an instance",
+			questions:   []dnsmessage.Question{{Name: mustName("NAS._https._tcp.local."), Type: dnsmessage.TypeALL, Class: dnsmessage.ClassINET}},
+			answers:     []string{"NAS._https._tcp.local. SRV nas.local.:443", `NAS._https._tcp.local. TXT ["path=/admin/"]`},
+			additionals: []string{"nas.local. A 192.168.1.10"},
+		},
+		{
+			name:      "resolve a host, whatever its case",
+			questions: []dnsmessage.Question{{Name: mustName("Grafana.LOCAL."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+			answers:   []string{"grafana.local. A 192.168.1.10"},
+		},
+		{
+			name: "several questions",
+			questions: []dnsmessage.Question{
+				{Name: mustName("grafana.local."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+				{Name: mustName("Grafana._http._tcp.local."), Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET},
+			},
+			answers: []string{"grafana.local. A 192.168.1.10", "Grafana._http._tcp.local. SRV grafana.local.:3000"},
+		},
+		{
+			name:      "another device's host",
+			questions: []dnsmessage.Question{{Name: mustName("printer.local."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		},
+		{
+			name:      "a type the host doesn't have",
+			questions: []dnsmessage.Question{{Name: mustName("grafana.local."), Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			query := dnsmessage.Message{Header: dnsmessage.Header{ID: 42}, Questions: tt.questions}
+			packet, err := query.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.handle(packet, client.LocalAddr().(*net.UDPAddr), &net.Interface{})
+
+			buf := make([]byte, 9000)
+			client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, err := client.Read(buf)
+			if len(tt.answers) == 0 {
+				if err == nil {
+					t.Errorf("answered a query for records it doesn't have")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reply dnsmessage.Message
+			if err := reply.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			// A legacy resolver gets its ID and questions back, short TTLs and no cache-flush bits
+			if reply.Header.ID != 42 || !reply.Header.Response || len(reply.Questions) != len(tt.questions) {
+				t.Errorf("reply header %+v with %d questions", reply.Header, len(reply.Questions))
+			}
+			for _, r := range append(reply.Answers, reply.Additionals...) {
+				if r.Header.TTL != legacyTTL || r.Header.Class != dnsmessage.ClassINET {
+					t.Errorf("%s has TTL %d and class %v", describe(r), r.Header.TTL, r.Header.Class)
+				}
+			}
+			if got := describeAll(reply.Answers); got != strings.Join(tt.answers, "\n") {
+				t.Errorf("answers:\n%s\nwant\n%s", got, strings.Join(tt.answers, "\n"))
+			}
+			if got := describeAll(reply.Additionals); got != strings.Join(tt.additionals, "\n") {
+				t.Errorf("additionals:\n%s\nwant\n%s", got, strings.Join(tt.additionals, "\n"))
+			}
+		})
+	}
+}
+
+func TestNoteAnswers(t *testing.T) {
+	a := newTestAdvertiser(t)
+	a.probing["grafana.local"] = false
+	a.probing["nas.local"] = false
+	a.probing["loki.local"] = false
+
+	svc := Service{Instance: "Grafana", Type: "_http._tcp", Host: "Grafana.local", Port: 3000}
+	a.noteAnswers(records(svc, net.IPv4(192, 168, 1, 20), recordTTL))
+	svc.Host = "nas.local"
+	a.noteAnswers(records(svc, net.IPv4(192, 168, 1, 20), 0)) // A goodbye isn't a claim
+
+	want := map[string]bool{"grafana.local": true, "nas.local": false, "loki.local": false}
+	for host, conflict := range want {
+		if a.probing[host] != conflict {
+			t.Errorf("conflict for %s = %t, want %t", host, a.probing[host], conflict)
+		}
+	}
+}
+
+func describeAll(records []dnsmessage.Resource) string {
+	var lines []string
+	for _, r := range records {
+		lines = append(lines, describe(r))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,124 @@
+package mdns
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"docklet/catalog"
+)
+
+// Container labels controlling advertisement.
+const (
+	NameLabel    = "docklet.mdns.name" // Host name to publish instead of the service name, without .local
+	EnabledLabel = "docklet.mdns"      // "false" keeps a service off the network
+)
+
+// Service is a discovered service published on the network.
+type Service struct {
+	EntryID  string `json:"entry_id"` // Catalog entry the service comes from
+	Instance string `json:"instance"` // DNS-SD instance name, e.g. "Grafana"
+	Type     string `json:"type"`     // _http._tcp or _https._tcp
+	Host     string `json:"host"`     // e.g. grafana.local
+	Port     int    `json:"port"`
+	Path     string `json:"path,omitempty"` // Published in the TXT record as path=
+	base     string // Host label before conflicts were resolved
+}
+
+// sameAs reports whether s publishes the same records as other, apart from the host name
+// picked to resolve conflicts.
+func (s Service) sameAs(other Service) bool {
+	return s.base == other.base && s.Instance == other.Instance && s.Type == other.Type &&
+		s.Port == other.Port && s.Path == other.Path
+}
+
+// candidates turns catalog entries served by this host into services to publish, with
+// their host label in base and Host not yet set. Links point elsewhere and are skipped,
+// as are URLs whose host isLocal rejects.
+func candidates(entries []catalog.Entry, isLocal func(host string) bool) []Service {
+	sorted := append([]catalog.Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var services []Service
+	instances := make(map[string]bool)
+	for _, entry := range sorted {
+		if entry.Source == catalog.SourceLink || entry.Labels[EnabledLabel] == "false" {
+			continue
+		}
+		u, err := url.Parse(entry.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !isLocal(u.Hostname()) {
+			continue
+		}
+		port := 80
+		if u.Scheme == "https" {
+			port = 443
+		}
+		if p := u.Port(); p != "" {
+			if port, err = strconv.Atoi(p); err != nil {
+				continue
+			}
+		}
+
+		name := entry.Labels[NameLabel]
+		if name == "" {
+			name = entry.Name
+		}
+		base := catalog.HostLabel(strings.TrimSuffix(name, ".local"))
+		if base == "" {
+			continue
+		}
+
+		serviceType := "_" + u.Scheme + "._tcp"
+		instance := instanceName(entry.Title, entry.Name)
+		if instance == "" {
+			continue // A title of only dots would make an empty label
+		}
+		for n := 2; instances[serviceType+"/"+instance]; n++ {
+			instance = instanceName(entry.Title, entry.Name) + " (" + strconv.Itoa(n) + ")"
+		}
+		instances[serviceType+"/"+instance] = true
+
+		path := u.EscapedPath()
+		if path == "/" {
+			path = ""
+		}
+		services = append(services, Service{
+			EntryID:  entry.ID,
+			Instance: instance,
+			Type:     serviceType,
+			Port:     port,
+			Path:     path,
+			base:     base,
+		})
+	}
+	return services
+}
+
+// instanceName returns the DNS-SD instance name: the title, which may contain spaces and
+// any Unicode, but no dots, since we can't escape them, and at most 63 bytes.
+func instanceName(title, name string) string {
+	if title == "" {
+		title = name
+	}
+	title = strings.TrimSpace(strings.ReplaceAll(title, ".", " "))
+	for len(title) > 63-len(" (99)") {
+		_, size := utf8.DecodeLastRuneInString(title)
+		title = title[:len(title)-size]
+	}
+	return title
+}
+
+// localAddrs returns the addresses of this host's interfaces.
+func localAddrs() map[string]bool {
+	addrs := map[string]bool{"localhost": true}
+	ifaceAddrs, _ := net.InterfaceAddrs()
+	for _, addr := range ifaceAddrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			addrs[ipNet.IP.String()] = true
+		}
+	}
+	return addrs
+}
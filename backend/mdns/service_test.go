@@ -0,0 +1,54 @@
+package mdns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"docklet/catalog"
+)
+
+func TestCandidates(t *testing.T) {
+	entries := []catalog.Entry{
+		{ID: "docker:a", Source: catalog.SourceDocker, Name: "grafana", Title: "Grafana", URL: "http://192.168.1.10:3000"},
+		{ID: "docker:b", Source: catalog.SourceDocker, Name: "grafana-2", Title: "Grafana", URL: "http://192.168.1.10:3001/"},
+		{ID: "docker:c", Source: catalog.SourceDocker, Name: "nas", Title: "NAS v1.2", URL: "https://192.168.1.10/admin/",
+			Labels: map[string]string{NameLabel: "NAS Admin.local"}},
+		{ID: "docker:d", Source: catalog.SourceDocker, Name: "hidden", URL: "http://192.168.1.10:8080", Labels: map[string]string{EnabledLabel: "false"}},
+		{ID: "docker:e", Source: catalog.SourceDocker, Name: "remote", URL: "http://192.168.1.20:8080"},
+		{ID: "docker:f", Source: catalog.SourceDocker, Name: "mqtt", URL: "mqtt://192.168.1.10:1883"},
+		{ID: "docker:g", Source: catalog.SourceDocker, Name: "dots", Title: "...", URL: "http://192.168.1.10:8081"},
+		{ID: "docker:h", Source: catalog.SourceDocker, Name: "!!!", URL: "http://192.168.1.10:8082"},
+		{ID: "system:nginx.service", Source: catalog.SourceSystem, Name: "nginx.service", URL: "http://localhost"},
+		{ID: "link:1", Source: catalog.SourceLink, Name: "router", URL: "http://192.168.1.10"},
+	}
+	isLocal := func(host string) bool { return host == "192.168.1.10" || host == "localhost" }
+
+	var got []string
+	for _, svc := range candidates(entries, isLocal) {
+		got = append(got, fmt.Sprintf("%s %q %s %s %d %q", svc.EntryID, svc.Instance, svc.Type, svc.base, svc.Port, svc.Path))
+	}
+	want := []string{
+		`docker:a "Grafana" _http._tcp grafana 3000 ""`,
+		`docker:b "Grafana (2)" _http._tcp grafana-2 3001 ""`,
+		`docker:c "NAS v1 2" _https._tcp nas-admin 443 "/admin/"`,
+		`system:nginx.service "nginx service" _http._tcp nginx-service 80 ""`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("candidates:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	long := strings.Repeat("é", 40) // 80 bytes
+	for _, tt := range []struct{ title, name, want string }{
+		{"Grafana", "grafana", "Grafana"},
+		{"", "grafana", "grafana"},
+		{" Home Assistant 2024.6 ", "ha", "Home Assistant 2024 6"},
+		{long, "x", strings.Repeat("é", 29)},
+	} {
+		if got := instanceName(tt.title, tt.name); got != tt.want {
+			t.Errorf("instanceName(%q, %q) = %q, want %q", tt.title, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import "strings"
+
+// Docklet's own metrics. Label values are bounded: sources and routes are fixed sets,
+// and statuses are normalized by StatusLabel.
+var (
+	ScanDuration = NewHistogramVec("docklet_scan_duration_seconds",
+		"Time spent listing services, by source.", DefaultBuckets, "source")
+	ScanErrors = NewCounterVec("docklet_scan_errors_total",
+		"Failed service scans, by source.", "source")
+	DockerAPIErrors = NewCounterVec("docklet_docker_api_errors_total",
+		"Errors returned by the Docker API, by operation.", "operation")
+	Services = NewGaugeVec("docklet_services",
+		"Number of services found by the last catalog scan, by source and status.", "source", "status")
+	HTTPRequestDuration = NewHistogramVec("docklet_http_request_duration_seconds",
+		"HTTP request latency, by route template, method and status code.", DefaultBuckets, "route", "method", "code")
+)
+
+func init() {
+	// Statuses come from external tools; cap them in case something unexpected shows up.
+	Services.maxSeries = 64
+	// Routes are templates, so this only guards against misuse.
+	HTTPRequestDuration.maxSeries = 512
+}
+
+// StatusLabel normalizes a service status for use as a label value, dropping details
+// such as exit codes ("exited(status: 1)" becomes "exited").
+func StatusLabel(status string) string {
+	if i := strings.IndexAny(status, "( "); i >= 0 {
+		status = status[:i]
+	}
+	if status == "" {
+		return "unknown"
+	}
+	return strings.ToLower(status)
+}
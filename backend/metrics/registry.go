@@ -0,0 +1,392 @@
+// Package metrics implements a small Prometheus-compatible metrics registry and the
+// text exposition format, without pulling in the full client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// overflowCounter counts samples dropped because a metric reached its series limit.
+var overflowCounter = &series{}
+
+// Registry holds metrics and renders them in the Prometheus text format.
+type Registry struct {
+	mu         sync.Mutex
+	metrics    []metric
+	collectors []func() []Family
+}
+
+// metric is anything that can describe itself as a family of samples.
+type metric interface {
+	family() Family
+}
+
+// Family is a set of samples sharing a name, help text and type.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string // "counter", "gauge" or "histogram"
+	Samples []Sample
+}
+
+// Sample is a single value with its labels. Suffix is appended to the family name,
+// e.g. "_bucket" for histograms.
+type Sample struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Label is a name/value pair.
+type Label struct {
+	Name, Value string
+}
+
+// Default is the registry used by the package-level constructors and Handler.
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	r := &Registry{}
+	r.Register(&overflowMetric{})
+	return r
+}
+
+// Register adds a metric to the registry.
+func (r *Registry) Register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// RegisterCollector adds a function called on every scrape, for metrics derived from other state.
+func (r *Registry) RegisterCollector(collect func() []Family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collect)
+}
+
+// WriteTo renders all metrics in the Prometheus text exposition format (version 0.0.4).
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	var families []Family
+	for _, m := range r.metrics {
+		families = append(families, m.family())
+	}
+	collectors := append([]func() []Family(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, collect := range collectors {
+		families = append(families, collect()...)
+	}
+	sort.SliceStable(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name + s.Suffix)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(l.Name + `="` + escapeLabelValue(l.Value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatValue(s.Value) + "\n")
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns an http.Handler serving the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.WriteTo(w)
+	})
+}
+
+// series is a single labelled value.
+type series struct {
+	mu     sync.Mutex
+	labels []string
+	value  float64
+	// Histogram state
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (s *series) add(v float64) {
+	s.mu.Lock()
+	s.value += v
+	s.mu.Unlock()
+}
+
+func (s *series) set(v float64) {
+	s.mu.Lock()
+	s.value = v
+	s.mu.Unlock()
+}
+
+// vec stores series by label values, up to maxSeries.
+type vec struct {
+	name      string
+	help      string
+	labels    []string
+	maxSeries int // 0 means unlimited
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help string, labels []string) *vec {
+	return &vec{name: name, help: help, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series for the label values, creating it if needed. It returns nil if the
+// metric is at its series limit, in which case the sample is counted as dropped.
+func (v *vec) get(values []string, init func(*series)) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+	if v.maxSeries > 0 && len(v.series) >= v.maxSeries {
+		overflowCounter.add(1)
+		return nil
+	}
+	s := &series{labels: append([]string(nil), values...)}
+	if init != nil {
+		init(s)
+	}
+	v.series[key] = s
+	return s
+}
+
+// sorted returns the series ordered by label values, for stable output.
+func (v *vec) sorted() []*series {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	list := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labels, "\xff") < strings.Join(list[j].labels, "\xff")
+	})
+	return list
+}
+
+func (v *vec) labelPairs(values []string) []Label {
+	labels := make([]Label, len(values))
+	for i, value := range values {
+		labels[i] = Label{Name: v.labels[i], Value: value}
+	}
+	return labels
+}
+
+// CounterVec is a monotonically increasing value per label set.
+type CounterVec struct{ *vec }
+
+// NewCounterVec creates and registers a counter in the default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels)}
+	Default.Register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter for the given label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	if s := c.get(values, nil); s != nil {
+		s.add(v)
+	}
+}
+
+func (c *CounterVec) family() Family {
+	f := Family{Name: c.name, Help: c.help, Type: "counter"}
+	for _, s := range c.sorted() {
+		s.mu.Lock()
+		f.Samples = append(f.Samples, Sample{Labels: c.labelPairs(s.labels), Value: s.value})
+		s.mu.Unlock()
+	}
+	return f
+}
+
+// GaugeVec is a value per label set that can go up and down.
+type GaugeVec struct{ *vec }
+
+// NewGaugeVec creates and registers a gauge in the default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, labels)}
+	Default.Register(g)
+	return g
+}
+
+// Set sets the gauge for the given label values.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	if s := g.get(values, nil); s != nil {
+		s.set(v)
+	}
+}
+
+// Reset removes all series, e.g. before setting a fresh set of counts.
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	g.series = make(map[string]*series)
+	g.mu.Unlock()
+}
+
+// GaugePoint is a value for one label set, used with SetAll.
+type GaugePoint struct {
+	Values []string
+	Value  float64
+}
+
+// SetAll replaces all series at once, so a scrape never sees a half-updated set.
+func (g *GaugeVec) SetAll(points []GaugePoint) {
+	fresh := make(map[string]*series, len(points))
+	for _, p := range points {
+		if len(p.Values) != len(g.labels) {
+			panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", g.name, len(g.labels), len(p.Values)))
+		}
+		if g.maxSeries > 0 && len(fresh) >= g.maxSeries {
+			overflowCounter.add(1)
+			continue
+		}
+		fresh[strings.Join(p.Values, "\xff")] = &series{labels: append([]string(nil), p.Values...), value: p.Value}
+	}
+	g.mu.Lock()
+	g.series = fresh
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) family() Family {
+	f := Family{Name: g.name, Help: g.help, Type: "gauge"}
+	for _, s := range g.sorted() {
+		s.mu.Lock()
+		f.Samples = append(f.Samples, Sample{Labels: g.labelPairs(s.labels), Value: s.value})
+		s.mu.Unlock()
+	}
+	return f
+}
+
+// DefaultBuckets are histogram buckets suited to request and scan latencies, in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// HistogramVec counts observations into buckets per label set.
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogramVec creates and registers a histogram in the default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, labels), buckets: buckets}
+	Default.Register(h)
+	return h
+}
+
+// Observe records a value for the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	s := h.get(values, func(s *series) { s.counts = make([]uint64, len(h.buckets)) })
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) family() Family {
+	f := Family{Name: h.name, Help: h.help, Type: "histogram"}
+	for _, s := range h.sorted() {
+		s.mu.Lock()
+		labels := h.labelPairs(s.labels)
+		for i, upper := range h.buckets {
+			le := append(append([]Label(nil), labels...), Label{Name: "le", Value: formatValue(upper)})
+			f.Samples = append(f.Samples, Sample{Suffix: "_bucket", Labels: le, Value: float64(s.counts[i])})
+		}
+		inf := append(append([]Label(nil), labels...), Label{Name: "le", Value: "+Inf"})
+		f.Samples = append(f.Samples,
+			Sample{Suffix: "_bucket", Labels: inf, Value: float64(s.count)},
+			Sample{Suffix: "_sum", Labels: labels, Value: s.sum},
+			Sample{Suffix: "_count", Labels: labels, Value: float64(s.count)},
+		)
+		s.mu.Unlock()
+	}
+	return f
+}
+
+// overflowMetric exposes how many samples were dropped due to series limits.
+type overflowMetric struct{}
+
+func (overflowMetric) family() Family {
+	overflowCounter.mu.Lock()
+	defer overflowCounter.mu.Unlock()
+	return Family{
+		Name:    "docklet_metrics_dropped_samples_total",
+		Help:    "Samples dropped because a metric reached its series limit.",
+		Type:    "counter",
+		Samples: []Sample{{Value: overflowCounter.value}},
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+package monitor
+
+import (
+	"sort"
+
+	"docklet/metrics"
+)
+
+// RegisterMetrics exposes per-service gauges on the default metrics registry:
+// docklet_service_up and docklet_service_probe_duration_seconds, labelled by service
+// name and source. To keep label cardinality bounded, at most maxServices services are
+// exported (sorted by source and name); the rest are counted in docklet_service_metrics_omitted.
+// maxServices <= 0 disables per-service metrics.
+func (m *Monitor) RegisterMetrics(maxServices int) {
+	if maxServices <= 0 {
+		return
+	}
+	metrics.Default.RegisterCollector(func() []metrics.Family {
+		statuses := m.Statuses()
+		sort.Slice(statuses, func(i, j int) bool {
+			if statuses[i].Source != statuses[j].Source {
+				return statuses[i].Source < statuses[j].Source
+			}
+			return statuses[i].Name < statuses[j].Name
+		})
+
+		up := metrics.Family{Name: "docklet_service_up", Help: "Whether the service answered its last probe (1) or not (0).", Type: "gauge"}
+		latency := metrics.Family{Name: "docklet_service_probe_duration_seconds", Help: "Duration of the last probe of the service.", Type: "gauge"}
+		omitted := 0
+		seen := make(map[[2]string]bool)
+		for _, status := range statuses {
+			key := [2]string{status.Name, status.Source}
+			if seen[key] {
+				continue // Two services with the same name; keep the first to avoid duplicate series
+			}
+			if len(seen) >= maxServices {
+				omitted++
+				continue
+			}
+			seen[key] = true
+
+			labels := []metrics.Label{{Name: "service", Value: status.Name}, {Name: "source", Value: status.Source}}
+			value := 0.0
+			if status.Up {
+				value = 1
+			}
+			up.Samples = append(up.Samples, metrics.Sample{Labels: labels, Value: value})
+			latency.Samples = append(latency.Samples, metrics.Sample{Labels: labels, Value: status.Latency.Seconds()})
+		}
+
+		return []metrics.Family{up, latency, {
+			Name:    "docklet_service_metrics_omitted",
+			Help:    "Services left out of per-service metrics because of DOCKLET_METRICS_MAX_SERVICES.",
+			Type:    "gauge",
+			Samples: []metrics.Sample{{Value: float64(omitted)}},
+		}}
+	})
+}
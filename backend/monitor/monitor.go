@@ -0,0 +1,196 @@
+// Package monitor periodically probes every service URL and keeps its latest status.
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+)
+
+// maxConcurrentProbes bounds how many services are probed at the same time.
+const maxConcurrentProbes = 8
+
+// Status is the result of the latest probe of a service.
+type Status struct {
+	ID         string        `json:"id"`     // Catalog entry ID
+	Name       string        `json:"name"`   // Catalog entry name
+	Source     string        `json:"source"` // Catalog entry source
+	URL        string        `json:"url"`
+	Up         bool          `json:"up"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Latency    time.Duration `json:"latency"` // Time to response headers
+	CheckedAt  time.Time     `json:"checked_at"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// Monitor probes services in the background.
+type Monitor struct {
+	// OnRound, if set, is called from Run after each complete round with the services
+	// collected for it and their new statuses, sorted by ID.
+	OnRound func(entries []catalog.Entry, statuses []Status)
+
+	interval time.Duration
+	client   *http.Client
+
+	mu       sync.RWMutex
+	statuses map[string]Status // Keyed by catalog entry ID
+}
+
+// NewMonitor creates a Monitor configured from environment variables:
+//
+//	DOCKLET_MONITOR_INTERVAL  time between probe rounds (default 30s)
+//	DOCKLET_MONITOR_TIMEOUT   per probe timeout (default 5s)
+//	DOCKLET_MONITOR_INSECURE  "false" enables certificate verification for https services
+func NewMonitor() (*Monitor, error) {
+	interval, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_MONITOR_INTERVAL", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_MONITOR_INTERVAL: %w", err)
+	}
+	timeout, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_MONITOR_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_MONITOR_TIMEOUT: %w", err)
+	}
+
+	// A self-signed certificate doesn't make a service down.
+	insecure := dockerscanner.GetEnvOrDefault("DOCKLET_MONITOR_INSECURE", "true") != "false"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	transport.DisableKeepAlives = true
+
+	return &Monitor{
+		interval: interval,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			// A redirect (e.g. to a login page) already shows the service is up.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		statuses: make(map[string]Status),
+	}, nil
+}
+
+// Run probes all services returned by collect every interval until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context, collect func(context.Context) ([]catalog.Entry, error)) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := collect(ctx)
+		if ctx.Err() != nil {
+			return // Shutting down
+		}
+		if err != nil {
+			log.Printf("Monitor: failed to collect services: %v", err)
+		} else {
+			m.probeAll(ctx, entries)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes every entry with a URL concurrently and replaces the stored statuses.
+func (m *Monitor) probeAll(ctx context.Context, entries []catalog.Entry) {
+	results := make(map[string]Status, len(entries))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentProbes)
+
+	for _, entry := range entries {
+		if entry.URL == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(entry catalog.Entry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			status := m.probe(ctx, entry)
+			resultsMu.Lock()
+			results[entry.ID] = status
+			resultsMu.Unlock()
+		}(entry)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return // Cancelled mid-round; keep the previous, complete results
+	}
+	m.mu.Lock()
+	m.statuses = results
+	m.mu.Unlock()
+
+	if m.OnRound != nil {
+		m.OnRound(entries, m.Statuses())
+	}
+}
+
+// probe requests a service URL. Any HTTP response below 500 counts as up,
+// since 401/403 pages still show the service is serving.
+func (m *Monitor) probe(ctx context.Context, entry catalog.Entry) Status {
+	status := Status{ID: entry.ID, Name: entry.Name, Source: entry.Source, URL: entry.URL, CheckedAt: time.Now()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.URL, nil)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	req.Header.Set("User-Agent", "Docklet")
+
+	start := time.Now()
+	resp, err := m.client.Do(req)
+	status.Latency = time.Since(start)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	status.StatusCode = resp.StatusCode
+	status.Up = resp.StatusCode < http.StatusInternalServerError
+	if !status.Up {
+		status.Error = resp.Status
+	}
+	return status
+}
+
+// Statuses returns the latest status of every probed service, sorted by ID.
+func (m *Monitor) Statuses() []Status {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	statuses := make([]Status, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// Status returns the latest status of one service.
+func (m *Monitor) Status(id string) (Status, bool) {
+	if m == nil {
+		return Status{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	status, ok := m.statuses[id]
+	return status, ok
+}
@@ -0,0 +1,248 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// Channel delivers notifications to one destination.
+type Channel interface {
+	Send(ctx context.Context, event Event) error
+	String() string // The destination without credentials, for logs and the API
+}
+
+// ParseChannel creates a channel from an Apprise-style URL:
+//
+//	ntfy://[user:pass@]host/topic, ntfys://...   ntfy; ntfy://topic posts to ntfy.sh
+//	gotify://host[:port][/path]/token, gotifys://...
+//	json://host[/path], jsons://...              JSON webhook in Apprise's format, or the template
+//	http://..., https://...                      JSON webhook with the event, or the template
+//	mailto://[user:pass@]host[:port]?to=a@b,c@d[&from=x@y], mailtos://...
+//	apprise://host[:port]/key, apprises://...    an Apprise API server, which fans out further
+//
+// Query parameters starting with + are sent as HTTP headers, like Apprise does. tmpl, if not
+// nil, renders the body of json:// and http:// webhooks.
+func ParseChannel(rawURL string, tmpl *template.Template, client *http.Client) (Channel, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s: missing host", redact(u))
+	}
+	scheme := "http"
+	if strings.HasSuffix(u.Scheme, "s") { // ntfys, gotifys, jsons, https...
+		scheme = "https"
+	}
+	h := &httpChannel{client: client, display: redact(u), headers: headerParams(u)}
+
+	switch u.Scheme {
+	case "ntfy", "ntfys":
+		host, topic := u.Host, strings.Trim(u.Path, "/")
+		if topic == "" {
+			host, topic, scheme = "ntfy.sh", u.Host, "https"
+		}
+		h.url = scheme + "://" + host + "/" + topic
+		if u.User != nil {
+			password, _ := u.User.Password()
+			h.user, h.password = u.User.Username(), password
+		}
+		if token := u.Query().Get("token"); token != "" {
+			h.headers.Set("Authorization", "Bearer "+token)
+		}
+		h.body = ntfyBody
+		return h, nil
+	case "gotify", "gotifys":
+		path := strings.Trim(u.Path, "/")
+		i := strings.LastIndex(path, "/")
+		token := path[i+1:]
+		if token == "" {
+			return nil, fmt.Errorf("%s: missing application token", h.display)
+		}
+		h.url = scheme + "://" + u.Host + "/" + strings.TrimPrefix(path[:i+1], "/") + "message"
+		h.headers.Set("X-Gotify-Key", token)
+		h.body = gotifyBody
+		return h, nil
+	case "json", "jsons", "http", "https":
+		target := *u
+		target.Scheme, target.RawQuery = scheme, ""
+		target.User = nil
+		h.url = target.String()
+		if u.User != nil {
+			password, _ := u.User.Password()
+			h.user, h.password = u.User.Username(), password
+		}
+		switch {
+		case tmpl != nil:
+			h.body = templateBody(tmpl)
+		case u.Scheme == "json" || u.Scheme == "jsons":
+			h.body = appriseJSONBody
+		default:
+			h.body = eventBody
+		}
+		return h, nil
+	case "apprise", "apprises":
+		key := strings.Trim(u.Path, "/")
+		if key == "" {
+			return nil, fmt.Errorf("%s: missing configuration key", h.display)
+		}
+		h.url = scheme + "://" + u.Host + "/notify/" + key
+		h.body = appriseAPIBody(u.Query().Get("tags"))
+		return h, nil
+	case "mailto", "mailtos":
+		return newMailChannel(u)
+	}
+	return nil, fmt.Errorf("%s: unsupported scheme %q", h.display, u.Scheme)
+}
+
+// redact returns u without credentials and query, and with only the first character of
+// each path segment, which may hold a token.
+func redact(u *url.URL) string {
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, segment := range path {
+		if len(segment) > 1 {
+			path[i] = segment[:1] + "***"
+		}
+	}
+	display := u.Scheme + "://" + u.Host
+	if len(path) > 0 && path[0] != "" {
+		display += "/" + strings.Join(path, "/")
+	}
+	return display
+}
+
+func headerParams(u *url.URL) http.Header {
+	headers := make(http.Header)
+	// Parsed again, since a + in a query is a space
+	query, _ := url.ParseQuery(strings.ReplaceAll(u.RawQuery, "+", "%2B"))
+	for name, values := range query {
+		if strings.HasPrefix(name, "+") && len(values) > 0 {
+			headers.Set(name[1:], values[0])
+		}
+	}
+	return headers
+}
+
+// httpChannel posts each event to a URL, in a body depending on the service.
+type httpChannel struct {
+	client         *http.Client
+	display        string
+	url            string
+	user, password string
+	headers        http.Header
+	body           func(Event, http.Header) ([]byte, error)
+}
+
+func (h *httpChannel) String() string { return h.display }
+
+func (h *httpChannel) Send(ctx context.Context, event Event) error {
+	headers := h.headers.Clone()
+	body, err := h.body(event, headers)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", "Docklet")
+	if h.user != "" {
+		req.SetBasicAuth(h.user, h.password)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", h.display, resp.Status, strings.TrimSpace(string(message)))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return nil
+}
+
+func jsonBody(v interface{}, headers http.Header) ([]byte, error) {
+	headers.Set("Content-Type", "application/json")
+	return json.Marshal(v)
+}
+
+func eventBody(event Event, headers http.Header) ([]byte, error) {
+	return jsonBody(event, headers)
+}
+
+func appriseJSONBody(event Event, headers http.Header) ([]byte, error) {
+	return jsonBody(map[string]string{"version": "1.0", "title": event.Title, "message": event.Message, "type": event.Severity}, headers)
+}
+
+func appriseAPIBody(tags string) func(Event, http.Header) ([]byte, error) {
+	return func(event Event, headers http.Header) ([]byte, error) {
+		body := map[string]string{"title": event.Title, "body": event.Message, "type": event.Severity}
+		if tags != "" {
+			body["tag"] = tags
+		}
+		return jsonBody(body, headers)
+	}
+}
+
+func gotifyBody(event Event, headers http.Header) ([]byte, error) {
+	priority := map[string]int{SeverityFailure: 8, SeverityWarning: 5}[event.Severity]
+	if priority == 0 {
+		priority = 2
+	}
+	return jsonBody(map[string]interface{}{"title": event.Title, "message": event.Message, "priority": priority}, headers)
+}
+
+func ntfyBody(event Event, headers http.Header) ([]byte, error) {
+	headers.Set("Title", mime.QEncoding.Encode("utf-8", event.Title)) // ntfy decodes RFC 2047
+	switch event.Severity {
+	case SeverityFailure:
+		headers.Set("Priority", "high")
+		headers.Set("Tags", "rotating_light")
+	case SeverityWarning:
+		headers.Set("Tags", "warning")
+	case SeveritySuccess:
+		headers.Set("Tags", "white_check_mark")
+	}
+	if event.URL != "" {
+		headers.Set("Click", event.URL)
+	}
+	return []byte(event.Message), nil
+}
+
+// templateBody renders tmpl for the event; the result has to be valid JSON.
+func templateBody(tmpl *template.Template) func(Event, http.Header) ([]byte, error) {
+	return func(event Event, headers http.Header) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, event); err != nil {
+			return nil, fmt.Errorf("rendering DOCKLET_NOTIFY_TEMPLATE: %w", err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			return nil, fmt.Errorf("DOCKLET_NOTIFY_TEMPLATE did not render valid JSON: %s", buf.String())
+		}
+		headers.Set("Content-Type", "application/json")
+		return buf.Bytes(), nil
+	}
+}
+
+// ParseTemplate parses a webhook body template. The template gets an Event and can use
+// json to quote values, as in {"text": {{json .Message}}}.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+}
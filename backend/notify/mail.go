@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// mailChannel sends events as plain text email over SMTP.
+type mailChannel struct {
+	display        string
+	host, port     string
+	user, password string
+	from           string
+	to             []string
+	implicitTLS    bool           // TLS from the first byte, usually port 465
+	requireTLS     bool           // Fail rather than send credentials and mail in the clear
+	roots          *x509.CertPool // The system's but in tests
+}
+
+// newMailChannel parses mailto://[user:pass@]host[:port]?to=...&from=.... mailto uses
+// STARTTLS if the server offers it, on port 25 by default; mailtos requires it, on port
+// 587, or with mode=ssl connects with TLS right away, on port 465.
+func newMailChannel(u *url.URL) (Channel, error) {
+	m := &mailChannel{display: redact(u), host: u.Hostname(), port: u.Port()}
+	query := u.Query()
+	if u.Scheme == "mailtos" {
+		m.requireTLS = true
+		m.implicitTLS = query.Get("mode") == "ssl"
+	}
+	if m.port == "" {
+		switch {
+		case m.implicitTLS:
+			m.port = "465"
+		case m.requireTLS:
+			m.port = "587"
+		default:
+			m.port = "25"
+		}
+	}
+	if u.User != nil {
+		m.user = u.User.Username()
+		m.password, _ = u.User.Password()
+	}
+
+	for _, to := range strings.Split(query.Get("to"), ",") {
+		if to = strings.TrimSpace(to); to == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, fmt.Errorf("%s: invalid recipient %q: %w", m.display, to, err)
+		}
+		m.to = append(m.to, to)
+	}
+	if len(m.to) == 0 && strings.Contains(m.user, "@") {
+		m.to = []string{m.user} // Like Apprise, mail yourself by default
+	}
+	if len(m.to) == 0 {
+		return nil, fmt.Errorf("%s: missing recipient; add ?to=you@example.com", m.display)
+	}
+	m.from = query.Get("from")
+	if m.from == "" {
+		if strings.Contains(m.user, "@") {
+			m.from = m.user
+		} else {
+			hostname, _ := os.Hostname()
+			m.from = "docklet@" + hostname
+		}
+	}
+	if _, err := mail.ParseAddress(m.from); err != nil {
+		return nil, fmt.Errorf("%s: invalid sender %q: %w", m.display, m.from, err)
+	}
+	return m, nil
+}
+
+func (m *mailChannel) String() string { return m.display }
+
+func (m *mailChannel) Send(ctx context.Context, event Event) error {
+	addr := net.JoinHostPort(m.host, m.port)
+	tlsConfig := &tls.Config{ServerName: m.host, RootCAs: m.roots}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if m.implicitTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !m.implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if m.requireTLS {
+			return fmt.Errorf("%s: server doesn't offer STARTTLS", m.display)
+		}
+	}
+	if m.user != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s: server doesn't accept authentication", m.display)
+		}
+		// PlainAuth refuses to send the password without TLS, except to localhost
+		if err := client.Auth(smtp.PlainAuth("", m.user, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.from); err != nil {
+		return err
+	}
+	for _, to := range m.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.message(event)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (m *mailChannel) message(event Event) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[Docklet] "+event.Title))
+	fmt.Fprintf(&buf, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := event.Message
+	if event.URL != "" {
+		body += "\n\n" + event.URL
+	}
+	// The data writer escapes lines starting with a dot
+	for _, line := range strings.Split(body, "\n") {
+		buf.WriteString(line + "\r\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"docklet/internal/fileutil"
+)
+
+const mutesFile = "notify-mutes.json"
+
+func mutesPath(dataDir string) string {
+	return filepath.Join(dataDir, mutesFile)
+}
+
+// MuteService silences a service by name for duration, or for good if duration is 0,
+// replacing an earlier mute.
+func (d *Dispatcher) MuteService(service string, duration time.Duration) (Mute, error) {
+	mute := Mute{Service: service}
+	if duration > 0 {
+		until := d.now().Add(duration).Truncate(time.Second)
+		mute.Until = &until
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mutes[strings.ToLower(service)] = mute
+	return mute, d.saveMutesLocked()
+}
+
+// UnmuteService lifts a mute, reporting whether there was one.
+func (d *Dispatcher) UnmuteService(service string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	name := strings.ToLower(service)
+	if _, ok := d.mutes[name]; !ok {
+		return false, nil
+	}
+	delete(d.mutes, name)
+	return true, d.saveMutesLocked()
+}
+
+// mutesLocked returns the mutes in effect at now, sorted by service.
+func (d *Dispatcher) mutesLocked(now time.Time) []Mute {
+	mutes := []Mute{}
+	for _, mute := range d.mutes {
+		if mute.Until == nil || now.Before(*mute.Until) {
+			mutes = append(mutes, mute)
+		}
+	}
+	sort.Slice(mutes, func(i, j int) bool { return mutes[i].Service < mutes[j].Service })
+	return mutes
+}
+
+// loadMutes reads the mutes from disk. A missing file is not an error.
+func (d *Dispatcher) loadMutes() error {
+	data, err := os.ReadFile(d.mutesPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var mutes []Mute
+	if err := json.Unmarshal(data, &mutes); err != nil {
+		return err
+	}
+	for _, mute := range mutes {
+		d.mutes[strings.ToLower(mute.Service)] = mute
+	}
+	return nil
+}
+
+func (d *Dispatcher) saveMutesLocked() error {
+	data, err := json.MarshalIndent(d.mutesLocked(d.now()), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(d.mutesPath, data, 0o600)
+}
@@ -0,0 +1,515 @@
+// Package notify tells people when services go down, recover or appear, and when their
+// certificates are about to expire, through ntfy, Gotify, webhooks, email or Apprise.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	_ "time/tzdata" // Quiet hours follow TZ, and the container image has no zone database
+
+	"docklet/catalog"
+	"docklet/certcheck"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/monitor"
+)
+
+// Event kinds.
+const (
+	KindDown        = "down"
+	KindUp          = "up"
+	KindNew         = "new" // A container appeared
+	KindFlapping    = "flapping"
+	KindStable      = "stable" // No longer flapping
+	KindCertificate = "certificate"
+	KindSummary     = "summary" // Events held back during quiet hours
+	KindTest        = "test"
+)
+
+// Severities, named like Apprise's notification types.
+const (
+	SeverityInfo    = "info"
+	SeveritySuccess = "success"
+	SeverityWarning = "warning"
+	SeverityFailure = "failure"
+)
+
+// EnabledLabel set to "false" on a container keeps it out of notifications.
+const EnabledLabel = "docklet.notify"
+
+const (
+	tickInterval = 10 * time.Second // How often debounced changes and quiet hours are checked
+	sendTimeout  = 15 * time.Second
+	maxHeld      = 100 // Events kept for the quiet hours summary
+)
+
+// Event is one notification. Templates for webhooks get an Event.
+type Event struct {
+	Kind     string    `json:"kind"`
+	Severity string    `json:"severity"`
+	Service  string    `json:"service,omitempty"` // Service name
+	Source   string    `json:"source,omitempty"`
+	EntryID  string    `json:"entry_id,omitempty"`
+	URL      string    `json:"url,omitempty"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// Mute silences one service, by name, until a time or for good.
+type Mute struct {
+	Service string     `json:"service"`
+	Until   *time.Time `json:"until,omitempty"` // Muted for good if not set
+}
+
+// Status describes the dispatcher's configuration and state.
+type Status struct {
+	Channels   []string `json:"channels"` // Without credentials
+	Events     []string `json:"events"`   // Kinds that are sent
+	QuietHours string   `json:"quiet_hours,omitempty"`
+	Quiet      bool     `json:"quiet"` // In quiet hours right now
+	Held       int      `json:"held"`  // Events to summarize when quiet hours end
+	Mutes      []Mute   `json:"mutes"`
+	Flapping   []string `json:"flapping"` // Services whose up/down notifications are paused
+}
+
+// ChannelResult is the outcome of sending to one channel.
+type ChannelResult struct {
+	Channel string `json:"channel"`
+	Error   string `json:"error,omitempty"`
+}
+
+// serviceState tracks a service between probe rounds.
+type serviceState struct {
+	name, source, entryID, url string
+	notified                   bool // Up or down, as last told to people
+	current                    bool // As probed
+	since                      time.Time
+	lastError                  string
+	downSince                  time.Time // When the service was reported down
+	changes                    []time.Time
+	flapping                   bool
+}
+
+// Dispatcher turns monitor and certificate changes into notifications. A service has to
+// stay down (or up) for Delay before anyone is told, and a service that changes more than
+// FlapLimit times within FlapWindow is reported once as flapping and then left alone until
+// it has been stable for FlapWindow.
+type Dispatcher struct {
+	Channels   []Channel
+	Events     map[string]bool
+	Delay      time.Duration
+	FlapLimit  int
+	FlapWindow time.Duration
+	Quiet      *QuietHours // nil for none
+
+	mutesPath string
+	now       func() time.Time // time.Now but in tests
+
+	mu          sync.Mutex
+	services    map[string]*serviceState // Keyed by source and name, which survive container re-creation
+	seen        map[string]bool          // Containers by name, for new container events
+	firstRound  bool
+	labelMuted  map[string]bool // Names of services with docklet.notify=false
+	mutes       map[string]Mute // Keyed by lower-case name
+	outbox      []Event
+	held        []Event
+	heldDropped int
+}
+
+// NewDispatcher creates a Dispatcher configured from environment variables:
+//
+//	DOCKLET_NOTIFY_URLS         Apprise-style channel URLs separated by spaces or commas (see ParseChannel)
+//	DOCKLET_NOTIFY_TEMPLATE     Go template rendering the JSON body of webhooks (default: the event)
+//	DOCKLET_NOTIFY_EVENTS       comma-separated kinds to send (default: down,up,new,flapping,certificate)
+//	DOCKLET_NOTIFY_DELAY        how long a service has to stay down or up before it is reported (default 1m)
+//	DOCKLET_NOTIFY_FLAP_LIMIT   changes within the flap window that make a service flapping (default 5)
+//	DOCKLET_NOTIFY_FLAP_WINDOW  (default 1h)
+//	DOCKLET_NOTIFY_QUIET_HOURS  e.g. 22:00-07:00 in the TZ time zone; events are summarized afterwards
+//
+// Mutes set through the API are kept in dataDir.
+func NewDispatcher(dataDir string) (*Dispatcher, error) {
+	rawURLs := splitURLs(dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_URLS", ""))
+	if len(rawURLs) == 0 {
+		return nil, fmt.Errorf("DOCKLET_NOTIFY_URLS is empty")
+	}
+	var tmpl *template.Template
+	if text := dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_TEMPLATE", ""); text != "" {
+		var err error
+		if tmpl, err = ParseTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid DOCKLET_NOTIFY_TEMPLATE: %w", err)
+		}
+	}
+	client := &http.Client{Timeout: sendTimeout}
+	var channels []Channel
+	for _, rawURL := range rawURLs {
+		channel, err := ParseChannel(rawURL, tmpl, client)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DOCKLET_NOTIFY_URLS: %w", err)
+		}
+		channels = append(channels, channel)
+	}
+
+	events := make(map[string]bool)
+	for _, kind := range strings.Split(dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_EVENTS", "down,up,new,flapping,certificate"), ",") {
+		switch kind = strings.TrimSpace(kind); kind {
+		case "":
+		case KindDown, KindUp, KindNew, KindFlapping, KindCertificate:
+			events[kind] = true
+		default:
+			return nil, fmt.Errorf("invalid DOCKLET_NOTIFY_EVENTS: unknown event %q", kind)
+		}
+	}
+	delay, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_DELAY", "1m"))
+	if err != nil || delay < 0 {
+		return nil, fmt.Errorf("invalid DOCKLET_NOTIFY_DELAY: must be a duration")
+	}
+	flapLimit, err := strconv.Atoi(dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_FLAP_LIMIT", "5"))
+	if err != nil || flapLimit < 2 {
+		return nil, fmt.Errorf("invalid DOCKLET_NOTIFY_FLAP_LIMIT: must be a number of at least 2")
+	}
+	flapWindow, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_FLAP_WINDOW", "1h"))
+	if err != nil || flapWindow <= 0 {
+		return nil, fmt.Errorf("invalid DOCKLET_NOTIFY_FLAP_WINDOW: must be a positive duration")
+	}
+	var quiet *QuietHours
+	if spec := dockerscanner.GetEnvOrDefault("DOCKLET_NOTIFY_QUIET_HOURS", ""); spec != "" {
+		if quiet, err = ParseQuietHours(spec); err != nil {
+			return nil, fmt.Errorf("invalid DOCKLET_NOTIFY_QUIET_HOURS: %w", err)
+		}
+	}
+
+	d := &Dispatcher{
+		Channels:   channels,
+		Events:     events,
+		Delay:      delay,
+		FlapLimit:  flapLimit,
+		FlapWindow: flapWindow,
+		Quiet:      quiet,
+		mutesPath:  mutesPath(dataDir),
+		now:        time.Now,
+		services:   make(map[string]*serviceState),
+		seen:       make(map[string]bool),
+		firstRound: true,
+		labelMuted: make(map[string]bool),
+		mutes:      make(map[string]Mute),
+	}
+	if err := d.loadMutes(); err != nil {
+		log.Printf("Warning: failed to load notification mutes, starting without: %v", err)
+	}
+	return d, nil
+}
+
+// splitURLs splits a list of URLs on spaces and on commas that start another URL, so
+// commas inside a URL, as in mailto://...?to=a@example.com,b@example.com, are kept.
+func splitURLs(list string) []string {
+	var urls []string
+	for _, field := range strings.Fields(list) {
+		for _, part := range strings.Split(field, ",") {
+			switch {
+			case part == "":
+			case strings.Contains(part, "://") || len(urls) == 0:
+				urls = append(urls, part)
+			default:
+				urls[len(urls)-1] += "," + part
+			}
+		}
+	}
+	return urls
+}
+
+// ObserveRound takes the services and probe results of a monitor round; set it as the
+// monitor's OnRound.
+func (d *Dispatcher) ObserveRound(entries []catalog.Entry, statuses []monitor.Status) {
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.labelMuted = make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Labels[EnabledLabel] == "false" {
+			d.labelMuted[strings.ToLower(entry.Name)] = true
+		}
+	}
+	for _, entry := range entries {
+		if entry.Source != catalog.SourceDocker || d.seen[entry.Name] {
+			continue
+		}
+		d.seen[entry.Name] = true
+		if !d.firstRound {
+			message := fmt.Sprintf("Container %s (%s) appeared", entry.Name, entry.Docker.ImageName)
+			if entry.URL != "" {
+				message += " at " + entry.URL
+			}
+			d.emitLocked(Event{Kind: KindNew, Severity: SeverityInfo, Service: entry.Name, Source: entry.Source, EntryID: entry.ID, URL: entry.URL,
+				Title: "New container: " + entry.Name, Message: message + ".", Time: now})
+		}
+	}
+	d.firstRound = false
+
+	current := make(map[string]bool)
+	for _, status := range statuses {
+		key := status.Source + ":" + status.Name
+		current[key] = true
+		state := d.services[key]
+		if state == nil {
+			// Nobody is told about the state a service is first seen in
+			d.services[key] = &serviceState{notified: status.Up, current: status.Up, since: status.CheckedAt}
+			state = d.services[key]
+		} else if status.Up != state.current {
+			state.current, state.since = status.Up, status.CheckedAt
+		}
+		state.name, state.source, state.entryID, state.url = status.Name, status.Source, status.ID, status.URL
+		state.lastError = status.Error
+	}
+	for key := range d.services {
+		if !current[key] {
+			delete(d.services, key) // Removed; a stopped container is still listed and reported down
+		}
+	}
+}
+
+// ObserveCertificate takes a certificate level change; set it as the certificate
+// checker's OnLevelChange. A certificate first seen as fine isn't reported.
+func (d *Dispatcher) ObserveCertificate(report certcheck.Report, previous string) {
+	details := report.Certificate
+	if details.Level == certcheck.LevelOK && (previous == "" || previous == certcheck.LevelError) {
+		return
+	}
+	event := Event{Kind: KindCertificate, Service: report.Name, Source: report.Source, EntryID: report.EntryID, URL: report.URL, Time: d.now()}
+	switch details.Level {
+	case certcheck.LevelOK:
+		event.Severity = SeveritySuccess
+		event.Title = "Certificate of " + report.Name + " renewed"
+		event.Message = fmt.Sprintf("The certificate of %s at %s is now valid until %s.", report.Name, report.Address, details.NotAfter.Format(time.DateOnly))
+	case certcheck.LevelWarning, certcheck.LevelCritical:
+		event.Severity = SeverityWarning
+		if details.Level == certcheck.LevelCritical {
+			event.Severity = SeverityFailure
+		}
+		event.Title = fmt.Sprintf("Certificate of %s expires in %d days", report.Name, *details.DaysLeft)
+		event.Message = fmt.Sprintf("The certificate of %s at %s, issued by %s, expires on %s.", report.Name, report.Address, details.Issuer, details.NotAfter.Format(time.DateOnly))
+	case certcheck.LevelExpired:
+		event.Severity = SeverityFailure
+		event.Title = "Certificate of " + report.Name + " expired"
+		event.Message = fmt.Sprintf("The certificate of %s at %s expired on %s.", report.Name, report.Address, details.NotAfter.Format(time.DateOnly))
+	default:
+		event.Severity = SeverityWarning
+		event.Title = "Certificate of " + report.Name + " can't be read"
+		event.Message = fmt.Sprintf("Reading the certificate of %s at %s failed: %s", report.Name, report.Address, details.Error)
+	}
+	d.mu.Lock()
+	d.emitLocked(event)
+	d.mu.Unlock()
+}
+
+// Run reports debounced changes and sends notifications until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		d.tickLocked(d.now())
+		outbox := d.outbox
+		d.outbox = nil
+		d.mu.Unlock()
+
+		for _, event := range outbox {
+			for _, result := range d.send(ctx, event) {
+				if result.Error != "" {
+					log.Printf("Notify: failed to send %q to %s: %s", event.Title, result.Channel, result.Error)
+				}
+			}
+		}
+	}
+}
+
+// tickLocked reports changes that lasted Delay, ends flapping and sends the quiet hours
+// summary once they are over.
+func (d *Dispatcher) tickLocked(now time.Time) {
+	for _, state := range d.services {
+		if state.flapping && len(state.changes) > 0 && now.Sub(state.changes[len(state.changes)-1]) >= d.FlapWindow {
+			state.flapping, state.changes = false, nil
+			d.emitLocked(Event{Kind: KindStable, Severity: SeverityInfo, Service: state.name, Source: state.source, EntryID: state.entryID, URL: state.url,
+				Title: state.name + " is stable again", Message: fmt.Sprintf("%s stopped flapping and is %s.", state.name, upDown(state.notified)), Time: now})
+		}
+		if state.current == state.notified || now.Sub(state.since) < d.Delay {
+			continue
+		}
+		state.notified = state.current
+		if !state.current {
+			state.downSince = state.since
+		}
+
+		window := now.Add(-d.FlapWindow)
+		for len(state.changes) > 0 && state.changes[0].Before(window) {
+			state.changes = state.changes[1:]
+		}
+		state.changes = append(state.changes, now)
+		if state.flapping {
+			continue
+		}
+		if len(state.changes) > d.FlapLimit {
+			state.flapping = true
+			d.emitLocked(Event{Kind: KindFlapping, Severity: SeverityWarning, Service: state.name, Source: state.source, EntryID: state.entryID, URL: state.url,
+				Title: state.name + " is flapping",
+				Message: fmt.Sprintf("%s went up and down %d times within %s; its notifications are paused until it stays %s for %s.",
+					state.name, len(state.changes), d.FlapWindow, upDown(state.current), d.FlapWindow),
+				Time: now})
+			continue
+		}
+
+		event := Event{Service: state.name, Source: state.source, EntryID: state.entryID, URL: state.url, Time: now}
+		if state.current {
+			event.Kind, event.Severity = KindUp, SeveritySuccess
+			event.Title = state.name + " is up"
+			event.Message = fmt.Sprintf("%s answers again at %s", state.name, state.url)
+			if !state.downSince.IsZero() {
+				event.Message += fmt.Sprintf(" after being down for %s", state.since.Sub(state.downSince).Round(time.Second))
+			}
+			event.Message += "."
+		} else {
+			event.Kind, event.Severity = KindDown, SeverityFailure
+			event.Title = state.name + " is down"
+			event.Message = fmt.Sprintf("%s at %s stopped answering at %s", state.name, state.url, state.since.Format("15:04"))
+			if state.lastError != "" {
+				event.Message += ": " + state.lastError
+			} else {
+				event.Message += "."
+			}
+		}
+		d.emitLocked(event)
+	}
+
+	if d.Quiet != nil && (len(d.held) > 0 || d.heldDropped > 0) && !d.Quiet.Contains(now) {
+		d.outbox = append(d.outbox, d.summaryLocked(now))
+		d.held, d.heldDropped = nil, 0
+	}
+	for name, mute := range d.mutes {
+		if mute.Until != nil && now.After(*mute.Until) {
+			delete(d.mutes, name)
+		}
+	}
+}
+
+// emitLocked queues an event, unless its kind is disabled or its service muted. In quiet
+// hours the event is held for the summary instead.
+func (d *Dispatcher) emitLocked(event Event) {
+	kind := event.Kind
+	if kind == KindStable {
+		kind = KindFlapping
+	}
+	if !d.Events[kind] || d.mutedLocked(event.Service, event.Time) {
+		return
+	}
+	if d.Quiet != nil && d.Quiet.Contains(event.Time) {
+		if len(d.held) < maxHeld {
+			d.held = append(d.held, event)
+		} else {
+			d.heldDropped++
+		}
+		return
+	}
+	d.outbox = append(d.outbox, event)
+}
+
+func (d *Dispatcher) mutedLocked(service string, now time.Time) bool {
+	name := strings.ToLower(service)
+	if d.labelMuted[name] {
+		return true
+	}
+	mute, ok := d.mutes[name]
+	return ok && (mute.Until == nil || now.Before(*mute.Until))
+}
+
+func (d *Dispatcher) summaryLocked(now time.Time) Event {
+	var lines []string
+	severity := SeverityInfo
+	for _, event := range d.held {
+		lines = append(lines, event.Time.Format("15:04")+" "+event.Title)
+		if event.Severity == SeverityFailure || (event.Severity == SeverityWarning && severity != SeverityFailure) {
+			severity = event.Severity
+		}
+	}
+	if d.heldDropped > 0 {
+		lines = append(lines, fmt.Sprintf("... and %d more", d.heldDropped))
+	}
+	count := len(d.held) + d.heldDropped
+	return Event{Kind: KindSummary, Severity: severity, Time: now,
+		Title:   fmt.Sprintf("%d notifications during quiet hours", count),
+		Message: strings.Join(lines, "\n")}
+}
+
+func (d *Dispatcher) send(ctx context.Context, event Event) []ChannelResult {
+	results := make([]ChannelResult, len(d.Channels))
+	var wg sync.WaitGroup
+	for i, channel := range d.Channels {
+		wg.Add(1)
+		go func(i int, channel Channel) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+			defer cancel()
+			results[i] = ChannelResult{Channel: channel.String()}
+			if err := channel.Send(ctx, event); err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, channel)
+	}
+	wg.Wait()
+	return results
+}
+
+// Test sends a test notification to every channel right away, ignoring mutes and quiet hours.
+func (d *Dispatcher) Test(ctx context.Context) []ChannelResult {
+	hostname, _ := os.Hostname()
+	return d.send(ctx, Event{Kind: KindTest, Severity: SeverityInfo, Time: d.now(),
+		Title:   "Docklet test notification",
+		Message: "Notifications from Docklet on " + hostname + " reach this channel."})
+}
+
+// Status returns the configuration and current state.
+func (d *Dispatcher) Status() Status {
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := Status{Channels: []string{}, Events: []string{}, Mutes: d.mutesLocked(now), Flapping: []string{}, Held: len(d.held) + d.heldDropped}
+	for _, channel := range d.Channels {
+		status.Channels = append(status.Channels, channel.String())
+	}
+	for kind := range d.Events {
+		status.Events = append(status.Events, kind)
+	}
+	sort.Strings(status.Events)
+	if d.Quiet != nil {
+		status.QuietHours = d.Quiet.String()
+		status.Quiet = d.Quiet.Contains(now)
+	}
+	for _, state := range d.services {
+		if state.flapping {
+			status.Flapping = append(status.Flapping, state.name)
+		}
+	}
+	sort.Strings(status.Flapping)
+	return status
+}
+
+func upDown(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
@@ -0,0 +1,322 @@
+package notify
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"docklet/catalog"
+	"docklet/certcheck"
+	"docklet/monitor"
+)
+
+// testDispatcher drives a Dispatcher with a clock that only moves when told to.
+type testDispatcher struct {
+	*Dispatcher
+	t     *testing.T
+	clock time.Time
+}
+
+func newTestDispatcher(t *testing.T, start time.Time) *testDispatcher {
+	td := &testDispatcher{t: t, clock: start}
+	td.Dispatcher = &Dispatcher{
+		Events:     map[string]bool{KindDown: true, KindUp: true, KindNew: true, KindFlapping: true, KindCertificate: true},
+		Delay:      time.Minute,
+		FlapLimit:  3,
+		FlapWindow: time.Hour,
+		mutesPath:  mutesPath(t.TempDir()),
+		now:        func() time.Time { return td.clock },
+		services:   make(map[string]*serviceState),
+		seen:       make(map[string]bool),
+		firstRound: true,
+		labelMuted: make(map[string]bool),
+		mutes:      make(map[string]Mute),
+	}
+	return td
+}
+
+// probe moves the clock by elapsed and reports grafana as up or down, with labels on
+// its container.
+func (td *testDispatcher) probe(elapsed time.Duration, up bool, labels ...string) {
+	td.clock = td.clock.Add(elapsed)
+	entry := catalog.Entry{ID: "docker:abc", Source: catalog.SourceDocker, Name: "grafana", URL: "http://nas.lan:3000",
+		Labels: make(map[string]string), Docker: &catalog.DockerDetails{ImageName: "grafana/grafana"}}
+	for _, label := range labels {
+		name, value, _ := strings.Cut(label, "=")
+		entry.Labels[name] = value
+	}
+	status := monitor.Status{ID: entry.ID, Name: entry.Name, Source: entry.Source, URL: entry.URL, Up: up, CheckedAt: td.clock}
+	if !up {
+		status.Error = "connection refused"
+	}
+	td.ObserveRound([]catalog.Entry{entry}, []monitor.Status{status})
+}
+
+// tick moves the clock by elapsed, ticks and returns the titles of the events to send.
+func (td *testDispatcher) tick(elapsed time.Duration) []string {
+	td.clock = td.clock.Add(elapsed)
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	td.tickLocked(td.clock)
+	var titles []string
+	for _, event := range td.outbox {
+		titles = append(titles, event.Title)
+	}
+	td.outbox = nil
+	return titles
+}
+
+// expect ticks after elapsed and checks the events sent.
+func (td *testDispatcher) expect(elapsed time.Duration, want ...string) {
+	td.t.Helper()
+	if got := td.tick(elapsed); strings.Join(got, "; ") != strings.Join(want, "; ") {
+		td.t.Errorf("at %s sent %q, want %q", td.clock.Format("15:04:05"), got, want)
+	}
+}
+
+var noon = time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+
+func TestDispatcherDelay(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	td.probe(0, true)
+	td.expect(time.Hour) // Nobody is told about the first state
+
+	td.probe(0, false)
+	td.expect(30 * time.Second)
+	td.expect(30*time.Second, "grafana is down")
+	td.expect(time.Minute)
+
+	// A blip shorter than Delay isn't reported
+	td.probe(0, true)
+	td.probe(20*time.Second, false)
+	td.expect(time.Minute)
+
+	td.probe(0, true)
+	td.expect(59 * time.Second)
+	td.outbox = nil
+	td.tickLocked(td.clock.Add(time.Second))
+	if len(td.outbox) != 1 || td.outbox[0].Kind != KindUp || td.outbox[0].Message != "grafana answers again at http://nas.lan:3000 after being down for 3m20s." {
+		t.Errorf("recovery events = %+v", td.outbox)
+	}
+}
+
+func TestDispatcherDownMessage(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	td.probe(0, true)
+	td.probe(time.Minute, false)
+	td.clock = td.clock.Add(time.Minute)
+	td.tickLocked(td.clock)
+	if len(td.outbox) != 1 {
+		t.Fatalf("events = %+v, want one", td.outbox)
+	}
+	event := td.outbox[0]
+	if event.Kind != KindDown || event.Severity != SeverityFailure || event.EntryID != "docker:abc" || event.URL != "http://nas.lan:3000" ||
+		event.Message != "grafana at http://nas.lan:3000 stopped answering at 12:01: connection refused" || !event.Time.Equal(td.clock) {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestDispatcherFlapping(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	td.Delay = 0
+	td.probe(0, true)
+
+	td.probe(time.Minute, false)
+	td.expect(0, "grafana is down")
+	td.probe(time.Minute, true)
+	td.expect(0, "grafana is up")
+	td.probe(time.Minute, false)
+	td.expect(0, "grafana is down")
+	// The fourth change within FlapWindow is more than FlapLimit
+	td.probe(time.Minute, true)
+	td.expect(0, "grafana is flapping")
+	if flapping := td.Status().Flapping; len(flapping) != 1 || flapping[0] != "grafana" {
+		t.Errorf("Status().Flapping = %q", flapping)
+	}
+	td.probe(time.Minute, false)
+	td.expect(0)
+	td.probe(time.Minute, true)
+	td.expect(0)
+
+	// Stable for FlapWindow after the last change
+	td.expect(59 * time.Minute)
+	td.expect(time.Minute, "grafana is stable again")
+	if flapping := td.Status().Flapping; len(flapping) != 0 {
+		t.Errorf("Status().Flapping = %q after it stopped", flapping)
+	}
+	td.probe(time.Minute, false)
+	td.expect(0, "grafana is down")
+}
+
+func TestDispatcherFlapWindow(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	td.Delay = 0
+	td.probe(0, true)
+
+	// Never more than FlapLimit changes within an hour
+	for i := 0; i < 8; i++ {
+		up := i%2 == 1
+		td.probe(30*time.Minute, up)
+		if up {
+			td.expect(0, "grafana is up")
+		} else {
+			td.expect(0, "grafana is down")
+		}
+	}
+}
+
+func TestDispatcherQuietHours(t *testing.T) {
+	td := newTestDispatcher(t, time.Date(2026, 3, 2, 21, 0, 0, 0, time.Local))
+	td.Quiet, _ = ParseQuietHours("22:00-07:00")
+	td.probe(0, true)
+
+	td.probe(time.Hour, false) // 22:00
+	td.expect(time.Minute)
+	td.probe(2*time.Hour, true) // 00:01
+	td.expect(time.Minute)
+	if status := td.Status(); !status.Quiet || status.Held != 2 || status.QuietHours != "22:00-07:00" {
+		t.Errorf("Status() = %+v, want 2 events held in quiet hours", status)
+	}
+	td.expect(6*time.Hour + 57*time.Minute) // 06:59
+
+	td.clock = td.clock.Add(time.Minute) // 07:00
+	td.tickLocked(td.clock)
+	if len(td.outbox) != 1 {
+		t.Fatalf("events = %+v, want the summary", td.outbox)
+	}
+	summary := td.outbox[0]
+	if summary.Kind != KindSummary || summary.Severity != SeverityFailure || summary.Title != "2 notifications during quiet hours" ||
+		summary.Message != "22:01 grafana is down\n00:02 grafana is up" {
+		t.Errorf("summary = %+v", summary)
+	}
+	td.outbox = nil
+	td.expect(time.Hour)
+	if held := td.Status().Held; held != 0 {
+		t.Errorf("%d events still held after the summary", held)
+	}
+}
+
+func TestDispatcherQuietHoursOverflow(t *testing.T) {
+	td := newTestDispatcher(t, time.Date(2026, 3, 2, 23, 0, 0, 0, time.Local))
+	td.Quiet, _ = ParseQuietHours("22:00-07:00")
+	td.mu.Lock()
+	for i := 0; i < maxHeld+5; i++ {
+		td.emitLocked(Event{Kind: KindNew, Severity: SeverityInfo, Title: "New container", Time: td.clock})
+	}
+	td.mu.Unlock()
+
+	td.clock = td.clock.Add(8 * time.Hour)
+	td.tickLocked(td.clock)
+	if len(td.outbox) != 1 {
+		t.Fatalf("events = %+v, want the summary", td.outbox)
+	}
+	summary := td.outbox[0]
+	lines := strings.Split(summary.Message, "\n")
+	if summary.Title != "105 notifications during quiet hours" || summary.Severity != SeverityInfo ||
+		len(lines) != maxHeld+1 || lines[maxHeld] != "... and 5 more" {
+		t.Errorf("summary %q with %d lines, last %q", summary.Title, len(lines), lines[len(lines)-1])
+	}
+}
+
+func TestDispatcherMutes(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	td.probe(0, true)
+
+	if _, err := td.MuteService("Grafana", 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	td.probe(0, false)
+	td.expect(time.Minute) // Muted, by name in any case
+	if mutes := td.Status().Mutes; len(mutes) != 1 || !mutes[0].Until.Equal(noon.Add(2*time.Hour)) {
+		t.Errorf("Status().Mutes = %+v", mutes)
+	}
+
+	// The mute outlives a restart
+	loaded := newTestDispatcher(t, td.clock)
+	loaded.mutesPath = td.mutesPath
+	if err := loaded.loadMutes(); err != nil || !loaded.mutedLocked("grafana", td.clock) {
+		t.Errorf("mute not loaded from %s: %v", filepath.Base(td.mutesPath), err)
+	}
+
+	// Expired: the change made while muted isn't reported late, the next one is
+	td.expect(2 * time.Hour)
+	if len(td.mutes) != 0 {
+		t.Errorf("expired mute kept: %+v", td.mutes)
+	}
+	td.probe(0, true)
+	td.expect(time.Minute, "grafana is up")
+
+	// Muted for good until unmuted
+	td.MuteService("grafana", 0)
+	td.probe(time.Hour, false)
+	td.expect(24 * time.Hour)
+	if found, err := td.UnmuteService("GRAFANA"); !found || err != nil {
+		t.Errorf("UnmuteService() = %t, %v", found, err)
+	}
+	td.probe(0, true)
+	td.expect(time.Minute, "grafana is up")
+
+	// The container's label
+	td.probe(time.Hour, false, EnabledLabel+"=false")
+	td.expect(time.Minute)
+	td.probe(0, true)
+	td.expect(time.Minute, "grafana is up")
+}
+
+func TestDispatcherNewContainers(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	td.probe(0, true)
+	td.expect(time.Minute) // Containers running when Docklet starts aren't new
+
+	td.ObserveRound([]catalog.Entry{{ID: "docker:def", Source: catalog.SourceDocker, Name: "immich", URL: "http://nas.lan:2283",
+		Docker: &catalog.DockerDetails{ImageName: "immich-server"}}}, nil)
+	td.mu.Lock()
+	events := td.outbox
+	td.mu.Unlock()
+	if len(events) != 1 || events[0].Kind != KindNew || events[0].Message != "Container immich (immich-server) appeared at http://nas.lan:2283." {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestDispatcherCertificates(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	notAfter := noon.Add(20 * 24 * time.Hour)
+	daysLeft := 20
+	report := certcheck.Report{EntryID: "docker:abc", Name: "grafana", Address: "nas.lan:3000",
+		Certificate: catalog.CertificateDetails{Level: certcheck.LevelOK, Issuer: "CN=Home CA", NotAfter: &notAfter, DaysLeft: &daysLeft}}
+
+	for _, tt := range []struct {
+		level, previous string
+		want            string
+	}{
+		{certcheck.LevelOK, "", ""},
+		{certcheck.LevelOK, certcheck.LevelError, ""},
+		{certcheck.LevelWarning, certcheck.LevelOK, "warning Certificate of grafana expires in 20 days"},
+		{certcheck.LevelCritical, certcheck.LevelWarning, "failure Certificate of grafana expires in 20 days"},
+		{certcheck.LevelExpired, certcheck.LevelCritical, "failure Certificate of grafana expired"},
+		{certcheck.LevelOK, certcheck.LevelExpired, "success Certificate of grafana renewed"},
+		{certcheck.LevelError, certcheck.LevelOK, "warning Certificate of grafana can't be read"},
+	} {
+		report.Certificate.Level = tt.level
+		td.ObserveCertificate(report, tt.previous)
+		var got string
+		if len(td.outbox) > 0 {
+			got = td.outbox[0].Severity + " " + td.outbox[0].Title
+		}
+		if len(td.outbox) > 1 || got != tt.want {
+			t.Errorf("%s after %s: sent %+v, want %q", tt.level, tt.previous, td.outbox, tt.want)
+		}
+		td.outbox = nil
+	}
+}
+
+func TestDispatcherEvents(t *testing.T) {
+	td := newTestDispatcher(t, noon)
+	td.Events = map[string]bool{KindDown: true}
+	td.probe(0, true)
+	td.probe(0, false)
+	td.expect(time.Minute, "grafana is down")
+	td.probe(0, true)
+	td.expect(time.Minute) // Up isn't enabled
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily time range in local time, which may span midnight.
+type QuietHours struct {
+	start, end int // Minutes since midnight
+}
+
+// ParseQuietHours parses a range such as 22:00-07:00.
+func ParseQuietHours(spec string) (*QuietHours, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("%q is not a range like 22:00-07:00", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("%q is empty", spec)
+	}
+	return &QuietHours{start: start, end: end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time like 07:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t falls in the quiet hours.
+func (q *QuietHours) Contains(t time.Time) bool {
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+func (q *QuietHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", q.start/60, q.start%60, q.end/60, q.end%60)
+}
@@ -0,0 +1,247 @@
+// Package openapi generates the OpenAPI 3 description of Docklet's HTTP API from the
+// Go types the handlers serialize, so the document can't drift from the implementation.
+package openapi
+
+import (
+	"encoding/json"
+	"sync"
+
+	"docklet/catalog"
+	"docklet/certcheck"
+	"docklet/dnsrecords"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/export"
+	"docklet/health"
+	"docklet/importer"
+	"docklet/links"
+	"docklet/mdns"
+	"docklet/notify"
+	"docklet/portaudit"
+	"docklet/portforward"
+	"docklet/proxy"
+	systemscanner "docklet/system_scanner"
+)
+
+// Version is the version of the API described by the document. Bump the minor version
+// for additions and the major version for breaking changes.
+const Version = "1.9.0"
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Code      string      `json:"code"`                 // Stable, e.g. DOCKER_UNAVAILABLE
+	Message   string      `json:"message"`              // Human-readable summary
+	Details   interface{} `json:"details,omitempty"`    // Cause or extra data, depending on the code
+	RequestID string      `json:"request_id,omitempty"` // Also sent as X-Request-ID
+}
+
+// HealthResponse is the body of /api/v1/health and /api/v1/health/live.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// PortForwardApplyRequest is the body of /api/v1/portforward/apply.
+type PortForwardApplyRequest struct {
+	PlanID string `json:"plan_id"` // ID of the reviewed plan
+}
+
+// operation describes one route.
+type operation struct {
+	method      string
+	path        string
+	id          string
+	summary     string
+	params      []param
+	requestBody string      // Content type of a raw request body, if any
+	request     interface{} // Value of the JSON request body type, if any
+	bearerAuth  bool        // Needs Authorization: Bearer <token>
+	response    interface{} // Value of the JSON response type; nil for non-JSON responses
+	contentType string      // Content type of non-JSON responses
+	noContent   bool
+}
+
+type param struct {
+	name, in, description string
+	required              bool
+	enum                  []string
+}
+
+// operations lists the documented routes. Keep in sync with registerAPIRoutes in routes.go;
+// routes_test.go checks that they match.
+var operations = []operation{
+	{method: "get", path: "/api/v1/services", id: "listServices", summary: "List Docker services",
+		response: []dockerscanner.ServiceInfo{}},
+	{method: "get", path: "/api/v1/system-services", id: "listSystemServices", summary: "List native system services that are likely web services",
+		response: []systemscanner.SystemServiceInfo{}},
+	{method: "get", path: "/api/v1/catalog", id: "getCatalog", summary: "List Docker services, system services and stored links in one normalized schema, with a warning per failed source",
+		response: catalog.Result{}},
+	{method: "get", path: "/api/v1/icons/{key}", id: "getIcon", summary: "Get a bundled or cached icon",
+		params: []param{{name: "key", in: "path", required: true}}, contentType: "image/*"},
+	{method: "get", path: "/api/v1/export", id: "exportCatalog", summary: "Render the catalog in another dashboard's config format",
+		params: []param{
+			{name: "format", in: "query", description: "Output format, default homer", enum: export.Formats()},
+			{name: "title", in: "query", description: "Dashboard title"},
+		}, contentType: "text/plain"},
+	{method: "get", path: "/api/v1/export/bookmarks.html", id: "exportBookmarks", summary: "Netscape bookmark file with one folder per category",
+		contentType: "text/html"},
+	{method: "get", path: "/api/v1/export/services.opml", id: "exportOPML", summary: "OPML outline of all services",
+		contentType: "text/x-opml"},
+	{method: "get", path: "/api/v1/export/feed.json", id: "exportJSONFeed", summary: "JSON Feed listing all services",
+		contentType: "application/feed+json"},
+	{method: "post", path: "/api/v1/import", id: "importLinks", summary: "Import links from a Homer/Homepage config or a bookmarks file, sent with its own Content-Type such as application/yaml, text/html or application/octet-stream; 415 for form and text/plain bodies",
+		params: []param{
+			{name: "format", in: "query", required: true, enum: importer.Formats()},
+			{name: "dry_run", in: "query", description: "Only report what would be imported"},
+			{name: "keep_conflicts", in: "query", description: "Also import links matching a discovered service"},
+		}, requestBody: "application/octet-stream", response: importer.Result{}},
+	{method: "get", path: "/api/v1/links", id: "listLinks", summary: "List stored links",
+		response: []links.Link{}},
+	{method: "delete", path: "/api/v1/links/{id}", id: "deleteLink", summary: "Delete a stored link",
+		params: []param{{name: "id", in: "path", required: true}}, noContent: true},
+	{method: "get", path: "/api/v1/ports", id: "auditPorts", summary: "Host ports in use by published container ports and native TCP and UDP listeners, with owner, bind address and warnings about conflicts, exposed sensitive services and unlabelled containers",
+		response: portaudit.Report{}},
+	{method: "get", path: "/api/v1/portforward/plan", id: "getPortForwardPlan", summary: "OpenWrt port forwards to add, modify and delete to match the policy; 404 DISABLED unless the openwrt method is enabled",
+		response: portforward.Plan{}},
+	{method: "post", path: "/api/v1/portforward/apply", id: "applyPortForwardPlan", summary: "Apply the port-forward plan to the router; needs the DOCKLET_PORTFORWARD_TOKEN bearer token; 409 PLAN_CHANGED with the new plan if it no longer matches plan_id",
+		request: PortForwardApplyRequest{}, bearerAuth: true, response: portforward.Plan{}},
+	{method: "get", path: "/api/v1/portforward/mappings", id: "listPortMappings", summary: "UPnP or NAT-PMP/PCP mappings Docklet keeps on the gateway; 404 DISABLED unless that method is enabled",
+		response: []portforward.Lease{}},
+	{method: "get", path: "/api/v1/mdns", id: "listMDNSServices", summary: "Services published as <name>.local with mDNS/DNS-SD; 404 DISABLED unless DOCKLET_MDNS=true",
+		response: []mdns.Service{}},
+	{method: "get", path: "/api/v1/proxy", id: "listProxyRoutes", summary: "Services that opted into the proxy, at <name>.<domain> and <domain>/s/<name>/; 404 DISABLED unless DOCKLET_PROXY=true",
+		response: []proxy.Route{}},
+	{method: "get", path: "/api/v1/certificates", id: "listCertificates", summary: "Certificates presented by https services, with issuer, validity and problems, the earliest expiry first; 404 DISABLED if DOCKLET_CERTCHECK=false",
+		response: []certcheck.Report{}},
+	{method: "get", path: "/api/v1/notify", id: "getNotifyStatus", summary: "Notification channels without credentials, enabled events, quiet hours, mutes and flapping services; 404 DISABLED unless DOCKLET_NOTIFY_URLS is set",
+		response: notify.Status{}},
+	{method: "post", path: "/api/v1/notify/test", id: "testNotify", summary: "Send a test notification to every channel, ignoring mutes and quiet hours, and report each channel's result; the body is an empty JSON object",
+		request: struct{}{}, response: []notify.ChannelResult{}},
+	{method: "put", path: "/api/v1/notify/mutes/{service}", id: "muteService", summary: "Mute notifications about a service by name, for a while or until unmuted",
+		params: []param{
+			{name: "service", in: "path", required: true},
+			{name: "for", in: "query", description: "Duration such as 2h; muted until unmuted if not set"},
+		}, response: notify.Mute{}},
+	{method: "delete", path: "/api/v1/notify/mutes/{service}", id: "unmuteService", summary: "Lift the mute of a service",
+		params: []param{{name: "service", in: "path", required: true}}, noContent: true},
+	{method: "get", path: "/api/v1/tls/ca.pem", id: "getCACertificate", summary: "Local CA certificate in PEM, for devices to trust; 404 DISABLED unless DOCKLET_TLS=true without DOCKLET_TLS_CERT",
+		contentType: "application/x-pem-file"},
+	{method: "get", path: "/api/v1/tls/ca.crt", id: "getCACertificateDER", summary: "Local CA certificate in DER, the form phones install from a download",
+		contentType: "application/x-x509-ca-cert"},
+	{method: "get", path: "/api/v1/dns", id: "listDNSRecords", summary: "Host names derived for Docker and system services, with the address they resolve to",
+		response: []dnsrecords.Record{}},
+	{method: "get", path: "/api/v1/dns/{format}", id: "renderDNSRecords", summary: "Host names in a local resolver's format: a hosts file, dnsmasq, Unbound, CoreDNS hosts, AdGuard Home or Pi-hole",
+		params: []param{{name: "format", in: "path", required: true, enum: dnsrecords.Formats()}}, contentType: "text/plain"},
+	{method: "get", path: "/api/v1/health", id: "getHealth", summary: "Liveness check (alias of /api/v1/health/live)",
+		response: HealthResponse{}},
+	{method: "get", path: "/api/v1/health/live", id: "getLiveness", summary: "Liveness check; succeeds while the process serves requests",
+		response: HealthResponse{}},
+	{method: "get", path: "/api/v1/health/ready", id: "getReadiness", summary: "Readiness check with per-component status and latency; 503 with the same body if a critical component failed",
+		response: health.Report{}},
+	{method: "get", path: "/api/v1/openapi.json", id: "getOpenAPI", summary: "This document",
+		contentType: "application/json"},
+}
+
+var (
+	documentOnce sync.Once
+	documentJSON []byte
+)
+
+// JSON returns the OpenAPI document, generated once.
+func JSON() []byte {
+	documentOnce.Do(func() {
+		data, err := json.MarshalIndent(Document(), "", "  ")
+		if err != nil {
+			panic(err) // Only maps, slices and strings; can't fail
+		}
+		documentJSON = data
+	})
+	return documentJSON
+}
+
+// Document builds the OpenAPI document.
+func Document() map[string]interface{} {
+	b := newSchemaBuilder()
+	errorSchema := b.schemaFor(ErrorResponse{})
+
+	paths := make(map[string]interface{})
+	for _, op := range operations {
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+			},
+		}
+		switch {
+		case op.noContent:
+			responses["204"] = map[string]interface{}{"description": "No content"}
+		case op.response != nil:
+			responses["200"] = map[string]interface{}{
+				"description": "OK",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaFor(op.response)}},
+			}
+		default:
+			responses["200"] = map[string]interface{}{
+				"description": "OK",
+				"content":     map[string]interface{}{op.contentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}},
+			}
+		}
+
+		spec := map[string]interface{}{
+			"operationId": op.id,
+			"summary":     op.summary,
+			"responses":   responses,
+		}
+		if len(op.params) > 0 {
+			var params []interface{}
+			for _, p := range op.params {
+				schema := map[string]interface{}{"type": "string"}
+				if len(p.enum) > 0 {
+					schema["enum"] = p.enum
+				}
+				param := map[string]interface{}{"name": p.name, "in": p.in, "required": p.required, "schema": schema}
+				if p.description != "" {
+					param["description"] = p.description
+				}
+				params = append(params, param)
+			}
+			spec["parameters"] = params
+		}
+		if op.request != nil {
+			spec["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaFor(op.request)}},
+			}
+		}
+		if op.bearerAuth {
+			spec["security"] = []interface{}{map[string]interface{}{"bearerAuth": []interface{}{}}}
+		}
+		if op.requestBody != "" {
+			spec["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{op.requestBody: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}},
+			}
+		}
+
+		item, _ := paths[op.path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[op.path] = item
+		}
+		item[op.method] = spec
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Docklet API",
+			"description": "Discovery of Docker containers and native services for a homelab dashboard.",
+			"version":     Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package openapi
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+	componentPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+	schemaTypes      = map[string]bool{"array": true, "boolean": true, "integer": true, "number": true, "object": true, "string": true}
+	httpMethods      = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+	paramLocations   = map[string]bool{"query": true, "header": true, "path": true, "cookie": true}
+)
+
+// TestDocumentWellFormed checks the generated document against the structural rules of
+// OpenAPI 3.0 that the generator could break: required fields, path templates matching
+// their path parameters, unique operation IDs, resolvable $refs and valid schema types.
+func TestDocumentWellFormed(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(JSON(), &doc); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.0.") {
+		t.Errorf("openapi = %q, want 3.0.x", v)
+	}
+	info, _ := doc["info"].(map[string]interface{})
+	if info["title"] == "" || info["title"] == nil {
+		t.Error("info.title is missing")
+	}
+	if info["version"] != Version {
+		t.Errorf("info.version = %v, want %s", info["version"], Version)
+	}
+
+	components, _ := doc["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+	for name, schema := range schemas {
+		if !componentPattern.MatchString(name) {
+			t.Errorf("component name %q is not allowed", name)
+		}
+		checkSchema(t, "#/components/schemas/"+name, schema, schemas)
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	if len(paths) == 0 {
+		t.Fatal("document has no paths")
+	}
+	ids := make(map[string]string)
+	for path, item := range paths {
+		if !strings.HasPrefix(path, "/") {
+			t.Errorf("path %q doesn't start with /", path)
+		}
+		templated := make(map[string]bool)
+		for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+			templated[m[1]] = true
+		}
+
+		for method, raw := range item.(map[string]interface{}) {
+			where := strings.ToUpper(method) + " " + path
+			if !httpMethods[method] {
+				t.Errorf("%s: %q is not an HTTP method", where, method)
+				continue
+			}
+			op := raw.(map[string]interface{})
+
+			id, _ := op["operationId"].(string)
+			if id == "" {
+				t.Errorf("%s: operationId is missing", where)
+			} else if other, ok := ids[id]; ok {
+				t.Errorf("%s: operationId %q is also used by %s", where, id, other)
+			}
+			ids[id] = where
+
+			declared := make(map[string]bool)
+			params, _ := op["parameters"].([]interface{})
+			for _, raw := range params {
+				p := raw.(map[string]interface{})
+				name, _ := p["name"].(string)
+				in, _ := p["in"].(string)
+				if name == "" || !paramLocations[in] {
+					t.Errorf("%s: parameter %v needs a name and a valid location", where, p)
+				}
+				if in == "path" {
+					declared[name] = true
+					if p["required"] != true {
+						t.Errorf("%s: path parameter %q must be required", where, name)
+					}
+					if !templated[name] {
+						t.Errorf("%s: path parameter %q is not in the path", where, name)
+					}
+				}
+				checkSchema(t, where+" parameter "+name, p["schema"], schemas)
+			}
+			for name := range templated {
+				if !declared[name] {
+					t.Errorf("%s: path parameter %q is not declared", where, name)
+				}
+			}
+
+			responses, _ := op["responses"].(map[string]interface{})
+			if len(responses) == 0 {
+				t.Errorf("%s: no responses", where)
+			}
+			for code, raw := range responses {
+				r := raw.(map[string]interface{})
+				if d, _ := r["description"].(string); d == "" {
+					t.Errorf("%s: response %s has no description", where, code)
+				}
+				content, _ := r["content"].(map[string]interface{})
+				for contentType, media := range content {
+					checkSchema(t, where+" "+code+" "+contentType, media.(map[string]interface{})["schema"], schemas)
+				}
+			}
+			if body, ok := op["requestBody"].(map[string]interface{}); ok {
+				if content, _ := body["content"].(map[string]interface{}); len(content) == 0 {
+					t.Errorf("%s: requestBody has no content", where)
+				}
+			}
+		}
+	}
+}
+
+// checkSchema checks a schema object and the schemas nested in it.
+func checkSchema(t *testing.T, where string, raw interface{}, schemas map[string]interface{}) {
+	t.Helper()
+	schema, ok := raw.(map[string]interface{})
+	if !ok {
+		t.Errorf("%s: schema is a %T, not an object", where, raw)
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		name, found := strings.CutPrefix(ref, "#/components/schemas/")
+		if !found || schemas[name] == nil {
+			t.Errorf("%s: $ref %q doesn't resolve", where, ref)
+		}
+		return
+	}
+	if typ, ok := schema["type"]; ok && !schemaTypes[typ.(string)] {
+		t.Errorf("%s: invalid type %v", where, typ)
+	}
+	if schema["type"] == "array" {
+		checkSchema(t, where+"[]", schema["items"], schemas)
+	}
+	if additional, ok := schema["additionalProperties"]; ok {
+		checkSchema(t, where+"{}", additional, schemas)
+	}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		for name, property := range properties {
+			checkSchema(t, where+"."+name, property, schemas)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := properties[name.(string)]; !ok {
+				t.Errorf("%s: required property %v is not defined", where, name)
+			}
+		}
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			checkSchema(t, where, sub, schemas)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// schemaBuilder turns Go types into JSON schemas, collecting named structs as components
+// so the document is generated from the same types the handlers serialize.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{components: make(map[string]interface{})}
+}
+
+// schemaFor returns the schema for v's type, or a $ref to a component for named structs.
+func (b *schemaBuilder) schemaFor(v interface{}) map[string]interface{} {
+	return b.schema(reflect.TypeOf(v))
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "Duration in nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = nil // Reserve the name first so recursive types terminate
+			b.components[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema describes a struct using its json tags. Fields without omitempty are required.
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := b.schema(field.Type)
+		if field.Type.Kind() == reflect.Pointer {
+			schema = map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		properties[name] = schema
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// componentName prefixes the type name with its package, e.g. CatalogEntry, so types with
+// the same name in different packages don't collide.
+func componentName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	switch pkg {
+	case "docker_scanner":
+		pkg = "Docker"
+	case "system_scanner":
+		pkg = "System"
+	case "portforward":
+		pkg = "PortForward"
+	case "portaudit":
+		pkg = "PortAudit"
+	case "mdns":
+		pkg = "MDNS"
+	case "dnsrecords":
+		pkg = "DNS"
+	case "certcheck":
+		pkg = "Certificate"
+	case "openapi":
+		return t.Name()
+	default:
+		pkg = strings.ToUpper(pkg[:1]) + pkg[1:]
+	}
+	if strings.HasPrefix(t.Name(), pkg) || strings.HasPrefix(pkg, t.Name()) {
+		return t.Name() // SystemServiceInfo, links.Link
+	}
+	return pkg + t.Name()
+}
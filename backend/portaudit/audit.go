@@ -0,0 +1,450 @@
+// Package portaudit lists the host ports in use, by published container ports and native
+// listeners, and warns about ports claimed twice, databases and other sensitive services
+// reachable from other hosts, and containers publishing ports nobody has vouched for.
+package portaudit
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/portforward"
+	systemscanner "docklet/system_scanner"
+)
+
+// SensitiveLabel set to "true" on a container marks its ports as ones other hosts must not
+// reach; "false" clears a guess made from its image or ports.
+const SensitiveLabel = "docklet.sensitive"
+
+// DefaultSensitivePorts are ports of databases, caches, search engines, etcd and the Docker
+// API, which rarely have to be reachable from other hosts.
+const DefaultSensitivePorts = "1433,1521,2375-2376,2379-2380,3306,5432,5984,6379,8086,9042,9200,9300,11211,27017-27019"
+
+// Scopes of a bind address.
+const (
+	ScopeAll      = "all"      // Every interface: 0.0.0.0 or ::
+	ScopeLoopback = "loopback" // This host only
+	ScopeAddress  = "address"  // One specific address, e.g. a LAN IP
+)
+
+// Sources of a port.
+const (
+	SourceDocker = "docker" // Published by Docker
+	SourceSocket = "socket" // A listening socket found on the host
+)
+
+// Owner kinds.
+const (
+	OwnerContainer = "container"
+	OwnerProcess   = "process"
+	OwnerUnknown   = "unknown" // Usually for lack of permission to inspect other users' processes
+)
+
+// Warning codes.
+const (
+	WarningConflict         = "conflict"          // Several owners listen on overlapping addresses
+	WarningSensitiveExposed = "sensitive_exposed" // A sensitive service listens on all interfaces
+	WarningUnlabelled       = "unlabelled"        // A container publishes ports without a docklet.expose label
+)
+
+// Warning severities.
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Port is one bound host port.
+type Port struct {
+	Protocol      string   `json:"protocol"` // "tcp" or "udp"
+	Address       string   `json:"address"`  // Bind address; 0.0.0.0 or :: for all interfaces
+	Port          int      `json:"port"`
+	Scope         string   `json:"scope"`
+	Source        string   `json:"source"`
+	Owner         Owner    `json:"owner"`
+	ContainerPort int      `json:"container_port,omitempty"` // For ports published by Docker
+	Sensitive     bool     `json:"sensitive"`
+	Warnings      []string `json:"warnings,omitempty"` // Codes of the warnings about this port
+}
+
+// Owner is the container or process a port belongs to.
+type Owner struct {
+	Kind        string `json:"kind"`
+	Name        string `json:"name"` // Container name, systemd unit or process name
+	ContainerID string `json:"container_id,omitempty"`
+	Image       string `json:"image,omitempty"`
+	PID         int    `json:"pid,omitempty"`
+	Process     string `json:"process,omitempty"`
+}
+
+// Warning is a problem found in the ports.
+type Warning struct {
+	Code     string   `json:"code"`
+	Severity string   `json:"severity"`
+	Owners   []string `json:"owners"`
+	Ports    []string `json:"ports"` // As "5432/tcp"
+	Message  string   `json:"message"`
+}
+
+// Report is the result of an audit.
+type Report struct {
+	Ports    []Port    `json:"ports"`
+	Warnings []Warning `json:"warnings"`
+	// Unavailable lists the sources that failed; their ports are missing from the report
+	Unavailable []catalog.Warning `json:"unavailable"`
+}
+
+// databaseImages are image names, without registry, namespace or tag, of databases and
+// similar services. Names match exactly or followed by a dash, as in timescaledb-ha.
+var databaseImages = []string{
+	"postgres", "postgresql", "postgis", "timescaledb", "mysql", "mariadb", "percona", "mongo", "mongodb",
+	"redis", "redis-stack", "valkey", "keydb", "memcached", "elasticsearch", "opensearch", "influxdb",
+	"couchdb", "cassandra", "scylla", "clickhouse", "neo4j", "etcd", "mssql", "cockroach",
+}
+
+// databaseProcesses are executable names of the same services.
+var databaseProcesses = map[string]bool{
+	"postgres": true, "postmaster": true, "mysqld": true, "mariadbd": true, "mongod": true, "mongos": true,
+	"redis-server": true, "valkey-server": true, "keydb-server": true, "memcached": true, "influxd": true,
+	"clickhouse-server": true, "etcd": true, "dockerd": true,
+}
+
+// owned is a port while the report is assembled, with what's needed to judge it.
+type owned struct {
+	Port
+	key     string                     // Identifies the owner, to tell conflicts from one owner's sockets
+	service *dockerscanner.ServiceInfo // For containers Docker knows about
+	reason  string                     // Why the port is sensitive
+}
+
+// Audit merges Docker's published ports with the host's listening sockets and judges them.
+// services are all running containers, as ListContainers returns them, so databases and
+// other services without a web page are judged too. A socket of docker-proxy is the
+// published port it serves, and a socket in a container's cgroup, as with host networking,
+// belongs to that container. sensitive are the port ranges considered sensitive whatever
+// listens on them.
+func Audit(services []dockerscanner.ServiceInfo, listeners []systemscanner.Listener, sensitive portforward.PortRanges) *Report {
+	byID := make(map[string]*dockerscanner.ServiceInfo)
+	byIP := make(map[string]*dockerscanner.ServiceInfo)
+	for i := range services {
+		byID[services[i].ID] = &services[i]
+		for _, ip := range services[i].NetworkIPs {
+			byIP[ip] = &services[i]
+		}
+	}
+
+	var ports []*owned
+	index := make(map[string]*owned) // By protocol, address and port
+	add := func(p *owned) {
+		p.Scope = scope(p.Address)
+		p.Sensitive, p.reason = isSensitive(p, sensitive)
+		ports = append(ports, p)
+		index[socketKey(p.Protocol, p.Address, p.Port.Port)] = p
+	}
+
+	for i := range services {
+		service := &services[i]
+		for _, binding := range service.PortBindings {
+			if binding.PublicPort == 0 {
+				continue
+			}
+			protocol, address := binding.Type, binding.IP
+			if protocol == "" {
+				protocol = "tcp"
+			}
+			if address == "" {
+				address = "0.0.0.0"
+			}
+			if index[socketKey(protocol, address, int(binding.PublicPort))] != nil {
+				continue
+			}
+			add(&owned{
+				Port: Port{
+					Protocol:      protocol,
+					Address:       address,
+					Port:          int(binding.PublicPort),
+					Source:        SourceDocker,
+					Owner:         containerOwner(service),
+					ContainerPort: int(binding.PrivatePort),
+				},
+				key:     "container:" + service.ID,
+				service: service,
+			})
+		}
+	}
+
+	for _, listener := range listeners {
+		if p := index[socketKey(listener.Protocol, listener.Address, listener.Port)]; p != nil {
+			// docker-proxy serving a published port
+			if p.Owner.PID == 0 {
+				p.Owner.PID, p.Owner.Process = listener.PID, listener.Process
+			}
+			continue
+		}
+		p := &owned{Port: Port{Protocol: listener.Protocol, Address: listener.Address, Port: listener.Port, Source: SourceSocket}}
+		switch ref := listener.Container; {
+		case ref != nil:
+			service := byID[ref.ID]
+			if service == nil && ref.IP != "" {
+				service = byIP[ref.IP]
+			}
+			if service != nil {
+				p.Owner, p.key, p.service = containerOwner(service), "container:"+service.ID, service
+			} else {
+				name := shortID(ref.ID)
+				if name == "" {
+					name = "container at " + ref.IP // docker-proxy only knows the container's address
+				}
+				p.Owner = Owner{Kind: OwnerContainer, Name: name, ContainerID: ref.ID}
+				p.key = "container:" + ref.ID + ref.IP
+			}
+			if ref.Port != "" {
+				p.ContainerPort, _ = strconv.Atoi(ref.Port)
+			}
+		case listener.PID != 0:
+			name := listener.Unit
+			if name == "" {
+				name = listener.Process
+			}
+			p.Owner = Owner{Kind: OwnerProcess, Name: name}
+			p.key = "process:" + name
+			if listener.Unit == "" {
+				p.key += ":" + strconv.Itoa(listener.PID)
+			}
+		default:
+			p.Owner = Owner{Kind: OwnerUnknown, Name: "unknown"}
+			p.key = "unknown"
+		}
+		p.Owner.PID, p.Owner.Process = listener.PID, listener.Process
+		add(p)
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		a, b := ports[i], ports[j]
+		if a.Port.Port != b.Port.Port {
+			return a.Port.Port < b.Port.Port
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Address < b.Address
+	})
+
+	report := &Report{Ports: make([]Port, 0, len(ports)), Unavailable: []catalog.Warning{}}
+	report.Warnings = append(report.Warnings, conflicts(ports)...)
+	report.Warnings = append(report.Warnings, exposures(ports)...)
+	if report.Warnings == nil {
+		report.Warnings = []Warning{}
+	}
+	sort.SliceStable(report.Warnings, func(i, j int) bool {
+		return report.Warnings[i].Severity == SeverityCritical && report.Warnings[j].Severity != SeverityCritical
+	})
+	for _, p := range ports {
+		report.Ports = append(report.Ports, p.Port)
+	}
+	return report
+}
+
+// conflicts warns about ports several owners listen on with overlapping addresses. Docker
+// can publish a port that a native service already listens on, and then quietly takes its
+// traffic.
+func conflicts(ports []*owned) []Warning {
+	var warnings []Warning
+	for start := 0; start < len(ports); {
+		end := start + 1
+		for end < len(ports) && ports[end].Port.Port == ports[start].Port.Port && ports[end].Protocol == ports[start].Protocol {
+			end++
+		}
+		group := ports[start:end]
+		start = end
+
+		clashing := make(map[*owned]bool)
+		for i, a := range group {
+			for _, b := range group[i+1:] {
+				if a.key != b.key && overlaps(a.Address, b.Address) {
+					clashing[a], clashing[b] = true, true
+				}
+			}
+		}
+		if len(clashing) == 0 {
+			continue
+		}
+		var owners, uses []string
+		seen := make(map[string]bool)
+		for _, p := range group {
+			if !clashing[p] {
+				continue
+			}
+			p.Warnings = append(p.Warnings, WarningConflict)
+			uses = append(uses, fmt.Sprintf("%s on %s", p.Owner.Name, p.Address))
+			if !seen[p.key] {
+				seen[p.key] = true
+				owners = append(owners, p.Owner.Name)
+			}
+		}
+		port := portName(group[0])
+		warnings = append(warnings, Warning{
+			Code:     WarningConflict,
+			Severity: SeverityWarning,
+			Owners:   owners,
+			Ports:    []string{port},
+			Message:  fmt.Sprintf("%s is claimed by %s; only one of them gets the traffic", port, strings.Join(uses, " and ")),
+		})
+	}
+	return warnings
+}
+
+// exposures warns, per owner, about sensitive services listening on all interfaces, and
+// about containers reachable from other hosts without a docklet.expose label saying
+// that's intended.
+func exposures(ports []*owned) []Warning {
+	type finding struct {
+		owner     string
+		reason    string
+		sensitive bool
+		container bool // Can be labelled
+		ports     []string
+	}
+	var order []string
+	findings := make(map[string]*finding)
+	note := func(code string, p *owned) {
+		k := code + "\x00" + p.key
+		f := findings[k]
+		if f == nil {
+			f = &finding{owner: p.Owner.Name, reason: p.reason, sensitive: p.Sensitive, container: p.service != nil}
+			findings[k] = f
+			order = append(order, k)
+		}
+		p.Warnings = append(p.Warnings, code)
+		if name := portName(p); len(f.ports) == 0 || f.ports[len(f.ports)-1] != name {
+			f.ports = append(f.ports, name) // 0.0.0.0 and :: sort next to each other
+		}
+	}
+
+	for _, p := range ports {
+		switch {
+		case p.Sensitive && p.Scope == ScopeAll:
+			note(WarningSensitiveExposed, p)
+		case p.service != nil && p.Scope != ScopeLoopback && p.service.RawLabels[portforward.ExposeLabel] == "":
+			note(WarningUnlabelled, p)
+		}
+	}
+
+	var warnings []Warning
+	for _, k := range order {
+		f := findings[k]
+		code, _, _ := strings.Cut(k, "\x00")
+		list := strings.Join(f.ports, ", ")
+		w := Warning{Code: code, Severity: SeverityWarning, Owners: []string{f.owner}, Ports: f.ports}
+		switch {
+		case code == WarningSensitiveExposed && f.container:
+			w.Severity = SeverityCritical
+			w.Message = fmt.Sprintf("%s %s and listens on %s on all interfaces; publish it on 127.0.0.1 or a private address, or label it %s=false if other hosts need it",
+				f.owner, f.reason, list, SensitiveLabel)
+		case code == WarningSensitiveExposed:
+			w.Severity = SeverityCritical
+			w.Message = fmt.Sprintf("%s %s and listens on %s on all interfaces; configure it to listen on 127.0.0.1 or a private address, or firewall the port",
+				f.owner, f.reason, list)
+		case f.sensitive:
+			w.Severity = SeverityCritical
+			w.Message = fmt.Sprintf("%s %s and publishes %s to other hosts without a %s label; label it %s=lan if that's intended, or publish on 127.0.0.1",
+				f.owner, f.reason, list, portforward.ExposeLabel, portforward.ExposeLabel)
+		default:
+			w.Message = fmt.Sprintf("%s publishes %s to other hosts but has no %s label; label it %s=lan (or wan) if that's intended, or publish on 127.0.0.1",
+				f.owner, list, portforward.ExposeLabel, portforward.ExposeLabel)
+		}
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+// isSensitive decides whether a port should be kept from other hosts, and why.
+func isSensitive(p *owned, sensitive portforward.PortRanges) (bool, string) {
+	if p.service != nil {
+		switch p.service.RawLabels[SensitiveLabel] {
+		case "true":
+			return true, "is labelled " + SensitiveLabel + "=true"
+		case "false":
+			return false, ""
+		}
+		if name := databaseImage(p.service.ImageName); name != "" {
+			return true, "runs " + name
+		}
+	}
+	if databaseProcesses[p.Owner.Process] {
+		return true, "runs " + p.Owner.Process
+	}
+	if sensitive.Contains(p.Port.Port) || (p.ContainerPort != 0 && sensitive.Contains(p.ContainerPort)) {
+		return true, "uses a database or admin port"
+	}
+	return false, ""
+}
+
+// databaseImage returns the database an image name is for, or "".
+func databaseImage(image string) string {
+	name := image
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.ToLower(name)
+	for _, db := range databaseImages {
+		if name == db || strings.HasPrefix(name, db+"-") {
+			return db
+		}
+	}
+	return ""
+}
+
+func containerOwner(service *dockerscanner.ServiceInfo) Owner {
+	return Owner{Kind: OwnerContainer, Name: service.ContainerName, ContainerID: service.ID, Image: service.ImageName}
+}
+
+// scope classifies a bind address.
+func scope(address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil || ip.IsUnspecified():
+		return ScopeAll
+	case ip.IsLoopback():
+		return ScopeLoopback
+	}
+	return ScopeAddress
+}
+
+// overlaps reports whether sockets bound to a and b can receive the same traffic. :: also
+// takes IPv4 traffic on dual-stack hosts.
+func overlaps(a, b string) bool {
+	covers := func(wildcard, other string) bool {
+		ip := net.ParseIP(wildcard)
+		if ip == nil || !ip.IsUnspecified() {
+			return false
+		}
+		if ip.To4() == nil {
+			return true
+		}
+		otherIP := net.ParseIP(other)
+		return otherIP != nil && otherIP.To4() != nil
+	}
+	return a == b || covers(a, b) || covers(b, a)
+}
+
+func socketKey(protocol, address string, port int) string {
+	return protocol + " " + net.JoinHostPort(address, strconv.Itoa(port))
+}
+
+func portName(p *owned) string {
+	return strconv.Itoa(p.Port.Port) + "/" + p.Protocol
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,190 @@
+package portaudit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	dockerscanner "docklet/docker_scanner"
+	"docklet/portforward"
+	systemscanner "docklet/system_scanner"
+)
+
+func published(id, name, image string, labels map[string]string, bindings ...dockerscanner.PortBinding) dockerscanner.ServiceInfo {
+	return dockerscanner.ServiceInfo{
+		ID:            id,
+		Name:          name,
+		ContainerName: name,
+		ImageName:     image,
+		RawLabels:     labels,
+		PortBindings:  bindings,
+		NetworkIPs:    map[string]string{"bridge": "172.17.0." + strings.TrimPrefix(id, "c")},
+	}
+}
+
+func binding(ip string, public, private uint16) dockerscanner.PortBinding {
+	return dockerscanner.PortBinding{IP: ip, PublicPort: public, PrivatePort: private, Type: "tcp"}
+}
+
+func process(address string, port, pid int, name, unit string) systemscanner.Listener {
+	return systemscanner.Listener{Protocol: "tcp", Address: address, Port: port, PID: pid, Process: name, Unit: unit}
+}
+
+var lan = map[string]string{portforward.ExposeLabel: "lan"}
+
+func TestAudit(t *testing.T) {
+	sensitive, err := portforward.ParsePortRanges(DefaultSensitivePorts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		services  []dockerscanner.ServiceInfo
+		listeners []systemscanner.Listener
+		ports     []string
+		warnings  []string
+	}{
+		{
+			name:      "published port over a native service",
+			services:  []dockerscanner.ServiceInfo{published("c2", "web", "nginx", lan, binding("0.0.0.0", 80, 80))},
+			listeners: []systemscanner.Listener{process("192.168.1.10", 80, 100, "nginx", "nginx.service")},
+			ports: []string{
+				"tcp 0.0.0.0:80 docker container web ->80 [conflict]",
+				"tcp 192.168.1.10:80 socket process nginx.service pid=100 [conflict]",
+			},
+			warnings: []string{
+				"conflict warning [web nginx.service] [80/tcp]: 80/tcp is claimed by web on 0.0.0.0 and nginx.service on 192.168.1.10; only one of them gets the traffic",
+			},
+		},
+		{
+			name: "one owner on both stacks, or on separate addresses",
+			services: []dockerscanner.ServiceInfo{
+				published("c2", "web", "nginx", lan, binding("0.0.0.0", 8080, 80), binding("::", 8080, 80)),
+				published("c3", "admin", "nginx", nil, binding("127.0.0.1", 8443, 443)),
+			},
+			listeners: []systemscanner.Listener{
+				process("127.0.0.1", 53, 10, "dnsmasq", "dnsmasq.service"),
+				process("192.168.1.10", 53, 20, "unbound", "unbound.service"),
+			},
+			ports: []string{
+				"tcp 127.0.0.1:53 socket process dnsmasq.service pid=10",
+				"tcp 192.168.1.10:53 socket process unbound.service pid=20",
+				"tcp 0.0.0.0:8080 docker container web ->80",
+				"tcp :::8080 docker container web ->80",
+				"tcp 127.0.0.1:8443 docker container admin ->443",
+			},
+		},
+		{
+			name: "unlabelled published ports",
+			services: []dockerscanner.ServiceInfo{
+				published("c2", "mosquitto", "eclipse-mosquitto:2", nil, binding("0.0.0.0", 1883, 1883), binding("::", 1883, 1883), binding("", 0, 9001)),
+				published("c3", "app", "example/app", nil, binding("192.168.1.10", 3000, 3000)),
+				published("c4", "local", "example/app", nil, binding("127.0.0.1", 3001, 3000)),
+			},
+			ports: []string{
+				"tcp 0.0.0.0:1883 docker container mosquitto ->1883 [unlabelled]",
+				"tcp :::1883 docker container mosquitto ->1883 [unlabelled]",
+				"tcp 192.168.1.10:3000 docker container app ->3000 [unlabelled]",
+				"tcp 127.0.0.1:3001 docker container local ->3000",
+			},
+			warnings: []string{
+				"unlabelled warning [mosquitto] [1883/tcp]: mosquitto publishes 1883/tcp to other hosts but has no docklet.expose label; label it docklet.expose=lan (or wan) if that's intended, or publish on 127.0.0.1",
+				"unlabelled warning [app] [3000/tcp]: app publishes 3000/tcp to other hosts but has no docklet.expose label; label it docklet.expose=lan (or wan) if that's intended, or publish on 127.0.0.1",
+			},
+		},
+		{
+			name: "sensitive exposures",
+			services: []dockerscanner.ServiceInfo{
+				published("c2", "db", "docker.io/library/postgres:16", lan, binding("0.0.0.0", 5433, 5432)),
+				published("c3", "mysql", "mariadb:11", nil, binding("192.168.1.10", 3306, 3306)),
+				published("c4", "mongo", "mongo", map[string]string{SensitiveLabel: "false", portforward.ExposeLabel: "lan"}, binding("0.0.0.0", 27017, 27017)),
+				published("c5", "secrets", "example/vault", map[string]string{SensitiveLabel: "true"}, binding("127.0.0.1", 8200, 8200), binding("0.0.0.0", 8201, 8201)),
+			},
+			listeners: []systemscanner.Listener{
+				process("0.0.0.0", 6379, 30, "redis-server", "redis.service"),
+				process("::", 9200, 40, "java", "elasticsearch.service"),
+				process("127.0.0.1", 11211, 50, "memcached", "memcached.service"),
+			},
+			ports: []string{
+				"tcp 192.168.1.10:3306 docker container mysql ->3306 sensitive [unlabelled]",
+				"tcp 0.0.0.0:5433 docker container db ->5432 sensitive [sensitive_exposed]",
+				"tcp 0.0.0.0:6379 socket process redis.service pid=30 sensitive [sensitive_exposed]",
+				"tcp 127.0.0.1:8200 docker container secrets ->8200 sensitive",
+				"tcp 0.0.0.0:8201 docker container secrets ->8201 sensitive [sensitive_exposed]",
+				"tcp :::9200 socket process elasticsearch.service pid=40 sensitive [sensitive_exposed]",
+				"tcp 127.0.0.1:11211 socket process memcached.service pid=50 sensitive",
+				"tcp 0.0.0.0:27017 docker container mongo ->27017",
+			},
+			warnings: []string{
+				"unlabelled critical [mysql] [3306/tcp]: mysql runs mariadb and publishes 3306/tcp to other hosts without a docklet.expose label; label it docklet.expose=lan if that's intended, or publish on 127.0.0.1",
+				"sensitive_exposed critical [db] [5433/tcp]: db runs postgres and listens on 5433/tcp on all interfaces; publish it on 127.0.0.1 or a private address, or label it docklet.sensitive=false if other hosts need it",
+				"sensitive_exposed critical [redis.service] [6379/tcp]: redis.service runs redis-server and listens on 6379/tcp on all interfaces; configure it to listen on 127.0.0.1 or a private address, or firewall the port",
+				"sensitive_exposed critical [secrets] [8201/tcp]: secrets is labelled docklet.sensitive=true and listens on 8201/tcp on all interfaces; publish it on 127.0.0.1 or a private address, or label it docklet.sensitive=false if other hosts need it",
+				"sensitive_exposed critical [elasticsearch.service] [9200/tcp]: elasticsearch.service uses a database or admin port and listens on 9200/tcp on all interfaces; configure it to listen on 127.0.0.1 or a private address, or firewall the port",
+			},
+		},
+		{
+			name: "sockets of containers",
+			services: []dockerscanner.ServiceInfo{
+				published("c2", "web", "nginx", lan, binding("0.0.0.0", 8080, 80)),
+				published("c3", "hass", "homeassistant/home-assistant", nil),
+				published("c4", "mqtt", "eclipse-mosquitto", nil),
+			},
+			listeners: []systemscanner.Listener{
+				// docker-proxy serving the published port
+				process("0.0.0.0", 8080, 60, "docker-proxy", ""),
+				// Host networking, found by cgroup
+				{Protocol: "tcp", Address: "0.0.0.0", Port: 8123, PID: 70, Process: "python3", Container: &systemscanner.ContainerRef{ID: "c3", Via: "cgroup"}},
+				// docker-proxy of a container known by its address only
+				{Protocol: "tcp", Address: "0.0.0.0", Port: 1883, PID: 80, Process: "docker-proxy", Container: &systemscanner.ContainerRef{IP: "172.17.0.4", Port: "1883", Via: "docker-proxy"}},
+				{Protocol: "tcp", Address: "0.0.0.0", Port: 1884, PID: 81, Process: "docker-proxy", Container: &systemscanner.ContainerRef{IP: "172.17.0.9", Port: "1883", Via: "docker-proxy"}},
+				{Protocol: "udp", Address: "0.0.0.0", Port: 5353},
+			},
+			ports: []string{
+				"tcp 0.0.0.0:1883 socket container mqtt pid=80 ->1883 [unlabelled]",
+				"tcp 0.0.0.0:1884 socket container container at 172.17.0.9 pid=81 ->1883",
+				"udp 0.0.0.0:5353 socket unknown unknown",
+				"tcp 0.0.0.0:8080 docker container web pid=60 ->80",
+				"tcp 0.0.0.0:8123 socket container hass pid=70 [unlabelled]",
+			},
+			warnings: []string{
+				"unlabelled warning [mqtt] [1883/tcp]: mqtt publishes 1883/tcp to other hosts but has no docklet.expose label; label it docklet.expose=lan (or wan) if that's intended, or publish on 127.0.0.1",
+				"unlabelled warning [hass] [8123/tcp]: hass publishes 8123/tcp to other hosts but has no docklet.expose label; label it docklet.expose=lan (or wan) if that's intended, or publish on 127.0.0.1",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Audit(tt.services, tt.listeners, sensitive)
+
+			var ports []string
+			for _, p := range report.Ports {
+				line := fmt.Sprintf("%s %s:%d %s %s %s", p.Protocol, p.Address, p.Port, p.Source, p.Owner.Kind, p.Owner.Name)
+				if p.Owner.PID != 0 {
+					line += fmt.Sprintf(" pid=%d", p.Owner.PID)
+				}
+				if p.ContainerPort != 0 {
+					line += fmt.Sprintf(" ->%d", p.ContainerPort)
+				}
+				if p.Sensitive {
+					line += " sensitive"
+				}
+				if len(p.Warnings) > 0 {
+					line += fmt.Sprintf(" %v", p.Warnings)
+				}
+				ports = append(ports, line)
+			}
+			if got, want := strings.Join(ports, "\n"), strings.Join(tt.ports, "\n"); got != want {
+				t.Errorf("ports:\n%s\nwant\n%s", got, want)
+			}
+
+			var warnings []string
+			for _, w := range report.Warnings {
+				warnings = append(warnings, fmt.Sprintf("%s %s %v %v: %s", w.Code, w.Severity, w.Owners, w.Ports, w.Message))
+			}
+			if got, want := strings.Join(warnings, "\n"), strings.Join(tt.warnings, "\n"); got != want {
+				t.Errorf("warnings:\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
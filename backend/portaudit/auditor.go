@@ -0,0 +1,57 @@
+package portaudit
+
+import (
+	"context"
+	"fmt"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+	"docklet/portforward"
+	systemscanner "docklet/system_scanner"
+)
+
+// Auditor audits the ports of the services a Collector finds.
+type Auditor struct {
+	Collector      *catalog.Collector
+	SensitivePorts portforward.PortRanges
+}
+
+// NewAuditor creates an Auditor configured from environment variables:
+//
+//	DOCKLET_PORTS_SENSITIVE  port ranges that must not be reachable from other hosts,
+//	                         whatever listens on them (default DefaultSensitivePorts; "none" for no ports)
+func NewAuditor(collector *catalog.Collector) (*Auditor, error) {
+	spec := dockerscanner.GetEnvOrDefault("DOCKLET_PORTS_SENSITIVE", DefaultSensitivePorts)
+	if spec == "none" {
+		spec = ""
+	}
+	sensitive, err := portforward.ParsePortRanges(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_PORTS_SENSITIVE: %w", err)
+	}
+	return &Auditor{Collector: collector, SensitivePorts: sensitive}, nil
+}
+
+// Report lists all running containers and sockets concurrently and audits them. A source that fails
+// is reported in Unavailable and the other is still audited.
+func (a *Auditor) Report(ctx context.Context) *Report {
+	var listeners []systemscanner.Listener
+	var listenErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		listeners, listenErr = a.Collector.Listeners(ctx)
+	}()
+
+	containers, dockerErr := a.Collector.DockerContainers(ctx)
+	<-done
+
+	report := Audit(containers, listeners, a.SensitivePorts)
+	if dockerErr != nil {
+		report.Unavailable = append(report.Unavailable, catalog.NewWarning(catalog.SourceDocker, dockerErr))
+	}
+	if listenErr != nil {
+		report.Unavailable = append(report.Unavailable, catalog.NewWarning(catalog.SourceSystem, listenErr))
+	}
+	return report
+}
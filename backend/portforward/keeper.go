@@ -0,0 +1,233 @@
+package portforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+)
+
+// Mapper creates port mappings on a gateway that leases them, such as a UPnP IGD or a
+// NAT-PMP/PCP router.
+type Mapper interface {
+	Name() string
+	// Map creates or renews the mapping for rule, asking for lifetime, and returns the
+	// external port and lifetime the gateway granted. A lifetime of 0 means permanent.
+	Map(ctx context.Context, rule Rule, lifetime time.Duration) (int, time.Duration, error)
+	Unmap(ctx context.Context, rule Rule) error
+	// LocalIP returns this host's address on the interface facing the gateway.
+	LocalIP(ctx context.Context) (string, error)
+}
+
+// Lease is a mapping the Keeper maintains.
+type Lease struct {
+	Rule         Rule      `json:"rule"`
+	ExternalPort int       `json:"external_port,omitempty"` // May differ from Rule.SrcPort with NAT-PMP/PCP
+	Expires      time.Time `json:"expires,omitempty"`       // Zero for permanent mappings
+	Error        string    `json:"error,omitempty"`         // Why the last attempt to map it failed
+	granted      time.Duration
+}
+
+// Keeper keeps the mappings the policy asks for on a gateway: it maps ports of newly
+// exposed services, renews leases halfway through their lifetime and removes mappings of
+// services that went away. Mappings are removed when Run returns.
+type Keeper struct {
+	Policy    Policy
+	Mapper    Mapper
+	Lifetime  time.Duration // Lease asked for
+	Interval  time.Duration // Time between checks for started and stopped services
+	collector *catalog.Collector
+
+	mu     sync.Mutex        // Guards leases against readers; only Run changes them
+	leases map[string]*Lease // Keyed by rule name
+}
+
+// NewKeeper creates a Keeper for method "upnp" or "natpmp", with the policy from
+// PolicyFromEnv and these environment variables:
+//
+//	DOCKLET_UPNP_URL               IGD root device description URL (default: found with SSDP)
+//	DOCKLET_NATPMP_GATEWAY         NAT-PMP/PCP gateway address (default: the default gateway)
+//	DOCKLET_PORTFORWARD_LEASE      lease lifetime asked for (default 1h)
+//	DOCKLET_PORTFORWARD_INTERVAL   time between checks for started and stopped services (default 1m)
+func NewKeeper(collector *catalog.Collector, method string) (*Keeper, error) {
+	policy, err := PolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	lifetime, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_LEASE", "1h"))
+	if err != nil || lifetime < time.Minute {
+		return nil, fmt.Errorf("invalid DOCKLET_PORTFORWARD_LEASE: must be a duration of at least 1m")
+	}
+	interval, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_INTERVAL", "1m"))
+	if err != nil || interval <= 0 || interval >= lifetime/2 {
+		return nil, fmt.Errorf("invalid DOCKLET_PORTFORWARD_INTERVAL: must be a duration shorter than half of DOCKLET_PORTFORWARD_LEASE")
+	}
+
+	var mapper Mapper
+	switch method {
+	case "upnp":
+		mapper = NewUPnPMapper(dockerscanner.GetEnvOrDefault("DOCKLET_UPNP_URL", ""), 10*time.Second)
+	case "natpmp":
+		if policy.TargetIP != "" {
+			return nil, errors.New("DOCKLET_PORTFORWARD_TARGET_IP can't be used with NAT-PMP, which maps ports to the host asking")
+		}
+		gateway := dockerscanner.GetEnvOrDefault("DOCKLET_NATPMP_GATEWAY", "")
+		if gateway == "" {
+			if gateway, err = defaultGateway(); err != nil {
+				return nil, fmt.Errorf("DOCKLET_NATPMP_GATEWAY not set and no default gateway found: %w", err)
+			}
+		}
+		mapper = NewNATPMPMapper(gateway, 10*time.Second)
+	default:
+		return nil, fmt.Errorf("unknown port-forward method %q", method)
+	}
+
+	return &Keeper{
+		Policy:    policy,
+		Mapper:    mapper,
+		Lifetime:  lifetime,
+		Interval:  interval,
+		collector: collector,
+		leases:    make(map[string]*Lease),
+	}, nil
+}
+
+// Run keeps the mappings up to date every Interval until ctx is cancelled, then removes them.
+func (k *Keeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.Interval)
+	defer ticker.Stop()
+
+	for {
+		k.sync(ctx)
+		select {
+		case <-ctx.Done():
+			// The gateway would drop them when their leases run out, but not permanent ones
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			k.unmapAll(cleanupCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Leases returns the mappings being maintained, sorted by port.
+func (k *Keeper) Leases() []Lease {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	leases := make([]Lease, 0, len(k.leases))
+	for _, lease := range k.leases {
+		leases = append(leases, *lease)
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		if leases[i].Rule.SrcPort != leases[j].Rule.SrcPort {
+			return leases[i].Rule.SrcPort < leases[j].Rule.SrcPort
+		}
+		return leases[i].Rule.Proto < leases[j].Rule.Proto
+	})
+	return leases
+}
+
+// sync maps, renews and removes mappings. Only Run's goroutine changes leases, so it reads
+// them without locking and only locks to swap in the new set.
+func (k *Keeper) sync(ctx context.Context) {
+	targetIP := k.Policy.TargetIP
+	if targetIP == "" {
+		ip, err := k.Mapper.LocalIP(ctx)
+		if err != nil {
+			log.Printf("Port mapping: %v", err)
+			return
+		}
+		targetIP = ip
+	}
+	desired, _, err := desiredRules(ctx, k.collector, k.Policy, targetIP)
+	if ctx.Err() != nil {
+		return // Shutting down
+	}
+	if err != nil {
+		// Keep renewing what we have rather than dropping mappings because Docker hiccupped
+		log.Printf("Port mapping: failed to list services: %v", err)
+		desired = nil
+		for _, lease := range k.leases {
+			desired = append(desired, lease.Rule)
+		}
+	}
+
+	next := make(map[string]*Lease)
+	for _, rule := range desired {
+		lease := k.leases[rule.Name]
+		if lease != nil && lease.Rule != rule && lease.Error == "" {
+			// Same external port, new target: drop the old mapping so the gateway accepts the new one
+			if err := k.Mapper.Unmap(ctx, lease.Rule); err != nil {
+				log.Printf("Port mapping: failed to remove %s: %v", lease.Rule.Name, err)
+			}
+			lease = nil
+		}
+		if lease != nil && lease.Error == "" && (lease.Expires.IsZero() || time.Until(lease.Expires) > lease.granted/2) {
+			next[rule.Name] = lease
+			continue
+		}
+		next[rule.Name] = k.mapRule(ctx, rule, lease == nil || lease.Error != "")
+	}
+
+	for name, lease := range k.leases {
+		if next[name] != nil || lease.Error != "" {
+			continue
+		}
+		if err := k.Mapper.Unmap(ctx, lease.Rule); err != nil {
+			log.Printf("Port mapping: failed to remove %s: %v", name, err)
+			if lease.Expires.IsZero() || time.Now().Before(lease.Expires) {
+				next[name] = lease // Try again next round
+			}
+			continue
+		}
+		log.Printf("Port mapping: removed %s %d via %s", lease.Rule.Proto, lease.ExternalPort, k.Mapper.Name())
+	}
+
+	k.mu.Lock()
+	k.leases = next
+	k.mu.Unlock()
+}
+
+// mapRule maps or renews rule, logging new mappings and failures.
+func (k *Keeper) mapRule(ctx context.Context, rule Rule, isNew bool) *Lease {
+	lease := &Lease{Rule: rule}
+	port, granted, err := k.Mapper.Map(ctx, rule, k.Lifetime)
+	if err != nil {
+		log.Printf("Port mapping: failed to map %s %d for %s via %s: %v", rule.Proto, rule.SrcPort, rule.Service, k.Mapper.Name(), err)
+		lease.Error = err.Error()
+		return lease
+	}
+	lease.ExternalPort = port
+	lease.granted = granted
+	if granted > 0 {
+		lease.Expires = time.Now().Add(granted)
+	}
+	if isNew {
+		log.Printf("Port mapping: mapped %s %d to %s:%d for %s via %s", rule.Proto, port, rule.DestIP, rule.DestPort, rule.Service, k.Mapper.Name())
+	}
+	return lease
+}
+
+func (k *Keeper) unmapAll(ctx context.Context) {
+	for name, lease := range k.leases {
+		if lease.Error != "" {
+			continue
+		}
+		if err := k.Mapper.Unmap(ctx, lease.Rule); err != nil {
+			log.Printf("Port mapping: failed to remove %s: %v", name, err)
+		}
+	}
+	k.mu.Lock()
+	k.leases = make(map[string]*Lease)
+	k.mu.Unlock()
+}
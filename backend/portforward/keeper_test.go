@@ -0,0 +1,239 @@
+package portforward
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"docklet/catalog"
+
+	"github.com/docker/docker/client"
+)
+
+// fakeDocker is a Docker API listing the containers it's given, or failing if down.
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []map[string]any
+	down       bool
+}
+
+// publish adds a running container labelled to have its published port forwarded.
+func (d *fakeDocker) publish(name string, port int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.containers = append(d.containers, map[string]any{
+		"Id":     name,
+		"Names":  []string{"/" + name},
+		"Image":  "nginx",
+		"Labels": map[string]string{ExposeLabel: ExposeWAN},
+		"Ports":  []map[string]any{{"IP": "0.0.0.0", "PrivatePort": 80, "PublicPort": port, "Type": "tcp"}},
+	})
+}
+
+func (d *fakeDocker) remove(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, c := range d.containers {
+		if c["Id"] == name {
+			d.containers = append(d.containers[:i], d.containers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *fakeDocker) setDown(down bool) {
+	d.mu.Lock()
+	d.down = down
+	d.mu.Unlock()
+}
+
+func (d *fakeDocker) collector(t *testing.T) *catalog.Collector {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.down || !strings.HasSuffix(r.URL.Path, "/containers/json") {
+			http.Error(w, `{"message":"unavailable"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(d.containers)
+	}))
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")), client.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return &catalog.Collector{Docker: cli, DockerTimeout: 5 * time.Second, SystemTimeout: 5 * time.Second}
+}
+
+// fakeMapper grants the lifetime asked for, or a fixed one, and records its calls.
+type fakeMapper struct {
+	grant     time.Duration // Lifetime granted, if not the one asked for
+	permanent bool          // Grant permanent mappings, like gateways answering UPnP error 725
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (m *fakeMapper) Name() string { return "fake" }
+
+func (m *fakeMapper) Map(ctx context.Context, rule Rule, lifetime time.Duration) (int, time.Duration, error) {
+	m.record(fmt.Sprintf("map %s %s:%d", rule.Name, rule.DestIP, rule.DestPort))
+	if m.grant != 0 {
+		lifetime = m.grant
+	}
+	if m.permanent {
+		lifetime = 0
+	}
+	return rule.SrcPort, lifetime, nil
+}
+
+func (m *fakeMapper) Unmap(ctx context.Context, rule Rule) error {
+	m.record("unmap " + rule.Name)
+	return nil
+}
+
+func (m *fakeMapper) LocalIP(context.Context) (string, error) { return "192.168.1.10", nil }
+
+func (m *fakeMapper) record(call string) {
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	m.mu.Unlock()
+}
+
+// take returns the calls made since it was last called.
+func (m *fakeMapper) take() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	calls := m.calls
+	m.calls = nil
+	return calls
+}
+
+func newTestKeeper(t *testing.T, docker *fakeDocker, mapper *fakeMapper) *Keeper {
+	t.Helper()
+	return &Keeper{
+		Policy:    Policy{Protocols: map[string]bool{"tcp": true}},
+		Mapper:    mapper,
+		Lifetime:  time.Hour,
+		Interval:  time.Hour,
+		collector: docker.collector(t),
+		leases:    make(map[string]*Lease),
+	}
+}
+
+func expectCalls(t *testing.T, mapper *fakeMapper, want ...string) {
+	t.Helper()
+	if got := mapper.take(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("mapper calls = %q, want %q", got, want)
+	}
+}
+
+func TestKeeperSync(t *testing.T) {
+	docker := &fakeDocker{}
+	docker.publish("web", 8080)
+	mapper := &fakeMapper{}
+	keeper := newTestKeeper(t, docker, mapper)
+	ctx := context.Background()
+
+	keeper.sync(ctx)
+	expectCalls(t, mapper, "map docklet-tcp-8080 192.168.1.10:8080")
+	leases := keeper.Leases()
+	if len(leases) != 1 || leases[0].ExternalPort != 8080 || time.Until(leases[0].Expires) < 59*time.Minute {
+		t.Fatalf("Leases() = %+v, want 8080 for an hour", leases)
+	}
+
+	// Nothing to do while the lease is fresh
+	keeper.sync(ctx)
+	expectCalls(t, mapper)
+
+	// Services that start are mapped, those that stop unmapped
+	docker.publish("git", 2222)
+	docker.remove("web")
+	keeper.sync(ctx)
+	expectCalls(t, mapper, "map docklet-tcp-2222 192.168.1.10:2222", "unmap docklet-tcp-8080")
+	if leases := keeper.Leases(); len(leases) != 1 || leases[0].Rule.SrcPort != 2222 {
+		t.Errorf("Leases() = %+v, want only 2222", leases)
+	}
+}
+
+func TestKeeperRenewsLeases(t *testing.T) {
+	docker := &fakeDocker{}
+	docker.publish("web", 8080)
+	mapper := &fakeMapper{grant: 10 * time.Minute} // Gateways may grant less than asked for
+	keeper := newTestKeeper(t, docker, mapper)
+	ctx := context.Background()
+
+	keeper.sync(ctx)
+	mapper.take()
+	lease := keeper.leases["docklet-tcp-8080"]
+	if lease.granted != 10*time.Minute {
+		t.Fatalf("granted = %s, want 10m", lease.granted)
+	}
+
+	// Not renewed before half of the granted lifetime is left...
+	lease.Expires = time.Now().Add(6 * time.Minute)
+	keeper.sync(ctx)
+	expectCalls(t, mapper)
+
+	// ...then renewed, without unmapping first
+	lease.Expires = time.Now().Add(4 * time.Minute)
+	keeper.sync(ctx)
+	expectCalls(t, mapper, "map docklet-tcp-8080 192.168.1.10:8080")
+	if renewed := keeper.Leases()[0]; time.Until(renewed.Expires) < 9*time.Minute {
+		t.Errorf("renewed lease expires at %s, want 10m from now", renewed.Expires)
+	}
+
+	// Leases are still renewed while Docker can't be reached
+	docker.setDown(true)
+	keeper.leases["docklet-tcp-8080"].Expires = time.Now().Add(time.Minute)
+	keeper.sync(ctx)
+	expectCalls(t, mapper, "map docklet-tcp-8080 192.168.1.10:8080")
+	if leases := keeper.Leases(); len(leases) != 1 {
+		t.Errorf("Leases() = %+v, want the mapping kept while Docker is down", leases)
+	}
+}
+
+func TestKeeperUnmapsOnShutdown(t *testing.T) {
+	docker := &fakeDocker{}
+	docker.publish("web", 8080)
+	docker.publish("git", 2222)
+	mapper := &fakeMapper{permanent: true} // Only unmapping removes these
+	keeper := newTestKeeper(t, docker, mapper)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		keeper.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(keeper.Leases()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("mappings not made")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mapper.take()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run didn't return after ctx was cancelled")
+	}
+	unmapped := mapper.take()
+	if len(unmapped) != 2 || !strings.Contains(strings.Join(unmapped, " "), "unmap docklet-tcp-8080") || !strings.Contains(strings.Join(unmapped, " "), "unmap docklet-tcp-2222") {
+		t.Errorf("calls after shutdown = %q, want both mappings removed", unmapped)
+	}
+	if leases := keeper.Leases(); len(leases) != 0 {
+		t.Errorf("Leases() = %+v after shutdown, want none", leases)
+	}
+}
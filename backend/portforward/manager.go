@@ -0,0 +1,105 @@
+package portforward
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"docklet/catalog"
+	systemscanner "docklet/system_scanner"
+)
+
+// ErrPlanChanged is returned by Apply when the plan no longer matches the one reviewed.
+var ErrPlanChanged = errors.New("the port-forward plan changed since it was listed")
+
+// Manager plans and applies port forwards for the services a collector discovers.
+type Manager struct {
+	Policy    Policy
+	Router    *Router
+	Token     string // Bearer token the API requires to apply a plan; applying over the API is refused if empty
+	collector *catalog.Collector
+	mu        sync.Mutex // Serializes applies
+}
+
+// NewManager creates a Manager with the policy and router configured from the environment
+// (see PolicyFromEnv and RouterConfigFromEnv), and the API token from
+// DOCKLET_PORTFORWARD_TOKEN.
+func NewManager(collector *catalog.Collector) (*Manager, error) {
+	policy, err := PolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := RouterConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{Policy: policy, Router: NewRouter(cfg), Token: os.Getenv("DOCKLET_PORTFORWARD_TOKEN"), collector: collector}, nil
+}
+
+// Plan discovers services, applies the policy and diffs the result against the router.
+// Nothing on the router is changed.
+func (m *Manager) Plan(ctx context.Context) (*Plan, error) {
+	targetIP := m.Policy.TargetIP
+	if targetIP == "" {
+		ip, err := m.Router.LocalIP()
+		if err != nil {
+			return nil, err
+		}
+		targetIP = ip
+	}
+
+	desired, skipped, err := desiredRules(ctx, m.collector, m.Policy, targetIP)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := m.Router.Redirects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan := Diff(existing, desired)
+	plan.Skipped = skipped
+	src, dest := m.Router.Zones()
+	sum := sha256.Sum256([]byte(strings.Join(plan.Commands(src, dest), "\n")))
+	plan.ID = hex.EncodeToString(sum[:8])
+	return plan, nil
+}
+
+// Apply plans again and applies the result. If planID is set and the new plan differs from
+// the one with that ID, nothing is changed and ErrPlanChanged is returned with the new plan.
+func (m *Manager) Apply(ctx context.Context, planID string) (*Plan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plan, err := m.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if planID != "" && plan.ID != planID {
+		return plan, ErrPlanChanged
+	}
+	if plan.Empty() {
+		return plan, nil
+	}
+	return plan, m.Router.Apply(ctx, plan)
+}
+
+// desiredRules lists services and returns the forwards policy asks for.
+func desiredRules(ctx context.Context, collector *catalog.Collector, policy Policy, targetIP string) ([]Rule, []Skip, error) {
+	docker, err := collector.DockerServices(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var system []systemscanner.SystemServiceInfo
+	if policy.IncludeSystem {
+		if system, err = collector.SystemServices(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	rules, skipped := policy.Rules(docker, system, targetIP)
+	return rules, skipped, nil
+}
@@ -0,0 +1,218 @@
+package portforward
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const natpmpPort = "5351"
+
+// Versions of the protocol spoken on port 5351.
+const (
+	natpmpVersion = 0 // NAT-PMP, RFC 6886
+	pcpVersion    = 2 // PCP, RFC 6887
+)
+
+const (
+	pcpOpMap             = 1
+	pcpResultUnsuppVer   = 1
+	natpmpResultUnsupVer = 1
+)
+
+// NATPMPMapper maps ports with PCP, falling back to NAT-PMP for gateways that only speak
+// the older protocol. Both map ports to the host sending the request, so a rule's DestIP
+// must be this host.
+type NATPMPMapper struct {
+	gateway string
+	port    string // natpmpPort but in tests
+	timeout time.Duration
+
+	mu     sync.Mutex
+	natpmp bool // Gateway answered PCP requests with an unsupported version error
+}
+
+// NewNATPMPMapper creates a mapper for the gateway at the given address.
+func NewNATPMPMapper(gateway string, timeout time.Duration) *NATPMPMapper {
+	return &NATPMPMapper{gateway: gateway, port: natpmpPort, timeout: timeout}
+}
+
+// Name implements Mapper.
+func (m *NATPMPMapper) Name() string { return "natpmp" }
+
+// Map implements Mapper. The gateway may assign another external port than requested.
+func (m *NATPMPMapper) Map(ctx context.Context, rule Rule, lifetime time.Duration) (int, time.Duration, error) {
+	return m.request(ctx, rule, lifetime)
+}
+
+// Unmap implements Mapper.
+func (m *NATPMPMapper) Unmap(ctx context.Context, rule Rule) error {
+	_, _, err := m.request(ctx, rule, 0)
+	return err
+}
+
+// LocalIP implements Mapper.
+func (m *NATPMPMapper) LocalIP(context.Context) (string, error) {
+	return localIPTowards(net.JoinHostPort(m.gateway, m.port))
+}
+
+// errUnsupportedVersion means the gateway doesn't speak the protocol version we sent.
+var errUnsupportedVersion = errors.New("unsupported version")
+
+func (m *NATPMPMapper) request(ctx context.Context, rule Rule, lifetime time.Duration) (int, time.Duration, error) {
+	m.mu.Lock()
+	natpmp := m.natpmp
+	m.mu.Unlock()
+
+	if !natpmp {
+		port, granted, err := m.exchange(ctx, rule, lifetime, pcpVersion)
+		if !errors.Is(err, errUnsupportedVersion) {
+			return port, granted, err
+		}
+		m.mu.Lock()
+		m.natpmp = true
+		m.mu.Unlock()
+	}
+	return m.exchange(ctx, rule, lifetime, natpmpVersion)
+}
+
+// exchange sends one request, retransmitting with doubling intervals as both RFCs ask,
+// and returns the external port and lifetime granted.
+func (m *NATPMPMapper) exchange(ctx context.Context, rule Rule, lifetime time.Duration, version byte) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", net.JoinHostPort(m.gateway, m.port))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	var req []byte
+	if version == pcpVersion {
+		req = pcpMapRequest(rule, lifetime, conn.LocalAddr().(*net.UDPAddr).IP)
+	} else {
+		req = natpmpMapRequest(rule, lifetime)
+	}
+
+	buf := make([]byte, 1100) // Largest PCP message
+	for wait := 250 * time.Millisecond; ; wait *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return 0, 0, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if ctx.Err() != nil {
+					return 0, 0, fmt.Errorf("no NAT-PMP/PCP answer from %s: %w", m.gateway, ctx.Err())
+				}
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break // Retransmit
+				}
+				return 0, 0, err
+			}
+			port, granted, ok, err := parseMapResponse(buf[:n], rule, version)
+			if ok {
+				return port, granted, err
+			}
+		}
+	}
+}
+
+// pcpNonce identifies a mapping across restarts, so a mapping made before a restart can
+// still be renewed or deleted.
+func pcpNonce(rule Rule) []byte {
+	sum := sha256.Sum256([]byte("docklet " + rule.Name))
+	return sum[:12]
+}
+
+func pcpMapRequest(rule Rule, lifetime time.Duration, clientIP net.IP) []byte {
+	req := make([]byte, 60)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:8], uint32(lifetime/time.Second))
+	copy(req[8:24], clientIP.To16())
+	// MAP payload
+	copy(req[24:36], pcpNonce(rule))
+	req[36] = ianaProto(rule.Proto)
+	binary.BigEndian.PutUint16(req[40:42], uint16(rule.DestPort))
+	binary.BigEndian.PutUint16(req[42:44], uint16(rule.SrcPort))
+	copy(req[44:60], net.IPv4zero.To16()) // Any external address
+	return req
+}
+
+func natpmpMapRequest(rule Rule, lifetime time.Duration) []byte {
+	req := make([]byte, 12)
+	req[0] = natpmpVersion
+	req[1] = natpmpOpcode(rule.Proto)
+	binary.BigEndian.PutUint16(req[4:6], uint16(rule.DestPort))
+	if lifetime > 0 {
+		binary.BigEndian.PutUint16(req[6:8], uint16(rule.SrcPort)) // Must be 0 when deleting
+	}
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	return req
+}
+
+// parseMapResponse reads a response to a request sent with the given version. ok is false
+// for packets that don't answer our request, which are ignored.
+func parseMapResponse(resp []byte, rule Rule, version byte) (port int, lifetime time.Duration, ok bool, err error) {
+	if len(resp) < 2 {
+		return 0, 0, false, nil
+	}
+	// A gateway that doesn't speak PCP answers with its own version and an error
+	if resp[0] != version {
+		if version == pcpVersion && resp[0] == natpmpVersion && len(resp) >= 4 &&
+			binary.BigEndian.Uint16(resp[2:4]) == natpmpResultUnsupVer {
+			return 0, 0, true, errUnsupportedVersion
+		}
+		return 0, 0, false, nil
+	}
+
+	if version == natpmpVersion {
+		if len(resp) < 16 || resp[1] != 128+natpmpOpcode(rule.Proto) || int(binary.BigEndian.Uint16(resp[8:10])) != rule.DestPort {
+			return 0, 0, false, nil
+		}
+		if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+			return 0, 0, true, fmt.Errorf("NAT-PMP mapping %s/%d failed with result code %d", rule.Proto, rule.SrcPort, code)
+		}
+		return int(binary.BigEndian.Uint16(resp[10:12])), time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second, true, nil
+	}
+
+	if len(resp) < 24 || resp[1] != 128+pcpOpMap {
+		return 0, 0, false, nil
+	}
+	if code := resp[3]; code != 0 {
+		if code == pcpResultUnsuppVer {
+			return 0, 0, true, errUnsupportedVersion
+		}
+		return 0, 0, true, fmt.Errorf("PCP mapping %s/%d failed with result code %d", rule.Proto, rule.SrcPort, code)
+	}
+	if len(resp) < 60 || string(resp[24:36]) != string(pcpNonce(rule)) {
+		return 0, 0, false, nil
+	}
+	return int(binary.BigEndian.Uint16(resp[42:44])), time.Duration(binary.BigEndian.Uint32(resp[4:8])) * time.Second, true, nil
+}
+
+func ianaProto(proto string) byte {
+	if proto == "udp" {
+		return 17
+	}
+	return 6
+}
+
+func natpmpOpcode(proto string) byte {
+	if proto == "udp" {
+		return 1
+	}
+	return 2
+}
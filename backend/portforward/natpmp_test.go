@@ -0,0 +1,209 @@
+package portforward
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGateway answers PCP, or only NAT-PMP, map requests on a local UDP port.
+type fakeGateway struct {
+	natpmpOnly   bool // Answer PCP requests with NAT-PMP's unsupported version error
+	dropFirst    bool // Ignore the first request, so the client has to retransmit
+	externalPort int  // Port assigned instead of the one asked for, if not 0
+
+	mu       sync.Mutex
+	requests [][]byte
+}
+
+// start serves on 127.0.0.1 and returns a mapper pointing at it.
+func (g *fakeGateway) start(t *testing.T) *NATPMPMapper {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := g.answer(append([]byte(nil), buf[:n]...)); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	mapper := NewNATPMPMapper("127.0.0.1", 5*time.Second)
+	_, mapper.port, _ = net.SplitHostPort(conn.LocalAddr().String())
+	return mapper
+}
+
+func (g *fakeGateway) answer(req []byte) []byte {
+	g.mu.Lock()
+	g.requests = append(g.requests, req)
+	drop := g.dropFirst && len(g.requests) == 1
+	g.mu.Unlock()
+	if drop {
+		return nil
+	}
+
+	switch {
+	case req[0] == pcpVersion && g.natpmpOnly:
+		resp := make([]byte, 8)
+		resp[0] = natpmpVersion
+		resp[1] = 128 + req[1]
+		binary.BigEndian.PutUint16(resp[2:4], natpmpResultUnsupVer)
+		return resp
+	case req[0] == pcpVersion:
+		resp := make([]byte, 60)
+		copy(resp, req)
+		resp[1] = 128 + pcpOpMap
+		resp[2], resp[3] = 0, 0 // Reserved, result code
+		if g.externalPort != 0 {
+			binary.BigEndian.PutUint16(resp[42:44], uint16(g.externalPort))
+		}
+		return resp
+	default:
+		resp := make([]byte, 16)
+		resp[0] = natpmpVersion
+		resp[1] = 128 + req[1]
+		copy(resp[8:10], req[4:6])   // Internal port
+		copy(resp[10:12], req[6:8])  // Mapped external port
+		copy(resp[12:16], req[8:12]) // Lifetime
+		if g.externalPort != 0 && binary.BigEndian.Uint32(req[8:12]) != 0 {
+			binary.BigEndian.PutUint16(resp[10:12], uint16(g.externalPort))
+		}
+		return resp
+	}
+}
+
+func (g *fakeGateway) received() [][]byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([][]byte(nil), g.requests...)
+}
+
+func TestPCPMap(t *testing.T) {
+	gateway := &fakeGateway{externalPort: 30443}
+	mapper := gateway.start(t)
+
+	port, granted, err := mapper.Map(context.Background(), webRule, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 30443 || granted != time.Hour {
+		t.Errorf("Map() = %d, %s; want the assigned port 30443 for 1h", port, granted)
+	}
+
+	requests := gateway.received()
+	if len(requests) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if len(req) != 60 || req[0] != pcpVersion || req[1] != pcpOpMap || req[36] != 6 {
+		t.Errorf("request % x is not a PCP MAP request for TCP", req)
+	}
+	if internal, external := binary.BigEndian.Uint16(req[40:42]), binary.BigEndian.Uint16(req[42:44]); internal != 443 || external != 8443 {
+		t.Errorf("request asks for %d -> %d, want 8443 -> 443", external, internal)
+	}
+	if lifetime := binary.BigEndian.Uint32(req[4:8]); lifetime != 3600 {
+		t.Errorf("requested lifetime = %d, want 3600", lifetime)
+	}
+	if client := net.IP(req[8:24]); !client.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("client address = %s, want 127.0.0.1", client)
+	}
+}
+
+func TestPCPUnmap(t *testing.T) {
+	gateway := &fakeGateway{}
+	mapper := gateway.start(t)
+
+	if _, _, err := mapper.Map(context.Background(), webRule, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapper.Unmap(context.Background(), webRule); err != nil {
+		t.Fatal(err)
+	}
+	requests := gateway.received()
+	if len(requests) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(requests))
+	}
+	// The gateway finds the mapping to delete by its nonce
+	if string(requests[1][24:36]) != string(requests[0][24:36]) || binary.BigEndian.Uint32(requests[1][4:8]) != 0 {
+		t.Error("delete request doesn't reuse the mapping's nonce with lifetime 0")
+	}
+}
+
+func TestNATPMPFallback(t *testing.T) {
+	gateway := &fakeGateway{natpmpOnly: true, externalPort: 30443}
+	mapper := gateway.start(t)
+
+	udpRule := Rule{Name: "docklet-udp-51820", Proto: "udp", SrcPort: 51820, DestIP: "127.0.0.1", DestPort: 51820}
+	port, granted, err := mapper.Map(context.Background(), udpRule, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 30443 || granted != time.Hour {
+		t.Errorf("Map() = %d, %s; want 30443, 1h", port, granted)
+	}
+	if err := mapper.Unmap(context.Background(), udpRule); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := gateway.received()
+	if len(requests) != 3 || requests[0][0] != pcpVersion {
+		t.Fatalf("sent %d requests, want PCP, then NAT-PMP twice", len(requests))
+	}
+	mapReq, deleteReq := requests[1], requests[2]
+	if len(mapReq) != 12 || mapReq[0] != natpmpVersion || mapReq[1] != 1 {
+		t.Errorf("request % x is not a NAT-PMP UDP mapping request", mapReq)
+	}
+	if binary.BigEndian.Uint16(mapReq[6:8]) != 51820 || binary.BigEndian.Uint32(mapReq[8:12]) != 3600 {
+		t.Errorf("request % x doesn't ask for port 51820 for 3600s", mapReq)
+	}
+	// Deleting needs the suggested external port and lifetime to be 0
+	if binary.BigEndian.Uint16(deleteReq[4:6]) != 51820 || binary.BigEndian.Uint16(deleteReq[6:8]) != 0 || binary.BigEndian.Uint32(deleteReq[8:12]) != 0 {
+		t.Errorf("delete request % x, want internal port 51820 and zero external port and lifetime", deleteReq)
+	}
+}
+
+func TestNATPMPRetransmits(t *testing.T) {
+	gateway := &fakeGateway{dropFirst: true}
+	mapper := gateway.start(t)
+
+	port, _, err := mapper.Map(context.Background(), webRule, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 8443 {
+		t.Errorf("Map() port = %d, want 8443", port)
+	}
+	if n := len(gateway.received()); n != 2 {
+		t.Errorf("sent %d requests, want the first retransmitted once", n)
+	}
+}
+
+func TestNATPMPNoAnswer(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	mapper := NewNATPMPMapper("127.0.0.1", 300*time.Millisecond)
+	_, mapper.port, _ = net.SplitHostPort(conn.LocalAddr().String())
+
+	start := time.Now()
+	if _, _, err := mapper.Map(context.Background(), webRule, time.Hour); err == nil {
+		t.Fatal("Map() succeeded without a gateway")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Map() took %s with a 300ms timeout", elapsed)
+	}
+}
@@ -0,0 +1,163 @@
+package portforward
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Plan is the set of changes that brings the router's redirects in line with the desired rules.
+type Plan struct {
+	// ID identifies the exact changes, so an apply can insist on the plan that was reviewed.
+	ID     string     `json:"id"`
+	Add    []Rule     `json:"add"`
+	Modify []Change   `json:"modify"`
+	Delete []Redirect `json:"delete"`
+	// Unchanged counts desired rules already covered by a redirect, Docklet's or manual.
+	Unchanged int `json:"unchanged"`
+	// Skipped lists discovered ports the policy doesn't forward.
+	Skipped []Skip `json:"skipped"`
+}
+
+// Change updates one of Docklet's redirects in place to match Rule.
+type Change struct {
+	Redirect Redirect `json:"redirect"`
+	Rule     Rule     `json:"rule"`
+}
+
+// Empty reports whether the plan changes nothing.
+func (p *Plan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Modify) == 0 && len(p.Delete) == 0
+}
+
+// Diff compares the redirects on the router with the desired rules. A desired rule that any
+// redirect already covers is left alone; one of Docklet's redirects (see NamePrefix) with
+// the rule's name but other settings is modified; otherwise the rule is added. Docklet's
+// redirects that back no desired rule, or duplicate another, are deleted. Other redirects
+// are never touched.
+func Diff(existing []Redirect, desired []Rule) *Plan {
+	plan := &Plan{Add: []Rule{}, Modify: []Change{}, Delete: []Redirect{}, Skipped: []Skip{}}
+	used := make(map[int]bool) // Redirect indexes backing a desired rule
+
+	var uncovered []Rule
+	for _, rule := range desired {
+		covering, ok := pickCovering(existing, rule, used)
+		if !ok {
+			uncovered = append(uncovered, rule)
+			continue
+		}
+		used[covering] = true
+		plan.Unchanged++
+	}
+	for _, rule := range uncovered {
+		if r, ok := findOwned(existing, rule.Name, used); ok {
+			used[r.Index] = true
+			plan.Modify = append(plan.Modify, Change{Redirect: r, Rule: rule})
+			continue
+		}
+		plan.Add = append(plan.Add, rule)
+	}
+
+	for _, r := range existing {
+		if r.Owned() && !used[r.Index] {
+			plan.Delete = append(plan.Delete, r)
+		}
+	}
+	return plan
+}
+
+// findOwned returns an unused redirect of Docklet's with the given name.
+func findOwned(existing []Redirect, name string, used map[int]bool) (Redirect, bool) {
+	for _, r := range existing {
+		if r.Owned() && r.Name() == name && !used[r.Index] {
+			return r, true
+		}
+	}
+	return Redirect{}, false
+}
+
+// pickCovering returns the index of the redirect to keep for rule. A redirect already kept
+// for another rule (e.g. one tcpudp redirect covering both protocols) wins, then manual
+// redirects, so duplicates of Docklet's own rules end up deleted.
+func pickCovering(existing []Redirect, rule Rule, used map[int]bool) (int, bool) {
+	rank := func(r Redirect) int {
+		switch {
+		case used[r.Index]:
+			return 2
+		case !r.Owned():
+			return 1
+		}
+		return 0
+	}
+	best := -1
+	for i, r := range existing {
+		if r.Covers(rule) && (best == -1 || rank(r) > rank(existing[best])) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return 0, false
+	}
+	return existing[best].Index, true
+}
+
+// Commands returns the shell script applying the plan with uci, given the zones rules
+// forward from and to. Modifications run first, then deletions from the highest index down,
+// so indexes stay valid. Each checks the section still carries the expected name and aborts
+// otherwise, so a concurrent edit on the router can't make us change someone else's rule;
+// nothing is committed in that case.
+func (p *Plan) Commands(srcZone, destZone string) []string {
+	var cmds []string
+	for _, change := range p.Modify {
+		section := redirectSection(change.Redirect)
+		cmds = append(cmds, guard(section, change.Redirect.Name()), "uci -q delete "+section+".enabled || true")
+		cmds = append(cmds, setOptions(section, change.Rule, srcZone, destZone)...)
+	}
+
+	deletes := append([]Redirect(nil), p.Delete...)
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i].Index > deletes[j].Index })
+	for _, r := range deletes {
+		section := redirectSection(r)
+		cmds = append(cmds, guard(section, r.Name()), "uci delete "+section)
+	}
+
+	for _, rule := range p.Add {
+		cmds = append(cmds, "uci add firewall redirect >/dev/null")
+		cmds = append(cmds, setOptions("firewall.@redirect[-1]", rule, srcZone, destZone)...)
+	}
+	if len(cmds) > 0 {
+		cmds = append(cmds, "uci commit firewall", "/etc/init.d/firewall reload")
+	}
+	return cmds
+}
+
+// guard aborts the script unless section is still named name.
+func guard(section, name string) string {
+	return fmt.Sprintf(`[ "$(uci -q get %s.name)" = %s ] || { echo %s >&2; exit 1; }`,
+		section, quoteShell(name), quoteShell(section+" is no longer "+name+"; list the plan again"))
+}
+
+// setOptions returns the uci commands setting every option of rule on section.
+func setOptions(section string, rule Rule, srcZone, destZone string) []string {
+	var cmds []string
+	for _, option := range [][2]string{
+		{"name", rule.Name},
+		{"target", "DNAT"},
+		{"src", srcZone},
+		{"dest", destZone},
+		{"proto", rule.Proto},
+		{"src_dport", strconv.Itoa(rule.SrcPort)},
+		{"dest_ip", rule.DestIP},
+		{"dest_port", strconv.Itoa(rule.DestPort)},
+	} {
+		cmds = append(cmds, fmt.Sprintf("uci set %s.%s=%s", section, option[0], quoteShell(option[1])))
+	}
+	return cmds
+}
+
+func redirectSection(r Redirect) string {
+	if r.Section != "" {
+		return "firewall." + r.Section
+	}
+	return fmt.Sprintf("firewall.@redirect[%d]", r.Index)
+}
@@ -0,0 +1,98 @@
+package portforward
+
+import (
+	"strings"
+	"testing"
+)
+
+func redirect(index int, options ...string) Redirect {
+	r := Redirect{Index: index, Options: make(map[string]string)}
+	for i := 0; i+1 < len(options); i += 2 {
+		r.Options[options[i]] = options[i+1]
+	}
+	return r
+}
+
+func TestDiff(t *testing.T) {
+	existing := []Redirect{
+		// Manual tcpudp redirect covering both protocols of port 53
+		redirect(0, "name", "DNS", "proto", "tcpudp", "src_dport", "53", "dest_ip", "192.168.1.2"),
+		// Docklet's duplicate of the manual one
+		redirect(1, "name", "docklet-tcp-53", "proto", "tcp", "src_dport", "53", "dest_ip", "192.168.1.2", "dest_port", "53"),
+		// Docklet's, pointing at an old address
+		redirect(2, "name", "docklet-tcp-8080", "proto", "tcp", "src_dport", "8080", "dest_ip", "192.168.1.9", "dest_port", "8080"),
+		// Docklet's, for a service that's gone
+		redirect(3, "name", "docklet-tcp-9000", "proto", "tcp", "src_dport", "9000", "dest_ip", "192.168.1.10"),
+		// Disabled, so it doesn't cover anything
+		redirect(4, "name", "docklet-tcp-443", "enabled", "0", "proto", "tcp", "src_dport", "443", "dest_ip", "192.168.1.10", "dest_port", "443"),
+		// Manual redirect nothing asks for; never touched
+		redirect(5, "name", "Game", "proto", "udp", "src_dport", "27015", "dest_ip", "192.168.1.20"),
+	}
+	desired := []Rule{
+		{Name: "docklet-tcp-53", Proto: "tcp", SrcPort: 53, DestIP: "192.168.1.2", DestPort: 53},
+		{Name: "docklet-udp-53", Proto: "udp", SrcPort: 53, DestIP: "192.168.1.2", DestPort: 53},
+		{Name: "docklet-tcp-8080", Proto: "tcp", SrcPort: 8080, DestIP: "192.168.1.10", DestPort: 8080},
+		{Name: "docklet-tcp-443", Proto: "tcp", SrcPort: 443, DestIP: "192.168.1.10", DestPort: 443},
+		{Name: "docklet-tcp-8443", Proto: "tcp", SrcPort: 8443, DestIP: "192.168.1.10", DestPort: 443},
+	}
+
+	plan := Diff(existing, desired)
+
+	if plan.Unchanged != 2 {
+		t.Errorf("Unchanged = %d, want 2 (both DNS rules covered by the manual redirect)", plan.Unchanged)
+	}
+	if len(plan.Add) != 1 || plan.Add[0].Name != "docklet-tcp-8443" {
+		t.Errorf("Add = %+v, want docklet-tcp-8443", plan.Add)
+	}
+	modified := make(map[int]string)
+	for _, change := range plan.Modify {
+		modified[change.Redirect.Index] = change.Rule.Name
+	}
+	if len(modified) != 2 || modified[2] != "docklet-tcp-8080" || modified[4] != "docklet-tcp-443" {
+		t.Errorf("Modify = %+v, want redirects 2 and 4", plan.Modify)
+	}
+	deleted := make(map[int]bool)
+	for _, r := range plan.Delete {
+		deleted[r.Index] = true
+	}
+	if len(deleted) != 2 || !deleted[1] || !deleted[3] {
+		t.Errorf("Delete = %+v, want redirects 1 and 3", plan.Delete)
+	}
+}
+
+func TestDiffInSync(t *testing.T) {
+	existing := []Redirect{redirect(0, "name", "docklet-tcp-80", "proto", "tcp", "src_dport", "80", "dest_ip", "192.168.1.10")}
+	desired := []Rule{{Name: "docklet-tcp-80", Proto: "tcp", SrcPort: 80, DestIP: "192.168.1.10", DestPort: 80}}
+
+	plan := Diff(existing, desired)
+	if !plan.Empty() || plan.Unchanged != 1 {
+		t.Errorf("plan = %+v, want no changes", plan)
+	}
+	if cmds := plan.Commands("wan", "lan"); len(cmds) != 0 {
+		t.Errorf("Commands() = %v, want none", cmds)
+	}
+}
+
+func TestPlanCommandsOrder(t *testing.T) {
+	plan := &Plan{
+		Delete: []Redirect{
+			redirect(1, "name", "docklet-tcp-1"),
+			redirect(4, "name", "docklet-tcp-4"),
+			{Index: 2, Section: "web", Options: map[string]string{"name": "docklet-tcp-2"}},
+		},
+		Add: []Rule{{Name: "docklet-tcp-80", Proto: "tcp", SrcPort: 80, DestIP: "192.168.1.10", DestPort: 80}},
+	}
+	script := strings.Join(plan.Commands("wan", "lan"), "\n")
+
+	// Deletions run from the highest index down so the lower indexes stay valid
+	order := []string{"uci delete firewall.@redirect[4]", "uci delete firewall.web", "uci delete firewall.@redirect[1]",
+		"uci add firewall redirect", "uci set firewall.@redirect[-1].src='wan'", "uci commit firewall", "/etc/init.d/firewall reload"}
+	last := -1
+	for _, cmd := range order {
+		i := strings.Index(script, cmd)
+		if i < 0 || i < last {
+			t.Fatalf("%q missing or out of order in:\n%s", cmd, script)
+		}
+		last = i
+	}
+}
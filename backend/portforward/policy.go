@@ -0,0 +1,229 @@
+package portforward
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	dockerscanner "docklet/docker_scanner"
+	systemscanner "docklet/system_scanner"
+)
+
+// Container labels controlling forwarding.
+const (
+	ExposeLabel      = "docklet.expose"       // "wan" forwards the container's published ports
+	ExposePortsLabel = "docklet.expose.ports" // Optional list of published ports to forward, e.g. "443,8443"
+	ExposeWAN        = "wan"
+)
+
+// DefaultDeny are ports never forwarded unless DOCKLET_PORTFORWARD_DENY is overridden:
+// remote shells, DNS, Windows/NFS file sharing, the Docker API, databases and caches.
+const DefaultDeny = "22,23,53,111,135-139,445,2049,2375-2376,3306,3389,5432,6379,9200,11211,27017"
+
+// Policy decides which discovered ports are forwarded.
+type Policy struct {
+	Allow         PortRanges      // Ports that may be forwarded; empty allows all
+	Deny          PortRanges      // Ports never forwarded, even if allowed
+	Protocols     map[string]bool // tcp and/or udp
+	IncludeSystem bool            // Also forward native services' ports, which have no labels
+	TargetIP      string          // Forward target; empty means this host's address towards the router
+}
+
+// PolicyFromEnv reads the policy from environment variables:
+//
+//	DOCKLET_PORTFORWARD_ALLOW      port ranges that may be forwarded, e.g. "80,443,8000-8999" (default: all)
+//	DOCKLET_PORTFORWARD_DENY       port ranges never forwarded (default DefaultDeny; "none" for no ports)
+//	DOCKLET_PORTFORWARD_PROTOCOLS  protocols to forward (default "tcp,udp")
+//	DOCKLET_PORTFORWARD_SYSTEM     "true" also forwards ports of native services (default false)
+//	DOCKLET_PORTFORWARD_TARGET_IP  address ports are forwarded to (default: this host's address towards the router)
+//
+// Containers are only forwarded if labelled docklet.expose=wan.
+func PolicyFromEnv() (Policy, error) {
+	allow, err := ParsePortRanges(dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_ALLOW", ""))
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid DOCKLET_PORTFORWARD_ALLOW: %w", err)
+	}
+	denySpec := dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_DENY", DefaultDeny)
+	if denySpec == "none" {
+		denySpec = ""
+	}
+	deny, err := ParsePortRanges(denySpec)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid DOCKLET_PORTFORWARD_DENY: %w", err)
+	}
+	protocols := make(map[string]bool)
+	for _, proto := range strings.Split(dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_PROTOCOLS", "tcp,udp"), ",") {
+		proto = strings.ToLower(strings.TrimSpace(proto))
+		if proto != "tcp" && proto != "udp" {
+			return Policy{}, fmt.Errorf("invalid DOCKLET_PORTFORWARD_PROTOCOLS: unknown protocol %q", proto)
+		}
+		protocols[proto] = true
+	}
+	return Policy{
+		Allow:         allow,
+		Deny:          deny,
+		Protocols:     protocols,
+		IncludeSystem: dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_SYSTEM", "false") == "true",
+		TargetIP:      dockerscanner.GetEnvOrDefault("DOCKLET_PORTFORWARD_TARGET_IP", ""),
+	}, nil
+}
+
+// Skip is a discovered port the policy doesn't forward, with the reason.
+type Skip struct {
+	Service string `json:"service"`
+	Proto   string `json:"proto"`
+	Port    int    `json:"port"`
+	Reason  string `json:"reason"`
+}
+
+// Rules returns the forwards the policy asks for, keeping port numbers, and the ports it
+// leaves out. Ports only published on loopback and sockets owned by containers (covered
+// by Docker's published ports) are ignored without being reported.
+func (p Policy) Rules(docker []dockerscanner.ServiceInfo, system []systemscanner.SystemServiceInfo, targetIP string) ([]Rule, []Skip) {
+	seen := make(map[string]bool)
+	rules := []Rule{}
+	skipped := []Skip{}
+	// unlabelled is why the service doesn't ask for the port to be forwarded, if it doesn't
+	consider := func(service, proto string, port int, unlabelled string) {
+		rule := newRule(proto, port, targetIP, service)
+		if seen[rule.key()] {
+			return
+		}
+		seen[rule.key()] = true
+		if reason := p.reject(proto, port, unlabelled); reason != "" {
+			skipped = append(skipped, Skip{Service: service, Proto: proto, Port: port, Reason: reason})
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	for _, service := range docker {
+		unlabelled := ""
+		if service.RawLabels[ExposeLabel] != ExposeWAN {
+			unlabelled = "not labelled " + ExposeLabel + "=" + ExposeWAN
+		}
+		only, err := ParsePortRanges(service.RawLabels[ExposePortsLabel])
+		if err != nil && unlabelled == "" {
+			unlabelled = "invalid " + ExposePortsLabel + " label" // Forward nothing rather than everything
+		}
+		for _, binding := range service.PortBindings {
+			if binding.PublicPort == 0 || isLoopback(binding.IP) {
+				continue
+			}
+			proto := binding.Type
+			if proto == "" {
+				proto = "tcp"
+			}
+			port := int(binding.PublicPort)
+			reason := unlabelled
+			if reason == "" && only != nil && !only.Contains(port) {
+				reason = "not in " + ExposePortsLabel
+			}
+			consider(service.ContainerName, proto, port, reason)
+		}
+	}
+	if p.IncludeSystem {
+		for _, service := range system {
+			if service.Container != nil {
+				continue
+			}
+			for _, portStr := range service.ListeningPorts {
+				if port, err := strconv.Atoi(portStr); err == nil && port > 0 {
+					consider(service.Name, "tcp", port, "") // The system scanner only sees TCP listeners
+				}
+			}
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].SrcPort != rules[j].SrcPort {
+			return rules[i].SrcPort < rules[j].SrcPort
+		}
+		return rules[i].Proto < rules[j].Proto
+	})
+	return rules, skipped
+}
+
+// reject returns why a port isn't forwarded, or "" if it is.
+func (p Policy) reject(proto string, port int, unlabelled string) string {
+	switch {
+	case unlabelled != "":
+		return unlabelled
+	case !p.Protocols[proto]:
+		return "protocol not allowed"
+	case p.Deny.Contains(port):
+		return "port denied"
+	case p.Allow != nil && !p.Allow.Contains(port):
+		return "port not allowed"
+	}
+	return ""
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// PortRanges is a set of port ranges. A nil PortRanges means "not configured".
+type PortRanges []PortRange
+
+// ParsePortRanges parses a comma-separated list of ports and ranges like "80,443,8000-8999".
+// An empty string gives nil.
+func ParsePortRanges(spec string) (PortRanges, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	ranges := PortRanges{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		lo, err := parsePort(from)
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if isRange {
+			if hi, err = parsePort(to); err != nil {
+				return nil, err
+			}
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("empty port range %q", part)
+		}
+		ranges = append(ranges, PortRange{From: lo, To: hi})
+	}
+	return ranges, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// Contains reports whether port is in any of the ranges.
+func (r PortRanges) Contains(port int) bool {
+	for _, pr := range r {
+		if port >= pr.From && port <= pr.To {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the ranges like ParsePortRanges accepts them.
+func (r PortRanges) String() string {
+	parts := make([]string, len(r))
+	for i, pr := range r {
+		parts[i] = strconv.Itoa(pr.From)
+		if pr.To != pr.From {
+			parts[i] += "-" + strconv.Itoa(pr.To)
+		}
+	}
+	return strings.Join(parts, ",")
+}
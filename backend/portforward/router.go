@@ -0,0 +1,276 @@
+package portforward
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	dockerscanner "docklet/docker_scanner"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// RouterConfig is how to reach the OpenWrt router and which firewall zones to forward between.
+type RouterConfig struct {
+	Host            string // Router address; defaults to the default gateway
+	Port            string
+	User            string
+	Password        string
+	KeyFile         string // Private key, used instead of or in addition to Password
+	KnownHostsFile  string
+	InsecureHostKey bool // Accept any host key; only for a router on a trusted LAN
+	SrcZone         string
+	DestZone        string
+	Timeout         time.Duration
+}
+
+// RouterConfigFromEnv reads the router configuration from environment variables:
+//
+//	DOCKLET_OPENWRT_HOST               router address (default: the default gateway)
+//	DOCKLET_OPENWRT_PORT               SSH port (default 22)
+//	DOCKLET_OPENWRT_USER               SSH user (default root)
+//	DOCKLET_OPENWRT_PASSWORD           SSH password
+//	DOCKLET_OPENWRT_KEY                path to an SSH private key
+//	DOCKLET_OPENWRT_KNOWN_HOSTS        known_hosts file to verify the router against (default ~/.ssh/known_hosts)
+//	DOCKLET_OPENWRT_INSECURE_HOST_KEY  "true" skips host key verification
+//	DOCKLET_OPENWRT_SRC_ZONE           zone forwarded from (default wan)
+//	DOCKLET_OPENWRT_DEST_ZONE          zone forwarded to (default lan)
+//	DOCKLET_OPENWRT_TIMEOUT            timeout for connecting and for each command (default 10s)
+func RouterConfigFromEnv() (RouterConfig, error) {
+	timeout, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_TIMEOUT", "10s"))
+	if err != nil {
+		return RouterConfig{}, fmt.Errorf("invalid DOCKLET_OPENWRT_TIMEOUT: %w", err)
+	}
+	knownHosts := dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_KNOWN_HOSTS", "")
+	if knownHosts == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			knownHosts = home + "/.ssh/known_hosts"
+		}
+	}
+	cfg := RouterConfig{
+		Host:            dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_HOST", ""),
+		Port:            dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_PORT", "22"),
+		User:            dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_USER", "root"),
+		Password:        os.Getenv("DOCKLET_OPENWRT_PASSWORD"),
+		KeyFile:         os.Getenv("DOCKLET_OPENWRT_KEY"),
+		KnownHostsFile:  knownHosts,
+		InsecureHostKey: dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_INSECURE_HOST_KEY", "false") == "true",
+		SrcZone:         dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_SRC_ZONE", "wan"),
+		DestZone:        dockerscanner.GetEnvOrDefault("DOCKLET_OPENWRT_DEST_ZONE", "lan"),
+		Timeout:         timeout,
+	}
+	if cfg.Host == "" {
+		gateway, err := defaultGateway()
+		if err != nil {
+			return RouterConfig{}, fmt.Errorf("DOCKLET_OPENWRT_HOST not set and no default gateway found: %w", err)
+		}
+		cfg.Host = gateway
+	}
+	if cfg.Password == "" && cfg.KeyFile == "" {
+		return RouterConfig{}, errors.New("set DOCKLET_OPENWRT_PASSWORD or DOCKLET_OPENWRT_KEY to log in to the router")
+	}
+	return cfg, nil
+}
+
+// Router runs uci commands on an OpenWrt router over SSH.
+type Router struct {
+	cfg RouterConfig
+}
+
+// NewRouter creates a Router. Nothing is dialled until a command runs.
+func NewRouter(cfg RouterConfig) *Router {
+	return &Router{cfg: cfg}
+}
+
+// Addr returns the router's SSH address.
+func (r *Router) Addr() string {
+	return net.JoinHostPort(r.cfg.Host, r.cfg.Port)
+}
+
+// Zones returns the firewall zones rules forward from and to.
+func (r *Router) Zones() (src, dest string) {
+	return r.cfg.SrcZone, r.cfg.DestZone
+}
+
+// LocalIP returns this host's address on the interface facing the router.
+func (r *Router) LocalIP() (string, error) {
+	return localIPTowards(r.Addr())
+}
+
+// localIPTowards returns this host's address on the interface facing addr.
+func localIPTowards(addr string) (string, error) {
+	// Connecting a UDP socket sends nothing; it only picks the route and source address.
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return "", fmt.Errorf("finding local address towards %s: %w", addr, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// Redirects lists the firewall redirects configured on the router.
+func (r *Router) Redirects(ctx context.Context) ([]Redirect, error) {
+	out, err := r.run(ctx, "uci show firewall")
+	if err != nil {
+		return nil, err
+	}
+	return ParseRedirects(out)
+}
+
+// applyPreamble starts every apply script. uci stages changes in /tmp/.uci until they are
+// committed, and the next commit of the package picks up whatever is staged, so changes
+// left by an aborted edit are dropped before the plan runs and ours are dropped if the
+// script exits early.
+const applyPreamble = "uci revert firewall\ntrap 'uci revert firewall' EXIT\nset -e\n"
+
+// Apply runs the plan's uci commands, committing and reloading the firewall at the end.
+// Uncommitted firewall changes on the router are reverted first. The script stops at the
+// first failing command and reverts what it staged, so the router keeps its committed
+// configuration unless the commit was reached.
+func (r *Router) Apply(ctx context.Context, plan *Plan) error {
+	cmds := plan.Commands(r.cfg.SrcZone, r.cfg.DestZone)
+	if len(cmds) == 0 {
+		return nil
+	}
+	script := applyPreamble + strings.Join(cmds, "\n") + "\n"
+	if _, err := r.run(ctx, script); err != nil {
+		// The trap doesn't run if the connection dropped or timed out mid-script
+		if _, revertErr := r.run(context.WithoutCancel(ctx), "uci revert firewall"); revertErr != nil {
+			return fmt.Errorf("%w (reverting staged changes also failed: %v)", err, revertErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// run runs a shell command on the router and returns its standard output.
+func (r *Router) run(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+
+	client, err := r.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	// Closing the connection is the only way to interrupt a running command
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("opening SSH session on %s: %w", r.Addr(), err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("running %q on %s: %w: %s", firstLine(command), r.Addr(), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func (r *Router) dial(ctx context.Context) (*ssh.Client, error) {
+	config, err := r.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr())
+	if err != nil {
+		return nil, fmt.Errorf("connecting to router: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) // Covers the handshake; cleared below
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, r.Addr(), config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH login to %s: %w", r.Addr(), err)
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (r *Router) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if r.cfg.KeyFile != "" {
+		key, err := os.ReadFile(r.cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing SSH key %s: %w", r.cfg.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if r.cfg.Password != "" {
+		auth = append(auth, ssh.Password(r.cfg.Password))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !r.cfg.InsecureHostKey {
+		callback, err := knownhosts.New(r.cfg.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading known hosts (set DOCKLET_OPENWRT_KNOWN_HOSTS, or DOCKLET_OPENWRT_INSECURE_HOST_KEY=true on a trusted LAN): %w", err)
+		}
+		hostKeyCallback = callback
+	}
+
+	return &ssh.ClientConfig{
+		User:            r.cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         r.cfg.Timeout,
+	}, nil
+}
+
+func firstLine(s string) string {
+	s = strings.TrimPrefix(s, applyPreamble)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + " ..."
+	}
+	return s
+}
+
+// defaultGateway reads the IPv4 default gateway from /proc/net/route.
+func defaultGateway() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...; addresses are little-endian hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]).String(), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no default route")
+}
@@ -0,0 +1,532 @@
+package portforward
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The fake router runs scripts with a real shell. uci is a symlink to the test binary,
+// which TestMain turns into a small uci keeping its state as JSON in $FAKE_UCI_DIR:
+// committed.json is the configuration, staged.json the uncommitted changes (like
+// /tmp/.uci), log lists every uci call, fail-on and hang-on make calls containing
+// their text fail or hang.
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "uci" {
+		os.Exit(fakeUCI(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+type fakeSection struct {
+	Name    string      `json:"name,omitempty"` // Empty for anonymous sections
+	Type    string      `json:"type"`
+	Options [][2]string `json:"options,omitempty"`
+}
+
+func (s *fakeSection) get(option string) (string, bool) {
+	for _, o := range s.Options {
+		if o[0] == option {
+			return o[1], true
+		}
+	}
+	return "", false
+}
+
+func (s *fakeSection) set(option, value string) {
+	for i, o := range s.Options {
+		if o[0] == option {
+			s.Options[i][1] = value
+			return
+		}
+	}
+	s.Options = append(s.Options, [2]string{option, value})
+}
+
+func (s *fakeSection) unset(option string) bool {
+	for i, o := range s.Options {
+		if o[0] == option {
+			s.Options = append(s.Options[:i], s.Options[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func readSections(path string) ([]fakeSection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sections []fakeSection
+	return sections, json.Unmarshal(data, &sections)
+}
+
+func writeSections(path string, sections []fakeSection) error {
+	data, err := json.Marshal(sections)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func fakeUCI(args []string) int {
+	dir := os.Getenv("FAKE_UCI_DIR")
+	if len(args) > 0 && args[0] == "-q" {
+		args = args[1:]
+	}
+	call := strings.Join(args, " ")
+	if f, err := os.OpenFile(filepath.Join(dir, "log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err == nil {
+		fmt.Fprintln(f, call)
+		f.Close()
+	}
+	if hang, _ := os.ReadFile(filepath.Join(dir, "hang-on")); len(hang) > 0 && strings.Contains(call, string(hang)) {
+		time.Sleep(5 * time.Second)
+		return 1
+	}
+	if fail, _ := os.ReadFile(filepath.Join(dir, "fail-on")); len(fail) > 0 && strings.Contains(call, string(fail)) {
+		fmt.Fprintln(os.Stderr, "uci: Injected failure")
+		return 1
+	}
+
+	committed, staged := filepath.Join(dir, "committed.json"), filepath.Join(dir, "staged.json")
+	sections, err := readSections(staged)
+	if errors.Is(err, os.ErrNotExist) {
+		sections, err = readSections(committed)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(args) < 2 {
+		return 2
+	}
+
+	// resolve finds the section a path like firewall.@redirect[-1].name or firewall.web
+	// points at, and the option if any.
+	resolve := func(path string) (int, string, bool) {
+		ref, option, _ := strings.Cut(strings.TrimPrefix(path, "firewall."), ".")
+		if !strings.HasPrefix(ref, "@") {
+			for i, s := range sections {
+				if s.Name == ref {
+					return i, option, true
+				}
+			}
+			return 0, "", false
+		}
+		typ, rest, _ := strings.Cut(ref[1:], "[")
+		n, err := strconv.Atoi(strings.TrimSuffix(rest, "]"))
+		if err != nil {
+			return 0, "", false
+		}
+		var ofType []int
+		for i, s := range sections {
+			if s.Type == typ {
+				ofType = append(ofType, i)
+			}
+		}
+		if n < 0 {
+			n += len(ofType)
+		}
+		if n < 0 || n >= len(ofType) {
+			return 0, "", false
+		}
+		return ofType[n], option, true
+	}
+
+	switch args[0] {
+	case "show":
+		counts := make(map[string]int)
+		for _, s := range sections {
+			ref := "firewall." + s.Name
+			if s.Name == "" {
+				ref = fmt.Sprintf("firewall.@%s[%d]", s.Type, counts[s.Type])
+			}
+			counts[s.Type]++
+			fmt.Printf("%s=%s\n", ref, s.Type)
+			for _, o := range s.Options {
+				fmt.Printf("%s.%s='%s'\n", ref, o[0], strings.ReplaceAll(o[1], "'", `'\''`))
+			}
+		}
+		return 0
+	case "get":
+		i, option, ok := resolve(args[1])
+		if !ok {
+			return 1
+		}
+		value, ok := sections[i].get(option)
+		if !ok {
+			return 1
+		}
+		fmt.Println(value)
+		return 0
+	case "set":
+		path, value, _ := strings.Cut(args[1], "=")
+		i, option, ok := resolve(path)
+		if !ok || option == "" {
+			return 1
+		}
+		sections[i].set(option, value)
+	case "delete":
+		i, option, ok := resolve(args[1])
+		if !ok {
+			return 1
+		}
+		if option == "" {
+			sections = append(sections[:i], sections[i+1:]...)
+		} else if !sections[i].unset(option) {
+			return 1
+		}
+	case "add":
+		if len(args) < 3 {
+			return 2
+		}
+		sections = append(sections, fakeSection{Type: args[2]})
+		fmt.Printf("cfg%06x\n", len(sections))
+	case "commit":
+		if err := os.Rename(staged, committed); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 1
+		}
+		return 0
+	case "revert":
+		if err := os.Remove(staged); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 1
+		}
+		return 0
+	default:
+		return 2
+	}
+	if err := writeSections(staged, sections); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// fakeRouter is an SSH server on loopback that runs commands like an OpenWrt router.
+type fakeRouter struct {
+	t      *testing.T
+	dir    string // $FAKE_UCI_DIR
+	router *Router
+}
+
+func startFakeRouter(t *testing.T, sections []fakeSection) *fakeRouter {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, bin := t.TempDir(), t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(bin, "uci")); err != nil {
+		t.Fatal(err)
+	}
+	reload := "#!/bin/sh\necho \"firewall $*\" >> \"$FAKE_UCI_DIR/log\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "firewall-init"), []byte(reload), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSections(filepath.Join(dir, "committed.json"), sections); err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "root" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	// With -race, the fake uci would otherwise sleep a second on exit
+	env := append(os.Environ(), "FAKE_UCI_DIR="+dir, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "GORACE=atexit_sleep_ms=0")
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSSH(conn, config, dir, env)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(ln.Addr().String())}, hostKey.PublicKey())
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &fakeRouter{t: t, dir: dir, router: NewRouter(RouterConfig{
+		Host:           host,
+		Port:           port,
+		User:           "root",
+		Password:       "secret",
+		KnownHostsFile: knownHostsFile,
+		SrcZone:        "wan",
+		DestZone:       "lan",
+		Timeout:        10 * time.Second,
+	})}
+}
+
+func serveFakeSSH(conn net.Conn, config *ssh.ServerConfig, dir string, env []string) {
+	server, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer server.Close()
+	// Commands are killed when the client hangs up, like sshd does
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					req.Reply(false, nil)
+					return
+				}
+				req.Reply(true, nil)
+
+				script := strings.ReplaceAll(payload.Command, "/etc/init.d/firewall", "firewall-init")
+				cmd := exec.CommandContext(ctx, "sh", "-c", script)
+				cmd.Dir, cmd.Env = dir, env
+				cmd.Stdout, cmd.Stderr = channel, channel.Stderr()
+				cmd.WaitDelay = 100 * time.Millisecond
+				status := 0
+				if err := cmd.Run(); err != nil {
+					status = 255
+					var exitErr *exec.ExitError
+					if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+						status = exitErr.ExitCode()
+					}
+				}
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+				return
+			}
+		}()
+	}
+}
+
+func (f *fakeRouter) file(name string) []byte {
+	data, err := os.ReadFile(filepath.Join(f.dir, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		f.t.Fatal(err)
+	}
+	return data
+}
+
+func (f *fakeRouter) setFile(name, content string) {
+	if err := os.WriteFile(filepath.Join(f.dir, name), []byte(content), 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fakeRouter) calls() []string {
+	return strings.Split(strings.TrimSpace(string(f.file("log"))), "\n")
+}
+
+// fakeRedirect returns a redirect section with the given name, empty for an anonymous
+// one, and option/value pairs.
+func fakeRedirect(name string, options ...string) fakeSection {
+	s := fakeSection{Name: name, Type: "redirect"}
+	for i := 0; i+1 < len(options); i += 2 {
+		s.Options = append(s.Options, [2]string{options[i], options[i+1]})
+	}
+	return s
+}
+
+// routerConfig has a manual redirect, a Docklet redirect in a named section pointing at an
+// old address and a Docklet redirect nothing wants any more.
+var routerConfig = []fakeSection{
+	{Name: "wan", Type: "zone", Options: [][2]string{{"name", "wan"}}},
+	fakeRedirect("", "name", "SSH to NAS", "target", "DNAT", "src", "wan", "dest", "lan", "proto", "tcp",
+		"src_dport", "2222", "dest_ip", "192.168.1.5", "dest_port", "22"),
+	fakeRedirect("web", "name", "docklet-tcp-8080", "target", "DNAT", "src", "wan", "dest", "lan", "proto", "tcp",
+		"src_dport", "8080", "dest_ip", "192.168.1.9", "dest_port", "8080"),
+	fakeRedirect("", "name", "docklet-tcp-9000", "target", "DNAT", "src", "wan", "dest", "lan", "proto", "tcp",
+		"src_dport", "9000", "dest_ip", "192.168.1.10", "dest_port", "9000"),
+}
+
+var routerDesired = []Rule{
+	{Name: "docklet-tcp-8080", Proto: "tcp", SrcPort: 8080, DestIP: "192.168.1.10", DestPort: 8080},
+	{Name: "docklet-udp-51820", Proto: "udp", SrcPort: 51820, DestIP: "192.168.1.10", DestPort: 51820},
+	{Name: "docklet-tcp-2222", Proto: "tcp", SrcPort: 2222, DestIP: "192.168.1.5", DestPort: 22},
+}
+
+func planFor(t *testing.T, f *fakeRouter) *Plan {
+	t.Helper()
+	existing, err := f.router.Redirects(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) != 3 {
+		t.Fatalf("listed %d redirects, want 3: %+v", len(existing), existing)
+	}
+	plan := Diff(existing, routerDesired)
+	if len(plan.Add) != 1 || len(plan.Modify) != 1 || len(plan.Delete) != 1 || plan.Unchanged != 1 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	return plan
+}
+
+func TestRouterApply(t *testing.T) {
+	f := startFakeRouter(t, routerConfig)
+	plan := planFor(t, f)
+
+	// Someone's edit was aborted after the plan was listed; it must not get committed with ours
+	f.setFile("staged.json", `[{"type":"redirect","options":[["name","half-done"]]}]`)
+
+	if err := f.router.Apply(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+	if staged := f.file("staged.json"); staged != nil {
+		t.Errorf("changes left staged: %s", staged)
+	}
+	calls := f.calls()
+	if calls[len(calls)-1] != "firewall reload" && calls[len(calls)-2] != "firewall reload" {
+		t.Errorf("firewall not reloaded: %v", calls)
+	}
+
+	after, err := f.router.Redirects(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range after {
+		if r.Name() == "half-done" {
+			t.Errorf("aborted edit was committed: %+v", r)
+		}
+	}
+	if again := Diff(after, routerDesired); !again.Empty() {
+		t.Errorf("router doesn't match the policy after apply, plan: %+v", again)
+	}
+	if len(after) != 3 || after[0].Name() != "SSH to NAS" || after[1].Section != "web" || after[1].Options["dest_ip"] != "192.168.1.10" {
+		t.Errorf("unexpected redirects after apply: %+v", after)
+	}
+}
+
+func TestRouterApplyRevertsOnFailure(t *testing.T) {
+	f := startFakeRouter(t, routerConfig)
+	plan := planFor(t, f)
+	before := f.file("committed.json")
+
+	// Fails adding the new redirect, after the modification and deletion were staged
+	f.setFile("fail-on", "dest_port=51820")
+	err := f.router.Apply(context.Background(), plan)
+	if err == nil || !strings.Contains(err.Error(), "Injected failure") {
+		t.Fatalf("Apply error = %v, want the injected failure", err)
+	}
+
+	if committed := f.file("committed.json"); !bytes.Equal(committed, before) {
+		t.Errorf("configuration changed:\n%s", committed)
+	}
+	if staged := f.file("staged.json"); staged != nil {
+		t.Errorf("changes left staged: %s", staged)
+	}
+	calls := f.calls()
+	if calls[len(calls)-1] != "revert firewall" {
+		t.Errorf("last call = %q, want a revert", calls[len(calls)-1])
+	}
+	for _, call := range calls {
+		if call == "commit firewall" || call == "firewall reload" {
+			t.Errorf("unexpected %q after a failure", call)
+		}
+	}
+}
+
+func TestRouterApplyGuard(t *testing.T) {
+	f := startFakeRouter(t, routerConfig)
+	plan := planFor(t, f)
+
+	// The redirect to delete was renamed on the router since the plan was listed
+	sections := append([]fakeSection(nil), routerConfig...)
+	sections[3] = fakeRedirect("", "name", "game server", "src_dport", "9000")
+	if err := writeSections(filepath.Join(f.dir, "committed.json"), sections); err != nil {
+		t.Fatal(err)
+	}
+	before := f.file("committed.json")
+
+	err := f.router.Apply(context.Background(), plan)
+	if err == nil || !strings.Contains(err.Error(), "is no longer docklet-tcp-9000") {
+		t.Fatalf("Apply error = %v, want the guard to stop it", err)
+	}
+	if committed := f.file("committed.json"); !bytes.Equal(committed, before) {
+		t.Errorf("configuration changed:\n%s", committed)
+	}
+	if staged := f.file("staged.json"); staged != nil {
+		t.Errorf("modification left staged: %s", staged)
+	}
+}
+
+func TestRouterApplyRevertsAfterTimeout(t *testing.T) {
+	f := startFakeRouter(t, routerConfig)
+	plan := planFor(t, f)
+	before := f.file("committed.json")
+
+	// The script is killed with the connection, so the trap never runs; Apply reverts
+	// in a second session
+	f.setFile("hang-on", "add firewall redirect")
+	f.router.cfg.Timeout = time.Second
+	err := f.router.Apply(context.Background(), plan)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Apply error = %v, want a timeout", err)
+	}
+	if committed := f.file("committed.json"); !bytes.Equal(committed, before) {
+		t.Errorf("configuration changed:\n%s", committed)
+	}
+	if staged := f.file("staged.json"); staged != nil {
+		t.Errorf("changes left staged: %s", staged)
+	}
+}
+
+func TestRouterRejectsUnknownHostKey(t *testing.T) {
+	f := startFakeRouter(t, routerConfig)
+	other := startFakeRouter(t, routerConfig)
+	f.router.cfg.KnownHostsFile = filepath.Join(other.dir, "known_hosts")
+
+	if _, err := f.router.Redirects(context.Background()); err == nil || !strings.Contains(err.Error(), "SSH login") {
+		t.Fatalf("Redirects error = %v, want a host key failure", err)
+	}
+}
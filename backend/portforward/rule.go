@@ -0,0 +1,43 @@
+// Package portforward keeps port forwards on the router in sync with the services Docklet
+// discovers. Rules are created on an OpenWrt router as firewall redirects over SSH.
+package portforward
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// NamePrefix marks rules created by Docklet. Rules without it are never changed or removed.
+const NamePrefix = "docklet-"
+
+// Rule is a port forward Docklet wants on the router.
+type Rule struct {
+	Name     string `json:"name"`
+	Proto    string `json:"proto"`    // tcp or udp
+	SrcPort  int    `json:"src_port"` // External port on the router
+	DestIP   string `json:"dest_ip"`
+	DestPort int    `json:"dest_port"`
+	Service  string `json:"service,omitempty"` // Service the port belongs to, for display
+}
+
+// key identifies what a rule forwards, regardless of its name.
+func (r Rule) key() string {
+	return fmt.Sprintf("%s/%d>%s:%d", r.Proto, r.SrcPort, r.DestIP, r.DestPort)
+}
+
+func newRule(proto string, port int, destIP, service string) Rule {
+	return Rule{
+		Name:     NamePrefix + proto + "-" + strconv.Itoa(port),
+		Proto:    proto,
+		SrcPort:  port,
+		DestIP:   destIP,
+		DestPort: port,
+		Service:  service,
+	}
+}
+
+func isLoopback(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.IsLoopback()
+}
@@ -0,0 +1,175 @@
+package portforward
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Redirect is a firewall redirect section on the router, as listed by `uci show firewall`.
+type Redirect struct {
+	Index   int               `json:"index"`             // Position among the firewall.@redirect sections
+	Section string            `json:"section,omitempty"` // Section name, for named sections
+	Options map[string]string `json:"options"`
+}
+
+// Name returns the name option of the redirect.
+func (r Redirect) Name() string {
+	return r.Options["name"]
+}
+
+// Owned reports whether the redirect was created by Docklet.
+func (r Redirect) Owned() bool {
+	return strings.HasPrefix(r.Name(), NamePrefix)
+}
+
+// Covers reports whether the redirect already forwards what rule asks for.
+func (r Redirect) Covers(rule Rule) bool {
+	if r.Options["enabled"] == "0" {
+		return false
+	}
+	if r.Options["target"] != "" && r.Options["target"] != "DNAT" {
+		return false
+	}
+	if !protoMatches(r.Options["proto"], rule.Proto) {
+		return false
+	}
+	destPort := r.Options["dest_port"]
+	if destPort == "" {
+		destPort = r.Options["src_dport"] // OpenWrt defaults the internal port to the external one
+	}
+	return r.Options["src_dport"] == strconv.Itoa(rule.SrcPort) &&
+		r.Options["dest_ip"] == rule.DestIP &&
+		destPort == strconv.Itoa(rule.DestPort)
+}
+
+// protoMatches reports whether a uci proto option ("tcp", "tcp udp", "tcpudp", or empty
+// for the default tcp+udp) includes proto.
+func protoMatches(option, proto string) bool {
+	if option == "" || option == "all" {
+		return true
+	}
+	for _, p := range strings.Fields(option) {
+		if p == proto || (p == "tcpudp" && (proto == "tcp" || proto == "udp")) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// firewall.@redirect[3].src_dport='8080' or firewall.@redirect[3]=redirect
+	anonymousLine = regexp.MustCompile(`^firewall\.@redirect\[(\d+)\](?:\.(\w+))?=(.*)$`)
+	// firewall.cfg0a3c7b.src_dport='8080' or firewall.web=redirect
+	namedLine = regexp.MustCompile(`^firewall\.(\w+)(?:\.(\w+))?=(.*)$`)
+)
+
+// ParseRedirects extracts the redirect sections from the output of `uci show firewall`,
+// grouping options by section rather than relying on their order. Both anonymous
+// (firewall.@redirect[N]) and named sections are understood. uci prints sections in
+// order, so a named section's index is the number of redirects listed before it.
+func ParseRedirects(output string) ([]Redirect, error) {
+	var redirects []*Redirect
+	byIndex := make(map[int]*Redirect)
+	named := make(map[string]*Redirect)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var r *Redirect
+		var option, value string
+		if m := anonymousLine.FindStringSubmatch(line); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			if r = byIndex[index]; r == nil {
+				r = &Redirect{Index: index, Options: make(map[string]string)}
+				byIndex[index] = r
+				redirects = append(redirects, r)
+			}
+			option, value = m[2], m[3]
+		} else if m := namedLine.FindStringSubmatch(line); m != nil {
+			section := m[1]
+			option, value = m[2], m[3]
+			if option == "" && value == "redirect" {
+				r = &Redirect{Index: len(redirects), Section: section, Options: make(map[string]string)}
+				named[section] = r
+				redirects = append(redirects, r)
+				continue
+			}
+			if r = named[section]; r == nil {
+				continue // Zones, rules and other section types
+			}
+		} else {
+			continue
+		}
+
+		if option == "" {
+			continue // Section declaration
+		}
+		decoded, err := unquoteUCI(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q: %w", line, err)
+		}
+		r.Options[option] = decoded
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]Redirect, len(redirects))
+	for i, r := range redirects {
+		result[i] = *r
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Index < result[j].Index })
+	return result, nil
+}
+
+// unquoteUCI decodes a value as printed by uci show: single quoted, with an embedded
+// quote written as closing quote, backslash-quote, opening quote, and lists as space
+// separated quoted items, which are joined with spaces.
+func unquoteUCI(value string) (string, error) {
+	if !strings.HasPrefix(value, "'") {
+		return value, nil // Older uci versions print simple values unquoted
+	}
+
+	var items []string
+	var current strings.Builder
+	inQuote := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+			if !inQuote && (i+1 == len(value) || value[i+1] == ' ') {
+				items = append(items, current.String())
+				current.Reset()
+			}
+		case c == '\\' && !inQuote && i+1 < len(value) && value[i+1] == '\'':
+			current.WriteByte('\'')
+			i++
+		case c == ' ' && !inQuote:
+			// Separator between list items
+		default:
+			if !inQuote {
+				return "", fmt.Errorf("unexpected %q outside quotes", c)
+			}
+			current.WriteByte(c)
+		}
+	}
+	if inQuote {
+		return "", fmt.Errorf("unterminated quote")
+	}
+	return strings.Join(items, " "), nil
+}
+
+// quoteShell quotes s for the router's shell.
+func quoteShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,61 @@
+package portforward
+
+import (
+	"reflect"
+	"testing"
+)
+
+const uciShowFirewall = `firewall.@defaults[0]=defaults
+firewall.@defaults[0].input='ACCEPT'
+firewall.@zone[0]=zone
+firewall.@zone[0].name='lan'
+firewall.@zone[0].network='lan' 'lan6'
+firewall.@redirect[0]=redirect
+firewall.@redirect[0].name='docklet-tcp-8080'
+firewall.@redirect[0].src_dport='8080'
+firewall.@redirect[0].dest_ip='192.168.1.10'
+firewall.web=redirect
+firewall.web.name='Bob'\''s web server'
+firewall.web.proto='tcp' 'udp'
+firewall.web.src_dport=443
+firewall.wan_ssh=rule
+firewall.wan_ssh.name='Allow-SSH'
+firewall.@redirect[2]=redirect
+firewall.@redirect[2].name='docklet-udp-51820'
+firewall.@redirect[2].enabled='0'
+`
+
+func TestParseRedirects(t *testing.T) {
+	redirects, err := ParseRedirects(uciShowFirewall)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Redirect{
+		{Index: 0, Options: map[string]string{"name": "docklet-tcp-8080", "src_dport": "8080", "dest_ip": "192.168.1.10"}},
+		{Index: 1, Section: "web", Options: map[string]string{"name": "Bob's web server", "proto": "tcp udp", "src_dport": "443"}},
+		{Index: 2, Options: map[string]string{"name": "docklet-udp-51820", "enabled": "0"}},
+	}
+	if !reflect.DeepEqual(redirects, want) {
+		t.Errorf("ParseRedirects() =\n%+v\nwant\n%+v", redirects, want)
+	}
+	if !redirects[0].Owned() || redirects[1].Owned() {
+		t.Error("only docklet- redirects are owned")
+	}
+}
+
+func TestParseRedirectsOptionsBeforeDeclaration(t *testing.T) {
+	// Options are grouped by section even if a line for another section comes in between
+	redirects, err := ParseRedirects("firewall.@redirect[1].name='b'\nfirewall.@redirect[0].name='a'\nfirewall.@redirect[1].src_dport='2'\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redirects) != 2 || redirects[0].Name() != "a" || redirects[1].Name() != "b" || redirects[1].Options["src_dport"] != "2" {
+		t.Errorf("unexpected redirects %+v", redirects)
+	}
+}
+
+func TestParseRedirectsInvalidQuoting(t *testing.T) {
+	if _, err := ParseRedirects("firewall.@redirect[0].name='unterminated\n"); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
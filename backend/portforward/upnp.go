@@ -0,0 +1,296 @@
+package portforward
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ssdpAddr = "239.255.255.250:1900"
+
+// Service types that can add port mappings, in order of preference.
+var igdServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// UPnP error codes AddPortMapping can answer with that we handle.
+const upnpOnlyPermanentLeases = 725
+
+// UPnPMapper maps ports on an Internet Gateway Device with the SOAP actions
+// AddPortMapping and DeletePortMapping.
+type UPnPMapper struct {
+	rootURL string // Device description; found with SSDP if empty
+	client  *http.Client
+
+	mu          sync.Mutex
+	controlURL  string
+	serviceType string
+}
+
+// NewUPnPMapper creates a mapper for the IGD whose root device description is at rootURL,
+// or the first one that answers an SSDP search if rootURL is empty.
+func NewUPnPMapper(rootURL string, timeout time.Duration) *UPnPMapper {
+	return &UPnPMapper{rootURL: rootURL, client: &http.Client{Timeout: timeout}}
+}
+
+// Name implements Mapper.
+func (m *UPnPMapper) Name() string { return "upnp" }
+
+// Map implements Mapper. Gateways that only support permanent mappings get one; Unmap
+// still removes it.
+func (m *UPnPMapper) Map(ctx context.Context, rule Rule, lifetime time.Duration) (int, time.Duration, error) {
+	args := func(lifetime time.Duration) [][2]string {
+		return [][2]string{
+			{"NewRemoteHost", ""},
+			{"NewExternalPort", strconv.Itoa(rule.SrcPort)},
+			{"NewProtocol", strings.ToUpper(rule.Proto)},
+			{"NewInternalPort", strconv.Itoa(rule.DestPort)},
+			{"NewInternalClient", rule.DestIP},
+			{"NewEnabled", "1"},
+			{"NewPortMappingDescription", rule.Name},
+			{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+		}
+	}
+	err := m.call(ctx, "AddPortMapping", args(lifetime))
+	var upnpErr *UPnPError
+	if errors.As(err, &upnpErr) && upnpErr.Code == upnpOnlyPermanentLeases {
+		return rule.SrcPort, 0, m.call(ctx, "AddPortMapping", args(0))
+	}
+	return rule.SrcPort, lifetime, err
+}
+
+// Unmap implements Mapper.
+func (m *UPnPMapper) Unmap(ctx context.Context, rule Rule) error {
+	return m.call(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(rule.SrcPort)},
+		{"NewProtocol", strings.ToUpper(rule.Proto)},
+	})
+}
+
+// LocalIP implements Mapper.
+func (m *UPnPMapper) LocalIP(ctx context.Context) (string, error) {
+	controlURL, _, err := m.service(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(controlURL)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	return localIPTowards(net.JoinHostPort(u.Hostname(), port))
+}
+
+// UPnPError is an error reported by the gateway in a SOAP fault.
+type UPnPError struct {
+	Action      string
+	Code        int
+	Description string
+}
+
+func (e *UPnPError) Error() string {
+	return fmt.Sprintf("UPnP %s failed: %d %s", e.Action, e.Code, e.Description)
+}
+
+// call invokes a SOAP action on the gateway's WAN connection service.
+func (m *UPnPMapper) call(ctx context.Context, action string, args [][2]string) error {
+	controlURL, serviceType, err := m.service(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + serviceType + `">`)
+	for _, arg := range args {
+		body.WriteString("<" + arg[0] + ">")
+		xml.EscapeText(&body, []byte(arg[1]))
+		body.WriteString("</" + arg[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+"#"+action+`"`)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.forget() // The gateway may have moved; discover it again next time
+		return fmt.Errorf("UPnP %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var fault struct {
+		Code        int    `xml:"Body>Fault>detail>UPnPError>errorCode"`
+		Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+	}
+	if xml.Unmarshal(data, &fault) == nil && fault.Code != 0 {
+		return &UPnPError{Action: action, Code: fault.Code, Description: fault.Description}
+	}
+	return fmt.Errorf("UPnP %s: %s", action, resp.Status)
+}
+
+// service returns the control URL and type of the gateway's WAN connection service,
+// discovering it on first use.
+func (m *UPnPMapper) service(ctx context.Context) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.controlURL != "" {
+		return m.controlURL, m.serviceType, nil
+	}
+
+	rootURL := m.rootURL
+	if rootURL == "" {
+		found, err := discoverIGD(ctx, m.client.Timeout)
+		if err != nil {
+			return "", "", err
+		}
+		rootURL = found
+	}
+	controlURL, serviceType, err := m.describe(ctx, rootURL)
+	if err != nil {
+		return "", "", err
+	}
+	m.controlURL, m.serviceType = controlURL, serviceType
+	return controlURL, serviceType, nil
+}
+
+func (m *UPnPMapper) forget() {
+	m.mu.Lock()
+	m.controlURL, m.serviceType = "", ""
+	m.mu.Unlock()
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// describe fetches the root device description and finds the WAN connection service,
+// which IGDs nest two devices deep.
+func (m *UPnPMapper) describe(ctx context.Context, rootURL string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rootURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("fetching UPnP device description: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("fetching UPnP device description %s: %s", rootURL, resp.Status)
+	}
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return "", "", fmt.Errorf("parsing UPnP device description %s: %w", rootURL, err)
+	}
+
+	base, err := url.Parse(rootURL)
+	if err != nil {
+		return "", "", err
+	}
+	if root.URLBase != "" {
+		if b, err := url.Parse(root.URLBase); err == nil {
+			base = b
+		}
+	}
+	for _, serviceType := range igdServiceTypes {
+		if controlURL, ok := findService(root.Device, serviceType); ok {
+			ref, err := url.Parse(controlURL)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid UPnP control URL %q: %w", controlURL, err)
+			}
+			return base.ResolveReference(ref).String(), serviceType, nil
+		}
+	}
+	return "", "", fmt.Errorf("%s is not an Internet Gateway Device with a WAN connection service", rootURL)
+}
+
+func findService(device upnpDevice, serviceType string) (string, bool) {
+	for _, service := range device.Services {
+		if service.ServiceType == serviceType {
+			return service.ControlURL, true
+		}
+	}
+	for _, child := range device.Devices {
+		if controlURL, ok := findService(child, serviceType); ok {
+			return controlURL, true
+		}
+	}
+	return "", false
+}
+
+// discoverIGD sends an SSDP search for Internet Gateway Devices and returns the
+// description URL of the first to answer.
+func discoverIGD(ctx context.Context, timeout time.Duration) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	for _, st := range []string{
+		"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+		"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	} {
+		search := "M-SEARCH * HTTP/1.1\r\nHOST: " + ssdpAddr + "\r\nST: " + st + "\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+			return "", fmt.Errorf("sending SSDP search: %w", err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP Internet Gateway Device answered (set DOCKLET_UPNP_URL to skip discovery): %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package portforward
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const igdDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+        <controlURL>/ctl/L3F</controlURL>
+      </service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const wanIPConnection1 = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+// soapCall is an action a fakeIGD received.
+type soapCall struct {
+	Action string
+	Args   map[string]string
+}
+
+// fakeIGD is an Internet Gateway Device answering the SOAP actions UPnPMapper sends.
+type fakeIGD struct {
+	permanentOnly bool // Answer leases other than 0 with error 725, like many routers
+	fault         int  // Answer every action with this UPnP error code
+
+	mu    sync.Mutex
+	calls []soapCall
+}
+
+func (g *fakeIGD) start(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, igdDescription)
+	})
+	mux.HandleFunc("POST /ctl/IPConn", g.control)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (g *fakeIGD) control(w http.ResponseWriter, r *http.Request) {
+	service, action, ok := strings.Cut(strings.Trim(r.Header.Get("SOAPAction"), `"`), "#")
+	if !ok || service != wanIPConnection1 {
+		http.Error(w, "bad SOAPAction "+r.Header.Get("SOAPAction"), http.StatusBadRequest)
+		return
+	}
+	args, name, err := parseSOAPArgs(r.Body)
+	if err != nil || name.Local != action || name.Space != service {
+		http.Error(w, "body doesn't match SOAPAction", http.StatusBadRequest)
+		return
+	}
+	g.mu.Lock()
+	g.calls = append(g.calls, soapCall{Action: action, Args: args})
+	g.mu.Unlock()
+
+	code, description := g.fault, "Simulated"
+	if code == 0 && g.permanentOnly && action == "AddPortMapping" && args["NewLeaseDuration"] != "0" {
+		code, description = upnpOnlyPermanentLeases, "OnlyPermanentLeasesSupported"
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	if code != 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+			`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+			`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>`+strconv.Itoa(code)+`</errorCode><errorDescription>`+description+`</errorDescription></UPnPError>`+
+			`</detail></s:Fault></s:Body></s:Envelope>`)
+		return
+	}
+	io.WriteString(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+		`<u:`+action+`Response xmlns:u="`+service+`"/></s:Body></s:Envelope>`)
+}
+
+func (g *fakeIGD) recorded() []soapCall {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]soapCall(nil), g.calls...)
+}
+
+// parseSOAPArgs returns the arguments of the action in a SOAP envelope, and its name.
+func parseSOAPArgs(body io.Reader) (map[string]string, xml.Name, error) {
+	var envelope struct {
+		Body struct {
+			Action struct {
+				XMLName xml.Name
+				Args    []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(body).Decode(&envelope); err != nil {
+		return nil, xml.Name{}, err
+	}
+	args := make(map[string]string)
+	for _, arg := range envelope.Body.Action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+	return args, envelope.Body.Action.XMLName, nil
+}
+
+var webRule = Rule{Name: "docklet-tcp-8443", Proto: "tcp", SrcPort: 8443, DestIP: "192.168.1.10", DestPort: 443, Service: "web"}
+
+func TestUPnPMap(t *testing.T) {
+	igd := &fakeIGD{}
+	server := igd.start(t)
+	mapper := NewUPnPMapper(server.URL+"/rootDesc.xml", 5*time.Second)
+
+	port, granted, err := mapper.Map(context.Background(), webRule, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 8443 || granted != time.Hour {
+		t.Errorf("Map() = %d, %s; want 8443, 1h", port, granted)
+	}
+	if err := mapper.Unmap(context.Background(), webRule); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := igd.recorded()
+	if len(calls) != 2 {
+		t.Fatalf("calls = %+v, want AddPortMapping and DeletePortMapping", calls)
+	}
+	add := calls[0]
+	want := map[string]string{
+		"NewRemoteHost":             "",
+		"NewExternalPort":           "8443",
+		"NewProtocol":               "TCP",
+		"NewInternalPort":           "443",
+		"NewInternalClient":         "192.168.1.10",
+		"NewEnabled":                "1",
+		"NewPortMappingDescription": "docklet-tcp-8443",
+		"NewLeaseDuration":          "3600",
+	}
+	if add.Action != "AddPortMapping" || len(add.Args) != len(want) {
+		t.Errorf("first call = %+v, want AddPortMapping with %v", add, want)
+	}
+	for k, v := range want {
+		if add.Args[k] != v {
+			t.Errorf("AddPortMapping %s = %q, want %q", k, add.Args[k], v)
+		}
+	}
+	del := calls[1]
+	if del.Action != "DeletePortMapping" || del.Args["NewExternalPort"] != "8443" || del.Args["NewProtocol"] != "TCP" || len(del.Args) != 3 {
+		t.Errorf("second call = %+v, want DeletePortMapping of TCP 8443", del)
+	}
+}
+
+func TestUPnPMapPermanentOnly(t *testing.T) {
+	igd := &fakeIGD{permanentOnly: true}
+	server := igd.start(t)
+	mapper := NewUPnPMapper(server.URL+"/rootDesc.xml", 5*time.Second)
+
+	port, granted, err := mapper.Map(context.Background(), webRule, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 8443 || granted != 0 {
+		t.Errorf("Map() = %d, %s; want 8443 and a permanent mapping", port, granted)
+	}
+	calls := igd.recorded()
+	if len(calls) != 2 || calls[0].Args["NewLeaseDuration"] != "3600" || calls[1].Args["NewLeaseDuration"] != "0" {
+		t.Errorf("calls = %+v, want a retry with lease 0 after error 725", calls)
+	}
+}
+
+func TestUPnPFault(t *testing.T) {
+	igd := &fakeIGD{fault: 718}
+	server := igd.start(t)
+	mapper := NewUPnPMapper(server.URL+"/rootDesc.xml", 5*time.Second)
+
+	_, _, err := mapper.Map(context.Background(), webRule, time.Hour)
+	var upnpErr *UPnPError
+	if !errors.As(err, &upnpErr) || upnpErr.Code != 718 || upnpErr.Action != "AddPortMapping" {
+		t.Fatalf("Map() error = %v, want UPnP error 718", err)
+	}
+	if calls := igd.recorded(); len(calls) != 1 {
+		t.Errorf("calls = %+v, want no retry for errors other than 725", calls)
+	}
+}
+
+func TestUPnPNotAGateway(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<root><device><deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType></device></root>`)
+	}))
+	defer server.Close()
+	mapper := NewUPnPMapper(server.URL, 5*time.Second)
+
+	if _, _, err := mapper.Map(context.Background(), webRule, time.Hour); err == nil || !strings.Contains(err.Error(), "not an Internet Gateway Device") {
+		t.Errorf("Map() error = %v, want not an Internet Gateway Device", err)
+	}
+}
@@ -0,0 +1,284 @@
+// Package proxy forwards requests for <name>.<domain>, and /s/<name>/ on <domain> itself, to
+// discovered services at their internal address, so their ports don't need to be published
+// on the host. Services are never served on Docklet's own origin, where their pages could use
+// Docklet's API as if they were Docklet's.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"docklet/catalog"
+	dockerscanner "docklet/docker_scanner"
+)
+
+// DefaultAllow are the networks allowed to use the proxy by default: loopback and
+// private addresses.
+const DefaultAllow = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7,fe80::/10"
+
+// Proxy forwards requests to the services it knows routes for. Routes are refreshed in
+// the background, so requests don't wait for Docker.
+type Proxy struct {
+	Domain   string          // Services are served as <name>.<Domain> and <Domain>/s/<name>/
+	Networks []string        // Docker networks to reach containers on, in order of preference
+	System   map[string]bool // System services to proxy, by name
+	Allow    []*net.IPNet    // Client addresses allowed to use the proxy
+	Interval time.Duration
+
+	// TLS-terminating proxies in front of Docklet, whose X-Forwarded-Proto is passed on
+	TrustedProxies []*net.IPNet
+
+	transport *http.Transport
+	mu        sync.RWMutex
+	routes    map[string]Route // Keyed by name
+}
+
+// NewProxy creates a Proxy configured from environment variables:
+//
+//	DOCKLET_PROXY_DOMAIN           domain to serve services under as <name>.<domain> and <domain>/s/<name>/ (required)
+//	DOCKLET_PROXY_NETWORKS         comma-separated Docker networks shared with Docklet to reach containers on
+//	                               (default: the first network of each container by name)
+//	DOCKLET_PROXY_SYSTEM_SERVICES  comma-separated system services to proxy (default: none)
+//	DOCKLET_PROXY_ALLOW            comma-separated client networks allowed, or "any" (default: loopback and private networks)
+//	DOCKLET_PROXY_INTERVAL         time between checks for started and stopped services (default 15s)
+//	DOCKLET_PROXY_INSECURE         "false" enables certificate verification for https services
+//
+// Containers are proxied if labelled docklet.proxy=true.
+func NewProxy() (*Proxy, error) {
+	domain := strings.Trim(strings.ToLower(dockerscanner.GetEnvOrDefault("DOCKLET_PROXY_DOMAIN", "")), ".")
+	if domain == "" {
+		return nil, fmt.Errorf("DOCKLET_PROXY_DOMAIN is required: services are served on their own host names, never on Docklet's")
+	}
+	var networks []string
+	for _, name := range strings.Split(dockerscanner.GetEnvOrDefault("DOCKLET_PROXY_NETWORKS", ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			networks = append(networks, name)
+		}
+	}
+	system := make(map[string]bool)
+	for _, name := range strings.Split(dockerscanner.GetEnvOrDefault("DOCKLET_PROXY_SYSTEM_SERVICES", ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			system[name] = true
+		}
+	}
+	allowSpec := dockerscanner.GetEnvOrDefault("DOCKLET_PROXY_ALLOW", DefaultAllow)
+	if allowSpec == "any" {
+		allowSpec = "0.0.0.0/0,::/0"
+	}
+	allow, err := ParseNetworks(allowSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_PROXY_ALLOW: %w", err)
+	}
+	interval, err := time.ParseDuration(dockerscanner.GetEnvOrDefault("DOCKLET_PROXY_INTERVAL", "15s"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid DOCKLET_PROXY_INTERVAL: must be a positive duration")
+	}
+
+	// Services behind the proxy are addressed by IP and often use self-signed certificates
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: dockerscanner.GetEnvOrDefault("DOCKLET_PROXY_INSECURE", "true") != "false"}
+
+	return &Proxy{
+		Domain:    domain,
+		Networks:  networks,
+		System:    system,
+		Allow:     allow,
+		Interval:  interval,
+		transport: transport,
+		routes:    make(map[string]Route),
+	}, nil
+}
+
+// ParseNetworks parses a comma-separated list of networks in CIDR notation and single
+// addresses, such as "192.168.0.0/16,10.0.0.1".
+func ParseNetworks(spec string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, network := range strings.Split(spec, ",") {
+		if network = strings.TrimSpace(network); network == "" {
+			continue
+		}
+		if !strings.Contains(network, "/") {
+			if ip := net.ParseIP(network); ip != nil && ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, nil
+}
+
+// Run refreshes the routes from collect every Interval until ctx is cancelled.
+func (p *Proxy) Run(ctx context.Context, collect func(context.Context) ([]catalog.Entry, error)) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := collect(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			// Keep the routes we have rather than dropping every service because Docker hiccupped
+			log.Printf("Proxy: failed to collect services: %v", err)
+		default:
+			next := make(map[string]Route)
+			for _, route := range routes(entries, p.Networks, p.Domain, p.System) {
+				next[route.Name] = route
+			}
+			p.mu.Lock()
+			p.routes = next
+			p.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Routes returns the services reachable through the proxy, sorted by name.
+func (p *Proxy) Routes() []Route {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	result := make([]Route, 0, len(p.routes))
+	for _, route := range p.routes {
+		result = append(result, route)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Lookup returns the route with the given name.
+func (p *Proxy) Lookup(name string) (Route, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	route, ok := p.routes[strings.ToLower(name)]
+	return route, ok
+}
+
+// NameForHost returns the service name in a <name>.<domain> host, with or without a port,
+// and whether host is under the domain at all.
+func (p *Proxy) NameForHost(host string) (string, bool) {
+	name, ok := strings.CutSuffix(hostName(host), "."+p.Domain)
+	if !ok || name == "" || strings.Contains(name, ".") {
+		return "", false
+	}
+	return name, true
+}
+
+// IsPathHost reports whether host, with or without a port, is the domain itself, which
+// serves services at /s/<name>/ and nothing else.
+func (p *Proxy) IsPathHost(host string) bool {
+	return hostName(host) == p.Domain
+}
+
+// hostName returns host in lower case, without a port or a trailing dot.
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Allowed reports whether a client at ip may use the proxy.
+func (p *Proxy) Allowed(ip net.IP) bool {
+	return containsIP(p.Allow, ip)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scheme returns the scheme a client used to reach Docklet: how r arrived, unless it came
+// from one of trustedProxies, TLS-terminating proxies whose X-Forwarded-Proto says how the
+// client reached them. Anyone else's X-Forwarded-Proto is ignored.
+func Scheme(r *http.Request, trustedProxies []*net.IPNet) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); (proto == "http" || proto == "https") && fromTrustedProxy(r, trustedProxies) {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// fromTrustedProxy reports whether r's connection comes from one of trustedProxies.
+func fromTrustedProxy(r *http.Request, trustedProxies []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && containsIP(trustedProxies, ip)
+}
+
+// Forward forwards r to route. prefix is the path the route is mounted at, such as
+// /s/grafana, and is stripped from the request and added to redirects and cookie paths; it
+// is "" for host routes, which keep the client's Host header. WebSocket upgrades are passed
+// through. onError writes the response when the service can't be reached.
+func (p *Proxy) Forward(w http.ResponseWriter, r *http.Request, route Route, prefix string, onError func(http.ResponseWriter, *http.Request, error)) {
+	scheme := Scheme(r, p.TrustedProxies)
+	publicOrigin := scheme + "://" + r.Host
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(route.target)
+			pr.Out.URL.Path = singleSlash(route.target.Path, strings.TrimPrefix(pr.In.URL.Path, prefix))
+			pr.Out.URL.RawPath = ""
+			pr.SetXForwarded()
+			pr.Out.Header.Set("X-Forwarded-Proto", scheme) // Docklet may itself be behind a proxy
+			if prefix != "" {
+				pr.Out.Header.Set("X-Forwarded-Prefix", prefix)
+			} else {
+				pr.Out.Host = pr.In.Host
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if location := resp.Header.Get("Location"); location != "" {
+				resp.Header.Set("Location", rewriteLocation(location, route.target, publicOrigin, prefix))
+			}
+			if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 && prefix != "" {
+				resp.Header.Del("Set-Cookie")
+				for _, cookie := range cookies {
+					resp.Header.Add("Set-Cookie", rewriteCookiePath(cookie, prefix))
+				}
+			}
+			return nil
+		},
+		Transport:     p.transport,
+		FlushInterval: -1, // Stream server-sent events and long polls as they come
+		ErrorHandler:  onError,
+	}
+	rp.ServeHTTP(w, r)
+}
+
+func singleSlash(base, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func networks(t *testing.T, spec string) []*net.IPNet {
+	t.Helper()
+	n, err := ParseNetworks(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestScheme(t *testing.T) {
+	trusted := networks(t, "192.168.1.2,fd00::2")
+	for _, tt := range []struct {
+		name   string
+		remote string
+		tls    bool
+		proto  string // X-Forwarded-Proto
+		want   string
+	}{
+		{"plain", "192.168.1.20:50000", false, "", "http"},
+		{"tls", "192.168.1.20:50000", true, "", "https"},
+		{"trusted proxy", "192.168.1.2:50000", false, "https", "https"},
+		{"trusted proxy over ipv6", "[fd00::2]:50000", false, "https", "https"},
+		{"trusted proxy, plain client", "192.168.1.2:50000", true, "http", "http"},
+		{"trusted proxy, bogus proto", "192.168.1.2:50000", false, "wss", "http"},
+		{"untrusted client", "192.168.1.20:50000", false, "https", "http"},
+		{"untrusted client downgrading", "192.168.1.20:50000", true, "http", "https"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if got := Scheme(r, trusted); got != tt.want {
+				t.Errorf("Scheme() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardProto(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "http://"+r.Host+"/login")
+		w.WriteHeader(http.StatusFound)
+		io.WriteString(w, r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	route := Route{Name: "grafana", target: target}
+
+	for _, tt := range []struct {
+		name         string
+		trusted      string
+		proto        string
+		wantProto    string
+		wantLocation string
+	}{
+		{"trusted proxy", "127.0.0.1", "https", "https", "https://docklet.test/s/grafana/login"},
+		{"untrusted client", "10.0.0.0/8", "https", "http", "http://docklet.test/s/grafana/login"},
+		{"no header", "127.0.0.1", "", "http", "http://docklet.test/s/grafana/login"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{TrustedProxies: networks(t, tt.trusted), transport: http.DefaultTransport.(*http.Transport).Clone()}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p.Forward(w, r, route, "/s/grafana", func(w http.ResponseWriter, r *http.Request, err error) {
+					t.Errorf("forwarding failed: %v", err)
+					w.WriteHeader(http.StatusBadGateway)
+				})
+			}))
+			defer server.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/s/grafana/", nil)
+			req.Host = "docklet.test"
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.wantProto {
+				t.Errorf("service got X-Forwarded-Proto %q, want %q", body, tt.wantProto)
+			}
+			if location := resp.Header.Get("Location"); location != tt.wantLocation {
+				t.Errorf("Location = %s, want %s", location, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"docklet/catalog"
+)
+
+// Container labels controlling the proxy.
+const (
+	NameLabel    = "docklet.proxy.name" // Name in /s/<name>/ and <name>.<domain> instead of the service name
+	EnabledLabel = "docklet.proxy"      // "true" puts a container behind the proxy
+)
+
+// Route is a service reachable through the proxy.
+type Route struct {
+	Name    string `json:"name"`           // In /s/<name>/ and <name>.<domain>
+	EntryID string `json:"entry_id"`       // Catalog entry the route comes from
+	Target  string `json:"target"`         // Internal address requests are forwarded to, e.g. http://172.18.0.5:3000
+	Path    string `json:"path"`           // On the domain's own host, e.g. /s/grafana/ on home.example
+	Host    string `json:"host,omitempty"` // e.g. grafana.home.example
+	target  *url.URL
+}
+
+// routes turns the catalog entries that opted into the proxy into routes. Containers are
+// reached on their IP in one of networks, or the first network they have an IP in if
+// networks is empty, at the port inside the container; others, and containers without such
+// an address, at their URL. Names taken by another service get a -2, -3... suffix.
+func routes(entries []catalog.Entry, networks []string, domain string, system map[string]bool) []Route {
+	sorted := append([]catalog.Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var result []Route
+	taken := make(map[string]bool)
+	for _, entry := range sorted {
+		target := targetURL(entry, networks)
+		if target == nil || !proxied(entry, target, system) {
+			continue
+		}
+		name := entry.Labels[NameLabel]
+		if name == "" {
+			name = entry.Name
+		}
+		base := catalog.HostLabel(name)
+		if base == "" {
+			continue
+		}
+		name = catalog.UniqueLabel(base, func(label string) bool { return taken[label] })
+		taken[name] = true
+
+		result = append(result, Route{
+			Name:    name,
+			EntryID: entry.ID,
+			Target:  target.String(),
+			Path:    "/s/" + name + "/",
+			Host:    name + "." + domain,
+			target:  target,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// proxied reports whether an entry opted into the proxy: containers labelled
+// docklet.proxy=true, and system services named in system. A system service is only
+// proxied if its target port is known to listen on an address other than loopback, since
+// binding to loopback is how an operator keeps a service off the network. Links point
+// elsewhere and are never proxied.
+func proxied(entry catalog.Entry, target *url.URL, system map[string]bool) bool {
+	switch entry.Source {
+	case catalog.SourceDocker:
+		return entry.Labels[EnabledLabel] == "true"
+	case catalog.SourceSystem:
+		if !system[entry.Name] || entry.System == nil {
+			return false
+		}
+		for _, address := range entry.System.ListenAddresses[target.Port()] {
+			if ip := net.ParseIP(address); ip != nil && !ip.IsLoopback() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// targetURL returns the address to forward an entry's requests to, or nil if it has none.
+// Containers only reachable on their network address have no URL, and are forwarded to
+// over plain HTTP.
+func targetURL(entry catalog.Entry, networks []string) *url.URL {
+	target := &url.URL{Scheme: "http"}
+	if u, err := url.Parse(entry.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		target.Scheme, target.Host = u.Scheme, u.Host
+	}
+
+	if d := entry.Docker; d != nil && d.InternalPort > 0 {
+		if ip := networkIP(d.NetworkIPs, networks); ip != "" {
+			target.Host = net.JoinHostPort(ip, strconv.Itoa(d.InternalPort))
+		}
+	}
+	if s := entry.System; s != nil && target.Port() != "" {
+		if ip := boundIP(s.ListenAddresses[target.Port()]); ip != "" {
+			target.Host = net.JoinHostPort(ip, target.Port())
+		}
+	}
+	if target.Host == "" {
+		return nil
+	}
+	return target
+}
+
+// boundIP returns the first address other than loopback a port is bound on, or "" if it's
+// bound on all addresses, where the service's URL reaches it too.
+func boundIP(addresses []string) string {
+	var bound string
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		switch {
+		case ip == nil || ip.IsUnspecified():
+			return ""
+		case bound == "" && !ip.IsLoopback():
+			bound = address
+		}
+	}
+	return bound
+}
+
+// networkIP returns the IP in the first of networks the container is attached to, or with
+// no networks given, in the first network by name.
+func networkIP(ips map[string]string, networks []string) string {
+	if len(networks) == 0 {
+		for name := range ips {
+			networks = append(networks, name)
+		}
+		sort.Strings(networks)
+	}
+	for _, name := range networks {
+		if ip := ips[name]; ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// rewriteLocation maps a redirect from the service back to the address the client used:
+// absolute URLs pointing at the target get the public origin, and absolute paths get the
+// prefix the route is mounted at.
+func rewriteLocation(location string, target *url.URL, publicOrigin, prefix string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	switch {
+	case u.Host == "" && strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(location, "//"):
+		return prefix + location
+	case u.Host != "" && strings.EqualFold(u.Host, target.Host):
+		return publicOrigin + prefix + strings.TrimPrefix(location, u.Scheme+"://"+u.Host)
+	}
+	return location
+}
+
+// rewriteCookiePath moves a cookie's Path attribute under prefix, so cookies of services
+// mounted under /s/<name>/ don't leak to each other.
+func rewriteCookiePath(cookie, prefix string) string {
+	if prefix == "" {
+		return cookie
+	}
+	// Without a Path, browsers default to the directory of the public URL, already under prefix
+	parts := strings.Split(cookie, ";")
+	for i, part := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if strings.EqualFold(name, "path") && strings.HasPrefix(value, "/") {
+			parts[i+1] = " Path=" + prefix + value
+		}
+	}
+	return strings.Join(parts, ";")
+}
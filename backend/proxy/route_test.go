@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"docklet/catalog"
+)
+
+func container(id, name string, labels map[string]string) catalog.Entry {
+	return catalog.Entry{
+		ID:     "docker:" + id,
+		Source: catalog.SourceDocker,
+		Name:   name,
+		URL:    "http://nas.lan:8080",
+		Labels: labels,
+		Docker: &catalog.DockerDetails{NetworkIPs: map[string]string{"proxy": "172.18.0.5", "bridge": "172.17.0.5"}, InternalPort: 3000},
+	}
+}
+
+func system(name, url string, addresses ...string) catalog.Entry {
+	return catalog.Entry{
+		ID:     "system:" + name,
+		Source: catalog.SourceSystem,
+		Name:   name,
+		URL:    url,
+		System: &catalog.SystemDetails{ListenAddresses: map[string][]string{"8080": addresses}},
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	entries := []catalog.Entry{
+		container("a", "grafana", map[string]string{EnabledLabel: "true"}),
+		container("b", "grafana", map[string]string{EnabledLabel: "true"}),
+		container("c", "prometheus", nil),
+		container("d", "loki", map[string]string{EnabledLabel: "false"}),
+		container("e", "other", map[string]string{EnabledLabel: "true", NameLabel: "Dash Board"}),
+		system("nginx.service", "http://localhost:8080", "0.0.0.0"),
+		system("lan.service", "http://localhost:8080", "127.0.0.1", "192.168.1.10"),
+		system("admin.service", "http://localhost:8080", "127.0.0.1", "::1"),
+		system("unknown.service", "http://localhost:8080"),
+		system("unlisted.service", "http://localhost:8080", "0.0.0.0"),
+		{ID: "link:1", Source: catalog.SourceLink, Name: "router", URL: "http://192.168.1.1"},
+	}
+	listed := map[string]bool{"nginx.service": true, "lan.service": true, "admin.service": true, "unknown.service": true}
+
+	var got []string
+	for _, route := range routes(entries, []string{"proxy"}, "home.test", listed) {
+		got = append(got, route.Name+" "+route.EntryID+" "+route.Target+" "+route.Host+" "+route.Path)
+	}
+	want := []string{
+		// Containers opt in with the label, and are reached on the preferred network
+		"dash-board docker:e http://172.18.0.5:3000 dash-board.home.test /s/dash-board/",
+		"grafana docker:a http://172.18.0.5:3000 grafana.home.test /s/grafana/",
+		"grafana-2 docker:b http://172.18.0.5:3000 grafana-2.home.test /s/grafana-2/",
+		// System services opt in by name; a port bound to one LAN address is reached there
+		"lan-service system:lan.service http://192.168.1.10:8080 lan-service.home.test /s/lan-service/",
+		"nginx-service system:nginx.service http://localhost:8080 nginx-service.home.test /s/nginx-service/",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("routes:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestIsPathHost(t *testing.T) {
+	p := &Proxy{Domain: "home.test"}
+	for host, want := range map[string]bool{
+		"home.test":         true,
+		"HOME.test.:8443":   true,
+		"grafana.home.test": false,
+		"nas.lan":           false,
+		"myhome.test":       false,
+	} {
+		if got := p.IsPathHost(host); got != want {
+			t.Errorf("IsPathHost(%q) = %t, want %t", host, got, want)
+		}
+	}
+	for host, want := range map[string]string{
+		"grafana.home.test":      "grafana",
+		"Grafana.Home.Test:8443": "grafana",
+		"home.test":              "",
+		"a.b.home.test":          "",
+		"grafana.myhome.test":    "",
+	} {
+		if got, _ := p.NameForHost(host); got != want {
+			t.Errorf("NameForHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+
+	"docklet/api"
+	"docklet/catalog"
+	"docklet/certcheck"
+	"docklet/certs"
+	"docklet/dnsrecords"
+	"docklet/enricher"
+	"docklet/health"
+	"docklet/links"
+	"docklet/mdns"
+	"docklet/notify"
+	"docklet/portaudit"
+	"docklet/portforward"
+	"docklet/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// components are the parts of Docklet the API routes serve. Optional ones are nil when
+// disabled; their routes stay registered and answer 404 DISABLED.
+type components struct {
+	collector      *catalog.Collector
+	enr            *enricher.Enricher
+	linkStore      *links.Store
+	auditor        *portaudit.Auditor
+	forwards       *portforward.Manager
+	mappings       *portforward.Keeper
+	advertiser     *mdns.Advertiser
+	reverseProxy   *proxy.Proxy
+	trustedProxies []*net.IPNet // TLS-terminating proxies in front of Docklet, whose X-Forwarded-Proto is believed
+	certChecker    *certcheck.Checker
+	certManager    *certs.Manager
+	notifier       *notify.Dispatcher
+	dnsGen         *dnsrecords.Generator
+	checker        *health.Checker
+}
+
+// registerAPIRoutes registers the API under apiRoutes. It is used for /api/v1 and for the
+// unversioned /api aliases kept for existing scripts; only /api/catalog keeps its old
+// bare-list response, so the catalog handler is passed in.
+// Keep in sync with the operations in openapi/openapi.go.
+func registerAPIRoutes(apiRoutes *gin.RouterGroup, catalogHandler gin.HandlerFunc, comp components) {
+	// Other sites can't make browsers change anything
+	apiRoutes.Use(api.SameOriginMiddleware())
+
+	apiRoutes.GET("/services", api.ServicesHandlerGin(comp.collector, comp.enr))                  // Docker services
+	apiRoutes.GET("/system-services", api.SystemServicesHandlerGin(comp.collector))               // Native system services
+	apiRoutes.GET("/catalog", catalogHandler)                                                     // Docker + system services, normalized
+	apiRoutes.GET("/icons/:key", api.IconHandlerGin(comp.enr))                                    // Icons cached by the enricher
+	apiRoutes.GET("/export", api.ExportHandlerGin(comp.collector, comp.enr, comp.trustedProxies)) // Homer/Homepage/Dashy/Heimdall configs
+	apiRoutes.GET("/export/bookmarks.html", api.ExportFormatHandlerGin(comp.collector, comp.enr, comp.trustedProxies, "bookmarks"))
+	apiRoutes.GET("/export/services.opml", api.ExportFormatHandlerGin(comp.collector, comp.enr, comp.trustedProxies, "opml"))
+	apiRoutes.GET("/export/feed.json", api.ExportFormatHandlerGin(comp.collector, comp.enr, comp.trustedProxies, "jsonfeed"))
+	apiRoutes.POST("/import", api.ImportHandlerGin(comp.collector, comp.linkStore)) // Homer/Homepage/bookmarks into stored links
+	apiRoutes.GET("/links", api.LinksHandlerGin(comp.linkStore))
+	apiRoutes.DELETE("/links/:id", api.DeleteLinkHandlerGin(comp.linkStore))
+	apiRoutes.GET("/ports", api.PortsHandlerGin(comp.auditor))                       // Host ports in use, with conflict and exposure warnings
+	apiRoutes.GET("/portforward/plan", api.PortForwardPlanHandlerGin(comp.forwards)) // Router changes the policy asks for
+	apiRoutes.POST("/portforward/apply", api.PortForwardApplyHandlerGin(comp.forwards))
+	apiRoutes.GET("/portforward/mappings", api.PortMappingsHandlerGin(comp.mappings)) // UPnP/NAT-PMP leases
+	apiRoutes.GET("/mdns", api.MDNSHandlerGin(comp.advertiser))                       // Services published with mDNS
+	apiRoutes.GET("/proxy", api.ProxyRoutesHandlerGin(comp.reverseProxy))             // Services reachable through the proxy
+	apiRoutes.GET("/certificates", api.CertificatesHandlerGin(comp.certChecker))      // Certificates of https services by expiry
+	apiRoutes.GET("/notify", api.NotifyStatusHandlerGin(comp.notifier))               // Channels, mutes and quiet hours
+	apiRoutes.POST("/notify/test", api.NotifyTestHandlerGin(comp.notifier))
+	apiRoutes.PUT("/notify/mutes/:service", api.NotifyMuteHandlerGin(comp.notifier))
+	apiRoutes.DELETE("/notify/mutes/:service", api.NotifyUnmuteHandlerGin(comp.notifier))
+	apiRoutes.GET("/tls/ca.pem", api.CACertificateHandlerGin(comp.certManager, false)) // Local CA for devices to trust
+	apiRoutes.GET("/tls/ca.crt", api.CACertificateHandlerGin(comp.certManager, true))
+	apiRoutes.GET("/dns", api.DNSRecordsHandlerGin(comp.dnsGen))        // Host names for local resolvers
+	apiRoutes.GET("/dns/:format", api.DNSFormatHandlerGin(comp.dnsGen)) // hosts, dnsmasq, unbound, ...
+	apiRoutes.GET("/health", api.HealthCheckHandlerGin())
+	apiRoutes.GET("/health/live", api.HealthCheckHandlerGin())
+	apiRoutes.GET("/health/ready", api.ReadinessHandlerGin(comp.checker)) // Per-component status, 503 if not ready
+	apiRoutes.GET("/openapi.json", api.OpenAPIHandlerGin())               // Machine-readable API description
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"docklet/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ginParamPattern = regexp.MustCompile(`[:*]([^/]+)`)
+
+// TestRoutesMatchOpenAPI checks that every route under /api/v1 is documented in the OpenAPI
+// document with the same method, and that the document lists no route that doesn't exist.
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerAPIRoutes(router.Group("/api/v1"), func(*gin.Context) {}, components{})
+
+	routes := make(map[string]bool)
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/v1/") {
+			continue
+		}
+		path := ginParamPattern.ReplaceAllString(route.Path, "{$1}")
+		routes[strings.ToLower(route.Method)+" "+path] = true
+	}
+
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapi.JSON(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			documented[method+" "+path] = true
+		}
+	}
+
+	for _, route := range sortedKeys(routes) {
+		if !documented[route] {
+			t.Errorf("route %s is not in the OpenAPI document", route)
+		}
+	}
+	for _, op := range sortedKeys(documented) {
+		if !routes[op] {
+			t.Errorf("OpenAPI operation %s has no route", op)
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,298 @@
+package systemscanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values for WebEndpoint.Source.
+const (
+	WebSourceProbe = "probe" // An HTTP response was actually received
+	WebSourceHint  = "hint"  // The port is in the configured hint list and could not be probed
+)
+
+// defaultWebPortHints are ports assumed to be web services when they can't be probed.
+// Overridden by DOCKLET_WEB_PORT_HINTS (comma separated).
+var defaultWebPortHints = []int{
+	80,
+	443,
+	3000, // Common for Node.js dev servers
+	3001, // Common for React dev servers (sometimes)
+	5000, // Common for Flask dev servers
+	5173, // Common for Vite dev servers
+	8000, // Common for Python dev servers, Django
+	8080, // Common for Java app servers, other dev servers
+	8888, // Common for Jupyter, other dev servers
+}
+
+// maxConcurrentProbes bounds how many ports are probed at the same time.
+const maxConcurrentProbes = 8
+
+// probeKey identifies a cached probe result. The PID is part of the key so that a
+// different process reusing the port gets probed again.
+type probeKey struct {
+	pid  string
+	host string
+	port string
+}
+
+type probeResult struct {
+	endpoint *WebEndpoint // nil if the port didn't answer HTTP
+	expires  time.Time
+}
+
+// webClassifier decides which listening ports are web services by talking to them.
+type webClassifier struct {
+	probe   bool
+	host    string
+	timeout time.Duration
+	ttl     time.Duration
+	hints   map[int]bool
+
+	mu    sync.Mutex
+	cache map[probeKey]probeResult
+}
+
+// newWebClassifierFromEnv creates a webClassifier configured from environment variables:
+//
+//	DOCKLET_WEB_PROBE          "false" disables active probing, leaving only the hints
+//	DOCKLET_WEB_PROBE_HOST     address to connect to for ports bound on all addresses (default 127.0.0.1)
+//	DOCKLET_WEB_PROBE_TIMEOUT  per connection timeout (default 500ms)
+//	DOCKLET_WEB_PROBE_TTL      how long results are cached per (pid, address, port) (default 5m)
+//	DOCKLET_WEB_PORT_HINTS     comma separated ports assumed to be web when they can't be probed
+func newWebClassifierFromEnv() (*webClassifier, error) {
+	c := &webClassifier{
+		probe: getEnvOrDefault("DOCKLET_WEB_PROBE", "true") != "false",
+		host:  getEnvOrDefault("DOCKLET_WEB_PROBE_HOST", "127.0.0.1"),
+		hints: make(map[int]bool),
+		cache: make(map[probeKey]probeResult),
+	}
+
+	var err error
+	if c.timeout, err = time.ParseDuration(getEnvOrDefault("DOCKLET_WEB_PROBE_TIMEOUT", "500ms")); err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_WEB_PROBE_TIMEOUT: %w", err)
+	}
+	if c.ttl, err = time.ParseDuration(getEnvOrDefault("DOCKLET_WEB_PROBE_TTL", "5m")); err != nil {
+		return nil, fmt.Errorf("invalid DOCKLET_WEB_PROBE_TTL: %w", err)
+	}
+
+	hints := defaultWebPortHints
+	if value := getEnvOrDefault("DOCKLET_WEB_PORT_HINTS", ""); value != "" {
+		hints = nil
+		for _, field := range strings.Split(value, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q in DOCKLET_WEB_PORT_HINTS", field)
+			}
+			hints = append(hints, port)
+		}
+	}
+	for _, port := range hints {
+		c.hints[port] = true
+	}
+	return c, nil
+}
+
+// classify fills in WebEndpoints and IsLikelyWebService for every service with listening ports.
+// Ports are probed concurrently; results are cached per (pid, address, port). Probes stop when ctx is done.
+func (c *webClassifier) classify(ctx context.Context, services []SystemServiceInfo) {
+	type job struct {
+		service int
+		port    int // index into ListeningPorts, to keep endpoints in port order
+	}
+
+	results := make([][]*WebEndpoint, len(services))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentProbes)
+	for i := range services {
+		results[i] = make([]*WebEndpoint, len(services[i].ListeningPorts))
+		for j := range services[i].ListeningPorts {
+			wg.Add(1)
+			go func(j job) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				service := services[j.service]
+				port := service.ListeningPorts[j.port]
+				results[j.service][j.port] = c.classifyPort(ctx, service.PID, c.probeHost(service.ListenAddresses[port]), port)
+			}(job{service: i, port: j})
+		}
+	}
+	wg.Wait()
+
+	for i := range services {
+		if len(services[i].ListeningPorts) == 0 {
+			continue // Nothing to classify, keep whatever the OS-specific scan decided
+		}
+		services[i].WebEndpoints = nil
+		for _, endpoint := range results[i] {
+			if endpoint != nil {
+				services[i].WebEndpoints = append(services[i].WebEndpoints, *endpoint)
+			}
+		}
+		services[i].IsLikelyWebService = len(services[i].WebEndpoints) > 0
+	}
+}
+
+// probeHost returns the address to probe a port bound on addresses at: the port's own
+// address, or the configured host if it's bound on all addresses or we don't know.
+func (c *webClassifier) probeHost(addresses []string) string {
+	if len(addresses) == 0 {
+		return c.host
+	}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip == nil || ip.IsUnspecified() {
+			return c.host
+		}
+	}
+	return addresses[0]
+}
+
+// classifyPort returns the web endpoint for a port reached at host, or nil if it isn't one.
+func (c *webClassifier) classifyPort(ctx context.Context, pid, host, port string) *WebEndpoint {
+	key := probeKey{pid: pid, host: host, port: port}
+	now := time.Now()
+
+	c.mu.Lock()
+	if result, ok := c.cache[key]; ok && now.Before(result.expires) {
+		c.mu.Unlock()
+		return result.endpoint
+	}
+	c.mu.Unlock()
+
+	endpoint, conclusive := c.probePort(ctx, host, port)
+	if !conclusive {
+		endpoint = c.hintEndpoint(port)
+	}
+	if ctx.Err() != nil {
+		return endpoint // Probe was cut short; don't cache the fallback
+	}
+
+	c.mu.Lock()
+	c.cache[key] = probeResult{endpoint: endpoint, expires: now.Add(c.ttl)}
+	for k, result := range c.cache {
+		if now.After(result.expires) {
+			delete(c.cache, k)
+		}
+	}
+	c.mu.Unlock()
+	return endpoint
+}
+
+// hintEndpoint returns an endpoint if the port is in the hint list.
+func (c *webClassifier) hintEndpoint(port string) *WebEndpoint {
+	p, err := strconv.Atoi(port)
+	if err != nil || !c.hints[p] {
+		return nil
+	}
+	scheme := "http"
+	if p == 443 {
+		scheme = "https"
+	}
+	return &WebEndpoint{Port: port, Scheme: scheme, Source: WebSourceHint}
+}
+
+// probePort tries a TLS handshake followed by HTTP, then plain HTTP. TLS goes first
+// because many HTTPS servers answer a plain request with an HTTP 400, which would
+// otherwise be mistaken for a plain HTTP service.
+// conclusive is false if we couldn't connect at all, in which case the caller falls back to hints.
+func (c *webClassifier) probePort(ctx context.Context, host, port string) (endpoint *WebEndpoint, conclusive bool) {
+	if !c.probe {
+		return nil, false
+	}
+	addr := net.JoinHostPort(host, port)
+
+	resp, err := c.roundTrip(ctx, addr, true)
+	if err != nil {
+		if isDialError(err) || ctx.Err() != nil {
+			return nil, false
+		}
+		resp, err = c.roundTrip(ctx, addr, false)
+	}
+	if err != nil {
+		if isDialError(err) || ctx.Err() != nil {
+			return nil, false
+		}
+		return nil, true
+	}
+
+	scheme := "http"
+	if resp.TLS != nil {
+		scheme = "https"
+	}
+	return &WebEndpoint{Port: port, Scheme: scheme, Server: resp.Header.Get("Server"), Source: WebSourceProbe}, true
+}
+
+// roundTrip sends a single HEAD request over a fresh connection and parses the response headers.
+func (c *webClassifier) roundTrip(ctx context.Context, addr string, useTLS bool) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &dialError{err}
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if useTLS {
+		// We only want to know whether the port speaks TLS, not whether the certificate is valid.
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req, err := http.NewRequest(http.MethodHead, "http://"+addr+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Docklet")
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		resp.TLS = &state
+	}
+	return resp, nil
+}
+
+// dialError marks errors that happened before we could talk to the port at all.
+type dialError struct{ err error }
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+func isDialError(err error) bool {
+	_, ok := err.(*dialError)
+	return ok
+}
+
+// logProbeConfig logs how web services are detected, once at startup.
+func (c *webClassifier) logProbeConfig() {
+	if c.probe {
+		log.Printf("System scanner: probing listening ports on their own address, or %s if bound on all, for HTTP/TLS (timeout %s, cache TTL %s)", c.host, c.timeout, c.ttl)
+	} else {
+		log.Printf("System scanner: web port probing disabled, using %d port hints", len(c.hints))
+	}
+}
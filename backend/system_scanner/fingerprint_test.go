@@ -0,0 +1,185 @@
+package systemscanner
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestClassifier(hints ...int) *webClassifier {
+	c := &webClassifier{
+		probe:   true,
+		host:    "127.0.0.1",
+		timeout: 200 * time.Millisecond,
+		ttl:     time.Minute,
+		hints:   make(map[int]bool),
+		cache:   make(map[probeKey]probeResult),
+	}
+	for _, port := range hints {
+		c.hints[port] = true
+	}
+	return c
+}
+
+// listen starts a TCP server on address handing each connection to serve, and returns its port.
+func listen(t *testing.T, address string, serve func(net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		t.Skipf("can't listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func serverPort(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	t.Cleanup(server.Close)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	return port
+}
+
+func closedPort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	return port
+}
+
+func TestProbePort(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25")
+		w.WriteHeader(http.StatusNotFound) // Any response makes it a web service
+	})
+
+	for _, tt := range []struct {
+		name       string
+		port       string
+		want       *WebEndpoint
+		conclusive bool
+	}{
+		{"http", serverPort(t, httptest.NewServer(handler)), &WebEndpoint{Scheme: "http", Server: "nginx/1.25", Source: WebSourceProbe}, true},
+		{"https", serverPort(t, httptest.NewTLSServer(handler)), &WebEndpoint{Scheme: "https", Server: "nginx/1.25", Source: WebSourceProbe}, true},
+		{"ssh banner", listen(t, "127.0.0.1", func(conn net.Conn) {
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			bufio.NewReader(conn).ReadString('\n')
+		}), nil, true},
+		// Like a database or MQTT broker waiting for its own protocol
+		{"silent", listen(t, "127.0.0.1", func(conn net.Conn) {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			io.Copy(io.Discard, conn)
+		}), nil, true},
+		{"closed", closedPort(t), nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClassifier()
+			start := time.Now()
+			endpoint, conclusive := c.probePort(context.Background(), "127.0.0.1", tt.port)
+			if elapsed := time.Since(start); elapsed > 2*c.timeout+time.Second {
+				t.Errorf("probe took %s with a %s timeout", elapsed, c.timeout)
+			}
+			if conclusive != tt.conclusive {
+				t.Errorf("conclusive = %t, want %t", conclusive, tt.conclusive)
+			}
+			if tt.want != nil {
+				tt.want.Port = tt.port
+			}
+			if (endpoint == nil) != (tt.want == nil) || (endpoint != nil && *endpoint != *tt.want) {
+				t.Errorf("endpoint = %+v, want %+v", endpoint, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeHost(t *testing.T) {
+	c := newTestClassifier()
+	c.host = "172.17.0.1"
+	for _, tt := range []struct {
+		addresses []string
+		want      string
+	}{
+		{nil, "172.17.0.1"},
+		{[]string{"0.0.0.0"}, "172.17.0.1"},
+		{[]string{"::"}, "172.17.0.1"},
+		{[]string{"127.0.0.1", "::"}, "172.17.0.1"}, // Reachable at the configured host through the wildcard
+		{[]string{"127.0.0.1"}, "127.0.0.1"},
+		{[]string{"192.168.1.10"}, "192.168.1.10"},
+		{[]string{"::1", "127.0.0.1"}, "::1"},
+	} {
+		if got := c.probeHost(tt.addresses); got != tt.want {
+			t.Errorf("probeHost(%q) = %s, want %s", tt.addresses, got, tt.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	web := serverPort(t, httptest.NewServer(http.NotFoundHandler()))
+	// Bound to a loopback address other than the probe host, like a service on one LAN interface
+	own := listen(t, "127.0.0.2", func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nServer: own\r\nContent-Length: 0\r\n\r\n"))
+		bufio.NewReader(conn).ReadString('\n')
+	})
+	hinted := closedPort(t)
+	hintedPort, _ := strconv.Atoi(hinted)
+	unknown := closedPort(t)
+
+	c := newTestClassifier(hintedPort)
+	services := []SystemServiceInfo{
+		{Name: "web", PID: "10", ListeningPorts: []string{web}, ListenAddresses: map[string][]string{web: {"0.0.0.0"}}},
+		{Name: "own", PID: "11", ListeningPorts: []string{own}, ListenAddresses: map[string][]string{own: {"127.0.0.2"}}},
+		{Name: "mixed", PID: "12", ListeningPorts: []string{unknown, hinted, web}},
+		{Name: "none", PID: "13", ListeningPorts: []string{unknown}},
+		{Name: "no ports", PID: "14", IsLikelyWebService: true},
+	}
+	c.classify(context.Background(), services)
+
+	for i, want := range []struct {
+		web       bool
+		endpoints string // port/scheme/source
+	}{
+		{true, web + "/http/probe"},
+		{true, own + "/http/probe"},
+		{true, hinted + "/http/hint " + web + "/http/probe"},
+		{false, ""},
+		{true, ""}, // Left alone
+	} {
+		var got string
+		for j, endpoint := range services[i].WebEndpoints {
+			if j > 0 {
+				got += " "
+			}
+			got += endpoint.Port + "/" + endpoint.Scheme + "/" + endpoint.Source
+		}
+		if services[i].IsLikelyWebService != want.web || got != want.endpoints {
+			t.Errorf("%s: web %t, endpoints %q; want %t, %q", services[i].Name, services[i].IsLikelyWebService, got, want.web, want.endpoints)
+		}
+	}
+
+	// Results are cached per process, address and port
+	if _, ok := c.cache[probeKey{pid: "11", host: "127.0.0.2", port: own}]; !ok {
+		t.Error("probe of 127.0.0.2 not cached")
+	}
+}
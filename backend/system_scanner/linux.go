@@ -0,0 +1,290 @@
+package systemscanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// procRoot is where procfs is mounted. When Docklet runs in a container with the
+// host's /proc bind-mounted elsewhere, this can be overridden via DOCKLET_PROC_ROOT.
+var procRoot = getEnvOrDefault("DOCKLET_PROC_ROOT", "/proc")
+
+// tcpListenState is the hex state code for LISTEN in /proc/net/tcp{,6}.
+const tcpListenState = "0A"
+
+// containerIDPattern matches a 64 character container ID at the end of a cgroup path segment,
+// e.g. "docker-<id>.scope", "/docker/<id>", "cri-containerd-<id>.scope" or "libpod-<id>.scope".
+var containerIDPattern = regexp.MustCompile(`(?:^|[-/])([0-9a-f]{64})(?:\.scope)?$`)
+
+// systemdUnitPattern matches the systemd unit a process belongs to, e.g. "/system.slice/nginx.service".
+var systemdUnitPattern = regexp.MustCompile(`/([^/]+\.service)$`)
+
+// procInfo is what we know about a single process owning listening sockets.
+type procInfo struct {
+	pid       int
+	comm      string
+	exe       string
+	cmdline   []string
+	cgroup    string
+	ports     []string
+	addresses map[string][]string // Bind addresses by port
+}
+
+// listLinuxServices lists services on Linux by reading listening TCP sockets from procfs
+// and mapping them back to the processes that own them. Processes are grouped by their
+// systemd unit when they have one, otherwise they are listed individually.
+func (s *SystemScanner) listLinuxServices(ctx context.Context) ([]SystemServiceInfo, error) {
+	sockets := make(map[string]Listener)
+	for _, name := range []string{"tcp", "tcp6"} {
+		if err := readSocketTable(filepath.Join(procRoot, "net", name), "tcp", sockets); err != nil {
+			if name == "tcp6" && os.IsNotExist(err) {
+				continue // IPv6 disabled
+			}
+			return nil, err
+		}
+	}
+
+	procs, err := findSocketOwners(ctx, sockets)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group processes by systemd unit so that e.g. nginx master and workers show up once.
+	services := []SystemServiceInfo{}
+	byName := make(map[string]int)
+	for _, proc := range procs {
+		name := proc.comm
+		if m := systemdUnitPattern.FindStringSubmatch(proc.cgroup); m != nil {
+			name = m[1]
+		}
+
+		if idx, ok := byName[name]; ok && services[idx].Container == nil {
+			services[idx].ListeningPorts = mergePorts(services[idx].ListeningPorts, proc.ports)
+			for port, addresses := range proc.addresses {
+				for _, address := range addresses {
+					services[idx].ListenAddresses = addAddress(services[idx].ListenAddresses, port, address)
+				}
+			}
+			continue
+		}
+
+		service := SystemServiceInfo{
+			Name:            name,
+			DisplayName:     proc.comm,
+			Status:          "running",
+			PathName:        proc.exe,
+			PID:             strconv.Itoa(proc.pid),
+			ListeningPorts:  proc.ports,
+			ListenAddresses: proc.addresses,
+			Container:       attributeContainer(proc),
+		}
+		if service.Container != nil {
+			// Container processes are listed individually; several containers can run the same binary.
+			service.Name = fmt.Sprintf("%s (%d)", name, proc.pid)
+		} else {
+			byName[name] = len(services)
+		}
+		services = append(services, service)
+	}
+
+	return services, nil
+}
+
+// pingLinux checks that the socket table in procfs is readable.
+func pingLinux() error {
+	f, err := os.Open(filepath.Join(procRoot, "net", "tcp"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := bufio.NewReader(f).ReadString('\n'); err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.Name(), err)
+	}
+	return nil
+}
+
+// findSocketOwners finds the processes owning the given listening sockets, keyed by
+// inode, with the ports each of them listens on, ordered by PID.
+func findSocketOwners(ctx context.Context, sockets map[string]Listener) ([]*procInfo, error) {
+	inodes := make(map[string]bool, len(sockets))
+	for inode := range sockets {
+		inodes[inode] = true
+	}
+	owners, err := socketOwners(ctx, inodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []*procInfo
+	for inode, proc := range owners {
+		if len(proc.ports) == 0 {
+			procs = append(procs, proc)
+		}
+		port := strconv.Itoa(sockets[inode].Port)
+		proc.ports = mergePorts(proc.ports, []string{port})
+		proc.addresses = addAddress(proc.addresses, port, sockets[inode].Address)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	return procs, nil
+}
+
+// socketOwners walks /proc/<pid>/fd to find which processes own the given socket inodes.
+// A socket shared by several processes (e.g. forked workers) is attributed to the lowest PID.
+// Inodes of processes we can't inspect are missing from the result. procfs reads don't
+// block, but a host with many processes takes a while, so ctx is checked per process.
+func socketOwners(ctx context.Context, inodes map[string]bool) (map[string]*procInfo, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", procRoot, err)
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	owners := make(map[string]*procInfo)
+	for _, pid := range pids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fdDir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue // Process exited or we lack permission; both are expected
+		}
+
+		var proc *procInfo
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if !inodes[inode] || owners[inode] != nil {
+				continue
+			}
+			if proc == nil {
+				proc = readProcInfo(pid, nil)
+			}
+			owners[inode] = proc
+		}
+	}
+	return owners, nil
+}
+
+// readProcInfo collects name, executable, arguments and cgroup of a process.
+// Missing fields are left empty; processes can exit while we're reading them.
+func readProcInfo(pid int, ports []string) *procInfo {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	info := &procInfo{pid: pid, ports: ports}
+
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		info.comm = strings.TrimSpace(string(comm))
+	}
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		info.exe = exe
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		info.cmdline = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+	if cgroup, err := os.ReadFile(filepath.Join(dir, "cgroup")); err == nil {
+		info.cgroup = parseCgroupPath(string(cgroup))
+	}
+	if info.comm == "" {
+		info.comm = fmt.Sprintf("pid-%d", pid)
+	}
+	return info
+}
+
+// parseCgroupPath returns the most specific cgroup path from /proc/<pid>/cgroup.
+// On cgroup v2 this is the single "0::" line; on v1 we prefer the pids or name=systemd hierarchy.
+func parseCgroupPath(content string) string {
+	var fallback string
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			return parts[2]
+		case parts[1] == "pids" || parts[1] == "name=systemd":
+			fallback = parts[2]
+		case fallback == "":
+			fallback = parts[2]
+		}
+	}
+	return fallback
+}
+
+// attributeContainer determines whether a process belongs to a container, either because
+// it runs inside the container's cgroup or because it is docker-proxy publishing a container port.
+func attributeContainer(proc *procInfo) *ContainerRef {
+	if filepath.Base(proc.exe) == "docker-proxy" || proc.comm == "docker-proxy" {
+		ref := &ContainerRef{Via: ContainerViaDockerProxy}
+		for i := 0; i+1 < len(proc.cmdline); i++ {
+			switch proc.cmdline[i] {
+			case "-container-ip":
+				ref.IP = proc.cmdline[i+1]
+			case "-container-port":
+				ref.Port = proc.cmdline[i+1]
+			}
+		}
+		return ref
+	}
+
+	for _, segment := range strings.Split(proc.cgroup, "/") {
+		if m := containerIDPattern.FindStringSubmatch(segment); m != nil {
+			return &ContainerRef{ID: m[1], Via: ContainerViaCgroup}
+		}
+	}
+	return nil
+}
+
+// addAddress records that port is bound on address, once, keeping the addresses sorted.
+func addAddress(addresses map[string][]string, port, address string) map[string][]string {
+	if addresses == nil {
+		addresses = make(map[string][]string)
+	}
+	for _, existing := range addresses[port] {
+		if existing == address {
+			return addresses
+		}
+	}
+	addresses[port] = append(addresses[port], address)
+	sort.Strings(addresses[port])
+	return addresses
+}
+
+// mergePorts appends ports not already present, keeping the result sorted numerically.
+func mergePorts(ports, more []string) []string {
+	for _, p := range more {
+		found := false
+		for _, existing := range ports {
+			if existing == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ports = append(ports, p)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		a, _ := strconv.Atoi(ports[i])
+		b, _ := strconv.Atoi(ports[j])
+		return a < b
+	})
+	return ports
+}
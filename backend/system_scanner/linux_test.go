@@ -0,0 +1,290 @@
+package systemscanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const (
+	hostNetworkID = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	publishedID   = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+)
+
+// fixtureLinks are the symlinks of testdata/proc, which are made at test time rather than
+// kept in the repository: open files and executables of its processes.
+var fixtureLinks = map[string]string{
+	"100/exe":   "/usr/sbin/nginx",
+	"100/fd/0":  "/dev/null",
+	"100/fd/6":  "socket:[1001]",
+	"100/fd/7":  "socket:[1002]",
+	"100/fd/8":  "socket:[1003]",
+	"101/exe":   "/usr/sbin/nginx",
+	"101/fd/6":  "socket:[1001]", // Inherited from the master
+	"101/fd/9":  "socket:[5000]", // A connection, not a listening socket
+	"200/exe":   "/usr/bin/docker-proxy",
+	"200/fd/4":  "socket:[2001]",
+	"300/exe":   "/usr/local/bin/python3.12",
+	"300/fd/12": "socket:[3001]",
+	"500/exe":   "/usr/sbin/avahi-daemon",
+	"500/fd/13": "socket:[1004]",
+}
+
+// useProcFixture points procRoot at a copy of testdata/proc for the rest of the test.
+func useProcFixture(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs symlinks")
+	}
+	root := filepath.Join(t.TempDir(), "proc")
+	if err := os.CopyFS(root, os.DirFS(filepath.Join("testdata", "proc"))); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range fixtureLinks {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := procRoot
+	procRoot = root
+	t.Cleanup(func() { procRoot = old })
+}
+
+func TestParseCgroupPath(t *testing.T) {
+	for _, tt := range []struct{ name, content, want string }{
+		{"v2", "0::/system.slice/nginx.service\n", "/system.slice/nginx.service"},
+		{"v1 pids", "12:pids:/docker/abc\n11:memory:/docker/def\n1:name=systemd:/docker/abc\n", "/docker/abc"},
+		{"v1 systemd", "4:memory:/user.slice\n1:name=systemd:/system.slice/ssh.service\n", "/system.slice/ssh.service"},
+		{"v1 other", "4:memory:/user.slice\n3:cpu:/other\n", "/user.slice"},
+		{"hybrid", "1:name=systemd:/init.scope\n0::/system.slice/cron.service\n", "/system.slice/cron.service"},
+		{"empty", "", ""},
+	} {
+		if got := parseCgroupPath(tt.content); got != tt.want {
+			t.Errorf("%s: parseCgroupPath = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseProcAddress(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"0100007F:1F90", "127.0.0.1:8080", true},
+		{"0A01A8C0:0016", "192.168.1.10:22", true},
+		{"00000000000000000000000000000000:0050", ":::80", true},
+		{"00000000000000000000000001000000:0277", "::1:631", true},
+		{"0000000000000000FFFF00000A01A8C0:2328", "192.168.1.10:9000", true}, // IPv4-mapped
+		{"0100007F", "", false},
+		{"0100007G:0050", "", false},
+		{"01007F:0050", "", false},
+	} {
+		ip, port, ok := parseProcAddress(tt.in)
+		got := ""
+		if ok {
+			got = fmt.Sprintf("%s:%d", ip, port)
+		}
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseProcAddress(%q) = %s, %t, want %s, %t", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReadSocketTable(t *testing.T) {
+	byInode := make(map[string]Listener)
+	for _, table := range []struct{ name, protocol string }{{"tcp", "tcp"}, {"tcp6", "tcp"}, {"udp", "udp"}} {
+		if err := readSocketTable(filepath.Join("testdata", "proc", "net", table.name), table.protocol, byInode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{
+		"1001": "tcp 0.0.0.0:80",
+		"1002": "tcp 127.0.0.1:8080",
+		"1003": "tcp :::80",
+		"1004": "udp 0.0.0.0:5353",
+		"2001": "tcp 0.0.0.0:8081",
+		"3001": "tcp 0.0.0.0:8123",
+		"4001": "tcp 192.168.1.10:22",
+		"4002": "tcp ::1:631",
+		"4003": "tcp 192.168.1.10:9000",
+	}
+	// Connections, connected UDP sockets and sockets without an inode are left out
+	if len(byInode) != len(want) {
+		t.Errorf("got %d sockets, want %d: %v", len(byInode), len(want), byInode)
+	}
+	for inode, w := range want {
+		l := byInode[inode]
+		if got := fmt.Sprintf("%s %s:%d", l.Protocol, l.Address, l.Port); got != w {
+			t.Errorf("socket %s = %s, want %s", inode, got, w)
+		}
+	}
+
+	if err := readSocketTable(filepath.Join("testdata", "proc", "net", "udp6"), "udp", byInode); !os.IsNotExist(err) {
+		t.Errorf("missing table: err = %v, want not exist", err)
+	}
+}
+
+func TestAttributeContainer(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		proc procInfo
+		want string
+	}{
+		{
+			name: "docker-proxy",
+			proc: procInfo{comm: "docker-proxy", exe: "/usr/bin/docker-proxy",
+				cmdline: []string{"/usr/bin/docker-proxy", "-proto", "tcp", "-host-port", "8081", "-container-ip", "172.17.0.4", "-container-port", "80"}},
+			want: "docker-proxy id= ip=172.17.0.4 port=80",
+		},
+		{
+			name: "renamed docker-proxy",
+			proc: procInfo{comm: "pid-7", exe: "/usr/libexec/docker/docker-proxy", cmdline: []string{"docker-proxy", "-container-ip"}},
+			want: "docker-proxy id= ip= port=",
+		},
+		{
+			name: "cgroup v2 scope",
+			proc: procInfo{comm: "node", cgroup: "/system.slice/docker-" + hostNetworkID + ".scope"},
+			want: "cgroup id=" + hostNetworkID + " ip= port=",
+		},
+		{
+			name: "cgroup v1",
+			proc: procInfo{comm: "node", cgroup: "/docker/" + hostNetworkID},
+			want: "cgroup id=" + hostNetworkID + " ip= port=",
+		},
+		{
+			name: "podman",
+			proc: procInfo{comm: "node", cgroup: "/machine.slice/libpod-" + hostNetworkID + ".scope/container"},
+			want: "cgroup id=" + hostNetworkID + " ip= port=",
+		},
+		{name: "systemd service", proc: procInfo{comm: "nginx", cgroup: "/system.slice/nginx.service"}},
+		{name: "short hex", proc: procInfo{comm: "x", cgroup: "/system.slice/docker-abc123.scope"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if ref := attributeContainer(&tt.proc); ref != nil {
+				got = fmt.Sprintf("%s id=%s ip=%s port=%s", ref.Via, ref.ID, ref.IP, ref.Port)
+			}
+			if got != tt.want {
+				t.Errorf("attributeContainer = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListListeners(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads procfs")
+	}
+	useProcFixture(t)
+
+	s := &SystemScanner{ContainerIDs: func(ctx context.Context) (map[string]string, error) {
+		return map[string]string{"172.17.0.4": publishedID, "172.17.0.5": hostNetworkID}, nil
+	}}
+	listeners, err := s.ListListeners(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range listeners {
+		line := fmt.Sprintf("%s %s:%d pid=%d %s %s", l.Protocol, l.Address, l.Port, l.PID, l.Process, l.Unit)
+		if ref := l.Container; ref != nil {
+			line += fmt.Sprintf(" %s:%s/%s:%s", ref.Via, ref.ID[:12], ref.IP, ref.Port)
+		}
+		got = append(got, strings.TrimSpace(line))
+	}
+	want := []string{
+		"tcp 192.168.1.10:22 pid=0",
+		"tcp 0.0.0.0:80 pid=100 nginx nginx.service",
+		"tcp :::80 pid=100 nginx nginx.service",
+		"tcp ::1:631 pid=0",
+		"udp 0.0.0.0:5353 pid=500 avahi-daemon avahi-daemon.service",
+		"tcp 127.0.0.1:8080 pid=100 nginx nginx.service",
+		"tcp 0.0.0.0:8081 pid=200 docker-proxy docker.service docker-proxy:9f86d081884c/172.17.0.4:80",
+		"tcp 0.0.0.0:8123 pid=300 python3  cgroup:e3b0c44298fc/:",
+		"tcp 192.168.1.10:9000 pid=0",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("listeners:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestListLinuxServices(t *testing.T) {
+	useProcFixture(t)
+
+	services, err := (&SystemScanner{}).listLinuxServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, svc := range services {
+		line := fmt.Sprintf("%s pid=%s exe=%s ports=%v addresses=%v", svc.Name, svc.PID, svc.PathName, svc.ListeningPorts, svc.ListenAddresses)
+		if svc.Container != nil {
+			line += " container=" + svc.Container.Via
+		}
+		got = append(got, line)
+	}
+	want := []string{
+		"nginx.service pid=100 exe=/usr/sbin/nginx ports=[80 8080] addresses=map[80:[0.0.0.0 ::] 8080:[127.0.0.1]]",
+		"docker.service (200) pid=200 exe=/usr/bin/docker-proxy ports=[8081] addresses=map[8081:[0.0.0.0]] container=docker-proxy",
+		"python3 (300) pid=300 exe=/usr/local/bin/python3.12 ports=[8123] addresses=map[8123:[0.0.0.0]] container=cgroup",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("services:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestResolveContainerIDs(t *testing.T) {
+	refs := func() []*ContainerRef {
+		return []*ContainerRef{
+			{IP: "172.17.0.4", Via: ContainerViaDockerProxy},
+			{IP: "172.17.0.9", Via: ContainerViaDockerProxy},
+			{ID: hostNetworkID, Via: ContainerViaCgroup},
+			nil,
+		}
+	}
+	ids := func(refs []*ContainerRef) string {
+		var s []string
+		for _, ref := range refs[:3] {
+			s = append(s, ref.ID)
+		}
+		return strings.Join(s, ",")
+	}
+
+	calls := 0
+	s := &SystemScanner{ContainerIDs: func(ctx context.Context) (map[string]string, error) {
+		calls++
+		return map[string]string{"172.17.0.4": publishedID, "172.17.0.5": "other"}, nil
+	}}
+	resolved := refs()
+	s.resolveContainerIDs(context.Background(), resolved)
+	if got, want := ids(resolved), publishedID+",,"+hostNetworkID; got != want || calls != 1 {
+		t.Errorf("resolved %s with %d lookups, want %s with 1", got, calls, want)
+	}
+
+	// Docker isn't asked when no socket is docker-proxy's
+	s.resolveContainerIDs(context.Background(), refs()[2:])
+	if calls != 1 {
+		t.Errorf("asked Docker without docker-proxy sockets")
+	}
+
+	for _, s := range []*SystemScanner{
+		{},
+		{ContainerIDs: func(ctx context.Context) (map[string]string, error) { return nil, errors.New("docker is down") }},
+	} {
+		unresolved := refs()
+		s.resolveContainerIDs(context.Background(), unresolved)
+		if got, want := ids(unresolved), ",,"+hostNetworkID; got != want {
+			t.Errorf("without Docker: %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package systemscanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// udpUnconnectedState is the hex state code of a UDP socket that isn't connected to a peer,
+// i.e. one waiting for datagrams from anyone, in /proc/net/udp{,6}.
+const udpUnconnectedState = "07"
+
+// Listener is a socket on the host accepting TCP connections or UDP datagrams.
+type Listener struct {
+	Protocol  string        `json:"protocol"` // "tcp" or "udp"
+	Address   string        `json:"address"`  // Bind address, e.g. "0.0.0.0", "::", "127.0.0.1"
+	Port      int           `json:"port"`
+	PID       int           `json:"pid,omitempty"`       // 0 if the owner couldn't be determined, usually for lack of permission
+	Process   string        `json:"process,omitempty"`   // Process name
+	Unit      string        `json:"unit,omitempty"`      // systemd unit of the process, if any
+	Container *ContainerRef `json:"container,omitempty"` // Set if the socket belongs to a container
+}
+
+// ListListeners lists every listening TCP socket and unconnected UDP socket on the host,
+// with its bind address and owner, sorted by port. Unlike ListServices it keeps sockets
+// of containers and of processes it can't attribute.
+func (s *SystemScanner) ListListeners(ctx context.Context) ([]Listener, error) {
+	var listeners []Listener
+	var err error
+	switch runtime.GOOS {
+	case "linux":
+		listeners, err = listLinuxListeners(ctx)
+	case "darwin":
+		listeners, err = listMacListeners(ctx)
+	default:
+		err = fmt.Errorf("listing sockets is not supported on %s", runtime.GOOS)
+	}
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]*ContainerRef, 0, len(listeners))
+	for _, listener := range listeners {
+		refs = append(refs, listener.Container)
+	}
+	s.resolveContainerIDs(ctx, refs)
+
+	sort.Slice(listeners, func(i, j int) bool {
+		a, b := listeners[i], listeners[j]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Address < b.Address
+	})
+	return listeners, nil
+}
+
+// resolveContainerIDs fills in the IDs of containers docker-proxy refers to by address,
+// asking Docker once. Without ContainerIDs, or if Docker can't be asked, they keep only
+// the address.
+func (s *SystemScanner) resolveContainerIDs(ctx context.Context, refs []*ContainerRef) {
+	var unresolved []*ContainerRef
+	for _, ref := range refs {
+		if ref != nil && ref.ID == "" && ref.IP != "" {
+			unresolved = append(unresolved, ref)
+		}
+	}
+	if len(unresolved) == 0 || s.ContainerIDs == nil {
+		return
+	}
+	ids, err := s.ContainerIDs(ctx)
+	if err != nil {
+		log.Printf("System scanner: can't look up the containers docker-proxy forwards to: %v", err)
+		return
+	}
+	for _, ref := range unresolved {
+		ref.ID = ids[ref.IP]
+	}
+}
+
+// listLinuxListeners reads the socket tables in procfs and maps each socket to its owner.
+func listLinuxListeners(ctx context.Context) ([]Listener, error) {
+	byInode := make(map[string]Listener)
+	for _, name := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		err := readSocketTable(filepath.Join(procRoot, "net", name), strings.TrimSuffix(name, "6"), byInode)
+		if err != nil {
+			if strings.HasSuffix(name, "6") && os.IsNotExist(err) {
+				continue // IPv6 disabled
+			}
+			return nil, err
+		}
+	}
+
+	inodes := make(map[string]bool, len(byInode))
+	for inode := range byInode {
+		inodes[inode] = true
+	}
+	owners, err := socketOwners(ctx, inodes)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]Listener, 0, len(byInode))
+	for inode, listener := range byInode {
+		if proc := owners[inode]; proc != nil {
+			listener.PID = proc.pid
+			listener.Process = proc.comm
+			if m := systemdUnitPattern.FindStringSubmatch(proc.cgroup); m != nil {
+				listener.Unit = m[1]
+			}
+			listener.Container = attributeContainer(proc)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// readSocketTable parses a /proc/net/{tcp,udp}{,6} file and records the listening TCP
+// sockets or unconnected UDP sockets in it, keyed by socket inode.
+func readSocketTable(path, protocol string, byInode map[string]Listener) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	state := tcpListenState
+	if protocol == "udp" {
+		state = udpUnconnectedState
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Skip header line
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state || fields[9] == "0" {
+			continue
+		}
+		ip, port, ok := parseProcAddress(fields[1])
+		if !ok || port == 0 {
+			continue
+		}
+		if _, remotePort, _ := parseProcAddress(fields[2]); remotePort != 0 {
+			continue // A UDP socket connected to one peer
+		}
+		byInode[fields[9]] = Listener{Protocol: protocol, Address: ip.String(), Port: port}
+	}
+	return scanner.Err()
+}
+
+// parseProcAddress decodes an address like "0100007F:1F90" from procfs. The IP is printed
+// as 32-bit words in host byte order.
+func parseProcAddress(s string) (net.IP, int, bool) {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, false
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, false
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+	if v4 := ip.To4(); v4 != nil && len(raw) == net.IPv6len && !ip.IsUnspecified() {
+		ip = v4 // Show IPv4-mapped addresses of dual-stack sockets as IPv4
+	}
+	return ip, int(port), true
+}
+
+// listMacListeners asks lsof for all listening TCP and unconnected UDP sockets. Without
+// root, lsof only sees the sockets of the current user's processes.
+func listMacListeners(ctx context.Context) ([]Listener, error) {
+	// -F prints one field per line: p<pid>, c<command>, P<protocol>, n<address>
+	cmd := commandContext(ctx, "lsof", "-nP", "-iTCP", "-sTCP:LISTEN", "-iUDP", "-FpcPn")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil && out.Len() == 0 {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, nil // No sockets
+		}
+		return nil, fmt.Errorf("lsof failed: %v, stderr: %s", err, stderr.String())
+	}
+
+	var listeners []Listener
+	seen := make(map[string]bool)
+	var pid int
+	var command, protocol string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		value := line[1:]
+		switch line[0] {
+		case 'p':
+			pid, _ = strconv.Atoi(value)
+		case 'c':
+			command = value
+		case 'P':
+			protocol = strings.ToLower(value)
+		case 'n':
+			if strings.Contains(value, "->") {
+				continue // Connected
+			}
+			i := strings.LastIndex(value, ":")
+			if i < 0 {
+				continue
+			}
+			port, err := strconv.Atoi(value[i+1:])
+			if err != nil || port == 0 {
+				continue
+			}
+			address := strings.Trim(value[:i], "[]")
+			if address == "*" {
+				address = "0.0.0.0"
+			}
+			key := fmt.Sprintf("%s/%s/%d/%d", protocol, address, port, pid)
+			if seen[key] {
+				continue // lsof lists each file descriptor; forked processes share sockets
+			}
+			seen[key] = true
+			listeners = append(listeners, Listener{Protocol: protocol, Address: address, Port: port, PID: pid, Process: command})
+		}
+	}
+	return listeners, nil
+}
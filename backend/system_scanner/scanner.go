@@ -2,66 +2,124 @@ package systemscanner
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log" // Added for logging errors during lsof
+	"os"
 	"os/exec"
 	"regexp" // Added for parsing lsof output
 	"runtime"
-	"strconv" // Added for port conversion
 	"strings"
+	"time"
+
+	"docklet/icons"
+	"docklet/metrics"
 )
 
-var commonWebPorts = map[int]bool{
-	80:   true,
-	443:  true,
-	3000: true, // Common for Node.js dev servers
-	3001: true, // Common for React dev servers (sometimes)
-	5000: true, // Common for Flask dev servers
-	5173: true, // Common for Vite dev servers
-	8000: true, // Common for Python dev servers, Django
-	8080: true, // Common for Java app servers, other dev servers
-	8888: true, // Common for Jupyter, other dev servers
-}
+// Modes for handling sockets that belong to containers (docker-proxy or processes in a container cgroup).
+const (
+	ContainerSocketsTag     = "tag"     // Keep them, with SystemServiceInfo.Container set
+	ContainerSocketsExclude = "exclude" // Drop them from the results
+)
 
-func isCommonWebPort(portStr string) bool {
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		return false
+// getEnvOrDefault gets an environment variable or returns a default value.
+func getEnvOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
-	return commonWebPorts[port]
+	return value
 }
 
 // SystemScanner provides methods to scan for native system services.
 type SystemScanner struct {
-	// Potentially add configuration options here if needed in the future
+	// containerSockets is one of ContainerSocketsTag or ContainerSocketsExclude.
+	containerSockets string
+	// web decides which listening ports are web services.
+	web *webClassifier
+	// ContainerIDs, if set, maps container addresses to container IDs, to name the
+	// containers docker-proxy forwards to.
+	ContainerIDs func(ctx context.Context) (map[string]string, error)
 }
 
 // NewSystemScanner creates a new SystemScanner.
+// DOCKLET_CONTAINER_SOCKETS controls whether container-owned sockets are tagged (default) or excluded.
+// See newWebClassifierFromEnv for the variables controlling web service detection.
 func NewSystemScanner() (*SystemScanner, error) {
-	return &SystemScanner{}, nil
+	mode := getEnvOrDefault("DOCKLET_CONTAINER_SOCKETS", ContainerSocketsTag)
+	if mode != ContainerSocketsTag && mode != ContainerSocketsExclude {
+		return nil, fmt.Errorf("invalid DOCKLET_CONTAINER_SOCKETS %q: must be %q or %q", mode, ContainerSocketsTag, ContainerSocketsExclude)
+	}
+	web, err := newWebClassifierFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	web.logProbeConfig()
+	return &SystemScanner{containerSockets: mode, web: web}, nil
 }
 
 // ListServices lists all detectable native system services.
-// It routes to the appropriate OS-specific implementation.
-func (s *SystemScanner) ListServices() ([]SystemServiceInfo, error) {
+// It routes to the appropriate OS-specific implementation, and stops external commands
+// and port probes when ctx is cancelled.
+func (s *SystemScanner) ListServices(ctx context.Context) ([]SystemServiceInfo, error) {
+	start := time.Now()
+	defer func() { metrics.ScanDuration.Observe(time.Since(start).Seconds(), "system") }()
+
+	var services []SystemServiceInfo
+	var err error
 	switch runtime.GOOS {
 	case "darwin":
-		return s.listMacServices()
+		services, err = s.listMacServices(ctx)
 	case "linux":
-		return s.listLinuxServices()
+		services, err = s.listLinuxServices(ctx)
 	case "windows":
-		return s.listWindowsServices()
+		services, err = s.listWindowsServices()
 	default:
-		return nil, fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+		err = fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+	if err != nil {
+		metrics.ScanErrors.Inc("system")
+		return nil, err
 	}
+
+	refs := make([]*ContainerRef, 0, len(services))
+	for _, service := range services {
+		refs = append(refs, service.Container)
+	}
+	s.resolveContainerIDs(ctx, refs)
+
+	if s.containerSockets == ContainerSocketsExclude {
+		native := services[:0]
+		for _, service := range services {
+			if service.Container == nil {
+				native = append(native, service)
+			}
+		}
+		services = native
+	}
+
+	for i := range services {
+		if services[i].Icon = icons.ForExecutable(services[i].PathName); services[i].Icon == "" {
+			services[i].Icon = icons.ForExecutable(services[i].Name)
+		}
+	}
+
+	s.web.classify(ctx, services)
+	if err := ctx.Err(); err != nil {
+		// Ports that weren't probed in time would be misclassified
+		metrics.ScanErrors.Inc("system")
+		return nil, err
+	}
+	return services, nil
 }
 
 // listMacServices lists services on macOS using launchctl.
-func (s *SystemScanner) listMacServices() ([]SystemServiceInfo, error) {
+func (s *SystemScanner) listMacServices(ctx context.Context) ([]SystemServiceInfo, error) {
 	var services []SystemServiceInfo
 
 	// List all services known to launchd
-	cmd := exec.Command("launchctl", "list")
+	cmd := commandContext(ctx, "launchctl", "list")
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	err := cmd.Run()
@@ -69,8 +127,8 @@ func (s *SystemScanner) listMacServices() ([]SystemServiceInfo, error) {
 		// launchctl list can return non-zero exit code if some services are in a bad state,
 		// but still output useful information. We'll log the error but try to parse.
 		// However, if there's no output, it's a more serious issue.
-		if out.Len() == 0 {
-			return nil, fmt.Errorf("failed to execute launchctl list: %v, output: %s", err, out.String())
+		if out.Len() == 0 || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to execute launchctl list: %w, output: %s", errors.Join(ctx.Err(), err), out.String())
 		}
 		// Log the error but continue: log.Printf("launchctl list returned error (continuing parsing): %v", err)
 	}
@@ -102,46 +160,51 @@ func (s *SystemScanner) listMacServices() ([]SystemServiceInfo, error) {
 			}
 
 			var listeningPorts []string
-			isLikelyWebService := false
+			var listenAddresses map[string][]string
 
 			if isRunning {
-				ports, err := getListeningTCPPorts(pidField)
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				ports, addresses, err := getListeningTCPPorts(ctx, pidField)
 				if err != nil {
 					log.Printf("Notice: Failed to get listening ports for PID %s (%s): %v. This might be due to permissions or the process terminating.", pidField, label, err)
 				} else {
-					listeningPorts = ports
-					if len(ports) > 0 {
-						for _, p := range ports {
-							if isCommonWebPort(p) {
-								isLikelyWebService = true
-								break
-							}
-						}
-					}
+					listeningPorts, listenAddresses = ports, addresses
 				}
 			}
 
 			services = append(services, SystemServiceInfo{
-				Name:               label,
-				DisplayName:        label,
-				Status:             currentStatus,
-				PID:                pidField,
-				ListeningPorts:     listeningPorts,
-				IsLikelyWebService: isLikelyWebService,
+				Name:            label,
+				DisplayName:     label,
+				Status:          currentStatus,
+				PID:             pidField,
+				ListeningPorts:  listeningPorts,
+				ListenAddresses: listenAddresses,
 			})
 		}
 	}
 
 	return services, nil
 }
+
+// commandContext is exec.CommandContext for tools that may hang, like lsof on a stale
+// NFS mount: the process is killed when ctx is done, and Wait doesn't block on
+// children that keep its output pipes open.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
 // getListeningTCPPorts uses lsof to find TCP ports a given PID is listening on.
-// Returns a list of port numbers as strings.
-func getListeningTCPPorts(pidStr string) ([]string, error) {
+// Returns a list of port numbers as strings, and the addresses each port is bound on.
+func getListeningTCPPorts(ctx context.Context, pidStr string) ([]string, map[string][]string, error) {
 	if pidStr == "-" || pidStr == "0" {
-		return nil, nil // Not a running process
+		return nil, nil, nil // Not a running process
 	}
 
-	cmd := exec.Command("lsof", "-p", pidStr, "-iTCP", "-sTCP:LISTEN", "-P", "-n")
+	cmd := commandContext(ctx, "lsof", "-p", pidStr, "-iTCP", "-sTCP:LISTEN", "-P", "-n")
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &out
@@ -153,20 +216,21 @@ func getListeningTCPPorts(pidStr string) ([]string, error) {
 		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
 			if strings.Contains(stderr.String(), "Can't be stat(2)ed") || strings.Contains(stderr.String(), "no such process") {
 				// Process might have terminated between launchctl list and lsof
-				return []string{}, nil
+				return []string{}, nil, nil
 			}
 			if out.Len() == 0 { // If output is empty and exit code is 1, it means no listening ports.
-				return []string{}, nil
+				return []string{}, nil, nil
 			}
 		}
 		// For other errors, or if lsof found something but still exited with an error.
-		return nil, fmt.Errorf("lsof command failed for PID %s: %v, stderr: %s, stdout: %s", pidStr, err, stderr.String(), out.String())
+		return nil, nil, fmt.Errorf("lsof command failed for PID %s: %v, stderr: %s, stdout: %s", pidStr, err, stderr.String(), out.String())
 	}
 
 	var ports []string
+	var addresses map[string][]string
 	// Regex to find port numbers like *:80 or 127.0.0.1:8080 or [::1]:80
 	// It looks for content like `*:port`, `host:port`, or `[ipv6]:port`
-	re := regexp.MustCompile(`\S+:(\d+)\s+\(LISTEN\)`)
+	re := regexp.MustCompile(`(\S+):(\d+)\s+\(LISTEN\)`)
 	lines := strings.Split(out.String(), "\n")
 
 	for _, line := range lines {
@@ -175,68 +239,26 @@ func getListeningTCPPorts(pidStr string) ([]string, error) {
 			continue
 		}
 		matches := re.FindStringSubmatch(line)
-		if len(matches) > 1 {
+		if len(matches) > 2 {
 			// Ensure port is not already added (lsof can list IPv4 and IPv6 separately for the same port)
 			found := false
 			for _, p := range ports {
-				if p == matches[1] {
+				if p == matches[2] {
 					found = true
 					break
 				}
 			}
 			if !found {
-				ports = append(ports, matches[1])
+				ports = append(ports, matches[2])
 			}
+			address := strings.Trim(matches[1], "[]")
+			if address == "*" {
+				address = "0.0.0.0"
+			}
+			addresses = addAddress(addresses, matches[2], address)
 		}
 	}
-	return ports, nil
-}
-
-// listLinuxServices lists services on Linux.
-// This is a placeholder and needs implementation (e.g., using systemctl, service, or /etc/init.d).
-func (s *SystemScanner) listLinuxServices() ([]SystemServiceInfo, error) {
-	services := []SystemServiceInfo{}
-	// Example: using systemctl (requires systemctl to be installed and user to have permissions)
-	/*
-	   cmd := exec.Command("systemctl", "list-units", "--type=service", "--all", "--no-pager")
-	   var out bytes.Buffer
-	   cmd.Stdout = &out
-	   err := cmd.Run()
-	   if err != nil {
-	       return nil, fmt.Errorf("failed to execute systemctl: %v", err)
-	   }
-
-	   lines := strings.Split(out.String(), "\n")
-	   for _, line := range lines {
-	       // Parse systemctl output
-	       // UNIT          LOAD   ACTIVE SUB     DESCRIPTION
-	       // service1.service loaded active running Service 1 Description
-	       // This parsing logic can be complex.
-	       if strings.Contains(line, ".service") {
-	           fields := strings.Fields(line)
-	           if len(fields) >= 4 {
-	               serviceName := fields[0]
-	               status := fields[2] // active, inactive, failed etc.
-	               description := strings.Join(fields[4:], " ")
-	               services = append(services, SystemServiceInfo{
-	                   Name:        serviceName,
-	                   DisplayName: description,
-	                   Status:      status,
-	               })
-	           }
-	       }
-	   }
-	*/
-	// For now, return a dummy service for Linux
-	services = append(services, SystemServiceInfo{
-		Name:        "dummy-linux-service",
-		DisplayName: "Dummy Linux Service",
-		Status:      "running",
-		Description: "This is a placeholder for Linux service detection.",
-	IsLikelyWebService: true, // Make it show up for testing
-	ListeningPorts: []string{"8080"},
-})
-return services, nil
+	return ports, addresses, nil
 }
 
 // listWindowsServices lists services on Windows.
@@ -266,14 +288,33 @@ func (s *SystemScanner) listWindowsServices() ([]SystemServiceInfo, error) {
 	*/
 	// For now, return a dummy service for Windows
 	services = append(services, SystemServiceInfo{
-		Name:        "dummy-windows-service",
-		DisplayName: "Dummy Windows Service",
-		Status:      "running",
-		Description: "This is a placeholder for Windows service detection.",
-	IsLikelyWebService: true, // Make it show up for testing
-	ListeningPorts: []string{"80"},
-})
-return services, nil
+		Name:               "dummy-windows-service",
+		DisplayName:        "Dummy Windows Service",
+		Status:             "running",
+		Description:        "This is a placeholder for Windows service detection.",
+		IsLikelyWebService: true, // Make it show up for testing
+		ListeningPorts:     []string{"80"},
+	})
+	return services, nil
+}
+
+// Ping checks that what ListServices relies on is available, without scanning.
+func (s *SystemScanner) Ping() error {
+	switch runtime.GOOS {
+	case "linux":
+		return pingLinux()
+	case "darwin":
+		for _, tool := range []string{"launchctl", "lsof"} {
+			if _, err := exec.LookPath(tool); err != nil {
+				return fmt.Errorf("%s not available: %w", tool, err)
+			}
+		}
+		return nil
+	case "windows":
+		return nil
+	default:
+		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
 }
 
 // Close cleans up any resources used by the SystemScanner.
@@ -281,4 +322,4 @@ return services, nil
 func (s *SystemScanner) Close() error {
 	// No-op for now
 	return nil
-}
\ No newline at end of file
+}
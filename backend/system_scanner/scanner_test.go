@@ -0,0 +1,48 @@
+package systemscanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHungCommandKilled runs getListeningTCPPorts against an lsof that never exits and
+// leaves a child holding its output open, which cmd.Wait alone would block on.
+func TestHungCommandKilled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as lsof")
+	}
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "started")
+	script := "#!/bin/sh\ntouch " + marker + "\nsleep 10 &\nsleep 10\n"
+	if err := os.WriteFile(filepath.Join(dir, "lsof"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	const timeout = 200 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := getListeningTCPPorts(ctx, "1")
+	elapsed := time.Since(start)
+
+	if _, statErr := os.Stat(marker); statErr != nil {
+		t.Fatalf("fake lsof didn't run: %v", statErr)
+	}
+	if err == nil {
+		t.Fatal("expected an error from a killed lsof")
+	}
+	if !strings.Contains(err.Error(), "killed") && !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("error = %v, want the process to be killed", err)
+	}
+	// The deadline kills lsof; WaitDelay then stops waiting for the orphaned sleep
+	if limit := timeout + 3*time.Second; elapsed > limit {
+		t.Errorf("lsof ran for %s, want it killed within %s", elapsed, limit)
+	}
+}
@@ -2,13 +2,41 @@ package systemscanner
 
 // SystemServiceInfo holds information about a native system service.
 type SystemServiceInfo struct {
-	Name        string `json:"name"`
-	DisplayName string `json:"display_name,omitempty"` // Often more user-friendly
-	Description string `json:"description,omitempty"`
-	Status      string `json:"status"`       // e.g., running, stopped, paused
-	StartType   string `json:"start_type,omitempty"`   // e.g., auto, manual, disabled
-	PathName    string `json:"path_name,omitempty"`    // Path to the service executable
-	PID         string `json:"pid,omitempty"` // Process ID, if running (string for flexibility with "-")
-	ListeningPorts []string `json:"listening_ports,omitempty"` // Ports the service is listening on
-	IsLikelyWebService bool `json:"is_likely_web_service,omitempty"` // True if it's likely a web service
-}
\ No newline at end of file
+	Name               string              `json:"name"`
+	DisplayName        string              `json:"display_name,omitempty"` // Often more user-friendly
+	Description        string              `json:"description,omitempty"`
+	Status             string              `json:"status"`                          // e.g., running, stopped, paused
+	StartType          string              `json:"start_type,omitempty"`            // e.g., auto, manual, disabled
+	PathName           string              `json:"path_name,omitempty"`             // Path to the service executable
+	Icon               string              `json:"icon,omitempty"`                  // Bundled icon URL, matched by executable or service name
+	PID                string              `json:"pid,omitempty"`                   // Process ID, if running (string for flexibility with "-")
+	ListeningPorts     []string            `json:"listening_ports,omitempty"`       // Ports the service is listening on
+	ListenAddresses    map[string][]string `json:"listen_addresses,omitempty"`      // Bind addresses by port, e.g. "0.0.0.0" or "127.0.0.1", where known
+	IsLikelyWebService bool                `json:"is_likely_web_service,omitempty"` // True if it's likely a web service
+	Container          *ContainerRef       `json:"container,omitempty"`             // Set if the sockets actually belong to a container
+	WebEndpoints       []WebEndpoint       `json:"web_endpoints,omitempty"`         // Listening ports that answered (or are assumed to answer) HTTP
+}
+
+// WebEndpoint is a listening port classified as a web service.
+type WebEndpoint struct {
+	Port   string `json:"port"`
+	Scheme string `json:"scheme"`           // "http" or "https"
+	Server string `json:"server,omitempty"` // Server response header, if probed
+	Source string `json:"source"`           // "probe" or "hint"
+}
+
+// Values for ContainerRef.Via.
+const (
+	ContainerViaCgroup      = "cgroup"       // Process runs inside the container's cgroup
+	ContainerViaDockerProxy = "docker-proxy" // Process is docker-proxy publishing a container port
+)
+
+// ContainerRef attributes a native listening socket to a container.
+// docker-proxy only knows the container's IP and port; the ID is looked up from the IP,
+// and stays empty if Docker couldn't be asked.
+type ContainerRef struct {
+	ID   string `json:"id,omitempty"`   // Container ID (from the cgroup path, or looked up by IP)
+	IP   string `json:"ip,omitempty"`   // Container IP (from docker-proxy -container-ip)
+	Port string `json:"port,omitempty"` // Container port (from docker-proxy -container-port)
+	Via  string `json:"via"`            // How the container was detected
+}
@@ -0,0 +1,24 @@
+//go:build embedui
+
+package webui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist is the frontend build, copied into webui/dist before building with -tags embedui.
+//
+//go:embed all:dist
+var dist embed.FS
+
+func embedded() (fs.FS, bool) {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil, false
+	}
+	if _, err := fs.Stat(sub, "index.html"); err != nil {
+		return nil, false // Built with the tag but without copying the frontend in
+	}
+	return sub, true
+}
@@ -0,0 +1,9 @@
+//go:build !embedui
+
+package webui
+
+import "io/fs"
+
+func embedded() (fs.FS, bool) {
+	return nil, false
+}
@@ -0,0 +1,167 @@
+// Package webui serves the Vue frontend: embedded in the binary when built with
+// -tags embedui, or from a directory on disk.
+package webui
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	dockerscanner "docklet/docker_scanner"
+)
+
+// DefaultDir is served when the binary has no embedded UI, for running from the repo.
+const DefaultDir = "./frontend/dist"
+
+// hashedAsset matches files Vite names after their content hash, like
+// assets/index-DiwrgTda.js, which can be cached forever.
+var hashedAsset = regexp.MustCompile(`^assets/.+-[A-Za-z0-9_-]{8}\.[a-z0-9]+$`)
+
+// encodings are the pre-compressed variants looked for next to each file, in order of preference.
+var encodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Source picks the UI to serve: DOCKLET_UI_DIR if set (for working on the frontend
+// without rebuilding the binary), then the embedded build, then DefaultDir.
+// It returns nil and a description if there is no UI.
+func Source() (fs.FS, string) {
+	if dir := dockerscanner.GetEnvOrDefault("DOCKLET_UI_DIR", ""); dir != "" {
+		return os.DirFS(dir), dir
+	}
+	if fsys, ok := embedded(); ok {
+		return fsys, "embedded build"
+	}
+	if _, err := os.Stat(path.Join(DefaultDir, "index.html")); err == nil {
+		return os.DirFS(DefaultDir), DefaultDir
+	}
+	return nil, "no UI found (build with -tags embedui or set DOCKLET_UI_DIR)"
+}
+
+// Handler serves files from fsys with ETags and cache headers, pre-compressed variants
+// when the client accepts them, and index.html for paths that look like client-side routes.
+type Handler struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	etags map[string]string // Keyed by file name, size and modification time
+}
+
+// NewHandler creates a Handler for fsys.
+func NewHandler(fsys fs.FS) *Handler {
+	return &Handler{fsys: fsys, etags: make(map[string]string)}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	if !h.isFile(name) {
+		// Paths with an extension are missing files; anything else is a route of the SPA
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = "index.html"
+	}
+	h.serveFile(w, r, name)
+}
+
+func (h *Handler) isFile(name string) bool {
+	info, err := fs.Stat(h.fsys, name)
+	return err == nil && !info.IsDir()
+}
+
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	if hashedAsset.MatchString(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		// index.html and unhashed files must be revalidated so a new build shows up
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	// Content-Type comes from the original name, not the .br/.gz variant
+	served := name
+	accepted := r.Header.Get("Accept-Encoding")
+	for _, enc := range encodings {
+		if strings.Contains(accepted, enc.name) && h.isFile(name+enc.ext) {
+			served = name + enc.ext
+			w.Header().Set("Content-Encoding", enc.name)
+			break
+		}
+	}
+
+	f, err := h.fsys.Open(served)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "file not seekable", http.StatusInternalServerError)
+		return
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+		return
+	}
+	etag, err := h.etag(served, info, content)
+	if err != nil {
+		log.Printf("Web UI: failed to read %s: %v", served, err)
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	// ServeContent handles If-None-Match, HEAD and ranges; the zero modtime disables
+	// Last-Modified, which embedded files don't have.
+	http.ServeContent(w, r, name, time.Time{}, content)
+}
+
+// etag returns a strong ETag from the content hash of a file. Hashes are cached until
+// the file's size or modification time changes, which only happens with DOCKLET_UI_DIR.
+func (h *Handler) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := fmt.Sprintf("%s|%d|%d", name, info.Size(), info.ModTime().UnixNano())
+	h.mu.Lock()
+	etag, ok := h.etags[key]
+	h.mu.Unlock()
+	if ok {
+		return etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag = `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+	h.mu.Lock()
+	h.etags[key] = etag
+	h.mu.Unlock()
+	return etag, nil
+}